@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/telegram"
+	"watgbridge/telegram/mtproto"
+	"watgbridge/utils"
+	"watgbridge/whatsapp"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+)
+
+type cliCommand struct {
+	run         func(args []string)
+	description string
+}
+
+var cliCommands map[string]cliCommand
+
+func init() {
+	cliCommands = map[string]cliCommand{
+		"run":            {runCommand, "Run the bridge (default when no command is given)"},
+		"pair":           {pairCommand, "Link the bridge to WhatsApp and exit"},
+		"telegram-login": {telegramLoginCommand, "Log the Telegram user account of the mtproto transport in and exit"},
+		"db":             {dbCommand, "Database maintenance: migrate, prune"},
+		"export":         {exportCommand, "Export the archived messages of a chat"},
+		"restore":        {restoreCommand, "Restore a backup made with /backup"},
+		"doctor":         {doctorCommand, "Check the config, dependencies and connections"},
+		"help":           {helpCommand, "Show this help"},
+	}
+}
+
+func helpCommand(args []string) {
+	fmt.Println("Usage: watgbridge [command] [flags] [config path]")
+	fmt.Println()
+	fmt.Println("Commands:")
+
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-14s %s\n", name, cliCommands[name].description)
+	}
+}
+
+func pairCommand(args []string) {
+	flags := flag.NewFlagSet("pair", flag.ExitOnError)
+	phone := flags.String("phone", "", "phone number to link to by entering a code on the phone instead of scanning a QR code")
+	_ = flags.Parse(args)
+
+	setupBridge(flags.Arg(0))
+	if *phone != "" {
+		state.State.Config.WhatsApp.PairPhoneNumber = *phone
+	}
+
+	if err := whatsapp.NewWhatsAppClient(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	state.State.WhatsAppClient.Disconnect()
+	fmt.Println("Linked to WhatsApp as", state.State.WhatsAppClient.Store.ID.ToNonAD().String())
+}
+
+func telegramLoginCommand(args []string) {
+	flags := flag.NewFlagSet("telegram-login", flag.ExitOnError)
+	phone := flags.String("phone", "", "phone number of the account, asked for if empty")
+	_ = flags.Parse(args)
+
+	setupBridge(flags.Arg(0))
+	opts := telegram.MTProtoOptions()
+	if opts.APIID == 0 || opts.APIHash == "" {
+		fmt.Fprintln(os.Stderr, "Set telegram.mtproto.api_id and api_hash in the config first, from https://my.telegram.org")
+		os.Exit(2)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	user, err := mtproto.Login(opts, *phone, func(question string) (string, error) {
+		fmt.Print(question, ": ")
+		answer, err := stdin.ReadString('\n')
+		return strings.TrimSpace(answer), err
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to log into Telegram:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Logged into Telegram as %s (%d), set telegram.transport to mtproto to use it\n", user.Name, user.ID)
+}
+
+func dbCommand(args []string) {
+	if len(args) == 0 || (args[0] != "migrate" && args[0] != "prune") {
+		fmt.Fprintln(os.Stderr, "Usage: watgbridge db <migrate|prune> [config path]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("db "+args[0], flag.ExitOnError)
+	_ = flags.Parse(args[1:])
+
+	setupBridge(flags.Arg(0))
+	setupDatabase()
+
+	switch args[0] {
+	case "migrate":
+		fmt.Println("Database is migrated")
+	case "prune":
+		utils.ArchivePrune()
+		utils.MediaStoreCleanup()
+		fmt.Println("Pruned the message archive and media store")
+	}
+}
+
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	chat := flags.String("chat", "", "WhatsApp chat to export, as a phone number, group ID or JID")
+	fromDate := flags.String("from", "", "export messages from this date (YYYY-MM-DD)")
+	toDate := flags.String("to", "", "export messages up to this date (YYYY-MM-DD)")
+	format := flags.String("format", "json", "output format: json or html")
+	output := flags.String("output", "", "file to write to, standard output if empty")
+	_ = flags.Parse(args)
+
+	setupBridge(flags.Arg(0))
+	setupDatabase()
+
+	waChatJid, ok := utils.WaParseJID(*chat)
+	if !ok || *chat == "" || (*format != "json" && *format != "html") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	from, to := time.Unix(0, 0), time.Now().Add(time.Minute)
+	for _, date := range []struct {
+		value  string
+		target *time.Time
+		days   int
+	}{{*fromDate, &from, 0}, {*toDate, &to, 1}} {
+		if date.value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", date.value, state.State.LocalLocation)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid date:", date.value)
+			os.Exit(2)
+		}
+		*date.target = parsed.AddDate(0, 0, date.days)
+	}
+
+	msgs, err := database.ArchivedMessageGetRange(waChatJid.String(), from, to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get archived messages:", err)
+		os.Exit(1)
+	}
+
+	exported, err := utils.ExportBuildChat(waChatJid, msgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export chat:", err)
+		os.Exit(1)
+	}
+
+	var data []byte
+	if *format == "html" {
+		data, err = utils.ExportChatHTML(exported)
+	} else {
+		data, err = utils.ExportChatJSON(exported)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export chat:", err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write export:", err)
+		os.Exit(1)
+	}
+}
+
+func restoreCommand(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	withSession := flags.Bool("session", true, "also restore the WhatsApp login database if the backup has it")
+	force := flags.Bool("force", false, "replace the existing databases, the bridge must not be running")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: watgbridge restore [flags] <backup file> [config path]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	setupBridge(flags.Arg(1))
+
+	restored, err := utils.BackupRestore(flags.Arg(0), *withSession, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to restore backup:", err)
+		os.Exit(1)
+	}
+	for _, path := range restored {
+		fmt.Println("Restored", path)
+	}
+}
+
+func doctorCommand(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	setupBridge(flags.Arg(0))
+	cfg := state.State.Config
+
+	failed := false
+	check := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("[FAIL] %s: %s\n", name, err)
+		} else {
+			fmt.Printf("[ OK ] %s\n", name)
+		}
+	}
+
+	check("config file", nil)
+
+	for name, path := range map[string]string{
+		"git executable":    cfg.GitExecutable,
+		"go executable":     cfg.GoExecutable,
+		"ffmpeg executable": cfg.FfmpegExecutable,
+	} {
+		if path == "" {
+			path = name[:len(name)-len(" executable")]
+		}
+		_, err := exec.LookPath(path)
+		check(name, err)
+	}
+
+	db, err := database.Connect()
+	check("database connection", err)
+	if err == nil {
+		state.State.Database = db
+		pending, err := database.PendingMigrations()
+		if err == nil && len(pending) > 0 {
+			err = fmt.Errorf("pending migrations for %s, start the bridge to apply them", strings.Join(pending, ", "))
+		}
+		check("database migrations", err)
+		if err == nil {
+			check("database writable", database.HealthCheckWrite(time.Now().UTC()))
+		}
+	}
+
+	if cfg.Telegram.Transport == "mtproto" {
+		bot, userClient, err := telegram.NewUserBot()
+		check("telegram user session", err)
+		if err == nil {
+			// A user account has no getChat, it shows itself typing instead
+			_, err = bot.SendChatAction(cfg.Telegram.TargetChatID, "typing", nil)
+			check("telegram target chat", err)
+			_ = userClient.Close()
+		}
+	} else {
+		bot, err := gotgbot.NewBot(cfg.Telegram.BotToken, &gotgbot.BotOpts{
+			BotClient: &gotgbot.BaseBotClient{
+				DefaultRequestOpts: &gotgbot.RequestOpts{APIURL: cfg.Telegram.APIURL},
+			},
+		})
+		check("telegram bot token", err)
+		if err == nil {
+			_, err = bot.GetChat(cfg.Telegram.TargetChatID, nil)
+			check("telegram target chat", err)
+		}
+	}
+
+	container, err := sqlstore.New(cfg.WhatsApp.LoginDatabase.Type, cfg.WhatsApp.LoginDatabase.URL, nil)
+	check("whatsapp login database", err)
+	if err == nil {
+		device, err := container.GetFirstDevice()
+		if err == nil && device.ID == nil {
+			err = fmt.Errorf("not linked yet, run 'watgbridge pair'")
+		}
+		check("whatsapp session", err)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
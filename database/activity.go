@@ -0,0 +1,122 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/state"
+)
+
+type activityKey struct {
+	kind     string
+	waChatId string
+}
+
+// activityPending holds the activity events counted since the last flush
+var activityPending = struct {
+	lock   sync.Mutex
+	events map[activityKey]*ActivityEvent
+}{
+	events: make(map[activityKey]*ActivityEvent),
+}
+
+// ActivityEventAdd counts an event of the kind in the chat, it is written to
+// the database with the next flush
+func ActivityEventAdd(kind, waChatId string, size int64) error {
+	activityPending.lock.Lock()
+	defer activityPending.lock.Unlock()
+
+	key := activityKey{kind, waChatId}
+	event, found := activityPending.events[key]
+	if !found {
+		event = &ActivityEvent{
+			Kind:      kind,
+			WaChatId:  waChatId,
+			Timestamp: time.Now().UTC(),
+		}
+		activityPending.events[key] = event
+	}
+	event.Count += 1
+	event.Size = max(event.Size, size)
+
+	return nil
+}
+
+// ActivityFlushPending writes the activity events counted since the last
+// flush, one row per kind and chat. Failed writes are counted again with the
+// next flush.
+func ActivityFlushPending() error {
+	activityPending.lock.Lock()
+	if len(activityPending.events) == 0 {
+		activityPending.lock.Unlock()
+		return nil
+	}
+	pending := activityPending.events
+	activityPending.events = make(map[activityKey]*ActivityEvent)
+	activityPending.lock.Unlock()
+
+	events := make([]ActivityEvent, 0, len(pending))
+	for _, event := range pending {
+		events = append(events, *event)
+	}
+
+	db := state.State.Database
+	res := db.Create(&events)
+	if res.Error == nil {
+		return nil
+	}
+
+	activityPending.lock.Lock()
+	defer activityPending.lock.Unlock()
+
+	for key, event := range pending {
+		if newer, found := activityPending.events[key]; found {
+			event.Count += newer.Count
+			event.Size = max(event.Size, newer.Size)
+		}
+		activityPending.events[key] = event
+	}
+	return res.Error
+}
+
+func ActivityEventCountByChat(kind string, since time.Time) (map[string]int64, error) {
+	if err := ActivityFlushPending(); err != nil {
+		return nil, err
+	}
+
+	db := state.State.Database
+
+	var rows []struct {
+		WaChatId string
+		Count    int64
+	}
+	res := db.Model(&ActivityEvent{}).Select("wa_chat_id, sum(count) as count").
+		Where("kind = ? AND timestamp >= ?", kind, since).Group("wa_chat_id").Find(&rows)
+
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		counts[row.WaChatId] = row.Count
+	}
+
+	return counts, res.Error
+}
+
+func ActivityEventLargest(kind string, since time.Time) (ActivityEvent, bool, error) {
+	if err := ActivityFlushPending(); err != nil {
+		return ActivityEvent{}, false, err
+	}
+
+	db := state.State.Database
+
+	var event ActivityEvent
+	res := db.Where("kind = ? AND timestamp >= ?", kind, since).Order("size desc").Limit(1).Find(&event)
+
+	return event, event.ID != 0, res.Error
+}
+
+func ActivityEventDeleteBefore(before time.Time) error {
+	db := state.State.Database
+	res := db.Where("timestamp < ?", before).Delete(&ActivityEvent{})
+
+	return res.Error
+}
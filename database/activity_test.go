@@ -0,0 +1,45 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityEventsAggregated(t *testing.T) {
+	db := newTestDatabase(t)
+	since := time.Now().UTC().Add(-time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ActivityEventAdd(ActivityMessage, "10000000002@s.whatsapp.net", 0)
+	}
+	ActivityEventAdd(ActivityMessage, "10000000003@s.whatsapp.net", 0)
+	ActivityEventAdd(ActivityMediaSkipped, "10000000003@s.whatsapp.net", 10)
+	ActivityEventAdd(ActivityMediaSkipped, "10000000003@s.whatsapp.net", 30)
+
+	var rows int64
+	if db.Model(&ActivityEvent{}).Count(&rows); rows != 0 {
+		t.Errorf("%d rows written before the flush, want 0", rows)
+	}
+
+	counts, err := ActivityEventCountByChat(ActivityMessage, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["10000000002@s.whatsapp.net"] != 3 || counts["10000000003@s.whatsapp.net"] != 1 {
+		t.Errorf("counted %v", counts)
+	}
+	if db.Model(&ActivityEvent{}).Count(&rows); rows != 3 {
+		t.Errorf("%d rows written, want one per kind and chat", rows)
+	}
+
+	// Counted again after the flush
+	ActivityEventAdd(ActivityMessage, "10000000002@s.whatsapp.net", 0)
+	if counts, _ = ActivityEventCountByChat(ActivityMessage, since); counts["10000000002@s.whatsapp.net"] != 4 {
+		t.Errorf("counted %v after the second flush", counts)
+	}
+
+	largest, found, err := ActivityEventLargest(ActivityMediaSkipped, since)
+	if err != nil || !found || largest.Size != 30 || largest.Count != 2 {
+		t.Errorf("largest skipped media is %+v (%v)", largest, err)
+	}
+}
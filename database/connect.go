@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"strings"
 
 	"watgbridge/state"
 
@@ -29,7 +30,10 @@ func Connect() (*gorm.DB, error) {
 		return nil, fmt.Errorf("Error: key 'type' not found in database config")
 	}
 
-	gormConfig := gorm.Config{}
+	var (
+		tuning     = state.State.Config.DatabaseTuning
+		gormConfig = gorm.Config{PrepareStmt: tuning.PrepareStatements}
+	)
 
 	switch dbType {
 
@@ -52,7 +56,11 @@ func Connect() (*gorm.DB, error) {
 			dns += " sslmode=disable"
 		}
 
-		return gorm.Open(postgres.Open(dns), &gormConfig)
+		db, err := gorm.Open(postgres.Open(dns), &gormConfig)
+		if err != nil {
+			return nil, err
+		}
+		return db, registerLatencyCallbacks(db)
 
 	case "sqlite":
 
@@ -60,7 +68,23 @@ func Connect() (*gorm.DB, error) {
 			return nil, fmt.Errorf("Error: database config for type '%s' requires the keys %+v", dbType, missingKeys)
 		}
 
-		return gorm.Open(sqlite.Open(dbConfig["path"]), &gormConfig)
+		dsn := dbConfig["path"]
+		if tuning.WAL {
+			// These are applied by the driver to every connection of the pool,
+			// a PRAGMA after opening would only reach one of them
+			if strings.Contains(dsn, "?") {
+				dsn += "&"
+			} else {
+				dsn += "?"
+			}
+			dsn += "_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
+		}
+
+		db, err := gorm.Open(sqlite.Open(dsn), &gormConfig)
+		if err != nil {
+			return nil, err
+		}
+		return db, registerLatencyCallbacks(db)
 
 	case "mysql":
 
@@ -78,7 +102,11 @@ func Connect() (*gorm.DB, error) {
 			dbConfig["dbname"],
 		)
 
-		return gorm.Open(mysql.Open(dns), &gormConfig)
+		db, err := gorm.Open(mysql.Open(dns), &gormConfig)
+		if err != nil {
+			return nil, err
+		}
+		return db, registerLatencyCallbacks(db)
 	}
 
 	return nil, fmt.Errorf("Database of type '%s' is not supported", dbType)
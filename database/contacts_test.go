@@ -0,0 +1,51 @@
+package database
+
+import "testing"
+
+func TestContactLIDBulkSet(t *testing.T) {
+	newTestDatabase(t)
+
+	if err := ContactNameAddNew("10000000002", "Alice", "Alice A", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ContactLIDSet("10000000003", "200000000000003"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ContactLIDSet("10000000004", "200000000000004"); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ContactLIDBulkSet(map[string]string{
+		"10000000002": "200000000000002",
+		"10000000003": "200000000000033",
+		"10000000004": "200000000000004",
+		"10000000005": "200000000000005",
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if written != 3 {
+		t.Errorf("ContactLIDBulkSet() wrote %d contacts, want 3", written)
+	}
+
+	contacts, err := ContactGetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for waUserId, want := range map[string]string{
+		"10000000002": "200000000000002",
+		"10000000003": "200000000000033",
+		"10000000004": "200000000000004",
+		"10000000005": "200000000000005",
+	} {
+		if got := contacts[waUserId].LID; got != want {
+			t.Errorf("LID of %s = %q, want %q", waUserId, got, want)
+		}
+	}
+	if contacts["10000000002"].FullName != "Alice A" {
+		t.Errorf("name of a contact lost when its LID was set")
+	}
+
+	if written, err = ContactLIDBulkSet(map[string]string{"10000000005": "200000000000005"}); err != nil || written != 0 {
+		t.Errorf("ContactLIDBulkSet() of a stored mapping wrote %d contacts: %v", written, err)
+	}
+}
@@ -2,16 +2,50 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"time"
 
 	"watgbridge/state"
 
 	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64) error {
+	return msgIdAddPair(waMsgId, participantId, waChatId, tgChatId, tgMsgId, tgThreadId, false)
+}
+
+// MsgIdAddCombinedPair pairs a text which was sent in the same Telegram
+// message as later texts, so that replies and reactions to that message go to
+// the last text
+func MsgIdAddCombinedPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64) error {
+	return msgIdAddPair(waMsgId, participantId, waChatId, tgChatId, tgMsgId, tgThreadId, true)
+}
+
+func msgIdAddPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64, combined bool) error {
 
 	db := state.State.Database
 
+	// Without topics Telegram still gives replies the thread of their reply
+	// chain, which has nothing to do with the chat they are in
+	if state.State.Config.Telegram.SingleStream {
+		tgThreadId = 0
+	}
+
+	if queued, err := msgIdQueuePair(MsgIdPair{
+		ID:            waMsgId,
+		ParticipantId: participantId,
+		WaChatId:      waChatId,
+		TgChatId:      tgChatId,
+		TgMsgId:       tgMsgId,
+		TgThreadId:    tgThreadId,
+		MarkRead:      sql.NullBool{Valid: true, Bool: false},
+		Combined:      combined,
+	}); queued {
+		return err
+	}
+
 	var bridgePair MsgIdPair
 	res := db.Where("id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
 	if res.Error != nil {
@@ -25,6 +59,7 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 		bridgePair.TgMsgId = tgMsgId
 		bridgePair.TgThreadId = tgThreadId
 		bridgePair.MarkRead = sql.NullBool{Valid: true, Bool: false}
+		bridgePair.Combined = combined
 		res = db.Save(&bridgePair)
 		return res.Error
 	}
@@ -37,6 +72,7 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 		TgMsgId:       tgMsgId,
 		TgThreadId:    tgThreadId,
 		MarkRead:      sql.NullBool{Valid: true, Bool: false},
+		Combined:      combined,
 	})
 	return res.Error
 }
@@ -45,6 +81,10 @@ func MsgIdGetTgFromWa(waMsgId, waChatId string) (int64, int64, int64, error) {
 
 	db := state.State.Database
 
+	if pair, found := msgIdPending.getByWa(waMsgId, waChatId); found {
+		return pair.TgChatId, pair.TgThreadId, pair.TgMsgId, nil
+	}
+
 	var bridgePair MsgIdPair
 	res := db.Where("id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
 
@@ -55,14 +95,27 @@ func MsgIdGetWaFromTg(tgChatId, tgMsgId, tgThreadId int64) (msgId, participantId
 
 	db := state.State.Database
 
+	if pair, found := msgIdPending.getByTg(tgChatId, tgMsgId, tgThreadId, state.State.Config.Telegram.SingleStream); found {
+		return pair.ID, pair.ParticipantId, pair.WaChatId, nil
+	}
+
+	query := db.Where("tg_chat_id = ? AND tg_msg_id = ? AND combined = ?", tgChatId, tgMsgId, false)
+	if !state.State.Config.Telegram.SingleStream {
+		query = query.Where("tg_thread_id = ?", tgThreadId)
+	}
+
 	var bridgePair MsgIdPair
-	res := db.Where("tg_chat_id = ? AND tg_msg_id = ? AND tg_thread_id = ?", tgChatId, tgMsgId, tgThreadId).Find(&bridgePair)
+	res := query.Find(&bridgePair)
 
 	return bridgePair.ID, bridgePair.ParticipantId, bridgePair.WaChatId, res.Error
 }
 
 func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
 
+	if err := MsgIdFlushPending(); err != nil {
+		return nil, err
+	}
+
 	db := state.State.Database
 
 	var bridgePairs []MsgIdPair
@@ -82,6 +135,10 @@ func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
 
 func MsgIdMarkRead(waChatId, waMsgId string) error {
 
+	if err := MsgIdFlushPending(); err != nil {
+		return err
+	}
+
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
@@ -101,6 +158,10 @@ func MsgIdMarkRead(waChatId, waMsgId string) error {
 
 func MsgIdDeletePair(tgChatId, tgMsgId int64) error {
 
+	if err := MsgIdFlushPending(); err != nil {
+		return err
+	}
+
 	db := state.State.Database
 	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Delete(&MsgIdPair{})
 
@@ -109,12 +170,64 @@ func MsgIdDeletePair(tgChatId, tgMsgId int64) error {
 
 func MsgIdDropAllPairs() error {
 
+	if err := MsgIdFlushPending(); err != nil {
+		return err
+	}
+
 	db := state.State.Database
 	res := db.Where("1 = 1").Delete(&MsgIdPair{})
 
 	return res.Error
 }
 
+func FanOutThreadGet(waChatId string, tgChatId int64) (int64, bool, error) {
+	db := state.State.Database
+
+	var pair FanOutThreadPair
+	res := db.Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).Find(&pair)
+
+	return pair.TgThreadId, pair.ID != "", res.Error
+}
+
+func FanOutThreadSet(waChatId string, tgChatId, tgThreadId int64) error {
+	db := state.State.Database
+	res := db.Save(&FanOutThreadPair{ID: waChatId, TgChatId: tgChatId, TgThreadId: tgThreadId})
+
+	return res.Error
+}
+
+func FanOutThreadDrop(waChatId string, tgChatId int64) error {
+	db := state.State.Database
+	res := db.Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).Delete(&FanOutThreadPair{})
+
+	return res.Error
+}
+
+func FanOutMessageAdd(tgMsgId, destChatId, destMsgId int64) error {
+	db := state.State.Database
+	res := db.Save(&FanOutMessage{TgMsgId: tgMsgId, DestChatId: destChatId, DestMsgId: destMsgId})
+
+	return res.Error
+}
+
+// FanOutMessageGet returns the copy of the message of the target chat in the
+// destination, zero if there is none
+func FanOutMessageGet(tgMsgId, destChatId int64) (int64, error) {
+	db := state.State.Database
+
+	var msg FanOutMessage
+	res := db.Where("tg_msg_id = ? AND dest_chat_id = ?", tgMsgId, destChatId).Find(&msg)
+
+	return msg.DestMsgId, res.Error
+}
+
+func FanOutMessageDropAll() error {
+	db := state.State.Database
+	res := db.Where("1 = 1").Delete(&FanOutMessage{})
+
+	return res.Error
+}
+
 func ChatThreadAddNewPair(waChatId string, tgChatId, tgThreadId int64) error {
 
 	db := state.State.Database
@@ -181,6 +294,16 @@ func ChatThreadGetAllPairs(tgChatId int64) ([]ChatThreadPair, error) {
 	return chatPairs, res.Error
 }
 
+// ChatThreadDropPairsNotInChat removes the pairs of topics in other Telegram
+// chats, left behind when the target chat is changed
+func ChatThreadDropPairsNotInChat(tgChatId int64) (int64, error) {
+
+	db := state.State.Database
+	res := db.Where("tg_chat_id <> ?", tgChatId).Delete(&ChatThreadPair{})
+
+	return res.RowsAffected, res.Error
+}
+
 func ChatThreadDropAllPairs() error {
 
 	db := state.State.Database
@@ -234,7 +357,15 @@ func ContactNameBulkAddOrUpdate(contacts map[types.JID]types.ContactInfo) error
 		})
 	}
 
-	res := db.Save(&contactNames)
+	if len(contactNames) == 0 {
+		return nil
+	}
+
+	// Only the names are updated so that known LIDs are kept
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"first_name", "full_name", "push_name", "business_name"}),
+	}).Create(&contactNames)
 	if res.Error != nil {
 		return res.Error
 	}
@@ -266,6 +397,93 @@ func ContactGetAll() (map[string]ContactName, error) {
 	return results, res.Error
 }
 
+func ContactLIDSet(waUserId, lidUser string) error {
+	db := state.State.Database
+
+	var contact ContactName
+	res := db.Where("id = ?", waUserId).Find(&contact)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if contact.ID != waUserId {
+		res = db.Create(&ContactName{
+			ID:  waUserId,
+			LID: lidUser,
+		})
+		return res.Error
+	} else if contact.LID == lidUser {
+		return nil
+	}
+
+	res = db.Model(&contact).Update("lid", lidUser)
+	return res.Error
+}
+
+// ContactLIDBulkSet saves the LIDs of many contacts at once, keyed by their
+// phone numbers, writing only the ones that differ from the stored mapping in
+// a single transaction. It returns the number of contacts written.
+func ContactLIDBulkSet(lids map[string]string) (int, error) {
+	db := state.State.Database
+
+	if len(lids) == 0 {
+		return 0, nil
+	}
+
+	var contacts []ContactName
+	res := db.Select("id", "lid").Where("1 = 1").Limit(-1).Find(&contacts)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	stored := make(map[string]string, len(contacts))
+	for _, contact := range contacts {
+		stored[contact.ID] = contact.LID
+	}
+
+	var (
+		created []ContactName
+		updated = make(map[string]string)
+	)
+	for waUserId, lidUser := range lids {
+		if storedLID, found := stored[waUserId]; !found {
+			created = append(created, ContactName{ID: waUserId, LID: lidUser})
+		} else if storedLID != lidUser {
+			updated[waUserId] = lidUser
+		}
+	}
+	if len(created) == 0 && len(updated) == 0 {
+		return 0, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(created) > 0 {
+			if res := tx.CreateInBatches(&created, 100); res.Error != nil {
+				return res.Error
+			}
+		}
+		for waUserId, lidUser := range updated {
+			res := tx.Model(&ContactName{}).Where("id = ?", waUserId).Update("lid", lidUser)
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(created) + len(updated), nil
+}
+
+func ContactGetByLID(lidUser string) (ContactName, bool, error) {
+	db := state.State.Database
+
+	var contact ContactName
+	res := db.Where("lid = ?", lidUser).Limit(1).Find(&contact)
+
+	return contact, contact.ID != "", res.Error
+}
+
 func ContactUpdatePushName(waUserId, pushName string) error {
 	if pushName == "" {
 		return nil
@@ -381,3 +599,936 @@ func GetEphemeralSettings(waChatId string) (bool, uint32, bool, error) {
 
 	return settings.IsEphemeral, settings.EphemeralTimer, true, nil
 }
+
+func AwayModeGet() (bool, string, error) {
+	db := state.State.Database
+
+	var settings AwayModeSettings
+	res := db.Where("id = ?", 1).Find(&settings)
+
+	return settings.Enabled, settings.Message, res.Error
+}
+
+func AwayModeSet(enabled bool, message string) error {
+	db := state.State.Database
+
+	res := db.Save(&AwayModeSettings{
+		ID:      1,
+		Enabled: enabled,
+		Message: message,
+	})
+
+	return res.Error
+}
+
+func AwayModeGetLastReply(waChatId string) (time.Time, bool, error) {
+	db := state.State.Database
+
+	var reply AwayModeReply
+	res := db.Where("id = ?", waChatId).Find(&reply)
+
+	return reply.LastReplied, reply.ID == waChatId, res.Error
+}
+
+func AwayModeUpdateLastReply(waChatId string, lastReplied time.Time) error {
+	db := state.State.Database
+
+	res := db.Save(&AwayModeReply{
+		ID:          waChatId,
+		LastReplied: lastReplied,
+	})
+
+	return res.Error
+}
+
+func AwayModeClearReplies() error {
+	db := state.State.Database
+	res := db.Where("1 = 1").Delete(&AwayModeReply{})
+
+	return res.Error
+}
+
+func AlertRuleAdd(pattern string) (uint, error) {
+	db := state.State.Database
+
+	rule := AlertRule{Pattern: pattern}
+	res := db.Create(&rule)
+
+	return rule.ID, res.Error
+}
+
+func AlertRuleDelete(id uint) (bool, error) {
+	db := state.State.Database
+	res := db.Where("id = ?", id).Delete(&AlertRule{})
+
+	return res.RowsAffected > 0, res.Error
+}
+
+func AlertRuleGetAll() ([]AlertRule, error) {
+	db := state.State.Database
+
+	var rules []AlertRule
+	res := db.Where("1 = 1").Order("id").Find(&rules)
+
+	return rules, res.Error
+}
+
+func ChatMediaPolicySet(waChatId, mediaType string, skip bool) error {
+	db := state.State.Database
+
+	res := db.Save(&ChatMediaPolicy{
+		ID:        waChatId,
+		MediaType: mediaType,
+		Skip:      skip,
+	})
+
+	return res.Error
+}
+
+func ChatMediaPolicyDelete(waChatId, mediaType string) error {
+	db := state.State.Database
+	res := db.Where("id = ? AND media_type = ?", waChatId, mediaType).Delete(&ChatMediaPolicy{})
+
+	return res.Error
+}
+
+func ChatMediaPolicyGet(waChatId, mediaType string) (bool, bool, error) {
+	db := state.State.Database
+
+	var policy ChatMediaPolicy
+	res := db.Where("id = ? AND media_type = ?", waChatId, mediaType).Find(&policy)
+
+	return policy.Skip, policy.ID == waChatId, res.Error
+}
+
+func QueuedMessageAdd(msg *QueuedMessage) error {
+	db := state.State.Database
+	res := db.Create(msg)
+
+	return res.Error
+}
+
+func QueuedMessageGetAll() ([]QueuedMessage, error) {
+	db := state.State.Database
+
+	var msgs []QueuedMessage
+	res := db.Where("1 = 1").Order("id").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+// QueuedMessageGetUnencrypted returns queued messages stored before the
+// encryption key was set
+func QueuedMessageGetUnencrypted(limit int) ([]QueuedMessage, error) {
+	db := state.State.Database
+
+	var msgs []QueuedMessage
+	res := db.Where("encrypted IS NULL OR encrypted = ?", false).Order("id").Limit(limit).Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func QueuedMessageReplace(msg *QueuedMessage) error {
+	db := state.State.Database
+	res := db.Save(msg)
+
+	return res.Error
+}
+
+func QueuedMessageDelete(id uint) error {
+	db := state.State.Database
+	res := db.Delete(&QueuedMessage{}, id)
+
+	return res.Error
+}
+
+func QueuedMessageDeleteUpTo(id uint) error {
+	db := state.State.Database
+	res := db.Where("id <= ?", id).Delete(&QueuedMessage{})
+
+	return res.Error
+}
+
+func archivedMessageSetTokens(tx *gorm.DB, id uint, tokens []string) error {
+	if res := tx.Where("archived_message_id = ?", id).Delete(&MessageSearchToken{}); res.Error != nil {
+		return res.Error
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+	searchTokens := make([]MessageSearchToken, 0, len(tokens))
+	for _, token := range tokens {
+		searchTokens = append(searchTokens, MessageSearchToken{Token: token, ArchivedMessageID: id})
+	}
+	return tx.Create(&searchTokens).Error
+}
+
+func ArchivedMessageAdd(msg *ArchivedMessage, tokens []string) error {
+	db := state.State.Database
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing ArchivedMessage
+		res := tx.Where("wa_msg_id = ? AND wa_chat_id = ?", msg.WaMsgId, msg.WaChatId).Find(&existing)
+		if res.Error != nil {
+			return res.Error
+		}
+		msg.ID = existing.ID
+
+		if res = tx.Save(msg); res.Error != nil {
+			return res.Error
+		}
+		return archivedMessageSetTokens(tx, msg.ID, tokens)
+	})
+}
+
+func ArchivedMessageUpdateBody(waMsgId, waChatId string, body []byte, encrypted bool, editedAt time.Time, tokens []string) error {
+	db := state.State.Database
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing ArchivedMessage
+		res := tx.Where("wa_msg_id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&existing)
+		if res.Error != nil || existing.ID == 0 {
+			return res.Error
+		}
+		if existing.Encrypted != encrypted {
+			return errors.New("the archived message was stored before the encryption key was set or removed")
+		}
+
+		existing.Body = body
+		existing.EditedAt = sql.NullTime{Time: editedAt, Valid: true}
+		if res = tx.Save(&existing); res.Error != nil {
+			return res.Error
+		}
+		return archivedMessageSetTokens(tx, existing.ID, tokens)
+	})
+}
+
+// ArchivedMessageGetUnencrypted returns archived messages stored before the
+// encryption key was set
+func ArchivedMessageGetUnencrypted(limit int) ([]ArchivedMessage, error) {
+	db := state.State.Database
+
+	var msgs []ArchivedMessage
+	res := db.Where("encrypted IS NULL OR encrypted = ?", false).Order("id").Limit(limit).Find(&msgs)
+
+	return msgs, res.Error
+}
+
+// ArchivedMessageReplace saves the archived message over the existing one
+// along with its new search tokens
+func ArchivedMessageReplace(msg *ArchivedMessage, tokens []string) error {
+	db := state.State.Database
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Save(msg); res.Error != nil {
+			return res.Error
+		}
+		return archivedMessageSetTokens(tx, msg.ID, tokens)
+	})
+}
+
+func ArchivedMessageGet(waMsgId, waChatId string) (ArchivedMessage, bool, error) {
+	db := state.State.Database
+
+	var msg ArchivedMessage
+	res := db.Where("wa_msg_id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&msg)
+
+	return msg, msg.ID != 0, res.Error
+}
+
+func ArchivedMessageMarkRevoked(waMsgId, waChatId string) error {
+	db := state.State.Database
+	res := db.Model(&ArchivedMessage{}).Where("wa_msg_id = ? AND wa_chat_id = ?", waMsgId, waChatId).
+		Update("revoked", true)
+
+	return res.Error
+}
+
+func ArchivedMessageSetStarred(waMsgId, waChatId string, starred bool) error {
+	db := state.State.Database
+	res := db.Model(&ArchivedMessage{}).Where("wa_msg_id = ? AND wa_chat_id = ?", waMsgId, waChatId).
+		Update("starred", starred)
+
+	return res.Error
+}
+
+func ArchivedMessageGetStarred(waChatId string, limit int) ([]ArchivedMessage, error) {
+	db := state.State.Database
+
+	query := db.Where("starred = ?", true)
+	if waChatId != "" {
+		query = query.Where("wa_chat_id = ?", waChatId)
+	}
+
+	var msgs []ArchivedMessage
+	res := query.Order("timestamp desc").Limit(limit).Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func ArchivedMessageSearch(tokens []string, waChatId string, limit int) ([]ArchivedMessage, error) {
+	db := state.State.Database
+
+	matchingIds := db.Model(&MessageSearchToken{}).Select("archived_message_id").
+		Where("token IN ?", tokens).Group("archived_message_id").
+		Having("COUNT(DISTINCT token) = ?", len(tokens))
+
+	query := db.Where("id IN (?)", matchingIds)
+	if waChatId != "" {
+		query = query.Where("wa_chat_id = ?", waChatId)
+	}
+
+	var msgs []ArchivedMessage
+	res := query.Order("timestamp desc").Limit(limit).Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func ArchivedMessageDeleteBefore(before time.Time) error {
+	db := state.State.Database
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		oldIds := tx.Model(&ArchivedMessage{}).Select("id").Where("timestamp < ?", before)
+		if res := tx.Where("archived_message_id IN (?)", oldIds).Delete(&MessageSearchToken{}); res.Error != nil {
+			return res.Error
+		}
+		return tx.Where("timestamp < ?", before).Delete(&ArchivedMessage{}).Error
+	})
+}
+
+func ArchivedMessageGetRange(waChatId string, from, to time.Time) ([]ArchivedMessage, error) {
+	db := state.State.Database
+
+	var msgs []ArchivedMessage
+	res := db.Where("wa_chat_id = ? AND timestamp >= ? AND timestamp < ?", waChatId, from, to).
+		Order("timestamp").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func PendingDeliveryAdd(delivery *PendingDelivery) error {
+	db := state.State.Database
+	res := db.Create(delivery)
+
+	return res.Error
+}
+
+func PendingDeliveryGetAll() ([]PendingDelivery, error) {
+	db := state.State.Database
+
+	var deliveries []PendingDelivery
+	res := db.Where("1 = 1").Order("id").Find(&deliveries)
+
+	return deliveries, res.Error
+}
+
+func PendingDeliverySetAttempts(id uint, attempts int) error {
+	db := state.State.Database
+	res := db.Model(&PendingDelivery{}).Where("id = ?", id).Update("attempts", attempts)
+
+	return res.Error
+}
+
+func PendingDeliveryDelete(id uint) error {
+	db := state.State.Database
+	res := db.Where("id = ?", id).Delete(&PendingDelivery{})
+
+	return res.Error
+}
+
+func StoredMediaAdd(media *StoredMedia) error {
+	db := state.State.Database
+	res := db.Save(media)
+
+	return res.Error
+}
+
+func StoredMediaGet(hash string) (StoredMedia, bool, error) {
+	db := state.State.Database
+
+	var media StoredMedia
+	res := db.Where("id = ?", hash).Find(&media)
+
+	return media, media.ID == hash, res.Error
+}
+
+func StoredMediaTouch(hash string, lastUsed time.Time) error {
+	db := state.State.Database
+	res := db.Model(&StoredMedia{}).Where("id = ?", hash).Update("last_used", lastUsed)
+
+	return res.Error
+}
+
+func StoredMediaGetUnusedSince(before time.Time) ([]StoredMedia, error) {
+	db := state.State.Database
+
+	var media []StoredMedia
+	res := db.Where("last_used < ?", before).Find(&media)
+
+	return media, res.Error
+}
+
+func StoredMediaDelete(hash string) error {
+	db := state.State.Database
+	res := db.Where("id = ?", hash).Delete(&StoredMedia{})
+
+	return res.Error
+}
+
+// HistoryAnchorUpdate saves the message as the oldest known one of the chat,
+// unless an older one is already known
+func HistoryAnchorUpdate(waChatId, msgId string, isFromMe bool, timestamp time.Time) error {
+	db := state.State.Database
+
+	var anchor HistoryAnchor
+	res := db.Where("id = ?", waChatId).Find(&anchor)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if anchor.ID == waChatId && !timestamp.Before(anchor.Timestamp) {
+		return nil
+	}
+
+	res = db.Save(&HistoryAnchor{
+		ID:        waChatId,
+		MsgId:     msgId,
+		IsFromMe:  isFromMe,
+		Timestamp: timestamp,
+	})
+	return res.Error
+}
+
+func HistoryAnchorGet(waChatId string) (HistoryAnchor, bool, error) {
+	db := state.State.Database
+
+	var anchor HistoryAnchor
+	res := db.Where("id = ?", waChatId).Find(&anchor)
+
+	return anchor, anchor.ID == waChatId, res.Error
+}
+
+func HealthCheckWrite(checkedAt time.Time) error {
+	db := state.State.Database
+	res := db.Save(&HealthCheck{ID: 1, CheckedAt: checkedAt})
+
+	return res.Error
+}
+
+func ShutdownMarkerSet(shutdownAt time.Time) error {
+	db := state.State.Database
+	res := db.Save(&ShutdownMarker{ID: 1, ShutdownAt: shutdownAt})
+
+	return res.Error
+}
+
+// ShutdownMarkerTake returns when the previous run shut down and removes the
+// marker, found is false if it did not shut down gracefully
+func ShutdownMarkerTake() (shutdownAt time.Time, found bool, err error) {
+	db := state.State.Database
+
+	var marker ShutdownMarker
+	if res := db.Where("id = ?", 1).Find(&marker); res.Error != nil || marker.ID == 0 {
+		return time.Time{}, false, res.Error
+	}
+	res := db.Delete(&marker)
+
+	return marker.ShutdownAt, true, res.Error
+}
+
+func GroupInviteAdd(msgId, groupJid, inviter, code string, expiration int64) error {
+	db := state.State.Database
+	res := db.Save(&GroupInvite{
+		ID:         msgId,
+		GroupJid:   groupJid,
+		Inviter:    inviter,
+		Code:       code,
+		Expiration: expiration,
+	})
+
+	return res.Error
+}
+
+func GroupInviteGet(msgId string) (GroupInvite, bool, error) {
+	db := state.State.Database
+
+	var invite GroupInvite
+	res := db.Where("id = ?", msgId).Find(&invite)
+
+	return invite, invite.ID == msgId, res.Error
+}
+
+func GroupInviteDelete(msgId string) error {
+	db := state.State.Database
+	res := db.Where("id = ?", msgId).Delete(&GroupInvite{})
+
+	return res.Error
+}
+
+func InteractiveOptionAdd(option *InteractiveOption) error {
+	db := state.State.Database
+	res := db.Create(option)
+
+	return res.Error
+}
+
+func InteractiveOptionGet(id uint) (InteractiveOption, bool, error) {
+	db := state.State.Database
+
+	var option InteractiveOption
+	res := db.Where("id = ?", id).Find(&option)
+
+	return option, id != 0 && option.ID == id, res.Error
+}
+
+func MentionNotificationAdd(tgChatId, tgMsgId int64, waMsgId, waChatId, sender string) error {
+	db := state.State.Database
+	res := db.Save(&MentionNotification{
+		TgChatId: tgChatId,
+		TgMsgId:  tgMsgId,
+		WaMsgId:  waMsgId,
+		WaChatId: waChatId,
+		Sender:   sender,
+	})
+
+	return res.Error
+}
+
+func MentionNotificationGet(tgChatId, tgMsgId int64) (MentionNotification, bool, error) {
+	db := state.State.Database
+
+	var notification MentionNotification
+	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Find(&notification)
+
+	return notification, notification.WaMsgId != "", res.Error
+}
+
+func AvatarChangeAdd(change *AvatarChange) error {
+	db := state.State.Database
+	res := db.Create(change)
+
+	return res.Error
+}
+
+// AvatarChangeGetLatest returns the latest changes of the chat's picture, the
+// most recent first
+func AvatarChangeGetLatest(waChatId string, limit int) ([]AvatarChange, error) {
+	db := state.State.Database
+
+	var changes []AvatarChange
+	res := db.Where("wa_chat_id = ?", waChatId).Order("changed_at DESC").Limit(limit).Find(&changes)
+
+	return changes, res.Error
+}
+
+func PausedChatAdd(waChatId string) error {
+	db := state.State.Database
+	res := db.Save(&PausedChat{
+		ID:       waChatId,
+		PausedAt: time.Now(),
+	})
+
+	return res.Error
+}
+
+func PausedChatDelete(waChatId string) error {
+	db := state.State.Database
+	res := db.Where("id = ?", waChatId).Delete(&PausedChat{})
+
+	return res.Error
+}
+
+func PausedChatGet(waChatId string) (PausedChat, bool, error) {
+	db := state.State.Database
+
+	var paused PausedChat
+	res := db.Where("id = ?", waChatId).Find(&paused)
+
+	return paused, paused.ID == waChatId, res.Error
+}
+
+// ChatStatAdd adds the counters to those stored for each chat
+func ChatStatAdd(stats []ChatStat) error {
+	db := state.State.Database
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stat := range stats {
+			res := tx.Model(&ChatStat{}).Where("id = ?", stat.ID).Updates(map[string]interface{}{
+				"undecryptable":    gorm.Expr("undecryptable + ?", stat.Undecryptable),
+				"unavailable":      gorm.Expr("unavailable + ?", stat.Unavailable),
+				"retry_recovered":  gorm.Expr("retry_recovered + ?", stat.RetryRecovered),
+				"media_downloads":  gorm.Expr("media_downloads + ?", stat.MediaDownloads),
+				"media_failures":   gorm.Expr("media_failures + ?", stat.MediaFailures),
+				"media_store_hits": gorm.Expr("media_store_hits + ?", stat.MediaStoreHits),
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				if res = tx.Create(&stat); res.Error != nil {
+					return res.Error
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func ChatStatGetAll() ([]ChatStat, error) {
+	db := state.State.Database
+
+	var stats []ChatStat
+	res := db.Find(&stats)
+
+	return stats, res.Error
+}
+
+func ForwardableMessageAdd(msgId, waChatId string, message []byte, encrypted bool) error {
+	db := state.State.Database
+	res := db.Save(&ForwardableMessage{
+		ID:        msgId,
+		WaChatId:  waChatId,
+		Message:   message,
+		CreatedAt: time.Now(),
+		Encrypted: encrypted,
+	})
+
+	return res.Error
+}
+
+// ForwardableMessageGetUnencrypted returns stored messages stored before the
+// encryption key was set
+func ForwardableMessageGetUnencrypted(limit int) ([]ForwardableMessage, error) {
+	db := state.State.Database
+
+	var msgs []ForwardableMessage
+	res := db.Where("encrypted IS NULL OR encrypted = ?", false).Limit(limit).Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func ForwardableMessageReplace(msg *ForwardableMessage) error {
+	db := state.State.Database
+	res := db.Save(msg)
+
+	return res.Error
+}
+
+func ForwardableMessageGet(msgId, waChatId string) (ForwardableMessage, bool, error) {
+	db := state.State.Database
+
+	var forwardable ForwardableMessage
+	res := db.Where("id = ? AND wa_chat_id = ?", msgId, waChatId).Find(&forwardable)
+
+	return forwardable, forwardable.ID == msgId, res.Error
+}
+
+func ForwardableMessageDeleteOlderThan(before time.Time) (int64, error) {
+	db := state.State.Database
+	res := db.Where("created_at < ?", before).Delete(&ForwardableMessage{})
+
+	return res.RowsAffected, res.Error
+}
+
+func ReminderAdd(reminder *Reminder) error {
+	db := state.State.Database
+	res := db.Create(reminder)
+
+	return res.Error
+}
+
+func ReminderGetAll() ([]Reminder, error) {
+	db := state.State.Database
+
+	var reminders []Reminder
+	res := db.Where("1 = 1").Order("id").Find(&reminders)
+
+	return reminders, res.Error
+}
+
+func ReminderDelete(id uint) (bool, error) {
+	db := state.State.Database
+	res := db.Where("id = ?", id).Delete(&Reminder{})
+
+	return res.RowsAffected > 0, res.Error
+}
+
+func CannedReplySet(name, text string) error {
+	db := state.State.Database
+	res := db.Save(&CannedReply{
+		ID:   name,
+		Text: text,
+	})
+
+	return res.Error
+}
+
+func CannedReplyGet(name string) (CannedReply, bool, error) {
+	db := state.State.Database
+
+	var reply CannedReply
+	res := db.Where("id = ?", name).Find(&reply)
+
+	return reply, reply.ID == name, res.Error
+}
+
+func CannedReplyGetAll() ([]CannedReply, error) {
+	db := state.State.Database
+
+	var replies []CannedReply
+	res := db.Where("1 = 1").Order("id").Find(&replies)
+
+	return replies, res.Error
+}
+
+func CannedReplyDelete(name string) (bool, error) {
+	db := state.State.Database
+	res := db.Where("id = ?", name).Delete(&CannedReply{})
+
+	return res.RowsAffected > 0, res.Error
+}
+
+func TopicAvatarSet(waChatId string, tgChatId, tgMsgId int64) error {
+	db := state.State.Database
+	res := db.Save(&TopicAvatar{
+		ID:       waChatId,
+		TgChatId: tgChatId,
+		TgMsgId:  tgMsgId,
+	})
+
+	return res.Error
+}
+
+func TopicAvatarGet(waChatId string) (TopicAvatar, bool, error) {
+	db := state.State.Database
+
+	var avatar TopicAvatar
+	res := db.Where("id = ?", waChatId).Find(&avatar)
+
+	return avatar, avatar.ID == waChatId, res.Error
+}
+
+func TopicAvatarDelete(waChatId string) error {
+	db := state.State.Database
+	res := db.Where("id = ?", waChatId).Delete(&TopicAvatar{})
+
+	return res.Error
+}
+
+func QuarantinedChatSet(waChatId, status string) error {
+	db := state.State.Database
+
+	var existing QuarantinedChat
+	res := db.Where("id = ?", waChatId).Find(&existing)
+	if res.Error != nil {
+		return res.Error
+	}
+	if existing.ID == "" {
+		existing = QuarantinedChat{
+			ID:        waChatId,
+			FirstSeen: time.Now(),
+		}
+	}
+	existing.Status = status
+
+	return db.Save(&existing).Error
+}
+
+func QuarantinedChatGet(waChatId string) (QuarantinedChat, bool, error) {
+	db := state.State.Database
+
+	var chat QuarantinedChat
+	res := db.Where("id = ?", waChatId).Find(&chat)
+
+	return chat, chat.ID == waChatId, res.Error
+}
+
+func QuarantinedChatDelete(waChatId string) error {
+	db := state.State.Database
+	res := db.Where("id = ?", waChatId).Delete(&QuarantinedChat{})
+
+	return res.Error
+}
+
+func MsgIdChatHasPairs(waChatId string) (bool, error) {
+	if err := MsgIdFlushPending(); err != nil {
+		return false, err
+	}
+
+	db := state.State.Database
+
+	var count int64
+	res := db.Model(&MsgIdPair{}).Where("wa_chat_id = ?", waChatId).Limit(1).Count(&count)
+
+	return count > 0, res.Error
+}
+
+func ChatAppStateGet(waChatId string) (ChatAppState, bool, error) {
+	db := state.State.Database
+
+	var appState ChatAppState
+	res := db.Where("id = ?", waChatId).Find(&appState)
+
+	return appState, appState.ID == waChatId, res.Error
+}
+
+func ChatAppStateSave(appState ChatAppState) error {
+	db := state.State.Database
+	res := db.Save(&appState)
+
+	return res.Error
+}
+
+func SilentChatAdd(waChatId string) error {
+	db := state.State.Database
+	res := db.Save(&SilentChat{
+		ID:         waChatId,
+		SilencedAt: time.Now(),
+	})
+
+	return res.Error
+}
+
+func SilentChatDelete(waChatId string) (bool, error) {
+	db := state.State.Database
+	res := db.Where("id = ?", waChatId).Delete(&SilentChat{})
+
+	return res.RowsAffected > 0, res.Error
+}
+
+func SilentChatGet(waChatId string) (SilentChat, bool, error) {
+	db := state.State.Database
+
+	var silent SilentChat
+	res := db.Where("id = ?", waChatId).Find(&silent)
+
+	return silent, silent.ID == waChatId, res.Error
+}
+
+func PendingReceiptAdd(receipt *PendingReceipt) error {
+	db := state.State.Database
+	res := db.Save(receipt)
+
+	return res.Error
+}
+
+// PendingReceiptGetOverdue returns the messages sent before the given time
+// that are still waiting for a receipt and haven't been warned about
+func PendingReceiptGetOverdue(sentBefore time.Time) ([]PendingReceipt, error) {
+	db := state.State.Database
+
+	var receipts []PendingReceipt
+	res := db.Where("sent_at < ? AND warning_msg_id = 0", sentBefore).Order("sent_at").Find(&receipts)
+
+	return receipts, res.Error
+}
+
+func PendingReceiptSetWarning(msgId string, warningMsgId int64) error {
+	db := state.State.Database
+	res := db.Model(&PendingReceipt{}).Where("id = ?", msgId).Update("warning_msg_id", warningMsgId)
+
+	return res.Error
+}
+
+// PendingReceiptDeliver stops waiting for the receipts of the messages and
+// returns the ones that were being waited for
+func PendingReceiptDeliver(msgIds []string) ([]PendingReceipt, error) {
+	db := state.State.Database
+
+	var receipts []PendingReceipt
+	res := db.Where("id IN ?", msgIds).Find(&receipts)
+	if res.Error != nil || len(receipts) == 0 {
+		return receipts, res.Error
+	}
+	res = db.Where("id IN ?", msgIds).Delete(&PendingReceipt{})
+
+	return receipts, res.Error
+}
+
+// PendingReceiptDeleteBefore gives up on the receipts of the messages sent
+// before the given time
+func PendingReceiptDeleteBefore(sentBefore time.Time) (int64, error) {
+	db := state.State.Database
+	res := db.Where("sent_at < ?", sentBefore).Delete(&PendingReceipt{})
+
+	return res.RowsAffected, res.Error
+}
+
+func WaPollAdd(poll *WaPoll) error {
+	db := state.State.Database
+	res := db.Save(poll)
+
+	return res.Error
+}
+
+func WaPollGet(waMsgId string) (WaPoll, bool, error) {
+	db := state.State.Database
+
+	var poll WaPoll
+	res := db.Where("id = ?", waMsgId).Find(&poll)
+
+	return poll, poll.ID == waMsgId, res.Error
+}
+
+func WaPollGetByTgPoll(tgPollId string) (WaPoll, bool, error) {
+	db := state.State.Database
+
+	var poll WaPoll
+	res := db.Where("tg_poll_id = ?", tgPollId).Find(&poll)
+
+	return poll, poll.ID != "", res.Error
+}
+
+func WaPollSetResultsMsg(waMsgId string, resultsMsgId int64) error {
+	db := state.State.Database
+	res := db.Model(&WaPoll{}).Where("id = ?", waMsgId).Update("results_msg_id", resultsMsgId)
+
+	return res.Error
+}
+
+func WaPollSetResultsStale(waMsgId string, stale bool) error {
+	db := state.State.Database
+	res := db.Model(&WaPoll{}).Where("id = ?", waMsgId).Update("results_stale", stale)
+
+	return res.Error
+}
+
+// WaPollVoteSave stores the vote and marks the results of the poll as stale
+func WaPollVoteSave(vote *WaPollVote) error {
+	db := state.State.Database
+	res := db.Save(vote)
+	if res.Error != nil {
+		return res.Error
+	}
+	res = db.Model(&WaPoll{}).Where("id = ?", vote.PollId).Update("results_stale", true)
+
+	return res.Error
+}
+
+func WaPollGetStale() ([]WaPoll, error) {
+	db := state.State.Database
+
+	var polls []WaPoll
+	res := db.Where("results_stale = ?", true).Find(&polls)
+
+	return polls, res.Error
+}
+
+func WaPollVoteGetAll(pollId string) ([]WaPollVote, error) {
+	db := state.State.Database
+
+	var votes []WaPollVote
+	res := db.Where("poll_id = ?", pollId).Order("voted_at").Find(&votes)
+
+	return votes, res.Error
+}
+
+func DataMigrationApplied(id string) (bool, error) {
+	db := state.State.Database
+
+	var count int64
+	res := db.Model(&DataMigration{}).Where("id = ?", id).Count(&count)
+
+	return count > 0, res.Error
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	latencyStartedKey = "watgbridge:started_at"
+	latencyWindow     = 256
+)
+
+type latencyTracker struct {
+	lock   sync.Mutex
+	count  int64
+	total  time.Duration
+	recent [latencyWindow]time.Duration
+}
+
+var latency = &latencyTracker{}
+
+type LatencyReport struct {
+	Operations       int64
+	Average          time.Duration
+	RecentOperations int64 // Up to the last 256, which the recent values are of
+	RecentAverage    time.Duration
+	RecentSlowest    time.Duration
+	PendingWrites    int
+}
+
+// registerLatencyCallbacks times every statement run through db, which
+// includes the ones of the write batches
+func registerLatencyCallbacks(db *gorm.DB) error {
+	start := func(tx *gorm.DB) {
+		tx.InstanceSet(latencyStartedKey, time.Now())
+	}
+	end := func(tx *gorm.DB) {
+		if started, found := tx.InstanceGet(latencyStartedKey); found {
+			latency.record(time.Since(started.(time.Time)))
+		}
+	}
+
+	var (
+		callbacks = db.Callback()
+		startName = "watgbridge:latency_start"
+		endName   = "watgbridge:latency_end"
+	)
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register(startName, start),
+		callbacks.Create().After("gorm:create").Register(endName, end),
+		callbacks.Query().Before("gorm:query").Register(startName, start),
+		callbacks.Query().After("gorm:query").Register(endName, end),
+		callbacks.Update().Before("gorm:update").Register(startName, start),
+		callbacks.Update().After("gorm:update").Register(endName, end),
+		callbacks.Delete().Before("gorm:delete").Register(startName, start),
+		callbacks.Delete().After("gorm:delete").Register(endName, end),
+		callbacks.Row().Before("gorm:row").Register(startName, start),
+		callbacks.Row().After("gorm:row").Register(endName, end),
+		callbacks.Raw().Before("gorm:raw").Register(startName, start),
+		callbacks.Raw().After("gorm:raw").Register(endName, end),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *latencyTracker) record(d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.recent[t.count%latencyWindow] = d
+	t.count += 1
+	t.total += d
+}
+
+func LatencyGetReport() LatencyReport {
+	latency.lock.Lock()
+	report := LatencyReport{Operations: latency.count}
+	if latency.count > 0 {
+		report.Average = latency.total / time.Duration(latency.count)
+
+		report.RecentOperations = latency.count
+		if report.RecentOperations > latencyWindow {
+			report.RecentOperations = latencyWindow
+		}
+		var recentTotal time.Duration
+		for _, d := range latency.recent[:report.RecentOperations] {
+			recentTotal += d
+			if d > report.RecentSlowest {
+				report.RecentSlowest = d
+			}
+		}
+		report.RecentAverage = recentTotal / time.Duration(report.RecentOperations)
+	}
+	latency.lock.Unlock()
+
+	report.PendingWrites = MsgIdPendingCount()
+
+	return report
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"reflect"
+	"time"
+
+	"watgbridge/state"
+
+	"gorm.io/gorm"
+)
+
+// normalizeJIDsMigration marks the JIDs as normalized, bump it when the
+// normalization changes so that it runs again
+const normalizeJIDsMigration = "normalize_jids_v1"
+
+// jidColumns are the columns holding the JIDs of chats and senders. The
+// device of senders is kept, it tells your own devices apart.
+var jidColumns = []struct {
+	model  interface{}
+	column string
+	sender bool
+}{
+	{&MsgIdPair{}, "wa_chat_id", false},
+	{&MsgIdPair{}, "participant_id", true},
+	{&QueuedMessage{}, "wa_chat_id", false},
+	{&QueuedMessage{}, "sender_id", true},
+	{&ArchivedMessage{}, "wa_chat_id", false},
+	{&ArchivedMessage{}, "sender_id", true},
+	{&PendingDelivery{}, "wa_chat_id", false},
+	{&PendingDelivery{}, "participant", true},
+	{&InteractiveOption{}, "chat_jid", false},
+	{&InteractiveOption{}, "sender", true},
+	{&MentionNotification{}, "wa_chat_id", false},
+	{&MentionNotification{}, "sender", true},
+	{&AvatarChange{}, "wa_chat_id", false},
+	{&AvatarChange{}, "author", true},
+	{&Reminder{}, "wa_chat_id", false},
+	{&ActivityEvent{}, "wa_chat_id", false},
+	{&PendingReceipt{}, "wa_chat_id", false},
+	{&WaPoll{}, "wa_chat_id", false},
+	{&WaPoll{}, "sender_id", true},
+}
+
+// jidKeyedModels are the tables with a row per chat, keyed by its JID in
+// column along with otherKeys
+var jidKeyedModels = []struct {
+	model     interface{}
+	column    string
+	otherKeys []string
+}{
+	{&ChatThreadPair{}, "id", nil},
+	{&FanOutThreadPair{}, "id", []string{"tg_chat_id"}},
+	{&ChatEphemeralSettings{}, "id", nil},
+	{&ChatMediaPolicy{}, "id", []string{"media_type"}},
+	{&AwayModeReply{}, "id", nil},
+	{&HistoryAnchor{}, "id", nil},
+	{&PausedChat{}, "id", nil},
+	{&ChatStat{}, "id", nil},
+	{&SilentChat{}, "id", nil},
+	{&QuarantinedChat{}, "id", nil},
+	{&ChatAppState{}, "id", nil},
+	{&TopicAvatar{}, "id", nil},
+	{&ForwardableMessage{}, "wa_chat_id", []string{"id"}},
+}
+
+// NormalizeJIDs rewrites the JIDs stored in different forms for the same chat
+// or sender to the forms returned by normalizeChat and normalizeSender, once.
+// Where a chat ended up with two rows, the one stored under the normalized JID
+// is kept as it is the one being looked up. Returns the number of rows
+// changed, nothing is changed if it fails.
+func NormalizeJIDs(normalizeChat, normalizeSender func(string) string) (int64, error) {
+	db := state.State.Database
+
+	if applied, err := DataMigrationApplied(normalizeJIDsMigration); err != nil || applied {
+		return 0, err
+	}
+
+	var changed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, jidColumn := range jidColumns {
+			normalize := normalizeChat
+			if jidColumn.sender {
+				normalize = normalizeSender
+			}
+
+			var values []string
+			// Rows from before the column was added have it NULL
+			res := tx.Model(jidColumn.model).Where(jidColumn.column+" IS NOT NULL").
+				Distinct(jidColumn.column).Pluck(jidColumn.column, &values)
+			if res.Error != nil {
+				return res.Error
+			}
+
+			for _, value := range values {
+				normalized := normalize(value)
+				if normalized == value {
+					continue
+				}
+				res = tx.Model(emptyModel(jidColumn.model)).Where(jidColumn.column+" = ?", value).Update(jidColumn.column, normalized)
+				if res.Error != nil {
+					return res.Error
+				}
+				changed += res.RowsAffected
+			}
+		}
+
+		for _, keyed := range jidKeyedModels {
+			keyedChanged, err := normalizeJIDKey(tx, keyed.model, keyed.column, keyed.otherKeys, normalizeChat)
+			if err != nil {
+				return err
+			}
+			changed += keyedChanged
+		}
+
+		return tx.Create(&DataMigration{ID: normalizeJIDsMigration, AppliedAt: time.Now().UTC()}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// normalizeJIDKey normalizes the JIDs in column, part of the primary key
+// along with otherKeys, dropping the rows which already exist under the
+// normalized JID
+func normalizeJIDKey(tx *gorm.DB, model interface{}, column string, otherKeys []string, normalize func(string) string) (int64, error) {
+	var values []string
+	res := tx.Model(model).Where(column+" IS NOT NULL").Distinct(column).Pluck(column, &values)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	var changed int64
+	for _, value := range values {
+		normalized := normalize(value)
+		if normalized == value {
+			continue
+		}
+
+		keys := []map[string]interface{}{{}}
+		if len(otherKeys) > 0 {
+			keys = nil
+			res = tx.Model(model).Select(otherKeys).Where(column+" = ?", value).Find(&keys)
+			if res.Error != nil {
+				return changed, res.Error
+			}
+		}
+
+		for _, key := range keys {
+			var count int64
+			res = tx.Model(model).Where(column+" = ?", normalized).Where(key).Count(&count)
+			if res.Error != nil {
+				return changed, res.Error
+			}
+			if count > 0 {
+				res = tx.Where(column+" = ?", value).Where(key).Delete(emptyModel(model))
+			} else {
+				res = tx.Model(emptyModel(model)).Where(column+" = ?", value).Where(key).Update(column, normalized)
+			}
+			if res.Error != nil {
+				return changed, res.Error
+			}
+			changed += res.RowsAffected
+		}
+	}
+	return changed, nil
+}
+
+// emptyModel returns a new model of the same type for a write. Writes fill the
+// model in and then have its primary key in the conditions of the next ones.
+func emptyModel(model interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(model).Elem()).Interface()
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	legacyJID     = "10000000002:3@c.us"
+	normalChatJID = "10000000002@s.whatsapp.net"
+	normalSender  = "10000000002:3@s.whatsapp.net"
+
+	otherLegacyJID = "10000000004@c.us"
+	otherNormalJID = "10000000004@s.whatsapp.net"
+)
+
+var testDeviceRegex = regexp.MustCompile(`:[0-9]+@`)
+
+func testNormalizeChat(jid string) string {
+	return testDeviceRegex.ReplaceAllString(testNormalizeSender(jid), "@")
+}
+
+func testNormalizeSender(jid string) string {
+	return strings.Replace(jid, "@c.us", "@s.whatsapp.net", 1)
+}
+
+var testDatabaseCount int
+
+func newTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	testDatabaseCount += 1
+	cfg := &state.Config{Path: "config.yaml"}
+	cfg.SetDefaults()
+	cfg.DatabaseTuning.WriteBatchMilliseconds = 0
+	cfg.Database = map[string]string{
+		"type": "sqlite",
+		"path": fmt.Sprintf("file:watgbridge_database_test_%d?mode=memory&cache=shared", testDatabaseCount),
+	}
+	state.State.Config = cfg
+	state.State.Logger = zap.NewNop()
+
+	db, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.State.Database = db
+	if err = AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testSchema(t *testing.T, db *gorm.DB, model interface{}) *schema.Schema {
+	t.Helper()
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		t.Fatal(err)
+	}
+	return stmt.Schema
+}
+
+// testRow returns the values of a row of the model with the primary key made
+// unique by n
+func testRow(t *testing.T, db *gorm.DB, model interface{}, n int) map[string]interface{} {
+	t.Helper()
+
+	row := map[string]interface{}{}
+	for _, field := range testSchema(t, db, model).PrimaryFields {
+		switch field.DataType {
+		case schema.String:
+			row[field.DBName] = fmt.Sprintf("row%d", n)
+		case schema.Int, schema.Uint:
+			row[field.DBName] = n
+		}
+	}
+	return row
+}
+
+func TestNormalizeJIDsColumns(t *testing.T) {
+	db := newTestDatabase(t)
+
+	for i, jidColumn := range jidColumns {
+		row := testRow(t, db, jidColumn.model, i+1)
+		row[jidColumn.column] = legacyJID
+		if err := db.Model(jidColumn.model).Create(row).Error; err != nil {
+			t.Fatalf("%T: %s", jidColumn.model, err)
+		}
+	}
+
+	changed, err := NormalizeJIDs(testNormalizeChat, testNormalizeSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != int64(len(jidColumns)) {
+		t.Errorf("changed %d rows, want %d", changed, len(jidColumns))
+	}
+
+	for _, jidColumn := range jidColumns {
+		want := normalChatJID
+		if jidColumn.sender {
+			want = normalSender
+		}
+
+		var values []string
+		db.Model(jidColumn.model).Where(jidColumn.column+" <> ''").Pluck(jidColumn.column, &values)
+		if len(values) != 1 || values[0] != want {
+			t.Errorf("%T.%s = %v, want %s", jidColumn.model, jidColumn.column, values, want)
+		}
+	}
+}
+
+func TestNormalizeJIDsKeyed(t *testing.T) {
+	t.Run("renamed", func(t *testing.T) {
+		db := newTestDatabase(t)
+
+		// Two chats, every one of them has to be renamed
+		for _, keyed := range jidKeyedModels {
+			for _, jid := range []string{legacyJID, otherLegacyJID} {
+				row := testRow(t, db, keyed.model, 1)
+				row[keyed.column] = jid
+				if err := db.Model(keyed.model).Create(row).Error; err != nil {
+					t.Fatalf("%T: %s", keyed.model, err)
+				}
+			}
+		}
+
+		changed, err := NormalizeJIDs(testNormalizeChat, testNormalizeSender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != int64(2*len(jidKeyedModels)) {
+			t.Errorf("changed %d rows, want %d", changed, 2*len(jidKeyedModels))
+		}
+
+		for _, keyed := range jidKeyedModels {
+			var values []string
+			db.Model(keyed.model).Order(keyed.column).Pluck(keyed.column, &values)
+			if strings.Join(values, ",") != normalChatJID+","+otherNormalJID {
+				t.Errorf("%T.%s = %v, want %s and %s", keyed.model, keyed.column, values, normalChatJID, otherNormalJID)
+			}
+		}
+	})
+
+	t.Run("duplicated", func(t *testing.T) {
+		db := newTestDatabase(t)
+
+		for _, keyed := range jidKeyedModels {
+			for _, jid := range []string{legacyJID, normalChatJID} {
+				row := testRow(t, db, keyed.model, 1)
+				row[keyed.column] = jid
+				if err := db.Model(keyed.model).Create(row).Error; err != nil {
+					t.Fatalf("%T: %s", keyed.model, err)
+				}
+			}
+			// Another row under the legacy JID, for the other keys
+			if len(keyed.otherKeys) > 0 {
+				row := testRow(t, db, keyed.model, 2)
+				row[keyed.column] = legacyJID
+				if err := db.Model(keyed.model).Create(row).Error; err != nil {
+					t.Fatalf("%T: %s", keyed.model, err)
+				}
+			}
+		}
+
+		if _, err := NormalizeJIDs(testNormalizeChat, testNormalizeSender); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, keyed := range jidKeyedModels {
+			want := []string{normalChatJID}
+			if len(keyed.otherKeys) > 0 {
+				want = append(want, normalChatJID)
+			}
+
+			var values []string
+			db.Model(keyed.model).Pluck(keyed.column, &values)
+			if strings.Join(values, ",") != strings.Join(want, ",") {
+				t.Errorf("%T.%s = %v, want %v", keyed.model, keyed.column, values, want)
+			}
+		}
+	})
+}
+
+func TestNormalizeJIDsOnlyOnce(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if _, err := NormalizeJIDs(testNormalizeChat, testNormalizeSender); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Create(&ChatThreadPair{ID: legacyJID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	changed, err := NormalizeJIDs(testNormalizeChat, testNormalizeSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pair ChatThreadPair
+	db.First(&pair)
+	if changed != 0 || pair.ID != legacyJID {
+		t.Errorf("ran again, changed %d rows and the chat to %s", changed, pair.ID)
+	}
+}
+
+// Every column holding the JID of a chat or sender has to be normalized, or
+// the rows of the old form are never found again
+func TestNormalizeJIDsCoversAllColumns(t *testing.T) {
+	db := newTestDatabase(t)
+
+	jidColumnNames := []string{"wa_chat_id", "chat_jid", "participant_id", "participant", "sender_id", "sender", "author"}
+
+	covered := map[string]bool{}
+	for _, jidColumn := range jidColumns {
+		covered[testSchema(t, db, jidColumn.model).Table+"."+jidColumn.column] = true
+	}
+	for _, keyed := range jidKeyedModels {
+		covered[testSchema(t, db, keyed.model).Table+"."+keyed.column] = true
+	}
+
+	for _, model := range models() {
+		modelSchema := testSchema(t, db, model)
+		for _, field := range modelSchema.Fields {
+			name := modelSchema.Table + "." + field.DBName
+			isJID := strings.HasSuffix(field.Comment, "Chat ID") // The chat keyed tables
+			for _, jidColumnName := range jidColumnNames {
+				isJID = isJID || field.DBName == jidColumnName
+			}
+			if isJID && !covered[name] {
+				t.Errorf("%s holds JIDs but isn't normalized", name)
+			}
+		}
+	}
+}
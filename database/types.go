@@ -2,8 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"watgbridge/state"
+
+	"gorm.io/gorm"
 )
 
 type MsgIdPair struct {
@@ -18,6 +21,7 @@ type MsgIdPair struct {
 	TgMsgId    int64
 
 	MarkRead sql.NullBool
+	Combined bool `gorm:"not null;default:false"` // Sent in TgMsgId with later texts, lookups from Telegram find the last of them
 }
 
 type ChatThreadPair struct {
@@ -26,12 +30,68 @@ type ChatThreadPair struct {
 	TgThreadId int64  // Telegram Thread ID (Topics)
 }
 
+// FanOutThreadPair is the topic of a WhatsApp chat in one of the fan_out
+// destinations, kept apart from the topics of the target chat
+type FanOutThreadPair struct {
+	ID         string `gorm:"primaryKey;"`                     // WhatsApp Chat ID
+	TgChatId   int64  `gorm:"primaryKey;autoIncrement:false;"` // Destination chat
+	TgThreadId int64
+}
+
+// FanOutMessage is the copy in a fan_out destination of a message bridged to
+// the target chat
+type FanOutMessage struct {
+	TgMsgId    int64 `gorm:"primaryKey;autoIncrement:false;"` // Message in the target chat
+	DestChatId int64 `gorm:"primaryKey;autoIncrement:false;"`
+	DestMsgId  int64
+}
+
+// PendingReceipt is a message sent from Telegram whose delivery receipt hasn't
+// arrived from WhatsApp yet
+type PendingReceipt struct {
+	ID           string `gorm:"primaryKey;"` // WhatsApp Message ID
+	WaChatId     string // Chat JID
+	TgChatId     int64  // Where the message was sent from
+	TgThreadId   int64
+	TgMsgId      int64
+	WarningMsgId int64     // Reply saying the message wasn't delivered, once posted
+	SentAt       time.Time `gorm:"index"`
+}
+
+// WaPoll is a WhatsApp poll bridged to Telegram, kept to vote on it from
+// Telegram and to count the votes
+type WaPoll struct {
+	ID              string `gorm:"primaryKey;"` // WhatsApp Message ID
+	WaChatId        string // Chat JID
+	SenderId        string // Sender JID
+	IsFromMe        bool
+	Name            string
+	Options         []byte // JSON of the names of the options
+	SelectableCount uint32 // 0 if any number of options can be chosen
+	TgPollId        string `gorm:"index"` // Empty if the poll was bridged as text
+	TgChatId        int64
+	TgThreadId      int64
+	TgMsgId         int64
+	ResultsMsgId    int64 // Message with the results, once posted
+	ResultsStale    bool  `gorm:"index"` // Votes changed since the results were posted
+	CreatedAt       time.Time
+}
+
+// WaPollVote is the latest vote of someone on a poll
+type WaPollVote struct {
+	PollId  string `gorm:"primaryKey;"` // WhatsApp Message ID of the poll
+	VoterId string `gorm:"primaryKey;"` // Voter JID
+	Options []byte // JSON of the names of the chosen options, empty if the vote was retracted
+	VotedAt time.Time
+}
+
 type ContactName struct {
 	ID           string `gorm:"primaryKey;"` // WhatsApp Contact JID
 	FirstName    string
 	FullName     string
 	PushName     string
 	BusinessName string
+	LID          string `gorm:"column:lid;index"` // User of the LID (hidden user JID) of the contact, if known
 }
 
 type ChatEphemeralSettings struct {
@@ -40,12 +100,325 @@ type ChatEphemeralSettings struct {
 	EphemeralTimer uint32
 }
 
-func AutoMigrate() error {
-	db := state.State.Database
-	return db.AutoMigrate(
+type AwayModeSettings struct {
+	ID      uint `gorm:"primaryKey;"`
+	Enabled bool
+	Message string
+}
+
+type AwayModeReply struct {
+	ID          string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	LastReplied time.Time
+}
+
+type AlertRule struct {
+	ID      uint `gorm:"primaryKey;"`
+	Pattern string
+}
+
+type ChatMediaPolicy struct {
+	ID        string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	MediaType string `gorm:"primaryKey;"`
+	Skip      bool
+}
+
+type QueuedMessage struct {
+	ID        uint   `gorm:"primaryKey;"`
+	WaChatId  string // Chat JID
+	SenderId  string // Sender JID
+	MsgId     string
+	PushName  string
+	IsGroup   bool
+	IsEdited  bool
+	Text      string // Encrypted and base64 encoded if a key is configured
+	Message   []byte // Serialized WhatsApp message, encrypted if a key is configured
+	Timestamp time.Time
+	Info      []byte // JSON encoded message info, used to replay the message
+	Encrypted bool   // Whether Text and Message are encrypted
+}
+
+type ArchivedMessage struct {
+	ID            uint   `gorm:"primaryKey;"`
+	WaMsgId       string `gorm:"index"`
+	WaChatId      string `gorm:"index"` // Chat JID
+	SenderId      string // Sender JID
+	SenderName    string
+	IsFromMe      bool
+	Body          []byte // Message text, encrypted if a key is configured
+	MediaType     string
+	MediaMimetype string
+	MediaFileName string // Encrypted and base64 encoded if a key is configured
+	MediaSize     uint64
+	Timestamp     time.Time `gorm:"index"`
+	EditedAt      sql.NullTime
+	Revoked       bool
+	Starred       bool `gorm:"index"`
+	Encrypted     bool // Whether Body and MediaFileName are encrypted
+}
+
+type MessageSearchToken struct {
+	Token             string `gorm:"primaryKey;"`
+	ArchivedMessageID uint   `gorm:"primaryKey;"`
+}
+
+type PendingDelivery struct {
+	ID           uint   `gorm:"primaryKey;"`
+	WaChatId     string // Chat JID
+	Context      []byte // JSON of the Telegram message that triggered the send
+	MsgToForward []byte // JSON of the Telegram message to send
+	MsgToReplyTo []byte // JSON of the Telegram message being replied to, if any
+	Participant  string
+	StanzaId     string
+	IsReply      bool
+	CreatedAt    time.Time
+	Attempts     int // Failed attempts to send it
+}
+
+type StoredMedia struct {
+	ID        string `gorm:"primaryKey;"` // SHA-256 of the content
+	Path      string
+	Mimetype  string
+	Size      int64
+	CreatedAt time.Time
+	LastUsed  time.Time `gorm:"index"`
+}
+
+type HistoryAnchor struct {
+	ID        string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	MsgId     string // Oldest known message of the chat
+	IsFromMe  bool
+	Timestamp time.Time
+}
+
+type HealthCheck struct {
+	ID        uint `gorm:"primaryKey;"`
+	CheckedAt time.Time
+}
+
+// ShutdownMarker is left by a graceful shutdown, it is not there after a crash
+type ShutdownMarker struct {
+	ID         uint `gorm:"primaryKey;"`
+	ShutdownAt time.Time
+}
+
+type InteractiveOption struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement;"`
+	MsgId       string // WhatsApp Message ID of the buttons/list/template message
+	ChatJid     string
+	Sender      string
+	Kind        string
+	OptionId    string
+	DisplayText string
+	Index       uint32
+}
+
+type GroupInvite struct {
+	ID         string `gorm:"primaryKey;"` // WhatsApp Message ID of the invite
+	GroupJid   string
+	Inviter    string
+	Code       string
+	Expiration int64
+}
+
+type MentionNotification struct {
+	TgChatId int64  `gorm:"primaryKey;autoIncrement:false;"` // Where the notification was sent
+	TgMsgId  int64  `gorm:"primaryKey;autoIncrement:false;"`
+	WaMsgId  string // WhatsApp Message ID of the message mentioning you
+	WaChatId string
+	Sender   string
+}
+
+type AvatarChange struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement;"`
+	WaChatId  string `gorm:"index"` // Chat JID of the contact or group
+	Author    string // Who changed the picture of a group
+	MediaHash string // SHA-256 of the picture in the media store, empty if it was removed or not stored
+	Removed   bool
+	ChangedAt time.Time
+}
+
+type PausedChat struct {
+	ID       string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	PausedAt time.Time
+}
+
+// ChatStat holds the decryption and media download counters of a chat
+type ChatStat struct {
+	ID             string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	Undecryptable  int64
+	Unavailable    int64
+	RetryRecovered int64
+	MediaDownloads int64
+	MediaFailures  int64
+	MediaStoreHits int64     // Media loaded from the media store instead of downloaded
+	Since          time.Time // When the first counter was recorded
+}
+
+type SilentChat struct {
+	ID         string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	SilencedAt time.Time
+}
+
+type Reminder struct {
+	ID        uint `gorm:"primaryKey;"`
+	Cron      string
+	WaChatId  string // Chat JID
+	Text      string
+	CreatedAt time.Time
+}
+
+const (
+	QuarantinePending = "pending"
+	QuarantineIgnored = "ignored"
+)
+
+type QuarantinedChat struct {
+	ID        string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	Status    string
+	FirstSeen time.Time
+}
+
+// ChatAppState is the archived, pinned and muted state of a chat as last
+// synced from the phone
+type ChatAppState struct {
+	ID         string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	Archived   bool
+	Pinned     bool
+	Muted      bool
+	MutedUntil time.Time // Zero if muted until unmuted
+}
+
+type TopicAvatar struct {
+	ID       string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	TgChatId int64
+	TgMsgId  int64 // Pinned message with the profile picture
+}
+
+type CannedReply struct {
+	ID   string `gorm:"primaryKey;"` // Shortcut used with /c
+	Text string
+}
+
+type ForwardableMessage struct {
+	ID        string    `gorm:"primaryKey;"` // Message ID
+	WaChatId  string    `gorm:"primaryKey;"` // Chat JID
+	Message   []byte    // Serialized WhatsApp message, encrypted if a key is configured
+	CreatedAt time.Time `gorm:"index"`
+	Encrypted bool      // Whether Message is encrypted
+}
+
+const (
+	ActivityMessage      = "message"
+	ActivityNewChat      = "new_chat"
+	ActivityMissedCall   = "missed_call"
+	ActivityFailure      = "failure"
+	ActivityMediaSkipped = "media_skipped"
+	ActivitySpamHeld     = "spam_held"
+	ActivitySpamBlocked  = "spam_blocked"
+)
+
+// ActivityEvent counts the events of a kind in a chat, they are aggregated in
+// memory and written once per flush rather than once per event
+type ActivityEvent struct {
+	ID        uint   `gorm:"primaryKey;"`
+	Kind      string `gorm:"index"`
+	WaChatId  string // Chat JID
+	Count     int64  `gorm:"default:1"`
+	Size      int64  // Size of the largest media for skipped media
+	Timestamp time.Time
+}
+
+// DataMigration is a one-shot rewrite of the stored data which was done
+type DataMigration struct {
+	ID        string `gorm:"primaryKey;"` // Name and version of the rewrite
+	AppliedAt time.Time
+}
+
+// models are all the tables of the bridge, in the order they are migrated
+func models() []interface{} {
+	return []interface{}{
 		&MsgIdPair{},
 		&ChatThreadPair{},
 		&ContactName{},
 		&ChatEphemeralSettings{},
-	)
+		&AwayModeSettings{},
+		&AwayModeReply{},
+		&AlertRule{},
+		&ChatMediaPolicy{},
+		&QueuedMessage{},
+		&ActivityEvent{},
+		&ArchivedMessage{},
+		&MessageSearchToken{},
+		&PendingDelivery{},
+		&StoredMedia{},
+		&HistoryAnchor{},
+		&HealthCheck{},
+		&ShutdownMarker{},
+		&GroupInvite{},
+		&InteractiveOption{},
+		&MentionNotification{},
+		&AvatarChange{},
+		&PausedChat{},
+		&ChatStat{},
+		&ForwardableMessage{},
+		&Reminder{},
+		&CannedReply{},
+		&TopicAvatar{},
+		&QuarantinedChat{},
+		&ChatAppState{},
+		&SilentChat{},
+		&FanOutThreadPair{},
+		&FanOutMessage{},
+		&PendingReceipt{},
+		&WaPoll{},
+		&WaPollVote{},
+		&DataMigration{},
+	}
+}
+
+func AutoMigrate() error {
+	db := state.State.Database
+	return db.AutoMigrate(models()...)
+}
+
+// MissingTables returns the names of the tables that don't exist yet and will
+// be created by AutoMigrate
+func MissingTables() ([]string, error) {
+	db := state.State.Database
+
+	var missing []string
+	for _, model := range models() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if !db.Migrator().HasTable(stmt.Schema.Table) {
+			missing = append(missing, stmt.Schema.Table)
+		}
+	}
+	return missing, nil
+}
+
+// PendingMigrations returns the tables and columns, as table.column, that
+// AutoMigrate would create. Nothing is changed in the database.
+func PendingMigrations() ([]string, error) {
+	db := state.State.Database
+
+	var pending []string
+	for _, model := range models() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if !db.Migrator().HasTable(stmt.Schema.Table) {
+			pending = append(pending, stmt.Schema.Table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				pending = append(pending, stmt.Schema.Table+"."+field.DBName)
+			}
+		}
+	}
+	return pending, nil
 }
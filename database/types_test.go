@@ -0,0 +1,34 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if pending, err := PendingMigrations(); err != nil {
+		t.Fatal(err)
+	} else if len(pending) != 0 {
+		t.Fatalf("pending migrations after AutoMigrate: %v", pending)
+	}
+
+	if err := db.Migrator().DropColumn(&Reminder{}, "Text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrator().DropTable(&CannedReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"reminders.text", "canned_replies"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("PendingMigrations() = %v, want %v", pending, want)
+	}
+	if db.Migrator().HasTable(&CannedReply{}) {
+		t.Error("PendingMigrations created a table")
+	}
+}
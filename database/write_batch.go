@@ -0,0 +1,154 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+// msgIdBatch holds the message ID pairs which were not written yet, so that
+// every bridged message doesn't have to wait for its own write. The pairs
+// being written are kept in writing until they are in the database, so
+// lookups never miss a pair in between.
+type msgIdBatch struct {
+	lock    sync.Mutex
+	pending map[string]MsgIdPair
+	writing map[string]MsgIdPair
+	timer   *time.Timer
+
+	writeLock sync.Mutex // Held while a batch is written, one at a time
+}
+
+var msgIdPending = &msgIdBatch{
+	pending: make(map[string]MsgIdPair),
+}
+
+// msgIdQueuePair adds the pair to the next batch, returns false if batching is
+// disabled and the pair has to be written right away
+func msgIdQueuePair(pair MsgIdPair) (bool, error) {
+	cfg := state.State.Config.DatabaseTuning
+	if cfg.WriteBatchMilliseconds <= 0 {
+		return false, nil
+	}
+
+	msgIdPending.lock.Lock()
+	msgIdPending.pending[pair.ID] = pair
+	isFull := cfg.WriteBatchSize > 0 && len(msgIdPending.pending) >= cfg.WriteBatchSize
+	if !isFull {
+		msgIdPending.schedule()
+	}
+	msgIdPending.lock.Unlock()
+
+	if isFull {
+		// A failed batch is kept for the next one, the pair isn't lost
+		_ = msgIdPending.flush()
+	}
+	return true, nil
+}
+
+// schedule starts the timer of the next batch if it isn't running, the lock
+// must be held
+func (b *msgIdBatch) schedule() {
+	if b.timer != nil {
+		return
+	}
+	interval := time.Duration(state.State.Config.DatabaseTuning.WriteBatchMilliseconds) * time.Millisecond
+	b.timer = time.AfterFunc(interval, func() {
+		_ = b.flush()
+	})
+}
+
+// flush writes all the pending pairs. If the write fails they are logged and
+// put back to be written with the next batch, unless newer pairs replaced
+// them in the meantime.
+func (b *msgIdBatch) flush() error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	b.lock.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		b.lock.Unlock()
+		return nil
+	}
+	b.writing, b.pending = b.pending, make(map[string]MsgIdPair)
+	pairs := make([]MsgIdPair, 0, len(b.writing))
+	for _, pair := range b.writing {
+		pairs = append(pairs, pair)
+	}
+	b.lock.Unlock()
+
+	err := state.State.Database.Clauses(clause.OnConflict{UpdateAll: true}).Create(&pairs).Error
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err != nil {
+		for id, pair := range b.writing {
+			if _, replaced := b.pending[id]; !replaced {
+				b.pending[id] = pair
+			}
+		}
+		if state.State.Config.DatabaseTuning.WriteBatchMilliseconds > 0 {
+			b.schedule()
+		}
+
+		logger := state.State.Logger
+		logger.Error("failed to write batch of message id pairs, keeping them for the next one",
+			zap.Int("count", len(pairs)),
+			zap.Error(err),
+		)
+		_ = logger.Sync()
+	}
+	b.writing = nil
+	return err
+}
+
+func (b *msgIdBatch) getByWa(waMsgId, waChatId string) (MsgIdPair, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	pair, found := b.pending[waMsgId]
+	if !found {
+		pair, found = b.writing[waMsgId]
+	}
+	if !found || pair.WaChatId != waChatId {
+		return MsgIdPair{}, false
+	}
+	return pair, true
+}
+
+func (b *msgIdBatch) getByTg(tgChatId, tgMsgId, tgThreadId int64, anyThread bool) (MsgIdPair, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, pairs := range []map[string]MsgIdPair{b.pending, b.writing} {
+		for _, pair := range pairs {
+			if pair.TgChatId == tgChatId && pair.TgMsgId == tgMsgId && !pair.Combined &&
+				(anyThread || pair.TgThreadId == tgThreadId) {
+				return pair, true
+			}
+		}
+	}
+	return MsgIdPair{}, false
+}
+
+// MsgIdFlushPending writes the message ID pairs waiting for their batch, it is
+// also called before the queries which can't look into the pending ones
+func MsgIdFlushPending() error {
+	return msgIdPending.flush()
+}
+
+func MsgIdPendingCount() int {
+	msgIdPending.lock.Lock()
+	defer msgIdPending.lock.Unlock()
+
+	return len(msgIdPending.pending) + len(msgIdPending.writing)
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+
+	"watgbridge/state"
+)
+
+func TestMsgIdBatchKeepsFailedWrites(t *testing.T) {
+	db := newTestDatabase(t)
+	// Only written when flushed
+	state.State.Config.DatabaseTuning.WriteBatchMilliseconds = 60 * 1000
+	state.State.Config.DatabaseTuning.WriteBatchSize = 0
+
+	if err := MsgIdAddNewPair("WAMSG", "10000000002@s.whatsapp.net", "10000000002@s.whatsapp.net", -100, 5, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write fails while the table is gone
+	if err := db.Migrator().RenameTable(&MsgIdPair{}, "msg_id_pairs_away"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MsgIdFlushPending(); err == nil {
+		t.Fatal("flushed without the table")
+	}
+	if count := MsgIdPendingCount(); count != 1 {
+		t.Errorf("%d pairs pending after the failed write, want 1", count)
+	}
+	if _, _, tgMsgId, _ := MsgIdGetTgFromWa("WAMSG", "10000000002@s.whatsapp.net"); tgMsgId != 5 {
+		t.Errorf("pair not found after the failed write")
+	}
+
+	if err := db.Migrator().RenameTable("msg_id_pairs_away", &MsgIdPair{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := MsgIdFlushPending(); err != nil {
+		t.Fatal(err)
+	}
+	if count := MsgIdPendingCount(); count != 0 {
+		t.Errorf("%d pairs pending after the write, want 0", count)
+	}
+
+	var pair MsgIdPair
+	if err := db.Where("id = ?", "WAMSG").First(&pair).Error; err != nil || pair.TgMsgId != 5 {
+		t.Errorf("pair written as %+v (%v)", pair, err)
+	}
+}
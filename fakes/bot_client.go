@@ -0,0 +1,71 @@
+package fakes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+var _ gotgbot.BotClient = (*Telegram)(nil)
+
+// RequestWithContext answers the Bot API calls the Telegram handlers make
+// through a *gotgbot.Bot, recording them like the other calls. Files are only
+// known if they were put into Files.
+func (f *Telegram) RequestWithContext(ctx context.Context, token string, method string, params map[string]string, data map[string]gotgbot.NamedReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	switch method {
+	case "getFile":
+		file, err := f.GetFile(params["file_id"], nil)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(file)
+
+	case "answerCallbackQuery", "sendChatAction", "setMessageReaction":
+		return json.RawMessage("true"), nil
+	}
+
+	sent := TelegramSent{
+		Method:    method,
+		ChatId:    paramInt(params, "chat_id"),
+		ThreadId:  paramInt(params, "message_thread_id"),
+		ReplyTo:   paramInt(params, "reply_to_message_id"),
+		Text:      params["text"],
+		MessageId: paramInt(params, "message_id"),
+	}
+	if sent.Text == "" {
+		sent.Text = params["caption"]
+	}
+	for _, named := range data {
+		sent.File = gotgbot.NamedFile{FileName: named.Name()}
+	}
+
+	msg, err := f.record(sent)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(method, "send") && !strings.HasPrefix(method, "edit") {
+		return json.RawMessage("true"), nil
+	}
+	return json.Marshal(msg)
+}
+
+func (f *Telegram) TimeoutContext(opts *gotgbot.RequestOpts) (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.Background())
+}
+
+func (f *Telegram) GetAPIURL(opts *gotgbot.RequestOpts) string {
+	return gotgbot.DefaultAPIURL
+}
+
+func (f *Telegram) FileURL(token string, tgFilePath string, opts *gotgbot.RequestOpts) string {
+	return fmt.Sprintf("%s/file/bot%s/%s", gotgbot.DefaultAPIURL, token, tgFilePath)
+}
+
+func paramInt(params map[string]string, key string) int64 {
+	value, _ := strconv.ParseInt(params[key], 10, 64)
+	return value
+}
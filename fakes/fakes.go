@@ -0,0 +1,447 @@
+// Package fakes provides in-memory implementations of the WhatsApp and
+// Telegram client interfaces from the state package, which record everything
+// sent through them instead of talking to the real services.
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ state.WhatsAppAPI = (*WhatsApp)(nil)
+	_ state.TelegramAPI = (*Telegram)(nil)
+)
+
+type WhatsAppSent struct {
+	To      types.JID
+	ID      types.MessageID
+	Message *waProto.Message
+}
+
+type WhatsApp struct {
+	lock   sync.Mutex
+	nextId int
+
+	OwnID      types.JID // The account the revokes and edits are built for
+	Sent       []WhatsAppSent
+	Media      map[string][]byte // Download results keyed by the direct path
+	Groups     map[types.JID]*types.GroupInfo
+	GroupPhoto map[types.JID][]byte
+	Err        error // Returned by every call if set
+}
+
+func NewWhatsApp() *WhatsApp {
+	return &WhatsApp{
+		Media:      make(map[string][]byte),
+		Groups:     make(map[types.JID]*types.GroupInfo),
+		GroupPhoto: make(map[types.JID][]byte),
+	}
+}
+
+func (f *WhatsApp) SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return whatsmeow.SendResponse{}, f.Err
+	}
+
+	f.nextId += 1
+	id := fmt.Sprintf("FAKE%016X", f.nextId)
+	f.Sent = append(f.Sent, WhatsAppSent{To: to, ID: id, Message: message})
+	return whatsmeow.SendResponse{ID: id, Timestamp: time.Now()}, nil
+}
+
+// messageKey builds the key of a message like whatsmeow does, from the point
+// of view of OwnID
+func (f *WhatsApp) messageKey(chat, sender types.JID, id types.MessageID) *waProto.MessageKey {
+	key := &waProto.MessageKey{
+		FromMe:    proto.Bool(true),
+		Id:        proto.String(id),
+		RemoteJid: proto.String(chat.String()),
+	}
+	if !sender.IsEmpty() && sender.User != f.OwnID.User {
+		key.FromMe = proto.Bool(false)
+		if chat.Server != types.DefaultUserServer {
+			key.Participant = proto.String(sender.ToNonAD().String())
+		}
+	}
+	return key
+}
+
+func (f *WhatsApp) BuildRevoke(chat, sender types.JID, id types.MessageID) *waProto.Message {
+	return &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key:  f.messageKey(chat, sender, id),
+		},
+	}
+}
+
+func (f *WhatsApp) BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message {
+	return &waProto.Message{
+		EditedMessage: &waProto.FutureProofMessage{
+			Message: &waProto.Message{
+				ProtocolMessage: &waProto.ProtocolMessage{
+					Key:           f.messageKey(chat, types.EmptyJID, id),
+					Type:          waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+					EditedMessage: newContent,
+					TimestampMs:   proto.Int64(time.Now().UnixMilli()),
+				},
+			},
+		},
+	}
+}
+
+func (f *WhatsApp) Download(msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	data, found := f.Media[msg.GetDirectPath()]
+	if !found {
+		return nil, whatsmeow.ErrNoURLPresent
+	}
+	return data, nil
+}
+
+func (f *WhatsApp) Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return whatsmeow.UploadResponse{}, f.Err
+	}
+
+	path := fmt.Sprintf("/fake/%s/%d", appInfo, len(f.Media))
+	f.Media[path] = plaintext
+	return whatsmeow.UploadResponse{URL: "https://mmg.whatsapp.net" + path, DirectPath: path, FileLength: uint64(len(plaintext))}, nil
+}
+
+func (f *WhatsApp) MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	return f.Err
+}
+
+func (f *WhatsApp) SendPresence(state types.Presence) error {
+	return f.Err
+}
+
+func (f *WhatsApp) GetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if info, found := f.Groups[jid]; found {
+		return info, nil
+	}
+	return nil, whatsmeow.ErrGroupNotFound
+}
+
+func (f *WhatsApp) GetProfilePictureInfo(jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	return nil, whatsmeow.ErrProfilePictureNotSet
+}
+
+func (f *WhatsApp) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return "", f.Err
+	}
+	f.GroupPhoto[jid] = avatar
+	return fmt.Sprintf("%d", len(f.GroupPhoto)), nil
+}
+
+type TelegramSent struct {
+	Method   string
+	ChatId   int64
+	ThreadId int64
+	ReplyTo  int64
+	Text     string
+	File     gotgbot.InputFile
+
+	MessageId int64 // The message edited, deleted, pinned or unpinned
+	SentId    int64 // The ID the message was given
+}
+
+type Telegram struct {
+	lock       sync.Mutex
+	nextId     int64
+	nextThread int64
+
+	Sent   []TelegramSent
+	Topics map[int64]string // Created forum topics keyed by thread ID
+	Files  map[string]*gotgbot.File
+	Err    error // Returned by every call if set
+}
+
+func NewTelegram() *Telegram {
+	return &Telegram{
+		Topics: make(map[int64]string),
+		Files:  make(map[string]*gotgbot.File),
+	}
+}
+
+// SentCopy returns a copy of Sent, for reading it while calls are still made
+// in the background
+func (f *Telegram) SentCopy() []TelegramSent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]TelegramSent(nil), f.Sent...)
+}
+
+func (f *Telegram) record(sent TelegramSent) (*gotgbot.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.nextId += 1
+	sent.SentId = f.nextId
+	f.Sent = append(f.Sent, sent)
+	msg := &gotgbot.Message{
+		MessageId:       f.nextId,
+		MessageThreadId: sent.ThreadId,
+		Chat:            gotgbot.Chat{Id: sent.ChatId},
+		Date:            time.Now().Unix(),
+		Text:            sent.Text,
+	}
+	if sent.ReplyTo != 0 {
+		msg.ReplyToMessage = &gotgbot.Message{MessageId: sent.ReplyTo, Chat: msg.Chat}
+	}
+	return msg, nil
+}
+
+func (f *Telegram) SendMessage(chatId int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendMessage", ChatId: chatId, Text: text}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendPhoto(chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendPhoto", ChatId: chatId, File: photo}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo, sent.Text = opts.MessageThreadId, opts.ReplyToMessageId, opts.Caption
+	}
+	msg, err := f.record(sent)
+	if err != nil {
+		return nil, err
+	}
+	msg.Caption, msg.Text = msg.Text, ""
+	msg.Photo = []gotgbot.PhotoSize{{FileId: fmt.Sprintf("PHOTO%d", msg.MessageId)}}
+	return msg, nil
+}
+
+func (f *Telegram) SendMediaGroup(chatId int64, media []gotgbot.InputMedia, opts *gotgbot.SendMediaGroupOpts) ([]gotgbot.Message, error) {
+	var sentMsgs []gotgbot.Message
+	for _, item := range media {
+		sent := TelegramSent{Method: "sendMediaGroup", ChatId: chatId, File: item.GetMedia()}
+		if opts != nil {
+			sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+		}
+		photo, isPhoto := item.(gotgbot.InputMediaPhoto)
+		if isPhoto {
+			sent.Text = photo.Caption
+		}
+		sentMsg, err := f.record(sent)
+		if err != nil {
+			return nil, err
+		}
+		if isPhoto {
+			sentMsg.Photo = []gotgbot.PhotoSize{{FileId: fmt.Sprintf("photo%d", sentMsg.MessageId)}}
+			sentMsg.Caption = photo.Caption
+		}
+		sentMsgs = append(sentMsgs, *sentMsg)
+	}
+	return sentMsgs, nil
+}
+
+func (f *Telegram) SendVideo(chatId int64, video gotgbot.InputFile, opts *gotgbot.SendVideoOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendVideo", ChatId: chatId, File: video}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo, sent.Text = opts.MessageThreadId, opts.ReplyToMessageId, opts.Caption
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendVideoNote(chatId int64, videoNote gotgbot.InputFile, opts *gotgbot.SendVideoNoteOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendVideoNote", ChatId: chatId, File: videoNote}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendAnimation(chatId int64, animation gotgbot.InputFile, opts *gotgbot.SendAnimationOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendAnimation", ChatId: chatId, File: animation}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo, sent.Text = opts.MessageThreadId, opts.ReplyToMessageId, opts.Caption
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendAudio(chatId int64, audio gotgbot.InputFile, opts *gotgbot.SendAudioOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendAudio", ChatId: chatId, File: audio}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo, sent.Text = opts.MessageThreadId, opts.ReplyToMessageId, opts.Caption
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendDocument(chatId int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendDocument", ChatId: chatId, File: document}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo, sent.Text = opts.MessageThreadId, opts.ReplyToMessageId, opts.Caption
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendSticker(chatId int64, sticker gotgbot.InputFile, opts *gotgbot.SendStickerOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendSticker", ChatId: chatId, File: sticker}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendContact(chatId int64, phoneNumber string, firstName string, opts *gotgbot.SendContactOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendContact", ChatId: chatId, Text: firstName + " " + phoneNumber}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendLocation(chatId int64, latitude float64, longitude float64, opts *gotgbot.SendLocationOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendLocation", ChatId: chatId, Text: fmt.Sprintf("%f,%f", latitude, longitude)}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	return f.record(sent)
+}
+
+func (f *Telegram) SendPoll(chatId int64, question string, options []string, opts *gotgbot.SendPollOpts) (*gotgbot.Message, error) {
+	sent := TelegramSent{Method: "sendPoll", ChatId: chatId, Text: question + "\n" + strings.Join(options, "\n")}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	msg, err := f.record(sent)
+	if err != nil {
+		return nil, err
+	}
+	msg.Poll = &gotgbot.Poll{Id: fmt.Sprintf("poll%d", msg.MessageId), Question: question}
+	return msg, nil
+}
+
+func (f *Telegram) CopyMessage(chatId int64, fromChatId int64, messageId int64, opts *gotgbot.CopyMessageOpts) (*gotgbot.MessageId, error) {
+	sent := TelegramSent{Method: "copyMessage", ChatId: chatId, Text: fmt.Sprintf("%d:%d", fromChatId, messageId)}
+	if opts != nil {
+		sent.ThreadId, sent.ReplyTo = opts.MessageThreadId, opts.ReplyToMessageId
+	}
+	msg, err := f.record(sent)
+	if err != nil {
+		return nil, err
+	}
+	return &gotgbot.MessageId{MessageId: msg.MessageId}, nil
+}
+
+func (f *Telegram) EditMessageText(text string, opts *gotgbot.EditMessageTextOpts) (*gotgbot.Message, bool, error) {
+	sent := TelegramSent{Method: "editMessageText", Text: text}
+	if opts != nil {
+		sent.ChatId, sent.MessageId = opts.ChatId, opts.MessageId
+	}
+	msg, err := f.record(sent)
+	return msg, err == nil, err
+}
+
+func (f *Telegram) EditMessageCaption(opts *gotgbot.EditMessageCaptionOpts) (*gotgbot.Message, bool, error) {
+	sent := TelegramSent{Method: "editMessageCaption"}
+	if opts != nil {
+		sent.ChatId, sent.MessageId, sent.Text = opts.ChatId, opts.MessageId, opts.Caption
+	}
+	msg, err := f.record(sent)
+	return msg, err == nil, err
+}
+
+func (f *Telegram) DeleteMessage(chatId int64, messageId int64, opts *gotgbot.DeleteMessageOpts) (bool, error) {
+	_, err := f.record(TelegramSent{Method: "deleteMessage", ChatId: chatId, MessageId: messageId})
+	return err == nil, err
+}
+
+func (f *Telegram) PinChatMessage(chatId int64, messageId int64, opts *gotgbot.PinChatMessageOpts) (bool, error) {
+	_, err := f.record(TelegramSent{Method: "pinChatMessage", ChatId: chatId, MessageId: messageId})
+	return err == nil, err
+}
+
+func (f *Telegram) UnpinChatMessage(chatId int64, opts *gotgbot.UnpinChatMessageOpts) (bool, error) {
+	var messageId int64
+	if opts != nil && opts.MessageId != nil {
+		messageId = *opts.MessageId
+	}
+	_, err := f.record(TelegramSent{Method: "unpinChatMessage", ChatId: chatId, MessageId: messageId})
+	return err == nil, err
+}
+
+func (f *Telegram) CreateForumTopic(chatId int64, name string, opts *gotgbot.CreateForumTopicOpts) (*gotgbot.ForumTopic, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.nextThread += 1
+	f.Topics[f.nextThread] = name
+	return &gotgbot.ForumTopic{MessageThreadId: f.nextThread, Name: name}, nil
+}
+
+func (f *Telegram) EditForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.EditForumTopicOpts) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Err != nil {
+		return false, f.Err
+	}
+	if opts != nil && opts.Name != "" {
+		f.Topics[messageThreadId] = opts.Name
+	}
+	return true, nil
+}
+
+func (f *Telegram) CloseForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.CloseForumTopicOpts) (bool, error) {
+	_, err := f.record(TelegramSent{Method: "closeForumTopic", ChatId: chatId, ThreadId: messageThreadId})
+	return err == nil, err
+}
+
+func (f *Telegram) ReopenForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.ReopenForumTopicOpts) (bool, error) {
+	_, err := f.record(TelegramSent{Method: "reopenForumTopic", ChatId: chatId, ThreadId: messageThreadId})
+	return err == nil, err
+}
+
+func (f *Telegram) GetFile(fileId string, opts *gotgbot.GetFileOpts) (*gotgbot.File, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if file, found := f.Files[fileId]; found {
+		return file, nil
+	}
+	return nil, fmt.Errorf("file %s not found", fileId)
+}
@@ -0,0 +1,43 @@
+package fakes
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// The fake has to build the same revokes and edits as the real client, they
+// are what the tests look at
+func TestWhatsAppBuildsLikeWhatsmeow(t *testing.T) {
+	h, err := NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		contact = types.NewJID("10000000002", types.DefaultUserServer)
+		group   = types.NewJID("120363000000000001", types.GroupServer)
+	)
+	for _, tc := range []struct {
+		chat, sender types.JID
+	}{
+		{contact, types.EmptyJID},
+		{contact, HarnessOwnJID},
+		{contact, contact},
+		{group, types.EmptyJID},
+		{group, types.NewADJID(contact.User, 0, 3)},
+	} {
+		got := h.WhatsApp.BuildRevoke(tc.chat, tc.sender, "MSGID")
+		want := h.Client.BuildRevoke(tc.chat, tc.sender, "MSGID")
+		if !proto.Equal(got, want) {
+			t.Errorf("BuildRevoke(%s, %s) = %v, want %v", tc.chat, tc.sender, got, want)
+		}
+	}
+
+	got := h.WhatsApp.BuildEdit(group, "MSGID", nil).GetEditedMessage().GetMessage().GetProtocolMessage()
+	want := h.Client.BuildEdit(group, "MSGID", nil).GetEditedMessage().GetMessage().GetProtocolMessage()
+	if !proto.Equal(got.GetKey(), want.GetKey()) || got.GetType() != want.GetType() {
+		t.Errorf("BuildEdit = %v, want %v", got, want)
+	}
+}
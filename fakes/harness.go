@@ -0,0 +1,122 @@
+package fakes
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// HarnessTargetChatID is the Telegram chat the harness bridges to
+const HarnessTargetChatID int64 = -1001000000001
+
+// HarnessOwnerID is the Telegram user the harness takes commands from
+const HarnessOwnerID int64 = 1000000001
+
+// HarnessOwnJID is the WhatsApp account the harness is logged in as
+var HarnessOwnJID = types.NewJID("10000000001", types.DefaultUserServer)
+
+var harnessCount int64
+
+// Harness sets the global state up for running the bridge without the real
+// services: the default config, an in-memory database and the fake clients.
+// WhatsApp events can then be passed to whatsapp.WhatsAppEventHandler, and
+// Telegram updates to the handlers with Bot, and the resulting messages and
+// database mappings looked at.
+type Harness struct {
+	WhatsApp *WhatsApp
+	Telegram *Telegram
+
+	// Bot makes its Bot API calls to Telegram, for running the Telegram
+	// handlers with updates from HarnessOwnerID
+	Bot *gotgbot.Bot
+
+	// Client is never connected, the handlers only read the account and the
+	// contacts from its store. What they send to WhatsApp goes to WhatsApp.
+	Client *whatsmeow.Client
+}
+
+// NewHarness replaces the global state with a fresh one, every harness gets
+// its own databases
+func NewHarness() (*Harness, error) {
+	id := atomic.AddInt64(&harnessCount, 1)
+
+	cfg := &state.Config{Path: "config.yaml"}
+	cfg.SetDefaults()
+	cfg.Telegram.TargetChatID = HarnessTargetChatID
+	cfg.Telegram.OwnerID = HarnessOwnerID
+	// Everything is bridged and written right away, so that it can be looked
+	// at as soon as the handler returns
+	cfg.Telegram.AlbumWindowSeconds = 0
+	cfg.Telegram.TextBatching.WindowSeconds = 0
+	cfg.DatabaseTuning.WriteBatchMilliseconds = 0
+	cfg.Database = map[string]string{
+		"type": "sqlite",
+		"path": fmt.Sprintf("file:watgbridge_harness_%d?mode=memory&cache=shared", id),
+	}
+
+	state.State.Config = cfg
+	state.State.Logger = zap.NewNop()
+	state.State.LocalLocation = time.UTC
+	state.State.StartTime = time.Now().UTC()
+
+	db, err := database.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database : %s", err)
+	}
+	state.State.Database = db
+	if err = database.AutoMigrate(); err != nil {
+		return nil, fmt.Errorf("failed to migrate database : %s", err)
+	}
+
+	container, err := sqlstore.New("sqlite3",
+		fmt.Sprintf("file:whatsmeow_harness_%d?mode=memory&cache=shared&_foreign_keys=on", id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open whatsmeow store : %s", err)
+	}
+	device := container.NewDevice()
+	ownJID := HarnessOwnJID
+	device.ID = &ownJID
+	device.PushName = "Harness"
+
+	harness := &Harness{
+		WhatsApp: NewWhatsApp(),
+		Telegram: NewTelegram(),
+		Client:   whatsmeow.NewClient(device, nil),
+	}
+	harness.WhatsApp.OwnID = ownJID
+	harness.Bot = &gotgbot.Bot{
+		Token:     "harness",
+		User:      gotgbot.User{Id: 1000000002, IsBot: true, FirstName: "Harness", Username: "harness_bot"},
+		BotClient: harness.Telegram,
+	}
+	state.State.WhatsAppClient = harness.Client
+	state.State.WhatsAppSender = harness.WhatsApp
+	state.State.TelegramBot = harness.Bot
+	state.State.TelegramSender = harness.Telegram
+
+	return harness, nil
+}
+
+// TelegramSentTo returns what was sent to a topic of the target chat
+func (h *Harness) TelegramSentTo(threadId int64) []TelegramSent {
+	h.Telegram.lock.Lock()
+	defer h.Telegram.lock.Unlock()
+
+	var sent []TelegramSent
+	for _, msg := range h.Telegram.Sent {
+		if msg.ChatId == HarnessTargetChatID && msg.ThreadId == threadId {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"html"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"watgbridge/database"
@@ -21,12 +26,31 @@ import (
 )
 
 func main() {
-	// Load configuration file
 	cfg := state.State.Config
 	cfg.SetDefaults()
 
+	// The bridge restarts itself without any arguments, in which case
+	// os.Args is empty
+	var args []string
 	if len(os.Args) > 1 {
-		cfg.Path = os.Args[1]
+		args = os.Args[1:]
+	}
+
+	if len(args) > 0 {
+		if command, found := cliCommands[args[0]]; found {
+			command.run(args[1:])
+			return
+		}
+	}
+	runCommand(args)
+}
+
+// setupBridge loads the config file and sets up the logger and everything
+// else needed by all the commands
+func setupBridge(configPath string) {
+	cfg := state.State.Config
+	if configPath != "" {
+		cfg.Path = configPath
 	}
 
 	err := cfg.LoadConfig()
@@ -34,6 +58,10 @@ func main() {
 		panic(fmt.Errorf("failed to load config file: %s", err))
 	}
 
+	if key, found := os.LookupEnv("WATG_ARCHIVE_KEY"); found {
+		cfg.MessageArchive.EncryptionKey = key
+	}
+
 	if cfg.Telegram.APIURL == "" {
 		cfg.Telegram.APIURL = gotgbot.DefaultAPIURL
 	}
@@ -54,6 +82,31 @@ func main() {
 		}
 		state.State.Logger = state.State.Logger.Named("WaTgBridge")
 	}
+	baseLevel, whatsmeowLevel := zap.InfoLevel, zap.InfoLevel
+	if cfg.DebugMode {
+		baseLevel = zap.DebugLevel
+	}
+	if cfg.WhatsApp.WhatsmeowDebugMode {
+		whatsmeowLevel = zap.DebugLevel
+	}
+	if err = utils.LogLevelsInit(baseLevel, whatsmeowLevel, cfg.Logging.Levels); err != nil {
+		panic(fmt.Errorf("failed to set log levels: %s", err))
+	}
+	// The obfuscation wraps the cores writing the logs, so the log file core
+	// gets its own
+	if cfg.LogObfuscation.Enabled {
+		if err = utils.LogObfuscationInit(cfg.LogObfuscation.Key); err != nil {
+			panic(fmt.Errorf("failed to initialize log obfuscation: %s", err))
+		}
+		state.State.Logger = state.State.Logger.WithOptions(zap.WrapCore(utils.LogObfuscateCore))
+	}
+	if cfg.Logging.File != "" {
+		if err = utils.LogFileInit(cfg.Logging.File, cfg.Logging.MaxSizeMB, cfg.Logging.MaxBackups); err != nil {
+			panic(fmt.Errorf("failed to initialize log file: %s", err))
+		}
+		state.State.Logger = state.State.Logger.WithOptions(zap.WrapCore(utils.LogFileCore))
+	}
+	state.State.Logger = state.State.Logger.WithOptions(zap.WrapCore(utils.LogLevelCore))
 	logger := state.State.Logger
 
 	logger.Debug("loaded config file and started logger",
@@ -75,6 +128,17 @@ func main() {
 	}
 	state.State.LocalLocation = locLoc
 
+	for _, timeZone := range cfg.TimeHeader.ExtraTimeZones {
+		extraLoc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			logger.Fatal("failed to load extra time zone",
+				zap.String("time_zone", timeZone),
+				zap.Error(err),
+			)
+		}
+		state.State.ExtraLocations = append(state.State.ExtraLocations, extraLoc)
+	}
+
 	if cfg.WhatsApp.SessionName == "" {
 		cfg.WhatsApp.SessionName = "watgbridge"
 	}
@@ -85,6 +149,15 @@ func main() {
 		logger.Debug("using sqlite3 as WhatsApp login database")
 		_ = logger.Sync()
 	}
+}
+
+// setupExecutables finds the paths of the executables used by the bridge and
+// saves them to the config file
+func setupExecutables() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
 
 	if cfg.GitExecutable == "" {
 		gitPath, err := exec.LookPath("git")
@@ -148,6 +221,10 @@ func main() {
 			)
 		}
 	}
+}
+
+func setupDatabase() {
+	logger := state.State.Logger
 
 	// Setup database
 	db, err := database.Connect()
@@ -157,6 +234,14 @@ func main() {
 		)
 	}
 	state.State.Database = db
+
+	newTables, err := database.MissingTables()
+	if err != nil {
+		logger.Warn("could not check for missing database tables",
+			zap.Error(err),
+		)
+	}
+	migrationStart := time.Now()
 	err = database.AutoMigrate()
 	if err != nil {
 		logger.Fatal("could not migrate database tabels",
@@ -164,7 +249,88 @@ func main() {
 		)
 	}
 
-	err = telegram.NewTelegramClient()
+	normalized, err := database.NormalizeJIDs(utils.WaNormalizeChatId, utils.WaNormalizeSenderId)
+	if err != nil {
+		logger.Error("could not normalize the JIDs in the database",
+			zap.Error(err),
+		)
+	}
+
+	encrypted, err := utils.ArchiveEncryptExisting()
+	if err != nil {
+		logger.Error("could not encrypt the existing archived messages",
+			zap.Error(err),
+		)
+	}
+
+	migrationNotice = fmt.Sprintf("<b>Database migrations</b>: done in %s", time.Since(migrationStart).Round(time.Millisecond))
+	if len(newTables) > 0 {
+		migrationNotice += fmt.Sprintf(", created %s", strings.Join(newTables, ", "))
+	}
+	if normalized > 0 {
+		migrationNotice += fmt.Sprintf(", normalized %d JIDs", normalized)
+	}
+	if encrypted > 0 {
+		migrationNotice += fmt.Sprintf(", encrypted %d archived messages", encrypted)
+	}
+}
+
+// migrationNotice summarizes the database migrations for the startup notice
+var migrationNotice string
+
+// startupNotice is posted to the '#System' topic once the bridge is up, with
+// the version and the settings that matter most when something goes wrong.
+// It says the bridge is back up only if the previous run shut down
+// gracefully.
+func startupNotice() string {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+
+	notice := "Bridge started\n\n"
+	if shutdownAt, found, err := database.ShutdownMarkerTake(); err != nil {
+		logger.Error("failed to read shutdown marker",
+			zap.Error(err),
+		)
+	} else if found {
+		notice = fmt.Sprintf("Bridge is back up after %s\n\n", time.Since(shutdownAt).Round(time.Second))
+	}
+	notice += fmt.Sprintf("<b>Version</b>: <code>%s</code>\n", state.WATGBRIDGE_VERSION)
+	notice += fmt.Sprintf("<b>Target chat</b>: <code>%d</code>\n", cfg.Telegram.TargetChatID)
+	notice += fmt.Sprintf("<b>Time zone</b>: %s\n", html.EscapeString(cfg.TimeZone))
+	notice += fmt.Sprintf("<b>Database</b>: %s\n", html.EscapeString(cfg.Database["type"]))
+	notice += fmt.Sprintf("<b>Telegram transport</b>: %s\n", html.EscapeString(cfg.Telegram.Transport))
+	notice += fmt.Sprintf("<b>Self hosted Bot API</b>: %t\n", cfg.Telegram.SelfHostedAPI)
+	notice += fmt.Sprintf("<b>Process offline messages</b>: %t\n", cfg.WhatsApp.ProcessOfflineMessages)
+	notice += fmt.Sprintf("<b>Message archive</b>: %t\n", cfg.MessageArchive.Enabled)
+	notice += fmt.Sprintf("<b>Media store</b>: %t\n", cfg.MediaStore.Enabled)
+	if migrationNotice != "" {
+		notice += "\n" + migrationNotice
+	}
+	return notice
+}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	pairPhone := flags.String("pair-phone", "", "phone number to link to by entering a code on the phone instead of scanning a QR code")
+	_ = flags.Parse(args)
+
+	setupBridge(flags.Arg(0))
+
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+
+	if *pairPhone != "" {
+		cfg.WhatsApp.PairPhoneNumber = *pairPhone
+	}
+
+	setupExecutables()
+	setupDatabase()
+
+	err := telegram.NewTelegramClient()
 	if err != nil {
 		logger.Fatal("failed to initialize telegram client",
 			zap.Error(err),
@@ -180,16 +346,58 @@ func main() {
 
 	state.State.StartTime = time.Now().UTC()
 
+	utils.LargeMediaServe()
+	utils.HealthServe()
+
 	s := gocron.NewScheduler(time.UTC)
 	s.TagsUnique()
+	state.State.Scheduler = s
 	_, _ = s.Every(1).Hour().Tag("foo").Do(func() {
 		contacts, err := state.State.WhatsAppClient.Store.Contacts.GetAllContacts()
 		if err == nil {
 			_ = database.ContactNameBulkAddOrUpdate(contacts)
 		}
+		utils.WaSyncLIDs()
 	})
+	_, _ = s.Every(1).Minute().Tag("quiet_hours").SingletonMode().Do(whatsapp.QuietHoursFlush)
+	_, _ = s.Every(1).Minute().Tag("queued_deliveries").SingletonMode().Do(utils.TgFlushQueuedDeliveries)
+	_, _ = s.Every(5).Minutes().Tag("activity_events").SingletonMode().Do(database.ActivityFlushPending)
+	_, _ = s.Every(5).Minutes().Tag("chat_stats").SingletonMode().Do(utils.StatsFlushPending)
+	_, _ = s.Every(1).Day().At("03:00").Tag("archive_prune").Do(utils.ArchivePrune)
+	_, _ = s.Every(1).Day().At("03:30").Tag("media_store_cleanup").Do(utils.MediaStoreCleanup)
+	_, _ = s.Every(1).Hour().Tag("local_files_cleanup").Do(utils.TgLocalFilesCleanup)
+	_, _ = s.Every(1).Day().At("04:00").Tag("forwardables_cleanup").Do(utils.ForwardablesCleanup)
+	utils.RemindersSchedule()
+	if cfg.Health.WatchdogIntervalSeconds > 0 {
+		_, _ = s.Every(cfg.Health.WatchdogIntervalSeconds).Seconds().Tag("watchdog").SingletonMode().Do(utils.HealthWatchdog)
+	}
+	if cfg.WhatsApp.DeliveryTimeoutMinutes > 0 {
+		_, _ = s.Every(1).Minute().Tag("delivery_receipts").SingletonMode().Do(utils.WaWarnUndelivered)
+	}
+	if cfg.WhatsApp.PollResults.IntervalMinutes > 0 {
+		_, _ = s.Every(cfg.WhatsApp.PollResults.IntervalMinutes).Minutes().Tag("poll_results").SingletonMode().Do(utils.TgRefreshPollResults)
+	}
+	if cfg.UpdateCheck.Enabled && cfg.UpdateCheck.IntervalHours > 0 {
+		_, _ = s.Every(cfg.UpdateCheck.IntervalHours).Hours().Tag("update_check").SingletonMode().Do(utils.UpdateCheck)
+	}
+	if cfg.Telegram.DailySummary.Enabled {
+		cron, err := utils.CronDailyAt(cfg.Telegram.DailySummary.Time)
+		if err == nil {
+			_, err = s.Cron(cron).Tag("daily_summary").Do(whatsapp.DailySummaryPost)
+		}
+		if err != nil {
+			logger.Error("failed to schedule daily summary",
+				zap.String("time", cfg.Telegram.DailySummary.Time),
+				zap.Error(err),
+			)
+		}
+	}
 
-	state.State.WhatsAppClient.AddEventHandler(whatsapp.WhatsAppEventHandler)
+	if cfg.WhatsApp.ProcessOfflineMessages {
+		whatsapp.OfflineEventsRelease()
+	} else {
+		state.State.WhatsAppClient.AddEventHandler(whatsapp.WhatsAppEventHandler)
+	}
 	telegram.AddTelegramHandlers()
 	modules.LoadModuleHandlers()
 
@@ -210,6 +418,8 @@ func main() {
 	}
 	_ = logger.Sync()
 
+	s.StartAsync()
+
 	{
 		isRestarted, found := os.LookupEnv("WATG_IS_RESTARTED")
 		if !found || isRestarted != "1" {
@@ -236,5 +446,13 @@ func main() {
 	}
 SKIP_RESTART:
 
-	state.State.TelegramUpdater.Idle()
+	if err = utils.TgSendSystemNotice(startupNotice()); err != nil {
+		logger.Error("failed to post startup notice",
+			zap.Error(err),
+		)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	gracefulShutdown(s, <-sigChan)
 }
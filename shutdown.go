@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/telegram"
+	"watgbridge/utils"
+	"watgbridge/whatsapp"
+
+	"github.com/go-co-op/gocron"
+	"go.uber.org/zap"
+)
+
+// gracefulShutdown stops taking in new updates from Telegram, waits for the
+// messages being bridged to finish (up to the configured timeout), sends the
+// queued deliveries which are due, disconnects WhatsApp, writes the batched
+// message ID pairs and the shutdown marker and closes the database before
+// exiting
+func gracefulShutdown(s *gocron.Scheduler, sig os.Signal) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+
+	logger.Info("shutting down",
+		zap.String("signal", sig.String()),
+	)
+	_ = logger.Sync()
+
+	if err := utils.TgSendSystemNotice("Bridge is going down"); err != nil {
+		logger.Error("failed to post shutdown notice",
+			zap.Error(err),
+		)
+	}
+
+	// Updates which were not fetched yet are delivered again after a restart.
+	// WhatsApp stays connected until the messages being bridged are done, as
+	// they may still have media to download.
+	s.Stop()
+	telegram.StopTelegramUpdates()
+
+	// Texts and photos held back for batching are sent right away instead of
+	// after their window, also the ones held back by the messages still being
+	// bridged
+	deadline := time.Now().Add(time.Duration(cfg.ShutdownTimeout) * time.Second)
+	for {
+		whatsapp.TextBatchFlushAll()
+		whatsapp.AlbumFlushAll()
+		if utils.LagInFlight() == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if pending := utils.LagInFlight(); pending > 0 {
+		logger.Warn("timed out waiting for messages being bridged",
+			zap.Int("pending", pending),
+		)
+	}
+
+	// The scheduler which sends them is stopped, the ones still in a delivery
+	// blackout stay queued for the next run
+	utils.TgFlushQueuedDeliveries()
+
+	// WhatsApp keeps the messages arriving from now on for the next connection
+	state.State.WhatsAppClient.Disconnect()
+
+	if err := database.MsgIdFlushPending(); err != nil {
+		logger.Error("failed to write pending message id pairs",
+			zap.Error(err),
+		)
+	}
+	if err := database.ActivityFlushPending(); err != nil {
+		logger.Error("failed to write pending activity events",
+			zap.Error(err),
+		)
+	}
+	if err := utils.StatsFlushPending(); err != nil {
+		logger.Error("failed to write pending chat stats",
+			zap.Error(err),
+		)
+	}
+
+	if err := database.ShutdownMarkerSet(time.Now().UTC()); err != nil {
+		logger.Error("failed to write shutdown marker",
+			zap.Error(err),
+		)
+	}
+
+	telegram.DisconnectTelegram()
+
+	if sqlDB, err := state.State.Database.DB(); err == nil {
+		if err = sqlDB.Close(); err != nil {
+			logger.Error("failed to close database",
+				zap.Error(err),
+			)
+		}
+	}
+
+	logger.Info("bridge stopped")
+	_ = logger.Sync()
+	os.Exit(0)
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// WhatsAppAPI is the part of the whatsmeow client used for bridging messages,
+// so that helpers can be driven by a fake client (see the fakes package)
+type WhatsAppAPI interface {
+	SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	BuildRevoke(chat, sender types.JID, id types.MessageID) *waProto.Message
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message
+	Download(msg whatsmeow.DownloadableMessage) ([]byte, error)
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error
+	SendPresence(state types.Presence) error
+	GetGroupInfo(jid types.JID) (*types.GroupInfo, error)
+	GetProfilePictureInfo(jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error)
+	SetGroupPhoto(jid types.JID, avatar []byte) (string, error)
+}
+
+// TelegramAPI is the part of the Telegram bot used for bridging messages
+type TelegramAPI interface {
+	SendMessage(chatId int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error)
+	SendPhoto(chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error)
+	SendMediaGroup(chatId int64, media []gotgbot.InputMedia, opts *gotgbot.SendMediaGroupOpts) ([]gotgbot.Message, error)
+	SendVideo(chatId int64, video gotgbot.InputFile, opts *gotgbot.SendVideoOpts) (*gotgbot.Message, error)
+	SendVideoNote(chatId int64, videoNote gotgbot.InputFile, opts *gotgbot.SendVideoNoteOpts) (*gotgbot.Message, error)
+	SendAnimation(chatId int64, animation gotgbot.InputFile, opts *gotgbot.SendAnimationOpts) (*gotgbot.Message, error)
+	SendAudio(chatId int64, audio gotgbot.InputFile, opts *gotgbot.SendAudioOpts) (*gotgbot.Message, error)
+	SendDocument(chatId int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error)
+	SendSticker(chatId int64, sticker gotgbot.InputFile, opts *gotgbot.SendStickerOpts) (*gotgbot.Message, error)
+	SendContact(chatId int64, phoneNumber string, firstName string, opts *gotgbot.SendContactOpts) (*gotgbot.Message, error)
+	SendLocation(chatId int64, latitude float64, longitude float64, opts *gotgbot.SendLocationOpts) (*gotgbot.Message, error)
+	SendPoll(chatId int64, question string, options []string, opts *gotgbot.SendPollOpts) (*gotgbot.Message, error)
+	CopyMessage(chatId int64, fromChatId int64, messageId int64, opts *gotgbot.CopyMessageOpts) (*gotgbot.MessageId, error)
+	EditMessageText(text string, opts *gotgbot.EditMessageTextOpts) (*gotgbot.Message, bool, error)
+	EditMessageCaption(opts *gotgbot.EditMessageCaptionOpts) (*gotgbot.Message, bool, error)
+	DeleteMessage(chatId int64, messageId int64, opts *gotgbot.DeleteMessageOpts) (bool, error)
+	PinChatMessage(chatId int64, messageId int64, opts *gotgbot.PinChatMessageOpts) (bool, error)
+	UnpinChatMessage(chatId int64, opts *gotgbot.UnpinChatMessageOpts) (bool, error)
+	CreateForumTopic(chatId int64, name string, opts *gotgbot.CreateForumTopicOpts) (*gotgbot.ForumTopic, error)
+	EditForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.EditForumTopicOpts) (bool, error)
+	CloseForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.CloseForumTopicOpts) (bool, error)
+	ReopenForumTopic(chatId int64, messageThreadId int64, opts *gotgbot.ReopenForumTopicOpts) (bool, error)
+	GetFile(fileId string, opts *gotgbot.GetFileOpts) (*gotgbot.File, error)
+}
+
+var (
+	_ WhatsAppAPI = (*whatsmeow.Client)(nil)
+	_ TelegramAPI = (*gotgbot.Bot)(nil)
+)
@@ -16,21 +16,158 @@ type Config struct {
 	GoExecutable     string `yaml:"go_executable"`
 	FfmpegExecutable string `yaml:"ffmpeg_executable"`
 	DebugMode        bool   `yaml:"debug_mode"`
+	ShutdownTimeout  int    `yaml:"shutdown_timeout"`
+
+	LogObfuscation struct {
+		Enabled bool   `yaml:"enabled"`
+		Key     string `yaml:"key"`
+	} `yaml:"log_obfuscation"`
+
+	Logging struct {
+		Levels     map[string]string `yaml:"levels"`
+		File       string            `yaml:"file"`
+		MaxSizeMB  int               `yaml:"max_size_mb"`
+		MaxBackups int               `yaml:"max_backups"`
+	} `yaml:"logging"`
+
+	ErrorReporting struct {
+		DedupWindowMinutes int `yaml:"dedup_window_minutes"`
+		MaxPerMinute       int `yaml:"max_per_minute"`
+	} `yaml:"error_reporting"`
+
+	TimeHeader struct {
+		DelayThresholdSeconds int      `yaml:"delay_threshold_seconds"`
+		Relative              bool     `yaml:"relative"`
+		ExtraTimeZones        []string `yaml:"extra_time_zones"`
+	} `yaml:"time_header"`
+
+	MessageArchive struct {
+		Enabled       bool   `yaml:"enabled"`
+		RetentionDays int    `yaml:"retention_days"`
+		EncryptionKey string `yaml:"encryption_key"`
+		SearchIndex   bool   `yaml:"search_index"`
+	} `yaml:"message_archive"`
+
+	Health struct {
+		ListenAddress           string `yaml:"listen_address"`
+		WatchdogIntervalSeconds int    `yaml:"watchdog_interval_seconds"`
+		StallTimeoutSeconds     int    `yaml:"stall_timeout_seconds"`
+	} `yaml:"health"`
+
+	MediaStore struct {
+		Enabled       bool   `yaml:"enabled"`
+		Directory     string `yaml:"directory"`
+		RetentionDays int    `yaml:"retention_days"`
+	} `yaml:"media_store"`
+
+	UpdateCheck struct {
+		Enabled            bool `yaml:"enabled"`
+		IntervalHours      int  `yaml:"interval_hours"`
+		IncludePreReleases bool `yaml:"include_pre_releases"`
+	} `yaml:"update_check"`
+
+	Translation struct {
+		Provider       string   `yaml:"provider"`
+		URL            string   `yaml:"url"`
+		APIKey         string   `yaml:"api_key"`
+		TargetLanguage string   `yaml:"target_language"`
+		Chats          []string `yaml:"chats"`
+	} `yaml:"translation"`
+
+	Reminders []struct {
+		Cron string `yaml:"cron"`
+		Chat string `yaml:"chat"`
+		Text string `yaml:"text"`
+	} `yaml:"reminders"`
+
+	Backup struct {
+		EncryptionKey  string   `yaml:"encryption_key"`
+		IncludeSession bool     `yaml:"include_session"`
+		Destination    string   `yaml:"destination"`
+		S3             S3Config `yaml:"s3"`
+	} `yaml:"backup"`
+
+	DatabaseTuning struct {
+		WAL                    bool `yaml:"wal"`
+		PrepareStatements      bool `yaml:"prepare_statements"`
+		WriteBatchMilliseconds int  `yaml:"write_batch_milliseconds"`
+		WriteBatchSize         int  `yaml:"write_batch_size"`
+	} `yaml:"database_tuning"`
 
 	UseGithHubBinaries bool   `yaml:"use_github_binaries"`
 	Architecture       string `yaml:"architecture"`
 
 	Telegram struct {
-		BotToken            string  `yaml:"bot_token"`
-		APIURL              string  `yaml:"api_url"`
-		SudoUsersID         []int64 `yaml:"sudo_users_id"`
-		OwnerID             int64   `yaml:"owner_id"`
-		TargetChatID        int64   `yaml:"target_chat_id"`
-		SelfHostedAPI       bool    `yaml:"self_hosted_api"`
-		SkipVideoStickers   bool    `yaml:"skip_video_stickers"`
-		SkipSettingCommands bool    `yaml:"skip_setting_commands"`
-		SendMyPresence      bool    `yaml:"send_my_presence"`
-		SendMyReadReceipts  bool    `yaml:"send_my_read_receipts"`
+		DailySummary struct {
+			Enabled bool   `yaml:"enabled"`
+			Time    string `yaml:"time"`
+		} `yaml:"daily_summary"`
+		LargeMediaHandler struct {
+			Type      string   `yaml:"type"`
+			PublicURL string   `yaml:"public_url"`
+			S3        S3Config `yaml:"s3"`
+			WebDAV    struct {
+				URL      string `yaml:"url"`
+				Username string `yaml:"username"`
+				Password string `yaml:"password"`
+			} `yaml:"webdav"`
+			Local struct {
+				Directory     string `yaml:"directory"`
+				ListenAddress string `yaml:"listen_address"`
+			} `yaml:"local"`
+		} `yaml:"large_media_handler"`
+		ImageProcessing struct {
+			Enabled      bool `yaml:"enabled"`
+			MaxDimension int  `yaml:"max_dimension"`
+			Quality      int  `yaml:"quality"`
+		} `yaml:"image_processing"`
+		DocumentParts struct {
+			Enabled           bool `yaml:"enabled"`
+			PartSizeMB        int  `yaml:"part_size_mb"`
+			JoinWindowSeconds int  `yaml:"join_window_seconds"`
+		} `yaml:"document_parts"`
+		FanOut []struct {
+			ChatID       int64    `yaml:"chat_id"`
+			Chats        []string `yaml:"chats"`
+			SingleThread bool     `yaml:"single_thread"`
+		} `yaml:"fan_out"`
+		SendConfirmation struct {
+			SuccessReply    bool   `yaml:"success_reply"`
+			SuccessReaction string `yaml:"success_reaction"`
+			FailureReaction string `yaml:"failure_reaction"`
+		} `yaml:"send_confirmation"`
+		TextBatching struct {
+			WindowSeconds int `yaml:"window_seconds"`
+			MaxLength     int `yaml:"max_length"`
+		} `yaml:"text_batching"`
+		MTProto struct {
+			APIID          int    `yaml:"api_id"`
+			APIHash        string `yaml:"api_hash"`
+			SessionFile    string `yaml:"session_file"`
+			FilesDirectory string `yaml:"files_directory"`
+		} `yaml:"mtproto"`
+		BotToken               string   `yaml:"bot_token"`
+		APIURL                 string   `yaml:"api_url"`
+		SudoUsersID            []int64  `yaml:"sudo_users_id"`
+		OwnerID                int64    `yaml:"owner_id"`
+		TargetChatID           int64    `yaml:"target_chat_id"`
+		SelfHostedAPI          bool     `yaml:"self_hosted_api"`
+		LocalFilesDirectory    string   `yaml:"local_files_directory"`
+		PhotoFallbackSize      int      `yaml:"photo_fallback_size"`
+		SkipVideoStickers      bool     `yaml:"skip_video_stickers"`
+		SkipSettingCommands    bool     `yaml:"skip_setting_commands"`
+		SendMyPresence         bool     `yaml:"send_my_presence"`
+		SendMyReadReceipts     bool     `yaml:"send_my_read_receipts"`
+		GeneralTopicChats      []string `yaml:"general_topic_chats"`
+		SendEventICS           bool     `yaml:"send_event_ics"`
+		AlbumWindowSeconds     int      `yaml:"album_window_seconds"`
+		HeaderTemplate         string   `yaml:"header_template"`
+		SyncTopicNames         bool     `yaml:"sync_topic_names"`
+		TopicAvatars           bool     `yaml:"topic_avatars"`
+		CaptionOverflow        string   `yaml:"caption_overflow"`
+		CollapseHeadersSeconds int      `yaml:"collapse_headers_seconds"`
+		SingleStream           bool     `yaml:"single_stream"`
+		Transport              string   `yaml:"transport"`
 	} `yaml:"telegram"`
 
 	WhatsApp struct {
@@ -42,31 +179,105 @@ type Config struct {
 			PackName   string `yaml:"pack_name"`
 			AuthorName string `yaml:"author_name"`
 		} `yaml:"sticker_metadata"`
-		SessionName                    string   `yaml:"session_name"`
-		TagAllAllowedGroups            []string `yaml:"tag_all_allowed_groups"`
-		IgnoreChats                    []string `yaml:"ignore_chats"`
-		StatusIgnoredChats             []string `yaml:"status_ignored_chats"`
-		SkipDocuments                  bool     `yaml:"skip_documents"`
-		SkipImages                     bool     `yaml:"skip_images"`
-		SkipGIFs                       bool     `yaml:"skip_gifs"`
-		SkipVideos                     bool     `yaml:"skip_videos"`
-		SkipVoiceNotes                 bool     `yaml:"skip_voice_notes"`
-		SkipAudios                     bool     `yaml:"skip_audios"`
-		SkipStatus                     bool     `yaml:"skip_status"`
-		SkipStickers                   bool     `yaml:"skip_stickers"`
-		SkipContacts                   bool     `yaml:"skip_contacts"`
-		SkipLocations                  bool     `yaml:"skip_locations"`
-		SkipProfilePictureUpdates      bool     `yaml:"skip_profile_picture_updates"`
-		SkipGroupSettingsUpdates       bool     `yaml:"skip_group_settings_updates"`
-		SkipChatDetails                bool     `yaml:"skip_chat_details"`
-		SendRevokedMessageUpdates      bool     `yaml:"send_revoked_message_updates"`
-		WhatsmeowDebugMode             bool     `yaml:"whatsmeow_debug_mode"`
-		SendMyMessagesFromOtherDevices bool     `yaml:"send_my_messages_from_other_devices"`
+		AwayMode struct {
+			DefaultMessage     string `yaml:"default_message"`
+			ReplyIntervalHours int    `yaml:"reply_interval_hours"`
+			ScheduleStart      string `yaml:"schedule_start"`
+			ScheduleEnd        string `yaml:"schedule_end"`
+		} `yaml:"away_mode"`
+		Alerts struct {
+			Rules       []string `yaml:"rules"`
+			SendToOwner bool     `yaml:"send_to_owner"`
+		} `yaml:"alerts"`
+		IgnoreRules struct {
+			SenderPatterns     []string `yaml:"sender_patterns"`
+			ChatPatterns       []string `yaml:"chat_patterns"`
+			TextPatterns       []string `yaml:"text_patterns"`
+			MaxForwardingScore uint32   `yaml:"max_forwarding_score"`
+		} `yaml:"ignore_rules"`
+		QuietHours struct {
+			Start           string `yaml:"start"`
+			End             string `yaml:"end"`
+			SendFullBacklog bool   `yaml:"send_full_backlog"`
+		} `yaml:"quiet_hours"`
+		HistoryBackfill struct {
+			OnPairing       bool `yaml:"on_pairing"`
+			MessagesPerChat int  `yaml:"messages_per_chat"`
+		} `yaml:"history_backfill"`
+		Mentions struct {
+			ChatID        int64            `yaml:"chat_id"`
+			GroupChatIDs  map[string]int64 `yaml:"group_chat_ids"`
+			ExcerptLength int              `yaml:"excerpt_length"`
+		} `yaml:"mentions"`
+		LinkPreviews struct {
+			Chats    []string `yaml:"chats"`
+			AllChats bool     `yaml:"all_chats"`
+		} `yaml:"link_previews"`
+		DeliveryBlackouts map[string]struct {
+			Start string `yaml:"start"`
+			End   string `yaml:"end"`
+		} `yaml:"delivery_blackouts"`
+		AntiSpam struct {
+			Enabled      bool `yaml:"enabled"`
+			BlockSenders bool `yaml:"block_senders"`
+		} `yaml:"anti_spam"`
+		MirrorAppState struct {
+			Archive bool `yaml:"archive"`
+			Pin     bool `yaml:"pin"`
+			Mute    bool `yaml:"mute"`
+		} `yaml:"mirror_app_state"`
+		PollResults struct {
+			IntervalMinutes int  `yaml:"interval_minutes"`
+			ShowVoters      bool `yaml:"show_voters"`
+		} `yaml:"poll_results"`
+		SessionName                    string                     `yaml:"session_name"`
+		PairPhoneNumber                string                     `yaml:"pair_phone_number"`
+		MaxOutgoingTextLength          int                        `yaml:"max_outgoing_text_length"`
+		MaxOutgoingCaptionLength       int                        `yaml:"max_outgoing_caption_length"`
+		TagAllAllowedGroups            []string                   `yaml:"tag_all_allowed_groups"`
+		IgnoreChats                    []string                   `yaml:"ignore_chats"`
+		OnlyChats                      []string                   `yaml:"only_chats"`
+		StatusIgnoredChats             []string                   `yaml:"status_ignored_chats"`
+		StatusTopics                   string                     `yaml:"status_topics"`
+		SkipDocuments                  bool                       `yaml:"skip_documents"`
+		SkipImages                     bool                       `yaml:"skip_images"`
+		SkipGIFs                       bool                       `yaml:"skip_gifs"`
+		SkipVideos                     bool                       `yaml:"skip_videos"`
+		SkipVoiceNotes                 bool                       `yaml:"skip_voice_notes"`
+		SkipAudios                     bool                       `yaml:"skip_audios"`
+		SkipStatus                     bool                       `yaml:"skip_status"`
+		SkipStickers                   bool                       `yaml:"skip_stickers"`
+		SkipContacts                   bool                       `yaml:"skip_contacts"`
+		SkipLocations                  bool                       `yaml:"skip_locations"`
+		SkipProfilePictureUpdates      bool                       `yaml:"skip_profile_picture_updates"`
+		SkipGroupSettingsUpdates       bool                       `yaml:"skip_group_settings_updates"`
+		SkipChatDetails                bool                       `yaml:"skip_chat_details"`
+		SendRevokedMessageUpdates      bool                       `yaml:"send_revoked_message_updates"`
+		SendLinkPreviews               bool                       `yaml:"send_link_previews"`
+		WhatsmeowDebugMode             bool                       `yaml:"whatsmeow_debug_mode"`
+		SendMyMessagesFromOtherDevices bool                       `yaml:"send_my_messages_from_other_devices"`
+		MyMessagesLabel                string                     `yaml:"my_messages_label"`
+		ProcessOfflineMessages         bool                       `yaml:"process_offline_messages"`
+		ReloginViaTelegram             bool                       `yaml:"relogin_via_telegram"`
+		ForwardableMessagesDays        int                        `yaml:"forwardable_messages_days"`
+		QuarantineNewChats             bool                       `yaml:"quarantine_new_chats"`
+		SilentChats                    []string                   `yaml:"silent_chats"`
+		ChatMediaPolicies              map[string]map[string]bool `yaml:"chat_media_policies"`
+		DeliveryTimeoutMinutes         int                        `yaml:"delivery_timeout_minutes"`
 	} `yaml:"whatsapp"`
 
 	Database map[string]string `yaml:"database"`
 }
 
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
 func (cfg *Config) LoadConfig() error {
 	configFilePath := cfg.Path
 
@@ -117,9 +328,52 @@ func (cfg *Config) SaveConfig() error {
 
 func (cfg *Config) SetDefaults() {
 	cfg.TimeZone = "UTC"
+	cfg.ShutdownTimeout = 30
 	cfg.WhatsApp.SessionName = "watgbridge"
 	cfg.WhatsApp.LoginDatabase.Type = "sqlite3"
 	cfg.WhatsApp.LoginDatabase.URL = "file:wawebstore.db?foreign_keys=on"
 	cfg.WhatsApp.StickerMetadata.PackName = "WaTgBridge"
 	cfg.WhatsApp.StickerMetadata.AuthorName = "WaTgBridge"
+	cfg.WhatsApp.AwayMode.DefaultMessage = "I am away right now and will get back to you later."
+	cfg.WhatsApp.AwayMode.ReplyIntervalHours = 6
+	cfg.WhatsApp.MaxOutgoingTextLength = 4096
+	cfg.WhatsApp.MyMessagesLabel = "You"
+	cfg.WhatsApp.StatusTopics = "single"
+	cfg.WhatsApp.HistoryBackfill.MessagesPerChat = 20
+	cfg.WhatsApp.Mentions.ExcerptLength = 300
+	cfg.Telegram.DailySummary.Time = "21:00"
+	cfg.Telegram.PhotoFallbackSize = 2560
+	cfg.Telegram.SendEventICS = true
+	cfg.Telegram.AlbumWindowSeconds = 2
+	cfg.Telegram.ImageProcessing.MaxDimension = 2048
+	cfg.Telegram.ImageProcessing.Quality = 85
+	cfg.Telegram.DocumentParts.PartSizeMB = 45
+	cfg.Telegram.DocumentParts.JoinWindowSeconds = 60
+	cfg.MessageArchive.SearchIndex = true
+	cfg.MediaStore.Directory = "media"
+	cfg.Health.WatchdogIntervalSeconds = 60
+	cfg.Health.StallTimeoutSeconds = 300
+	cfg.UpdateCheck.IntervalHours = 24
+	cfg.Translation.TargetLanguage = "en"
+	cfg.Telegram.LargeMediaHandler.Local.Directory = "large_media"
+	cfg.WhatsApp.MaxOutgoingCaptionLength = 1024
+	cfg.Logging.MaxSizeMB = 50
+	cfg.Logging.MaxBackups = 3
+	cfg.ErrorReporting.DedupWindowMinutes = 60
+	cfg.ErrorReporting.MaxPerMinute = 10
+	cfg.TimeHeader.DelayThresholdSeconds = 60
+	cfg.Backup.Destination = "telegram"
+	cfg.Telegram.SendConfirmation.SuccessReply = true
+	cfg.Telegram.SendConfirmation.SuccessReaction = "👍"
+	cfg.Telegram.SendConfirmation.FailureReaction = "👎"
+	cfg.WhatsApp.PollResults.IntervalMinutes = 5
+	cfg.WhatsApp.PollResults.ShowVoters = true
+	cfg.Telegram.CaptionOverflow = "truncate"
+	cfg.Telegram.TextBatching.MaxLength = 300
+	cfg.Telegram.Transport = "bot"
+	cfg.Telegram.MTProto.SessionFile = "telegram_session.json"
+	cfg.Telegram.MTProto.FilesDirectory = "telegram_files"
+	// WAL, prepared statements and batching the writes are opt-in, they
+	// change when the writes reach the database or break behind poolers
+	cfg.DatabaseTuning.WriteBatchSize = 100
 }
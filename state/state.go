@@ -3,8 +3,11 @@ package state
 import (
 	"time"
 
+	"watgbridge/telegram/mtproto"
+
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/go-co-op/gocron"
 	"go.mau.fi/whatsmeow"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -18,16 +21,22 @@ type state struct {
 	Logger   *zap.Logger
 
 	TelegramBot        *gotgbot.Bot
+	TelegramSender     TelegramAPI // Used by the WhatsApp handlers, the bot unless replaced by a fake
 	TelegramDispatcher *ext.Dispatcher
-	TelegramUpdater    *ext.Updater
+	TelegramUpdater    *ext.Updater    // Only with the bot transport
+	TelegramUserClient *mtproto.Client // Only with the mtproto transport, in place of the updater
 	TelegramCommands   []gotgbot.BotCommand
 
 	WhatsAppClient *whatsmeow.Client
+	WhatsAppSender WhatsAppAPI // Used for bridging messages, the client unless replaced by a fake
+
+	Scheduler *gocron.Scheduler
 
 	Modules []string
 
-	StartTime     time.Time
-	LocalLocation *time.Location
+	StartTime      time.Time
+	LocalLocation  *time.Location
+	ExtraLocations []*time.Location
 }
 
 var State state
@@ -0,0 +1,365 @@
+package telegram
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/fakes"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+var testContact = types.NewJID("10000000002", types.DefaultUserServer)
+
+// testThreadId is the topic of testContact
+const testThreadId int64 = 7
+
+var testMessageId int64 = 1000
+
+func newTestHarness(t *testing.T) *fakes.Harness {
+	t.Helper()
+
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = database.ChatThreadAddNewPair(testContact.String(), fakes.HarnessTargetChatID, testThreadId); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// testUpdate returns the context of a message the owner sent in the topic
+func testUpdate(msg gotgbot.Message) *ext.Context {
+	testMessageId += 1
+	msg.MessageId = testMessageId
+	msg.MessageThreadId = testThreadId
+	msg.IsTopicMessage = true
+	msg.Date = time.Now().Unix()
+	msg.Chat = gotgbot.Chat{Id: fakes.HarnessTargetChatID, Type: "supergroup", IsForum: true}
+	msg.From = &gotgbot.User{Id: fakes.HarnessOwnerID, FirstName: "Owner"}
+	return ext.NewContext(&gotgbot.Update{Message: &msg}, nil)
+}
+
+// sendTestUpdate runs the bridging handler and returns what was sent to
+// WhatsApp
+func sendTestUpdate(t *testing.T, h *fakes.Harness, c *ext.Context) fakes.WhatsAppSent {
+	t.Helper()
+
+	before := len(h.WhatsApp.Sent)
+	if err := BridgeTelegramToWhatsAppHandler(h.Bot, c); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.Sent) != before+1 {
+		t.Fatalf("sent to WhatsApp as %d messages, want 1, Telegram got %+v",
+			len(h.WhatsApp.Sent)-before, h.Telegram.Sent)
+	}
+	return h.WhatsApp.Sent[before]
+}
+
+func assertPair(t *testing.T, c *ext.Context, sent fakes.WhatsAppSent) {
+	t.Helper()
+
+	msgId, _, chatId, err := database.MsgIdGetWaFromTg(fakes.HarnessTargetChatID, c.EffectiveMessage.MessageId, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgId != sent.ID || chatId != sent.To.String() {
+		t.Errorf("Telegram message %d is paired with %s in %s, want %s in %s",
+			c.EffectiveMessage.MessageId, msgId, chatId, sent.ID, sent.To)
+	}
+}
+
+func TestBridgeTextToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	c := testUpdate(gotgbot.Message{Text: "Hello from Telegram"})
+	sent := sendTestUpdate(t, h, c)
+	if sent.To != testContact {
+		t.Errorf("sent to %s, want %s", sent.To, testContact)
+	}
+	if text := sent.Message.GetConversation() + sent.Message.GetExtendedTextMessage().GetText(); text != "Hello from Telegram" {
+		t.Errorf("sent the text %q", text)
+	}
+	assertPair(t, c, sent)
+}
+
+func TestBridgeSplitTextToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+	defer func(limit int) { state.State.Config.WhatsApp.MaxOutgoingTextLength = limit }(state.State.Config.WhatsApp.MaxOutgoingTextLength)
+	state.State.Config.WhatsApp.MaxOutgoingTextLength = 10
+
+	c := testUpdate(gotgbot.Message{Text: "First part second part"})
+	if err := BridgeTelegramToWhatsAppHandler(h.Bot, c); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.Sent) < 2 {
+		t.Fatalf("sent to WhatsApp as %d messages, want more than 1", len(h.WhatsApp.Sent))
+	}
+	assertPair(t, c, h.WhatsApp.Sent[0])
+	if _, _, tgMsgId, _ := database.MsgIdGetTgFromWa(h.WhatsApp.Sent[1].ID, testContact.String()); tgMsgId != 0 {
+		t.Errorf("second part paired with Telegram message %d, only the first part should be", tgMsgId)
+	}
+}
+
+func TestBridgeMediaToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+	// Files are read from the disk like with a local Bot API server
+	state.State.Config.Telegram.SelfHostedAPI = true
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, []byte("\xff\xd8\xff\xe0 fake jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.Telegram.Files["PHOTO"] = &gotgbot.File{FileId: "PHOTO", FilePath: path}
+
+	c := testUpdate(gotgbot.Message{
+		Photo:   []gotgbot.PhotoSize{{FileId: "PHOTO", Width: 640, Height: 480}},
+		Caption: "A photo",
+	})
+	sent := sendTestUpdate(t, h, c)
+	if image := sent.Message.GetImageMessage(); image == nil || image.GetCaption() != "A photo" {
+		t.Errorf("photo sent as %v", sent.Message)
+	}
+	assertPair(t, c, sent)
+}
+
+func TestBridgeReplyToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	// A message of the contact bridged to Telegram
+	bridged := gotgbot.Message{
+		MessageId:       500,
+		MessageThreadId: testThreadId,
+		Chat:            gotgbot.Chat{Id: fakes.HarnessTargetChatID},
+		From:            &h.Bot.User,
+		Text:            "Question?",
+	}
+	err := database.MsgIdAddNewPair("WAQUESTION", testContact.String(), testContact.String(),
+		fakes.HarnessTargetChatID, bridged.MessageId, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testUpdate(gotgbot.Message{Text: "Answer", ReplyToMessage: &bridged})
+	sent := sendTestUpdate(t, h, c)
+	contextInfo := sent.Message.GetExtendedTextMessage().GetContextInfo()
+	if contextInfo.GetStanzaId() != "WAQUESTION" || contextInfo.GetParticipant() != testContact.String() {
+		t.Errorf("reply sent with the context %v", contextInfo)
+	}
+	assertPair(t, c, sent)
+}
+
+func TestBridgeReplyQuotesStoredMessage(t *testing.T) {
+	h := newTestHarness(t)
+	state.State.Config.WhatsApp.ForwardableMessagesDays = 7
+
+	utils.ForwardableStore("WAPHOTO", testContact, &waProto.Message{
+		ImageMessage: &waProto.ImageMessage{Caption: proto.String("A photo")},
+	})
+	bridged := gotgbot.Message{
+		MessageId:       501,
+		MessageThreadId: testThreadId,
+		Chat:            gotgbot.Chat{Id: fakes.HarnessTargetChatID},
+		From:            &h.Bot.User,
+		Caption:         "A photo",
+	}
+	err := database.MsgIdAddNewPair("WAPHOTO", testContact.String(), testContact.String(),
+		fakes.HarnessTargetChatID, bridged.MessageId, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := sendTestUpdate(t, h, testUpdate(gotgbot.Message{Text: "Nice", ReplyToMessage: &bridged}))
+	quoted := sent.Message.GetExtendedTextMessage().GetContextInfo().GetQuotedMessage()
+	if quoted.GetImageMessage().GetCaption() != "A photo" {
+		t.Errorf("reply quotes %v, want the stored photo", quoted)
+	}
+}
+
+func TestEditToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	c := testUpdate(gotgbot.Message{Text: "Helo"})
+	original := sendTestUpdate(t, h, c)
+
+	edited := *c.EffectiveMessage
+	edited.Text = "Hello"
+	if err := EditedMessageHandler(h.Bot, ext.NewContext(&gotgbot.Update{EditedMessage: &edited}, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	edit := h.WhatsApp.Sent[len(h.WhatsApp.Sent)-1]
+	protocolMsg := edit.Message.GetEditedMessage().GetMessage().GetProtocolMessage()
+	if edit.To != testContact || protocolMsg.GetKey().GetId() != original.ID ||
+		protocolMsg.GetEditedMessage().GetConversation() != "Hello" {
+		t.Errorf("edit sent to %s as %v", edit.To, edit.Message)
+	}
+}
+
+func TestCaptionEditToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	err := database.MsgIdAddNewPair("WAPHOTO", fakes.HarnessOwnJID.String(), testContact.String(),
+		fakes.HarnessTargetChatID, 800, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testUpdate(gotgbot.Message{Caption: "A photo", Photo: []gotgbot.PhotoSize{{FileId: "photo"}}})
+	c.EffectiveMessage.MessageId = 800
+	if err := EditedMessageHandler(h.Bot, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.WhatsApp.Sent) != 1 {
+		t.Fatalf("caption edit sent to WhatsApp as %d messages, want 1, Telegram got %+v",
+			len(h.WhatsApp.Sent), h.Telegram.Sent)
+	}
+	protocolMsg := h.WhatsApp.Sent[0].Message.GetEditedMessage().GetMessage().GetProtocolMessage()
+	if protocolMsg.GetKey().GetId() != "WAPHOTO" || protocolMsg.GetEditedMessage().GetImageMessage().GetCaption() != "A photo" {
+		t.Errorf("caption edit sent as %v", h.WhatsApp.Sent[0].Message)
+	}
+}
+
+func TestRevokeToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	c := testUpdate(gotgbot.Message{Text: "Oops"})
+	original := sendTestUpdate(t, h, c)
+
+	revokeCmd := testUpdate(gotgbot.Message{
+		Text:           "/revoke",
+		Entities:       []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: 7}},
+		ReplyToMessage: c.EffectiveMessage,
+	})
+	if err := RevokeCommandHandler(h.Bot, revokeCmd); err != nil {
+		t.Fatal(err)
+	}
+
+	revoke := h.WhatsApp.Sent[len(h.WhatsApp.Sent)-1]
+	protocolMsg := revoke.Message.GetProtocolMessage()
+	if revoke.To != testContact || protocolMsg.GetKey().GetId() != original.ID || !protocolMsg.GetKey().GetFromMe() {
+		t.Errorf("revoke sent to %s as %v", revoke.To, revoke.Message)
+	}
+
+	// Messages of the contact can't be revoked outside of groups
+	err := database.MsgIdAddNewPair("WATHEIRS", testContact.String(), testContact.String(),
+		fakes.HarnessTargetChatID, 600, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(h.WhatsApp.Sent)
+	revokeCmd = testUpdate(gotgbot.Message{
+		Text:           "/revoke",
+		ReplyToMessage: &gotgbot.Message{MessageId: 600, MessageThreadId: testThreadId},
+	})
+	if err := RevokeCommandHandler(h.Bot, revokeCmd); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.Sent) != before {
+		t.Errorf("a message of the contact was revoked with %v", h.WhatsApp.Sent[before].Message)
+	}
+}
+
+// Messages sent from another device of the account are paired with its device
+// in the JID, they are still your own
+func TestEditFromOtherDeviceToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	ownDevice := types.NewADJID(fakes.HarnessOwnJID.User, 0, 12)
+	err := database.MsgIdAddNewPair("WAMINE", ownDevice.String(), testContact.String(),
+		fakes.HarnessTargetChatID, 700, testThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testUpdate(gotgbot.Message{Text: "Hello"})
+	c.EffectiveMessage.MessageId = 700
+	if err := EditedMessageHandler(h.Bot, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.WhatsApp.Sent) != 1 {
+		t.Fatalf("edit sent to WhatsApp as %d messages, want 1", len(h.WhatsApp.Sent))
+	}
+	key := h.WhatsApp.Sent[0].Message.GetEditedMessage().GetMessage().GetProtocolMessage().GetKey()
+	if key.GetId() != "WAMINE" {
+		t.Errorf("edited %s, want WAMINE", key.GetId())
+	}
+}
+
+func TestMentionReplyToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	testGroup := types.NewJID("120363000000000001", types.GroupServer)
+	err := database.MentionNotificationAdd(fakes.HarnessOwnerID, 900, "WAMENTION", testGroup.String(), testContact.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(msg gotgbot.Message) *ext.Context {
+		c := testUpdate(msg)
+		c.EffectiveMessage.Chat = gotgbot.Chat{Id: fakes.HarnessOwnerID, Type: "private"}
+		c.EffectiveMessage.MessageThreadId = 0
+		c.EffectiveMessage.ReplyToMessage = &gotgbot.Message{MessageId: 900}
+		return ext.NewContext(&gotgbot.Update{Message: c.EffectiveMessage}, nil)
+	}
+
+	command := reply(gotgbot.Message{
+		Text:     "/revoke",
+		Entities: []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: 7}},
+	})
+	if err := MentionReplyHandler(h.Bot, command); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.Sent) != 0 {
+		t.Fatalf("command sent to the group as %v", h.WhatsApp.Sent[0].Message)
+	}
+
+	if err := MentionReplyHandler(h.Bot, reply(gotgbot.Message{Text: "Hi"})); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.Sent) != 1 || h.WhatsApp.Sent[0].To != testGroup {
+		t.Errorf("reply sent to WhatsApp as %+v", h.WhatsApp.Sent)
+	}
+}
+
+func TestCannedReplyToWhatsApp(t *testing.T) {
+	h := newTestHarness(t)
+
+	add := testUpdate(gotgbot.Message{
+		Text:     "/canned\nadd thanks\nThank you!",
+		Entities: []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: 7}},
+	})
+	if err := CannedCommandHandler(h.Bot, add); err != nil {
+		t.Fatal(err)
+	}
+	if reply, found, err := database.CannedReplyGet("thanks"); err != nil || !found || reply.Text != "Thank you!" {
+		t.Fatalf("canned reply saved as %+v (%v)", reply, err)
+	}
+
+	// The bridging handler of the later group must not see the command
+	c := testUpdate(gotgbot.Message{
+		Text:     "/c thanks",
+		Entities: []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: 2}},
+	})
+	if err := CannedReplyHandler(h.Bot, c); err != ext.EndGroups {
+		t.Fatalf("CannedReplyHandler() = %v, want ext.EndGroups", err)
+	}
+	if c.EffectiveMessage.Text != "/c thanks" {
+		t.Errorf("command changed to %q", c.EffectiveMessage.Text)
+	}
+	if len(h.WhatsApp.Sent) != 1 || h.WhatsApp.Sent[0].Message.GetConversation() != "Thank you!" {
+		t.Errorf("canned reply sent to WhatsApp as %+v", h.WhatsApp.Sent)
+	}
+}
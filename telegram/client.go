@@ -8,6 +8,7 @@ import (
 
 	"watgbridge/state"
 	"watgbridge/telegram/middlewares"
+	"watgbridge/telegram/mtproto"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
@@ -21,24 +22,44 @@ func NewTelegramClient() error {
 	)
 	defer logger.Sync()
 
-	bot, err := gotgbot.NewBot(cfg.Telegram.BotToken, &gotgbot.BotOpts{
-		BotClient: &gotgbot.BaseBotClient{
-			Client: http.Client{},
-			DefaultRequestOpts: &gotgbot.RequestOpts{
-				APIURL:  cfg.Telegram.APIURL,
-				Timeout: time.Duration(math.MaxInt64),
+	// Both transports are a gotgbot.Bot to the rest of the bridge. With the
+	// mtproto one, its BotClient is the user account, which translates the
+	// Bot API methods into MTProto calls.
+	var (
+		bot        *gotgbot.Bot
+		userClient *mtproto.Client
+		err        error
+	)
+	switch cfg.Telegram.Transport {
+	case "", "bot":
+		bot, err = gotgbot.NewBot(cfg.Telegram.BotToken, &gotgbot.BotOpts{
+			BotClient: &gotgbot.BaseBotClient{
+				Client: http.Client{},
+				DefaultRequestOpts: &gotgbot.RequestOpts{
+					APIURL:  cfg.Telegram.APIURL,
+					Timeout: time.Duration(math.MaxInt64),
+				},
 			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("Could not initialize telegram bot : %s", err)
+		})
+		if err != nil {
+			return fmt.Errorf("Could not initialize telegram bot : %s", err)
+		}
+	case "mtproto":
+		bot, userClient, err = NewUserBot()
+		if err != nil {
+			return fmt.Errorf("Could not connect to telegram as a user : %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown telegram transport '%s', it can be 'bot' or 'mtproto'", cfg.Telegram.Transport)
 	}
 	state.State.TelegramBot = bot
+	state.State.TelegramSender = bot
 
 	bot.UseMiddleware(middlewares.AutoHandleRateLimit)
 	bot.UseMiddleware(middlewares.ParseAsHTML)
 	bot.UseMiddleware(middlewares.DisableWebPagePreview)
 	bot.UseMiddleware(middlewares.SendWithoutReply)
+	bot.UseMiddleware(middlewares.RecreateDeletedTopics)
 
 	dispatcher := ext.NewDispatcher(&ext.DispatcherOpts{
 		UnhandledErrFunc: func(err error) {
@@ -49,6 +70,20 @@ func NewTelegramClient() error {
 		MaxRoutines: ext.DefaultMaxRoutines,
 	})
 
+	state.State.TelegramDispatcher = dispatcher
+
+	if userClient != nil {
+		state.State.TelegramUserClient = userClient
+		userClient.Listen(bot, dispatcher)
+
+		logger.Info("successfully logged into telegram as a user",
+			zap.Int64("id", bot.Id),
+			zap.String("name", bot.FirstName),
+			zap.String("username", "@"+bot.Username),
+		)
+		return nil
+	}
+
 	updater := ext.NewUpdater(dispatcher, &ext.UpdaterOpts{
 		UnhandledErrFunc: func(err error) {
 			logger.Error("telegram updater received error",
@@ -58,7 +93,6 @@ func NewTelegramClient() error {
 	})
 
 	state.State.TelegramUpdater = updater
-	state.State.TelegramDispatcher = dispatcher
 
 	err = updater.StartPolling(bot, &ext.PollingOpts{
 		DropPendingUpdates: true,
@@ -82,3 +116,61 @@ func NewTelegramClient() error {
 
 	return nil
 }
+
+// MTProtoOptions returns the options of the user account for the mtproto
+// transport
+func MTProtoOptions() mtproto.Options {
+	cfg := state.State.Config
+	return mtproto.Options{
+		APIID:          cfg.Telegram.MTProto.APIID,
+		APIHash:        cfg.Telegram.MTProto.APIHash,
+		SessionFile:    cfg.Telegram.MTProto.SessionFile,
+		FilesDirectory: cfg.Telegram.MTProto.FilesDirectory,
+		Logger:         state.State.Logger,
+	}
+}
+
+// NewUserBot connects to the user account logged in with 'watgbridge
+// telegram-login', and returns a bot sending through it
+func NewUserBot() (*gotgbot.Bot, *mtproto.Client, error) {
+	opts := MTProtoOptions()
+	if opts.APIID == 0 || opts.APIHash == "" {
+		return nil, nil, fmt.Errorf("telegram.mtproto.api_id and api_hash have to be set for the mtproto transport")
+	}
+
+	userClient := mtproto.New(opts)
+	if err := userClient.Connect(); err != nil {
+		return nil, nil, err
+	}
+
+	// There is no token to check, the account is the bot user
+	bot, err := gotgbot.NewBot("", &gotgbot.BotOpts{
+		BotClient:         userClient,
+		DisableTokenCheck: true,
+	})
+	if err != nil {
+		_ = userClient.Close()
+		return nil, nil, err
+	}
+	bot.User = *userClient.Self()
+
+	return bot, userClient, nil
+}
+
+// StopTelegramUpdates stops handling Telegram updates, and waits for the ones
+// being handled. Messages can still be sent.
+func StopTelegramUpdates() {
+	if userClient := state.State.TelegramUserClient; userClient != nil {
+		userClient.StopListening(state.State.TelegramDispatcher)
+		return
+	}
+	_ = state.State.TelegramUpdater.Stop()
+}
+
+// DisconnectTelegram disconnects the user account of the mtproto transport,
+// the Bot API needs no disconnecting
+func DisconnectTelegram() {
+	if userClient := state.State.TelegramUserClient; userClient != nil {
+		_ = userClient.Close()
+	}
+}
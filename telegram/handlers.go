@@ -1,6 +1,7 @@
 package telegram
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"html"
@@ -9,8 +10,12 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	"watgbridge/database"
@@ -22,8 +27,11 @@ import (
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
 )
 
 type waTgBridgeCommand struct {
@@ -44,6 +52,18 @@ func AddTelegramHandlers() {
 			return msg.Chat.Id == cfg.Telegram.TargetChatID
 		}, BridgeTelegramToWhatsAppHandler,
 	), DispatcherForwardHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(
+		func(msg *gotgbot.Message) bool {
+			return msg.Chat.Id != cfg.Telegram.TargetChatID && msg.ReplyToMessage != nil
+		}, MentionReplyHandler,
+	), DispatcherForwardHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.Message{
+		AllowEdited: true,
+		Filter: func(msg *gotgbot.Message) bool {
+			return msg.Chat.Id == cfg.Telegram.TargetChatID && msg.EditDate != 0
+		},
+		Response: EditedMessageHandler,
+	}, DispatcherForwardHandlerGroup)
 
 	commands = append(commands,
 		waTgBridgeCommand{
@@ -102,6 +122,10 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("synctopicnames", SyncTopicNamesHandler),
 			"Update the names of the topics created",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("resync_topics", ResyncTopicsHandler),
+			"Recreate deleted topics and remove stale topic mappings",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("send", SendToWhatsAppHandler),
 			"Send a message to WhatsApp",
@@ -118,6 +142,114 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("unblock", UnblockCommandHandler),
 			"Unblock a user in WhatsApp",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("away", AwayCommandHandler),
+			"Toggle automatic replies to WhatsApp private chats",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("lag", LagCommandHandler),
+			"Show the pending work and delays of the bridge",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("alert", AlertCommandHandler),
+			"Manage the keyword alert rules for incoming messages",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("chat_policy", ChatPolicyCommandHandler),
+			"View or change which media is skipped for the current thread",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("setgroupphoto", SetGroupPhotoHandler),
+			"Set the replied photo as the picture of the WhatsApp group",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("search", SearchCommandHandler),
+			"Search the bridged messages",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("vcard", VCardCommandHandler),
+			"Get the contact card of the WhatsApp contact of the current thread",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("export", ExportCommandHandler),
+			"Export the archived messages of a chat as JSON or HTML",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("stats", StatsCommandHandler),
+			"Show decryption and media download failures per chat",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("backfill", BackfillCommandHandler),
+			"Bridge older messages of a chat from the WhatsApp history",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("relogin", ReloginCommandHandler),
+			"Log back into WhatsApp using a QR code or a pairing code",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("loglevel", LogLevelCommandHandler),
+			"Show or change the log level of a module",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("wa_follow", FollowNewsletterHandler),
+			"Follow a WhatsApp channel and bridge its posts",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("wa_unfollow", UnfollowNewsletterHandler),
+			"Stop following a WhatsApp channel",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("avatar_history", AvatarHistoryHandler),
+			"Show the past profile pictures of a contact or group",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("pause_chat", PauseChatHandler),
+			"Stop bridging messages from the WhatsApp chat of the current topic",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("resume_chat", ResumeChatHandler),
+			"Bridge messages from the WhatsApp chat of the current topic again",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("silent", SilentChatHandler),
+			"Toggle bridging messages from the WhatsApp chat of the current topic without a notification",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("wa_forward", WaForwardHandler),
+			"Forward the replied to WhatsApp message to another WhatsApp chat",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("star", StarCommandHandler),
+			"Star the replied to message on WhatsApp",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("unstar", UnstarCommandHandler),
+			"Unstar the replied to message on WhatsApp",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("starred", StarredCommandHandler),
+			"List the starred messages of a chat or of all chats",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("remind", RemindCommandHandler),
+			"Send a message to a WhatsApp chat on a schedule",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("c", CannedReplyHandler),
+			"Send a canned reply to the WhatsApp chat of the topic",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("canned", CannedCommandHandler),
+			"Add, delete or list the canned replies",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("wa_blocklist", WaBlocklistHandler),
+			"List the users blocked on WhatsApp",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("backup", BackupCommandHandler),
+			"Make an encrypted backup of the bridge",
+		},
 	)
 
 	for _, command := range commands {
@@ -136,6 +268,27 @@ func AddTelegramHandlers() {
 		func(cq *gotgbot.CallbackQuery) bool {
 			return strings.HasPrefix(cq.Data, "revoke")
 		}, RevokeCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "joininvite_")
+		}, JoinInviteCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "joinreq_")
+		}, JoinRequestCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "newchat_")
+		}, NewChatCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "interactive_")
+		}, InteractiveCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "pollresults_")
+		}, PollResultsCallbackHandler), DispatcherCallbackHandlerGroup)
+	dispatcher.AddHandlerToGroup(handlers.NewPollAnswer(nil, PollAnswerHandler), DispatcherCallbackHandlerGroup)
 }
 
 func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
@@ -149,6 +302,10 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		}
 	}
 
+	if c.EffectiveMessage.PinnedMessage != nil {
+		return PinnedMessageHandler(b, c)
+	}
+
 	var (
 		waClient     = state.State.WhatsAppClient
 		msgToForward = c.EffectiveMessage
@@ -159,6 +316,10 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	var err error
 
 	if msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil {
+		if _, found, _ := database.MentionNotificationGet(c.EffectiveChat.Id, msgToReplyTo.MessageId); found {
+			return MentionReplyHandler(b, c)
+		}
+
 		stanzaID, participantID, waChatID, err = database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToReplyTo.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
@@ -174,7 +335,10 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		if err != nil {
 			return utils.TgReplyWithErrorByContext(b, c, "Failed to find the chat pairing between this topic and a WhatsApp chat", err)
 		} else if waChatID == "" {
-			if c.EffectiveMessage.MessageThreadId != 0 {
+			if state.State.Config.Telegram.SingleStream {
+				_, err = utils.TgReplyTextByContext(b, c, "Reply to a bridged message to send this to its WhatsApp chat", nil)
+				return err
+			} else if c.EffectiveMessage.MessageThreadId != 0 {
 				_, err = utils.TgReplyTextByContext(b, c, "No mapping found between current topic and a WhatsApp chat", nil)
 				return err
 			}
@@ -182,8 +346,16 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		}
 	}
 
+	// Messages sent in the stories topic of a contact go to their chat
+	if poster, isStoryTopic := utils.WaStoryTopicPoster(waChatID); isStoryTopic && stanzaID == "" {
+		waChatID = poster.String()
+	}
+
 	// Status Update
-	if strings.HasSuffix(waChatID, "@broadcast") {
+	if waChatID == waTypes.StatusBroadcastJID.String() && stanzaID != "" && msgToForward.Text != "" {
+		poster, _ := utils.WaParseJID(participantID)
+		return utils.TgSendStatusReply(b, c, msgToForward, poster.ToNonAD(), stanzaID)
+	} else if strings.HasSuffix(waChatID, "@broadcast") {
 		waChatID = participantID
 	} else if participantID != "" {
 		participant, _ := utils.WaParseJID(participantID)
@@ -195,6 +367,72 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return utils.TgSendToWhatsApp(b, c, msgToForward, msgToReplyTo, waChatJID, participantID, stanzaID, msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil)
 }
 
+// MentionReplyHandler sends a reply to a mention notification to the group as
+// a reply to the message which mentioned you
+func MentionReplyHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	msgToReplyTo := c.EffectiveMessage.ReplyToMessage
+	if msgToReplyTo == nil {
+		return nil
+	}
+
+	// Commands replying to the notification are for the bot
+	for _, entity := range c.EffectiveMessage.Entities {
+		if entity.Type == "bot_command" && entity.Offset == 0 {
+			return nil
+		}
+	}
+
+	notification, found, err := database.MentionNotificationGet(c.EffectiveChat.Id, msgToReplyTo.MessageId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive the mention from database", err)
+	} else if !found {
+		return nil
+	}
+
+	waChatJID, _ := utils.WaParseJID(notification.WaChatId)
+	return utils.TgSendToWhatsApp(b, c, c.EffectiveMessage, msgToReplyTo, waChatJID,
+		notification.Sender, notification.WaMsgId, true)
+}
+
+// PinnedMessageHandler pins the WhatsApp message mapped to the one pinned in
+// the topic. Telegram sends no update when a message is unpinned, so only pins
+// can be mirrored this way.
+func PinnedMessageHandler(b *gotgbot.Bot, c *ext.Context) error {
+	var (
+		waClient    = state.State.WhatsAppClient
+		pinnedMsg   = c.EffectiveMessage.PinnedMessage
+		stanzaID    string
+		waChatID    string
+		participant string
+		err         error
+	)
+
+	stanzaID, participant, waChatID, err = database.MsgIdGetWaFromTg(c.EffectiveChat.Id, pinnedMsg.MessageId, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
+	} else if stanzaID == "" {
+		return nil
+	}
+
+	if waChatID == waClient.Store.ID.String() || strings.HasSuffix(waChatID, "@broadcast") {
+		_, err = utils.TgReplyTextByContext(b, c, "Messages in this chat cannot be pinned on WhatsApp", nil)
+		return err
+	}
+
+	waChatJID, _ := utils.WaParseJID(waChatID)
+	senderJID, _ := utils.WaParseJID(participant)
+
+	err = utils.WaPinMessage(waChatJID, senderJID, stanzaID, true)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to pin the message on WhatsApp", err)
+	}
+	return nil
+}
+
 func StartCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -394,6 +632,9 @@ func ClearMessageIdPairsHistoryHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	err := database.MsgIdDropAllPairs()
+	if err == nil {
+		err = database.FanOutMessageDropAll()
+	}
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to delete stored pairs", err)
 	}
@@ -466,11 +707,11 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	var (
 		cfg      = state.State.Config
 		groupID  = args[1]
-		waClient = state.State.WhatsAppClient
+		waSender = state.State.WhatsAppSender
 	)
 
 	groupJID, _ := utils.WaParseJID(groupID)
-	groupInfo, err := waClient.GetGroupInfo(groupJID)
+	groupInfo, err := waSender.GetGroupInfo(groupJID)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to get group info", err)
 	}
@@ -629,13 +870,13 @@ func GetProfilePictureHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		waClient = state.State.WhatsAppClient
+		waSender = state.State.WhatsAppSender
 		userID   = args[1]
 	)
 
 	userJID, _ := utils.WaParseJID(userID)
 
-	ppInfo, err := waClient.GetProfilePictureInfo(userJID, &whatsmeow.GetProfilePictureParams{})
+	ppInfo, err := waSender.GetProfilePictureInfo(userJID, &whatsmeow.GetProfilePictureParams{})
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to fetch profile picture info from WhatsApp", err)
 	}
@@ -687,14 +928,16 @@ func SyncTopicNamesHandler(b *gotgbot.Bot, c *ext.Context) error {
 		waChatJid, _ := utils.WaParseJID(waChatId)
 
 		var newName string
-		if waChatJid.Server == waTypes.GroupServer {
-			newName = utils.WaGetGroupName(waChatJid)
+		if poster, isStoryTopic := utils.WaStoryTopicPoster(waChatId); isStoryTopic {
+			newName = utils.WaGetStoryTopicName(poster)
+		} else if waChatJid.Server == waTypes.GroupServer {
+			newName = utils.WaGetGroupTopicName(waChatJid)
 		} else {
 			newName = utils.WaGetContactName(waChatJid)
 		}
 
 		b.EditForumTopic(c.EffectiveChat.Id, tgThreadId, &gotgbot.EditForumTopicOpts{
-			Name:              newName,
+			Name:              utils.TgTopicNameWithPin(waChatId, newName),
 			IconCustomEmojiId: nil,
 		})
 		time.Sleep(5 * time.Second)
@@ -704,6 +947,75 @@ func SyncTopicNamesHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return err
 }
 
+func ResyncTopicsHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	tgChatId := state.State.Config.Telegram.TargetChatID
+
+	orphans, err := database.ChatThreadDropPairsNotInChat(tgChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "failed to remove chat thread pairs of other chats", err)
+	}
+
+	chatThreadPairs, err := database.ChatThreadGetAllPairs(tgChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "failed to retreive chat thread pairs from database", err)
+	}
+
+	var (
+		recreated int
+		failed    []string
+	)
+	for _, pair := range chatThreadPairs {
+		exists, err := utils.TgTopicExists(b, tgChatId, pair.TgThreadId)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("<code>%s</code>: %s", html.EscapeString(pair.ID), html.EscapeString(err.Error())))
+			continue
+		}
+		if exists {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		// Keys other than the special topics have to be a chat to recreate it for
+		if !strings.HasPrefix(pair.ID, "#") && !strings.Contains(pair.ID, "@") {
+			if err = database.ChatThreadDropPairByTg(tgChatId, pair.TgThreadId); err != nil {
+				failed = append(failed, fmt.Sprintf("<code>%s</code>: %s", html.EscapeString(pair.ID), html.EscapeString(err.Error())))
+			} else {
+				orphans += 1
+			}
+			continue
+		}
+
+		newTopic, err := b.CreateForumTopic(tgChatId, utils.TgTopicNameWithPin(pair.ID, utils.TgGetTopicNameForWa(pair.ID)), &gotgbot.CreateForumTopicOpts{
+			IconColor: utils.TgTopicIconColor(pair.ID),
+		})
+		if err == nil {
+			err = database.ChatThreadAddNewPair(pair.ID, tgChatId, newTopic.MessageThreadId)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("<code>%s</code>: %s", html.EscapeString(pair.ID), html.EscapeString(err.Error())))
+			continue
+		}
+		recreated += 1
+		time.Sleep(5 * time.Second)
+	}
+
+	summary := fmt.Sprintf("Successfully resynced topics\n\n<b>Checked</b>: %d\n<b>Recreated</b>: %d\n<b>Orphans removed</b>: %d\n<b>Failed</b>: %d",
+		len(chatThreadPairs), recreated, orphans, len(failed))
+	if len(failed) > 20 {
+		failed = append(failed[:20], "...")
+	}
+	if len(failed) > 0 {
+		summary += "\n\n" + strings.Join(failed, "\n")
+	}
+
+	_, err = c.EffectiveMessage.Reply(b, summary, nil)
+	return err
+}
+
 func HelpCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -751,6 +1063,80 @@ func SendToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return utils.TgSendToWhatsApp(b, c, msgToForward, msgToReplyTo, waChatJID, participantID, stanzaID, false)
 }
 
+// tgEditedContent returns the content of a WhatsApp edit for the edited text
+// or caption of a Telegram message, nil if WhatsApp can't edit the message
+func tgEditedContent(msg *gotgbot.Message) (*waProto.Message, string) {
+	switch {
+	case msg.Text != "":
+		return &waProto.Message{Conversation: proto.String(msg.Text)}, msg.Text
+	case msg.Photo != nil:
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: proto.String(msg.Caption)}}, msg.Caption
+	case msg.Video != nil, msg.Animation != nil:
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{Caption: proto.String(msg.Caption)}}, msg.Caption
+	case msg.Document != nil:
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{Caption: proto.String(msg.Caption)}}, msg.Caption
+	}
+	return nil, ""
+}
+
+// EditedMessageHandler edits the WhatsApp message mapped to a message edited
+// in Telegram, WhatsApp only allows this for text and captions within 15
+// minutes of sending
+func EditedMessageHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		cfg        = state.State.Config
+		waClient   = state.State.WhatsAppClient
+		waSender   = state.State.WhatsAppSender
+		editedMsg  = c.EffectiveMessage
+		editWindow = 15 * time.Minute
+	)
+
+	stanzaID, participantID, waChatID, err := database.MsgIdGetWaFromTg(c.EffectiveChat.Id, editedMsg.MessageId, editedMsg.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
+	} else if stanzaID == "" {
+		return nil
+	}
+
+	// Only your own messages can be edited, from any of your devices
+	editedContent, editedText := tgEditedContent(editedMsg)
+	if senderJid, _ := utils.WaParseJID(participantID); senderJid.User != waClient.Store.ID.User {
+		return nil
+	} else if editedContent == nil {
+		_, err = utils.TgReplyTextByContext(b, c, "Only edits of text messages and captions can be sent to WhatsApp", nil)
+		return err
+	} else if time.Since(time.Unix(editedMsg.Date, 0)) > editWindow {
+		_, err = utils.TgReplyTextByContext(b, c, "WhatsApp only allows editing messages within 15 minutes of sending them", nil)
+		return err
+	} else if len([]rune(editedText)) > cfg.WhatsApp.MaxOutgoingTextLength {
+		_, err = utils.TgReplyTextByContext(b, c, "The edited text is too long to fit in the WhatsApp message", nil)
+		return err
+	} else if strings.HasSuffix(waChatID, "@broadcast") {
+		_, err = utils.TgReplyTextByContext(b, c, "Stories cannot be edited", nil)
+		return err
+	}
+
+	waChatJID, _ := utils.WaParseJID(waChatID)
+	_, err = waSender.SendMessage(context.Background(), waChatJID, waSender.BuildEdit(waChatJID, stanzaID, editedContent))
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to edit the message on WhatsApp", err)
+	}
+	utils.ArchiveMessageEdit(stanzaID, waChatJID, editedText, time.Now())
+
+	msg, err := utils.TgReplyTextByContext(b, c, "Successfully edited", nil)
+	if err == nil {
+		go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
+			time.Sleep(15 * time.Second)
+			_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
+		}(b, msg)
+	}
+	return err
+}
+
 func RevokeCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -758,28 +1144,42 @@ func RevokeCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	usageString := "Usage: Reply to a message, <code>/revoke</code>"
 
-	if c.EffectiveMessage.ReplyToMessage == nil || c.EffectiveMessage.ReplyToMessage.ForumTopicClosed != nil {
+	if c.EffectiveMessage.ReplyToMessage == nil || c.EffectiveMessage.ReplyToMessage.ForumTopicCreated != nil {
 		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
 		return err
 	}
 
 	var (
 		waClient    = state.State.WhatsAppClient
+		waSender    = state.State.WhatsAppSender
 		msgToRevoke = c.EffectiveMessage.ReplyToMessage
 		chatId      = c.EffectiveChat.Id
 	)
 
-	waMsgId, _, waChatId, err := database.MsgIdGetWaFromTg(chatId, msgToRevoke.MessageId, msgToRevoke.MessageThreadId)
+	waMsgId, participantId, waChatId, err := database.MsgIdGetWaFromTg(chatId, msgToRevoke.MessageId, msgToRevoke.MessageThreadId)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "failed to retrieve WhatsApp side IDs", err)
+	} else if waMsgId == "" {
+		_, err = utils.TgReplyTextByContext(b, c, "The replied to message was not bridged from or to WhatsApp", nil)
+		return err
 	}
 
+	// Messages of others can only be revoked in groups you are an admin of
 	chatJid, _ := utils.WaParseJID(waChatId)
-	revokeMessage := waClient.BuildRevoke(chatJid, waTypes.EmptyJID, waMsgId)
-	_, err = waClient.SendMessage(context.Background(), chatJid, revokeMessage)
+	senderJid, _ := utils.WaParseJID(participantId)
+	if chatJid.Server != waTypes.GroupServer {
+		if senderJid.User != "" && senderJid.User != waClient.Store.ID.User {
+			_, err = utils.TgReplyTextByContext(b, c, "Only your own messages can be revoked outside of groups", nil)
+			return err
+		}
+		senderJid = waTypes.EmptyJID
+	}
+	revokeMessage := waSender.BuildRevoke(chatJid, senderJid, waMsgId)
+	_, err = waSender.SendMessage(context.Background(), chatJid, revokeMessage)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "failed to revoke message", err)
 	}
+	utils.ArchiveMessageRevoked(waMsgId, chatJid)
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully revoked", nil)
 	return err
@@ -791,7 +1191,7 @@ func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		waClient = state.State.WhatsAppClient
+		waSender = state.State.WhatsAppSender
 		cq       = c.CallbackQuery
 		data     = strings.Split(cq.Data, "_")
 	)
@@ -830,8 +1230,8 @@ func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 		} else if confirmation == "y" {
 
 			chatJid, _ := utils.WaParseJID(data[2])
-			revokeMesssage := waClient.BuildRevoke(chatJid, waTypes.EmptyJID, data[1])
-			_, err := waClient.SendMessage(context.Background(), chatJid, revokeMesssage)
+			revokeMesssage := waSender.BuildRevoke(chatJid, waTypes.EmptyJID, data[1])
+			_, err := waSender.SendMessage(context.Background(), chatJid, revokeMesssage)
 			if err != nil {
 				_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
 					Text:      "Failed to send revoke message: " + err.Error(),
@@ -876,3 +1276,1793 @@ func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return err
 	}
 }
+
+func AwayCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cfg := state.State.Config
+
+	enabled, message, err := database.AwayModeGet()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get away mode settings", err)
+	}
+
+	args := strings.SplitN(c.EffectiveMessage.Text, " ", 2)
+	if len(args) > 1 && strings.TrimSpace(args[1]) != "" {
+		if strings.ToLower(strings.TrimSpace(args[1])) == "off" {
+			enabled = false
+		} else {
+			enabled = true
+			message = strings.TrimSpace(args[1])
+		}
+	} else {
+		enabled = !enabled
+	}
+
+	err = database.AwayModeSet(enabled, message)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to save away mode settings", err)
+	}
+
+	if !enabled {
+		_, err = utils.TgReplyTextByContext(b, c, "Away mode has been turned off", nil)
+		return err
+	}
+
+	err = database.AwayModeClearReplies()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to reset away mode reply history", err)
+	}
+
+	if message == "" {
+		message = cfg.WhatsApp.AwayMode.DefaultMessage
+	}
+
+	replyText := "Away mode has been turned on with the message:\n\n"
+	replyText += fmt.Sprintf("<code>%s</code>\n\n", html.EscapeString(message))
+	replyText += fmt.Sprintf("Replying at most once every <b>%v</b> hour(s) per chat", cfg.WhatsApp.AwayMode.ReplyIntervalHours)
+	if cfg.WhatsApp.AwayMode.ScheduleStart != "" && cfg.WhatsApp.AwayMode.ScheduleEnd != "" {
+		replyText += fmt.Sprintf(", only between <b>%s</b> and <b>%s</b>",
+			html.EscapeString(cfg.WhatsApp.AwayMode.ScheduleStart), html.EscapeString(cfg.WhatsApp.AwayMode.ScheduleEnd))
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, replyText, nil)
+	return err
+}
+
+func LagCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	report := utils.LagGetReport()
+
+	lagMessage := "<b>Bridge Lag Report</b>\n\n"
+	lagMessage += fmt.Sprintf("  <b>Pending WhatsApp Events</b>: %v (oldest: %s)\n",
+		report.PendingEvents, report.OldestEventAge.Round(time.Millisecond))
+	lagMessage += fmt.Sprintf("  <b>Media Transfers In Progress</b>: %v (oldest: %s)\n",
+		report.PendingMedia, report.OldestMediaAge.Round(time.Millisecond))
+	lagMessage += fmt.Sprintf("  <b>Last Message Delivery Lag</b>: %s\n",
+		report.LastDeliveryLag.Round(time.Millisecond))
+
+	dbReport := database.LatencyGetReport()
+	lagMessage += fmt.Sprintf("  <b>Database Latency</b>: %s average, %s slowest of the last %v queries (%s overall, %v queries)\n",
+		dbReport.RecentAverage.Round(time.Microsecond), dbReport.RecentSlowest.Round(time.Microsecond),
+		dbReport.RecentOperations, dbReport.Average.Round(time.Microsecond), dbReport.Operations)
+	lagMessage += fmt.Sprintf("  <b>Pending Database Writes</b>: %v\n", dbReport.PendingWrites)
+
+	if len(report.PendingSends) > 0 {
+		lagMessage += fmt.Sprintf("  <b>Pending Sends Per Chat</b> (oldest: %s):\n",
+			report.OldestSendAge.Round(time.Millisecond))
+		for chat, count := range report.PendingSends {
+			lagMessage += fmt.Sprintf("    - <code>%s</code>: %v\n", html.EscapeString(chat), count)
+		}
+	} else {
+		lagMessage += "  No Pending Sends\n"
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, lagMessage, nil)
+	return err
+}
+
+func AlertCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage:\n"
+	usageString += "<code>" + html.EscapeString("/alert add <regex>") + "</code>\n"
+	usageString += "<code>" + html.EscapeString("/alert del <rule_id>") + "</code>\n"
+	usageString += "<code>/alert list</code>\n\n"
+	usageString += "Example: <code>/alert add (?i)urgent</code>"
+
+	args := strings.SplitN(c.EffectiveMessage.Text, " ", 3)
+	if len(args) <= 1 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	switch args[1] {
+
+	case "add":
+		if len(args) <= 2 || strings.TrimSpace(args[2]) == "" {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+		pattern := strings.TrimSpace(args[2])
+
+		if _, err := regexp.Compile(pattern); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "The provided regex is not valid", err)
+		}
+
+		ruleId, err := database.AlertRuleAdd(pattern)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to add the alert rule", err)
+		}
+		if err = utils.AlertRulesReload(); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Added the rule but failed to reload alert rules", err)
+		}
+
+		_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully added the alert rule with ID <code>%v</code>", ruleId), nil)
+		return err
+
+	case "del":
+		if len(args) <= 2 {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+
+		ruleId, err := strconv.ParseUint(strings.TrimSpace(args[2]), 10, 64)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "The provided rule ID is not valid", err)
+		}
+
+		deleted, err := database.AlertRuleDelete(uint(ruleId))
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to delete the alert rule", err)
+		} else if !deleted {
+			_, err = utils.TgReplyTextByContext(b, c, "No alert rule found with the given ID", nil)
+			return err
+		}
+		if err = utils.AlertRulesReload(); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Deleted the rule but failed to reload alert rules", err)
+		}
+
+		_, err = utils.TgReplyTextByContext(b, c, "Successfully deleted the alert rule", nil)
+		return err
+
+	case "list":
+		cfg := state.State.Config
+
+		rules, err := database.AlertRuleGetAll()
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the alert rules", err)
+		}
+
+		if len(rules) == 0 && len(cfg.WhatsApp.Alerts.Rules) == 0 {
+			_, err = utils.TgReplyTextByContext(b, c, "No alert rules have been set", nil)
+			return err
+		}
+
+		outputString := "Here are the alert rules:\n\n"
+		for _, pattern := range cfg.WhatsApp.Alerts.Rules {
+			outputString += fmt.Sprintf("- (config): <code>%s</code>\n", html.EscapeString(pattern))
+		}
+		for _, rule := range rules {
+			outputString += fmt.Sprintf("- %v: <code>%s</code>\n", rule.ID, html.EscapeString(rule.Pattern))
+		}
+
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+		return err
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+	return err
+}
+
+func ChatPolicyCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage (Send in a topic): <code>" + html.EscapeString("/chat_policy [<media_type> <skip|allow|default>]") + "</code>\n"
+	usageString += "Media types: <code>" + strings.Join(utils.MediaTypes, ", ") + "</code>\n"
+	usageString += "Example: <code>/chat_policy images skip</code>"
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+	waChatJid, _ := utils.WaParseJID(waChatId)
+
+	args := c.Args()
+	if len(args) == 1 {
+		outputString := "Media policy for this chat:\n\n"
+		for _, mediaType := range utils.MediaTypes {
+			skip, reason := utils.WaChatSkipsMedia(waChatJid, mediaType)
+			action := "bridged"
+			if skip {
+				action = "skipped"
+			}
+			outputString += fmt.Sprintf("- <code>%s</code>: %s (%s)\n", mediaType, action, html.EscapeString(reason))
+		}
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+		return err
+	}
+
+	if len(args) != 3 || !slices.Contains(utils.MediaTypes, args[1]) {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+	mediaType := args[1]
+
+	switch args[2] {
+	case "skip":
+		err = database.ChatMediaPolicySet(waChatJid.String(), mediaType, true)
+	case "allow":
+		err = database.ChatMediaPolicySet(waChatJid.String(), mediaType, false)
+	case "default":
+		err = database.ChatMediaPolicyDelete(waChatJid.String(), mediaType)
+	default:
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to save the media policy", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, "Successfully updated the media policy", nil)
+	return err
+}
+
+func SetGroupPhotoHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: Reply to a photo in a group's topic, <code>/setgroupphoto</code>"
+
+	msgToUse := c.EffectiveMessage.ReplyToMessage
+	if !c.EffectiveMessage.IsTopicMessage || msgToUse == nil || len(msgToUse.Photo) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+
+	waChatJid, _ := utils.WaParseJID(waChatId)
+	if waChatJid.Server != waTypes.GroupServer {
+		_, err := utils.TgReplyTextByContext(b, c, "The topic does not belong to a WhatsApp group", nil)
+		return err
+	}
+
+	bestPhoto := msgToUse.Photo[0]
+	for _, photo := range msgToUse.Photo {
+		if photo.Height*photo.Width > bestPhoto.Height*bestPhoto.Width {
+			bestPhoto = photo
+		}
+	}
+
+	photoFile, err := b.GetFile(bestPhoto.FileId, &gotgbot.GetFileOpts{
+		RequestOpts: &gotgbot.RequestOpts{
+			Timeout: -1,
+		},
+	})
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive image file from Telegram", err)
+	}
+
+	photoBytes, err := utils.TgDownloadByFilePath(b, photoFile.FilePath)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to download image from Telegram", err)
+	}
+
+	avatarBytes, err := utils.ImageToSquareJPEG(photoBytes, 640)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to convert image for WhatsApp", err)
+	}
+
+	waSender := state.State.WhatsAppSender
+	_, err = waSender.SetGroupPhoto(waChatJid, avatarBytes)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to set the group photo on WhatsApp", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, "Successfully updated the group photo", nil)
+	return err
+}
+
+func SearchCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if cfg := state.State.Config; !cfg.MessageArchive.Enabled || !cfg.MessageArchive.SearchIndex {
+		_, err := utils.TgReplyTextByContext(b, c, "Message archive with search index is not enabled in the config file", nil)
+		return err
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/search <query> [chat]") + "</code>\n\n"
+	usageString += "The chat should be the full JID, like <code>91xxxxxxxxxx@s.whatsapp.net</code>, "
+	usageString += "when used inside a topic the search is limited to that chat by default"
+
+	args := c.Args()[1:]
+	if len(args) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatId string
+	if lastArg := args[len(args)-1]; len(args) > 1 && strings.Contains(lastArg, "@") {
+		waChatJid, ok := utils.WaParseJID(lastArg)
+		if !ok {
+			_, err := utils.TgReplyTextByContext(b, c, "Invalid chat JID", nil)
+			return err
+		}
+		waChatId = waChatJid.String()
+		args = args[:len(args)-1]
+	} else if c.EffectiveMessage.IsTopicMessage {
+		var err error
+		waChatId, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		}
+	}
+
+	results, err := utils.SearchMessages(strings.Join(args, " "), waChatId, 10)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to search messages", err)
+	} else if len(results) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "No messages found", nil)
+		return err
+	}
+
+	outputString := fmt.Sprintf("Found %d messages:\n\n", len(results)) + tgFormatSearchResults(results)
+	_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+	return err
+}
+
+func tgFormatSearchResults(results []utils.SearchResult) string {
+	outputString := ""
+	for _, result := range results {
+		chatJid, _ := utils.WaParseJID(result.Message.WaChatId)
+		senderJid, _ := utils.WaParseJID(result.Message.SenderId)
+
+		header := fmt.Sprintf("%s in %s, %s",
+			utils.WaGetContactName(senderJid), utils.WaGetChatName(chatJid),
+			result.Message.Timestamp.In(state.State.LocalLocation).Format(state.State.Config.TimeFormat))
+		if result.Link != "" {
+			outputString += fmt.Sprintf("<a href=\"%s\">%s</a>\n", result.Link, html.EscapeString(header))
+		} else {
+			outputString += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(header))
+		}
+		if result.Text != "" {
+			outputString += html.EscapeString(utils.SubString(result.Text, 0, 200)) + "\n\n"
+		} else if result.Message.MediaType != "" {
+			outputString += "<i>" + html.EscapeString(result.Message.MediaType) + "</i>\n\n"
+		} else {
+			outputString += "\n"
+		}
+	}
+	return outputString
+}
+
+func VCardCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a private chat's topic", nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+
+	waChatJid, _ := utils.WaParseJID(waChatId)
+	if waChatJid.Server != waTypes.DefaultUserServer {
+		_, err := utils.TgReplyTextByContext(b, c, "The topic does not belong to a WhatsApp contact", nil)
+		return err
+	}
+
+	cardBytes, firstName, lastName := utils.WaBuildContactVCard(waChatJid)
+
+	opts := &gotgbot.SendContactOpts{
+		LastName:         lastName,
+		Vcard:            string(cardBytes),
+		ReplyToMessageId: c.EffectiveMessage.MessageId,
+		MessageThreadId:  c.EffectiveMessage.MessageThreadId,
+	}
+	_, err = b.SendContact(c.EffectiveChat.Id, "+"+waChatJid.User, firstName, opts)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to send the contact card", err)
+	}
+
+	return nil
+}
+
+func ExportCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !state.State.Config.MessageArchive.Enabled {
+		_, err := utils.TgReplyTextByContext(b, c, "Message archive is not enabled in the config file", nil)
+		return err
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/export <chat|here> [from] [to] [json|html]") + "</code>\n\n"
+	usageString += "Dates should be in <code>YYYY-MM-DD</code> format, <code>here</code> exports the chat of the current topic\n"
+	usageString += "Example: <code>/export 91xxxxxxxxxx 2024-01-01 2024-01-31 html</code>"
+
+	var (
+		format = "json"
+		args   []string
+	)
+	for _, arg := range c.Args()[1:] {
+		if arg == "json" || arg == "html" {
+			format = arg
+		} else {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 || len(args) > 3 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatJid waTypes.JID
+	if args[0] == "here" {
+		if !c.EffectiveMessage.IsTopicMessage {
+			_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic to use <code>here</code>", nil)
+			return err
+		}
+		waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		} else if waChatId == "" {
+			_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+			return err
+		}
+		waChatJid, _ = utils.WaParseJID(waChatId)
+	} else {
+		var ok bool
+		if waChatJid, ok = utils.WaParseJID(args[0]); !ok {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+	}
+
+	from, to := time.Unix(0, 0), time.Now().Add(time.Minute)
+	for idx, arg := range args[1:] {
+		date, err := time.ParseInLocation("2006-01-02", arg, state.State.LocalLocation)
+		if err != nil {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+		if idx == 0 {
+			from = date
+		} else {
+			to = date.AddDate(0, 0, 1)
+		}
+	}
+
+	msgs, err := database.ArchivedMessageGetRange(waChatJid.String(), from, to)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get archived messages", err)
+	} else if len(msgs) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "No archived messages found for the chat in the given period", nil)
+		return err
+	}
+
+	exported, err := utils.ExportBuildChat(waChatJid, msgs)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to read archived messages", err)
+	}
+
+	var fileBytes []byte
+	if format == "html" {
+		fileBytes, err = utils.ExportChatHTML(exported)
+	} else {
+		fileBytes, err = utils.ExportChatJSON(exported)
+	}
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to generate export file", err)
+	}
+
+	fileToSend := gotgbot.NamedFile{
+		FileName: fmt.Sprintf("WhatsApp Chat - %s.%s", waChatJid.User, format),
+		File:     bytes.NewReader(fileBytes),
+	}
+	opts := &gotgbot.SendDocumentOpts{
+		Caption:          fmt.Sprintf("Exported %d messages of <b>%s</b>", len(msgs), html.EscapeString(exported.ChatName)),
+		ReplyToMessageId: c.EffectiveMessage.MessageId,
+		RequestOpts: &gotgbot.RequestOpts{
+			Timeout: -1,
+		},
+	}
+	if c.EffectiveMessage.IsTopicMessage {
+		opts.MessageThreadId = c.EffectiveMessage.MessageThreadId
+	}
+	_, err = b.SendDocument(c.EffectiveChat.Id, fileToSend, opts)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to send export file", err)
+	}
+
+	return nil
+}
+
+func StatsCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	allStats, err := utils.StatsGetAll()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the stats", err)
+	}
+
+	since := state.State.StartTime
+	chats := make([]string, 0, len(allStats))
+	for chat, stats := range allStats {
+		chats = append(chats, chat)
+		if stats.Since.Before(since) {
+			since = stats.Since
+		}
+	}
+	failures := func(s database.ChatStat) int64 {
+		return s.Undecryptable + s.Unavailable + s.MediaFailures
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return failures(allStats[chats[i]]) > failures(allStats[chats[j]])
+	})
+
+	statsMessage := fmt.Sprintf("<b>Bridge Stats</b> (since %s)\n\n",
+		html.EscapeString(since.In(state.State.LocalLocation).Format(state.State.Config.TimeFormat)))
+
+	var totalUndecryptable, totalRecovered, totalDownloads, totalMediaFailures, totalStoreHits int64
+	for _, stats := range allStats {
+		totalUndecryptable += stats.Undecryptable + stats.Unavailable
+		totalRecovered += stats.RetryRecovered
+		totalDownloads += stats.MediaDownloads
+		totalMediaFailures += stats.MediaFailures
+		totalStoreHits += stats.MediaStoreHits
+	}
+	statsMessage += fmt.Sprintf("  <b>Undecryptable Messages</b>: %v\n", totalUndecryptable)
+	statsMessage += fmt.Sprintf("  <b>Recovered By Retry</b>: %v (%s)\n", totalRecovered, percentage(totalRecovered, totalUndecryptable))
+	statsMessage += fmt.Sprintf("  <b>Media Download Failures</b>: %v/%v (%s)\n", totalMediaFailures, totalDownloads,
+		percentage(totalMediaFailures, totalDownloads))
+	statsMessage += fmt.Sprintf("  <b>Media From The Store</b>: %v\n\n", totalStoreHits)
+
+	shown := 0
+	for _, chat := range chats {
+		stats := allStats[chat]
+		if failures(stats) == 0 || shown == 15 {
+			continue
+		}
+		shown += 1
+
+		chatJid, _ := utils.WaParseJID(chat)
+		statsMessage += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(utils.WaGetChatName(chatJid)))
+		statsMessage += fmt.Sprintf("    undecryptable: %v, unavailable: %v, recovered: %v\n",
+			stats.Undecryptable, stats.Unavailable, stats.RetryRecovered)
+		statsMessage += fmt.Sprintf("    media failures: %v/%v (%s)\n", stats.MediaFailures, stats.MediaDownloads,
+			percentage(stats.MediaFailures, stats.MediaDownloads))
+	}
+	if shown == 0 {
+		statsMessage += "No chats with failures"
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, statsMessage, nil)
+	return err
+}
+
+func percentage(part, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+func BackfillCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/backfill <chat|here> [count]") + "</code>\n\n"
+	usageString += "Requests <code>count</code> (default 50) messages sent before the oldest known one from your phone, "
+	usageString += "<code>here</code> uses the chat of the current topic\n"
+	usageString += "Example: <code>/backfill 91xxxxxxxxxx 100</code>"
+
+	args := c.Args()[1:]
+	if len(args) == 0 || len(args) > 2 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatJid waTypes.JID
+	if args[0] == "here" {
+		if !c.EffectiveMessage.IsTopicMessage {
+			_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic to use <code>here</code>", nil)
+			return err
+		}
+		waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		} else if waChatId == "" {
+			_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+			return err
+		}
+		waChatJid, _ = utils.WaParseJID(waChatId)
+	} else {
+		var ok bool
+		if waChatJid, ok = utils.WaParseJID(args[0]); !ok {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+	}
+
+	count := 50
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil || count <= 0 {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+	}
+
+	if err := utils.WaRequestHistory(waChatJid, count); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to request history", err)
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf(
+		"Requested %d older messages of <code>%s</code>, they will be bridged once your phone sends them",
+		count, html.EscapeString(waChatJid.String())), nil)
+	return err
+}
+
+func ReloginCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if waClient := state.State.WhatsAppClient; waClient.Store.ID != nil {
+		_, err := utils.TgReplyTextByContext(b, c, "Already logged into WhatsApp", nil)
+		return err
+	}
+
+	var phone string
+	if args := c.Args(); len(args) > 1 {
+		phone = args[1]
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, "Sending the codes to log back into WhatsApp to the owner...", nil)
+	go func() {
+		if err := utils.WaLoginViaTelegram(phone); err != nil {
+			utils.TgReportError("Failed to log back into WhatsApp", err)
+		}
+	}()
+	return err
+}
+
+func LogLevelCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	args := c.Args()
+	if len(args) == 1 {
+		outputText := "<b>Log levels:</b>\n"
+		for _, module := range utils.LogModules {
+			outputText += fmt.Sprintf("\n- <code>%s</code>: %s", module, utils.LogLevelGet(module))
+		}
+		outputText += "\n\nUsage: <code>/loglevel module level</code>"
+		_, err := utils.TgReplyTextByContext(b, c, outputText, nil)
+		return err
+	} else if len(args) != 3 {
+		_, err := utils.TgReplyTextByContext(b, c, "Usage: <code>/loglevel module level</code>", nil)
+		return err
+	}
+
+	if err := utils.LogLevelSet(args[1], args[2]); err != nil {
+		_, err = utils.TgReplyTextByContext(b, c, html.EscapeString(err.Error()), nil)
+		return err
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Set the log level of <code>%s</code> to %s", html.EscapeString(args[1]), html.EscapeString(args[2])), nil)
+	return err
+}
+
+func FollowNewsletterHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/wa_follow <invite_link>") + "</code>\n"
+	usageString += "Example: <code>/wa_follow https://whatsapp.com/channel/0029Va...</code>"
+
+	args := c.Args()
+	if len(args) != 2 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	info, err := utils.WaNewsletterFollow(args[1])
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to follow the channel", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Following the channel <b>%s</b> with ID: <code>%s</code>",
+			html.EscapeString(info.ThreadMeta.Name.Text), info.ID.String()), nil)
+	return err
+}
+
+func UnfollowNewsletterHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/wa_unfollow <channel_id|here>") + "</code>\n"
+	usageString += "<code>here</code> uses the channel of the current topic\n"
+	usageString += "Example: <code>/wa_unfollow 120363xxxxxxxxxxxx@newsletter</code>"
+
+	args := c.Args()
+	if len(args) != 2 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatId string
+	if args[1] == "here" {
+		if !c.EffectiveMessage.IsTopicMessage {
+			_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic to use <code>here</code>", nil)
+			return err
+		}
+		var err error
+		waChatId, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		} else if waChatId == "" {
+			_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+			return err
+		}
+	} else {
+		waChatId = args[1]
+		if !strings.ContainsRune(waChatId, '@') {
+			waChatId += "@" + waTypes.NewsletterServer
+		}
+	}
+
+	waChatJid, err := waTypes.ParseJID(waChatId)
+	if err != nil {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	if err = utils.WaNewsletterUnfollow(waChatJid); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to unfollow the channel", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Unfollowed the channel <code>%s</code>", waChatJid.String()), nil)
+	return err
+}
+
+func AvatarHistoryHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/avatar_history <chat|here>") + "</code>\n"
+	usageString += "<code>here</code> uses the chat of the current topic\n"
+	usageString += "Example: <code>/avatar_history 91xxxxxxxxxx</code>"
+
+	args := c.Args()
+	if len(args) != 2 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatJid waTypes.JID
+	if args[1] == "here" {
+		if !c.EffectiveMessage.IsTopicMessage {
+			_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic to use <code>here</code>", nil)
+			return err
+		}
+		waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		} else if waChatId == "" {
+			_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+			return err
+		}
+		waChatJid, _ = utils.WaParseJID(waChatId)
+	} else {
+		var ok bool
+		if waChatJid, ok = utils.WaParseJID(args[1]); !ok {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+	}
+
+	changes, err := database.AvatarChangeGetLatest(waChatJid.ToNonAD().String(), 20)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the profile picture changes", err)
+	} else if len(changes) == 0 {
+		_, err = utils.TgReplyTextByContext(b, c, "No profile picture changes were recorded for this chat", nil)
+		return err
+	}
+
+	var (
+		cfg       = state.State.Config
+		replyText = fmt.Sprintf("Profile picture changes of <b>%s</b>:\n\n", html.EscapeString(utils.WaGetChatName(waChatJid)))
+		media     []gotgbot.InputMedia
+	)
+	for _, change := range changes {
+		changedAt := change.ChangedAt.In(state.State.LocalLocation).Format(cfg.TimeFormat)
+		action := "Updated"
+		if change.Removed {
+			action = "Removed"
+		}
+		replyText += fmt.Sprintf("- %s on <i>%s</i>", action, html.EscapeString(changedAt))
+		if change.Author != "" {
+			author, _ := utils.WaParseJID(change.Author)
+			replyText += fmt.Sprintf(" by %s", html.EscapeString(utils.WaGetContactName(author)))
+		}
+		replyText += "\n"
+
+		if picture, found := utils.AvatarLoad(change); found && len(media) < 10 {
+			media = append(media, gotgbot.InputMediaPhoto{
+				Media:     gotgbot.NamedFile{File: bytes.NewReader(picture), FileName: "avatar.jpg"},
+				Caption:   html.EscapeString(changedAt),
+				ParseMode: "html",
+			})
+		}
+	}
+
+	if _, err = utils.TgReplyTextByContext(b, c, replyText, nil); err != nil {
+		return err
+	}
+	if len(media) == 1 {
+		// Media groups need at least two items
+		photo := media[0].(gotgbot.InputMediaPhoto)
+		_, err = b.SendPhoto(c.EffectiveChat.Id, photo.Media, &gotgbot.SendPhotoOpts{
+			MessageThreadId: c.EffectiveMessage.MessageThreadId,
+			Caption:         photo.Caption,
+		})
+	} else if len(media) > 1 {
+		_, err = b.SendMediaGroup(c.EffectiveChat.Id, media, &gotgbot.SendMediaGroupOpts{
+			MessageThreadId: c.EffectiveMessage.MessageThreadId,
+		})
+	}
+	return err
+}
+
+func JoinInviteCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		waClient = state.State.WhatsAppClient
+		cq       = c.CallbackQuery
+		msgId    = strings.TrimPrefix(cq.Data, "joininvite_")
+	)
+
+	invite, found, err := database.GroupInviteGet(msgId)
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to retrieve the invite: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	} else if !found {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The invite was not found, it might have been accepted already",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	if invite.Expiration != 0 && time.Now().Unix() > invite.Expiration {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The invite has expired",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	groupJid, _ := waTypes.ParseJID(invite.GroupJid)
+	inviterJid, _ := waTypes.ParseJID(invite.Inviter)
+	err = waClient.JoinGroupWithInvite(groupJid, inviterJid, invite.Code, invite.Expiration)
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to join the group: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	database.GroupInviteDelete(msgId)
+	b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+		ChatId:    c.EffectiveChat.Id,
+		MessageId: c.EffectiveMessage.MessageId,
+		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{},
+		},
+	})
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text:      "Joined the group",
+		ShowAlert: true,
+		CacheTime: 60,
+	})
+	return err
+}
+
+func JoinRequestCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		waClient = state.State.WhatsAppClient
+		cq       = c.CallbackQuery
+		data     = strings.SplitN(cq.Data, "_", 4)
+	)
+
+	if cq.Data == "joinreq_done" {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The request was already handled",
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	if len(data) != 4 || (data[1] != "a" && data[1] != "r") {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Invalid callback query",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	groupJid := waTypes.NewJID(data[2], waTypes.GroupServer)
+	requesterJid, _ := utils.WaParseJID(data[3])
+
+	action, resultText := whatsmeow.ParticipantChangeApprove, "Approved"
+	if data[1] == "r" {
+		action, resultText = whatsmeow.ParticipantChangeReject, "Rejected"
+	}
+
+	_, err := waClient.UpdateGroupRequestParticipants(groupJid, []waTypes.JID{requesterJid}, action)
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to update the request: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+		ChatId:    c.EffectiveChat.Id,
+		MessageId: c.EffectiveMessage.MessageId,
+		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text:         resultText,
+				CallbackData: "joinreq_done",
+			}}},
+		},
+	})
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text:      resultText + " the request to join",
+		ShowAlert: false,
+		CacheTime: 60,
+	})
+	return err
+}
+
+// NewChatCallbackHandler accepts or ignores a chat held in the #NewChats topic
+func NewChatCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		cfg  = state.State.Config
+		cq   = c.CallbackQuery
+		data = strings.SplitN(cq.Data, "_", 3)
+	)
+
+	if cq.Data == "newchat_done" {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The chat was already accepted",
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	if len(data) != 3 || (data[1] != "a" && data[1] != "i") {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Invalid callback query",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	chatJid := waTypes.NewJID(data[2], waTypes.DefaultUserServer)
+
+	if data[1] == "i" {
+		if err := database.QuarantinedChatSet(chatJid.String(), database.QuarantineIgnored); err != nil {
+			_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+				Text:      "Failed to ignore the chat: " + err.Error(),
+				ShowAlert: true,
+			})
+			return err
+		}
+
+		b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+			ChatId:      c.EffectiveChat.Id,
+			MessageId:   c.EffectiveMessage.MessageId,
+			ReplyMarkup: utils.TgMakeNewChatKeyboard(chatJid, true),
+		})
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text: "Messages from the chat will be dropped",
+		})
+		return err
+	}
+
+	if err := database.QuarantinedChatDelete(chatJid.String()); err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to accept the chat: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa(chatJid.String(), cfg.Telegram.TargetChatID,
+		utils.TgGetTopicNameForWa(chatJid.String()))
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to create the topic of the chat: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+	b.SendMessage(cfg.Telegram.TargetChatID,
+		fmt.Sprintf("Accepted the chat, its <a href=\"%s\">earlier messages</a> are in #NewChats",
+			utils.TgBuildMessageLink(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId, c.EffectiveMessage.MessageId)),
+		&gotgbot.SendMessageOpts{MessageThreadId: threadId})
+
+	b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+		ChatId:    c.EffectiveChat.Id,
+		MessageId: c.EffectiveMessage.MessageId,
+		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text:         "Accepted",
+				CallbackData: "newchat_done",
+			}}},
+		},
+	})
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "Accepted the chat",
+	})
+	return err
+}
+
+func InteractiveCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cq := c.CallbackQuery
+
+	optionId, err := strconv.ParseUint(strings.TrimPrefix(cq.Data, "interactive_"), 10, 64)
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Invalid callback query",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	option, found, err := database.InteractiveOptionGet(uint(optionId))
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to retrieve the option: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	} else if !found {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The option was not found",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	if err = utils.WaSendInteractiveResponse(option); err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to send the response: " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "Sent: " + option.DisplayText,
+	})
+	return err
+}
+
+// PollAnswerHandler sends the votes on bridged polls to WhatsApp. Poll answers
+// have no message, so failures are replied to the poll.
+func PollAnswerHandler(b *gotgbot.Bot, c *ext.Context) error {
+	answer := c.PollAnswer
+	if answer.User == nil || !utils.TgUserIsAuthorized(answer.User.Id) {
+		return nil
+	}
+
+	poll, found, err := utils.WaSendPollVote(answer.PollId, answer.OptionIds)
+	if !found || err == nil {
+		return err
+	}
+
+	database.ActivityEventAdd(database.ActivityFailure, poll.WaChatId, 0)
+	_, err = b.SendMessage(poll.TgChatId,
+		fmt.Sprintf("Failed to send the vote to WhatsApp:\n\n<code>%s</code>", html.EscapeString(err.Error())),
+		&gotgbot.SendMessageOpts{
+			MessageThreadId:  poll.TgThreadId,
+			ReplyToMessageId: poll.TgMsgId,
+		})
+	return err
+}
+
+func PollResultsCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cq := c.CallbackQuery
+
+	poll, found, err := database.WaPollGet(strings.TrimPrefix(cq.Data, "pollresults_"))
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the poll", err)
+	} else if !found {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "The poll was not found",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	if err = utils.TgUpdatePollResults(b, poll); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to post the results", err)
+	}
+
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "Updated the results",
+	})
+	return err
+}
+
+func PauseChatHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+
+	if err = database.PausedChatAdd(waChatId); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to pause the chat", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c,
+		"Successfully paused the chat, messages from it are not bridged until /resume_chat is sent here", nil)
+	return err
+}
+
+func ResumeChatHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+
+	paused, found, err := database.PausedChatGet(waChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the pause of the chat", err)
+	} else if !found {
+		_, err := utils.TgReplyTextByContext(b, c, "The chat is not paused", nil)
+		return err
+	}
+	if err = database.PausedChatDelete(waChatId); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to resume the chat", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully resumed the chat, it was paused for %s",
+		time.Since(paused.PausedAt).Round(time.Minute)), nil)
+	return err
+}
+
+func SilentChatHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+		return err
+	}
+
+	unsilenced, err := database.SilentChatDelete(waChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to change the silent mode of the chat", err)
+	}
+	if !unsilenced {
+		if err = database.SilentChatAdd(waChatId); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to change the silent mode of the chat", err)
+		}
+		_, err = utils.TgReplyTextByContext(b, c,
+			"Messages from the chat are now bridged without a notification, send /silent again to undo it", nil)
+		return err
+	}
+
+	replyText := "Messages from the chat are bridged with a notification again"
+	if utils.WaChatIsSilent(waChatId) {
+		replyText = "The chat is no longer silent here, but it is still in <code>silent_chats</code> of the config"
+	} else if utils.WaChatIsMuted(waChatId) {
+		replyText = "The chat is no longer silent here, but it is still muted on WhatsApp"
+	}
+	_, err = utils.TgReplyTextByContext(b, c, replyText, nil)
+	return err
+}
+
+func WaForwardHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: Reply to a message, <code>" + html.EscapeString("/wa_forward <target_id>") + "</code>\n"
+	usageString += "Example: <code>/wa_forward 911234567890</code>"
+
+	args := c.Args()
+	if len(args) <= 1 || c.EffectiveMessage.ReplyToMessage == nil || c.EffectiveMessage.ReplyToMessage.ForumTopicCreated != nil {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	targetJID, ok := utils.WaParseJID(args[1])
+	if !ok {
+		_, err := utils.TgReplyTextByContext(b, c, "Provided JID is not valid", nil)
+		return err
+	}
+
+	var (
+		cfg          = state.State.Config
+		msgToForward = c.EffectiveMessage.ReplyToMessage
+	)
+
+	waMsgId, _, waChatId, err := database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve WhatsApp side IDs", err)
+	} else if waMsgId == "" {
+		_, err = utils.TgReplyTextByContext(b, c, "The replied to message was not bridged from WhatsApp", nil)
+		return err
+	}
+
+	sourceJID, _ := utils.WaParseJID(waChatId)
+	waMsg, found, err := utils.ForwardableGet(waMsgId, sourceJID)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the message from database", err)
+	} else if !found && cfg.WhatsApp.ForwardableMessagesDays <= 0 {
+		_, err = utils.TgReplyTextByContext(b, c,
+			"The content of received messages is not stored, set <code>forwardable_messages_days</code> to forward them", nil)
+		return err
+	} else if !found {
+		_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf(
+			"The content of the message is not stored, only messages received from WhatsApp in the last %d days can be forwarded",
+			cfg.WhatsApp.ForwardableMessagesDays), nil)
+		return err
+	}
+
+	sentMsg, err := utils.WaForwardMessage(sourceJID, targetJID, waMsg)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to forward the message", err)
+	}
+
+	// Map the forwarded message to the topic of the target chat, where the
+	// replied to message itself is not, so replies to it there work
+	if threadId, err := utils.TgGetOrMakeThreadFromWa(targetJID.String(), cfg.Telegram.TargetChatID,
+		utils.TgGetTopicNameForWa(targetJID.String())); err == nil {
+		sentNotice, err := b.SendMessage(cfg.Telegram.TargetChatID,
+			fmt.Sprintf("Forwarded a message from <b>%s</b>", html.EscapeString(utils.TgGetTopicNameForWa(waChatId))),
+			&gotgbot.SendMessageOpts{MessageThreadId: threadId})
+		if err == nil {
+			database.MsgIdAddNewPair(sentMsg.ID, state.State.WhatsAppClient.Store.ID.String(), targetJID.String(),
+				cfg.Telegram.TargetChatID, sentNotice.MessageId, sentNotice.MessageThreadId)
+		}
+	}
+
+	revokeKeyboard := utils.TgMakeRevokeKeyboard(sentMsg.ID, targetJID.String(), false)
+	_, err = utils.TgReplyTextByContext(b, c, "Successfully forwarded", revokeKeyboard)
+	return err
+}
+
+func StarCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return tgStarMessage(b, c, true)
+}
+
+func UnstarCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return tgStarMessage(b, c, false)
+}
+
+func tgStarMessage(b *gotgbot.Bot, c *ext.Context, starred bool) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	command := "/star"
+	if !starred {
+		command = "/unstar"
+	}
+	usageString := "Usage: Reply to a message, <code>" + command + "</code>"
+
+	if c.EffectiveMessage.ReplyToMessage == nil || c.EffectiveMessage.ReplyToMessage.ForumTopicCreated != nil {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var (
+		waClient = state.State.WhatsAppClient
+		msgToRef = c.EffectiveMessage.ReplyToMessage
+	)
+
+	waMsgId, participantId, waChatId, err := database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToRef.MessageId, msgToRef.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve WhatsApp side IDs", err)
+	} else if waMsgId == "" {
+		_, err = utils.TgReplyTextByContext(b, c, "The replied to message was not bridged from or to WhatsApp", nil)
+		return err
+	}
+
+	chatJid, _ := utils.WaParseJID(waChatId)
+	senderJid, _ := utils.WaParseJID(participantId)
+	isFromMe := senderJid.User == waClient.Store.ID.User
+
+	if err = utils.WaStarMessage(chatJid, senderJid, waMsgId, isFromMe, starred); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to update the message on WhatsApp", err)
+	}
+	utils.ArchiveMessageStarred(waMsgId, chatJid, starred)
+
+	if starred {
+		_, err = utils.TgReplyTextByContext(b, c, "Successfully starred", nil)
+	} else {
+		_, err = utils.TgReplyTextByContext(b, c, "Successfully unstarred", nil)
+	}
+	return err
+}
+
+func StarredCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !state.State.Config.MessageArchive.Enabled {
+		_, err := utils.TgReplyTextByContext(b, c, "Message archive is not enabled in the config file", nil)
+		return err
+	}
+
+	var (
+		args     = c.Args()[1:]
+		waChatId string
+	)
+	if len(args) > 0 {
+		waChatJid, ok := utils.WaParseJID(args[0])
+		if !ok {
+			_, err := utils.TgReplyTextByContext(b, c, "Invalid chat JID", nil)
+			return err
+		}
+		waChatId = waChatJid.String()
+	} else if c.EffectiveMessage.IsTopicMessage {
+		var err error
+		waChatId, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		}
+	}
+
+	results, err := utils.StarredMessages(waChatId, 20)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get starred messages", err)
+	} else if len(results) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "No starred messages found", nil)
+		return err
+	}
+
+	outputString := fmt.Sprintf("Latest %d starred messages:\n\n", len(results)) + tgFormatSearchResults(results)
+	_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+	return err
+}
+
+func RemindCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cfg := state.State.Config
+
+	usageString := "Usage:\n"
+	usageString += "<code>" + html.EscapeString("/remind [chat] <cron> | <text>") + "</code> to add a reminder\n"
+	usageString += "<code>/remind list</code> to list the reminders\n"
+	usageString += "<code>" + html.EscapeString("/remind delete <id>") + "</code> to delete a reminder\n\n"
+	usageString += "The chat is that of the topic when used inside one. The schedule is a cron expression "
+	usageString += "in the configured time zone, or a descriptor like <code>@daily</code>\n"
+	usageString += "Example: <code>/remind 50 9 * * 1-5 | Standup in 10 min</code>"
+
+	args := c.Args()[1:]
+	if len(args) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		reminders, err := database.ReminderGetAll()
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get reminders from database", err)
+		}
+		if len(reminders) == 0 && len(cfg.Reminders) == 0 {
+			_, err = utils.TgReplyTextByContext(b, c, "No reminders are set", nil)
+			return err
+		}
+
+		outputString := ""
+		for _, reminder := range cfg.Reminders {
+			outputString += fmt.Sprintf("<b>config</b>: <code>%s</code> to %s\n%s\n\n",
+				html.EscapeString(reminder.Cron), html.EscapeString(reminder.Chat),
+				html.EscapeString(utils.SubString(reminder.Text, 0, 100)))
+		}
+		for _, reminder := range reminders {
+			outputString += fmt.Sprintf("<b>%d</b>: <code>%s</code> to %s, next at %s\n%s\n\n",
+				reminder.ID, html.EscapeString(reminder.Cron), html.EscapeString(utils.TgGetTopicNameForWa(reminder.WaChatId)),
+				html.EscapeString(utils.ReminderNextRun(reminder.ID)), html.EscapeString(utils.SubString(reminder.Text, 0, 100)))
+		}
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+		return err
+
+	case "delete":
+		if len(args) < 2 {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			_, err = utils.TgReplyTextByContext(b, c, "Invalid reminder ID, reminders from the config file can only be removed there", nil)
+			return err
+		}
+
+		deleted, err := database.ReminderDelete(uint(id))
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to delete the reminder", err)
+		} else if !deleted {
+			_, err = utils.TgReplyTextByContext(b, c, "No reminder with that ID", nil)
+			return err
+		}
+		utils.ReminderUnschedule(uint(id))
+
+		_, err = utils.TgReplyTextByContext(b, c, "Successfully deleted the reminder", nil)
+		return err
+	}
+
+	_, rest := utils.TgCutWord(c.EffectiveMessage.Text)
+	schedule, text, found := strings.Cut(rest, "|")
+	text = strings.TrimSpace(text)
+	if !found || text == "" {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	var waChatId string
+	scheduleFields := strings.Fields(schedule)
+	if c.EffectiveMessage.IsTopicMessage && c.EffectiveMessage.MessageThreadId != 0 {
+		var err error
+		waChatId, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		} else if waChatId == "" || strings.HasPrefix(waChatId, "#") {
+			_, err = utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil)
+			return err
+		}
+	} else {
+		if len(scheduleFields) < 2 {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+		waChatJID, ok := utils.ReminderParseChat(scheduleFields[0])
+		if !ok {
+			_, err := utils.TgReplyTextByContext(b, c, "Provided JID is not valid", nil)
+			return err
+		}
+		waChatId = waChatJID.String()
+		scheduleFields = scheduleFields[1:]
+	}
+
+	reminder := database.Reminder{
+		Cron:     strings.Join(scheduleFields, " "),
+		WaChatId: waChatId,
+		Text:     text,
+	}
+	if err := database.ReminderAdd(&reminder); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to save the reminder", err)
+	}
+	if err := utils.ReminderSchedule(reminder); err != nil {
+		database.ReminderDelete(reminder.ID)
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to schedule the reminder", err)
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully added reminder <b>%d</b>, next sent at %s",
+		reminder.ID, html.EscapeString(utils.ReminderNextRun(reminder.ID))), nil)
+	return err
+}
+
+func CannedReplyHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/c <name>") + "</code> in a topic, "
+	usageString += "optionally as a reply to a message. See /canned for the saved replies"
+
+	args := c.Args()
+	if len(args) != 2 || !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	reply, found, err := database.CannedReplyGet(strings.ToLower(args[1]))
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the canned reply", err)
+	} else if !found {
+		_, err = utils.TgReplyTextByContext(b, c, "No canned reply with that name, see /canned list", nil)
+		return err
+	}
+
+	// The contact is the sender of the message replied to, or the chat itself
+	var (
+		msgToReplyTo            = c.EffectiveMessage.ReplyToMessage
+		participantId, waChatId string
+	)
+	if msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil {
+		_, participantId, waChatId, err = database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToReplyTo.MessageId, msgToReplyTo.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
+		}
+	}
+	if waChatId == "" {
+		waChatId, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to find the chat pairing between this topic and a WhatsApp chat", err)
+		} else if waChatId == "" {
+			_, err = utils.TgReplyTextByContext(b, c, "No mapping found between current topic and a WhatsApp chat", nil)
+			return err
+		}
+	}
+
+	chatJID, _ := utils.WaParseJID(waChatId)
+	contactJID := chatJID
+	if participantId != "" {
+		contactJID, _ = utils.WaParseJID(participantId)
+	}
+
+	text, err := utils.CannedReplyRender(reply.Text, chatJID, contactJID)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to fill in the canned reply", err)
+	}
+
+	// Bridge the command as if the text had been sent instead, a copy so that
+	// the command stays as it is for the other handlers
+	bridgedMsg := *c.EffectiveMessage
+	bridgedMsg.Text = text
+	bridgedMsg.Entities = nil
+	update := *c.Update
+	update.Message = &bridgedMsg
+	if err = BridgeTelegramToWhatsAppHandler(b, ext.NewContext(&update, c.Data)); err != nil {
+		return err
+	}
+	// Already bridged, the handlers of the later groups must not bridge it again
+	return ext.EndGroups
+}
+
+func CannedCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage:\n"
+	usageString += "<code>" + html.EscapeString("/canned add <name> <text>") + "</code> to add or replace a canned reply\n"
+	usageString += "<code>" + html.EscapeString("/canned del <name>") + "</code> to delete a canned reply\n"
+	usageString += "<code>/canned list</code> to list the canned replies\n\n"
+	usageString += "The text can use {{.Name}}, {{.FirstName}}, {{.Number}}, {{.Chat}}, {{.Me}}, {{.Date}} and {{.Time}}, "
+	usageString += "send it in a topic with <code>" + html.EscapeString("/c <name>") + "</code>\n"
+	usageString += "Example: <code>" + html.EscapeString("/canned add thanks Thank you {{.FirstName}}!") + "</code>"
+
+	args := c.Args()[1:]
+	if len(args) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+		return err
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		// Keep the newlines and spacing of the text as they are
+		_, rest := utils.TgCutWord(c.EffectiveMessage.Text)
+		_, rest = utils.TgCutWord(rest)
+		name, text := utils.TgCutWord(rest)
+		name = strings.ToLower(name)
+		if name == "" || text == "" {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+
+		if _, err := template.New("canned").Parse(text); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "The text is not a valid template", err)
+		}
+		if err := database.CannedReplySet(name, text); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to save the canned reply", err)
+		}
+
+		_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully saved, send it with <code>/c %s</code>",
+			html.EscapeString(name)), nil)
+		return err
+
+	case "del", "delete":
+		if len(args) != 2 {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+			return err
+		}
+
+		deleted, err := database.CannedReplyDelete(strings.ToLower(args[1]))
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to delete the canned reply", err)
+		} else if !deleted {
+			_, err = utils.TgReplyTextByContext(b, c, "No canned reply with that name", nil)
+			return err
+		}
+
+		_, err = utils.TgReplyTextByContext(b, c, "Successfully deleted the canned reply", nil)
+		return err
+
+	case "list":
+		replies, err := database.CannedReplyGetAll()
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to get the canned replies", err)
+		} else if len(replies) == 0 {
+			_, err = utils.TgReplyTextByContext(b, c, "No canned replies are saved", nil)
+			return err
+		}
+
+		outputString := "Canned replies:\n\n"
+		for _, reply := range replies {
+			outputString += fmt.Sprintf("<code>/c %s</code>\n%s\n\n",
+				html.EscapeString(reply.ID), html.EscapeString(utils.SubString(reply.Text, 0, 100)))
+		}
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+		return err
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, usageString, nil)
+	return err
+}
+
+func WaBlocklistHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	blocklist, err := state.State.WhatsAppClient.GetBlocklist()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the blocklist", err)
+	} else if len(blocklist.JIDs) == 0 {
+		_, err = utils.TgReplyTextByContext(b, c, "No users are blocked", nil)
+		return err
+	}
+
+	outputString := fmt.Sprintf("Blocked users (%d):\n\n", len(blocklist.JIDs))
+	for _, jid := range blocklist.JIDs {
+		outputString += fmt.Sprintf("- %s: <code>%s</code>\n",
+			html.EscapeString(utils.WaGetContactName(jid)), html.EscapeString(jid.User))
+
+		if len(outputString) >= 1800 {
+			utils.TgReplyTextByContext(b, c, outputString, nil)
+			time.Sleep(500 * time.Millisecond)
+			outputString = ""
+		}
+	}
+
+	if len(outputString) > 0 {
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil)
+		return err
+	}
+	return nil
+}
+
+func BackupCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	data, fileName, err := utils.BackupCreate()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to make backup", err)
+	}
+
+	where, err := utils.BackupUpload(data, fileName)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to upload backup", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Backup <code>%s</code> (%d KB) uploaded to %s", html.EscapeString(fileName), len(data)/1024, html.EscapeString(where)), nil)
+	return err
+}
@@ -0,0 +1,138 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+type recreateDeletedTopicsBotClient struct {
+	gotgbot.BotClient
+}
+
+type recreatedTopicKey struct {
+	tgChatId   int64
+	tgThreadId int64
+}
+
+var (
+	// Recreating a topic is done one at a time in every chat, so that messages
+	// sent to it at the same time don't each make a new one
+	recreateTopicLocks sync.Map
+	// New topics of the recreated ones, for messages still sent to the old one
+	recreatedTopics sync.Map
+)
+
+// isContentSend reports whether the method posts something to the topic,
+// chat actions are also used to check whether a topic still exists
+func isContentSend(method string) bool {
+	return strings.HasPrefix(method, "send") && method != "sendChatAction"
+}
+
+func (b *recreateDeletedTopicsBotClient) RequestWithContext(ctx context.Context,
+	token string, method string, params map[string]string,
+	data map[string]gotgbot.NamedReader,
+	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+
+	response, err := b.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+	if err == nil || !isContentSend(method) || !utils.TgIsThreadNotFoundError(err) {
+		return response, err
+	}
+
+	tgChatId, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	tgThreadId, _ := strconv.ParseInt(params["message_thread_id"], 10, 64)
+	if tgChatId == 0 || tgThreadId == 0 || !rewindFiles(data) {
+		return response, err
+	}
+
+	newThreadId := recreateTopic(tgChatId, tgThreadId)
+	if newThreadId == 0 {
+		return response, err
+	}
+
+	params["message_thread_id"] = strconv.FormatInt(newThreadId, 10)
+	// The message replied to was in the deleted topic
+	delete(params, "reply_to_message_id")
+	return b.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+}
+
+// recreateTopic makes the deleted topic again for its WhatsApp chat, or
+// returns the one already made for it. Returns 0 if it can't be made.
+func recreateTopic(tgChatId, tgThreadId int64) int64 {
+	lock, _ := recreateTopicLocks.LoadOrStore(tgChatId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	key := recreatedTopicKey{tgChatId, tgThreadId}
+	if newThreadId, found := recreatedTopics.Load(key); found {
+		return newThreadId.(int64)
+	}
+
+	// Only topics created for a WhatsApp chat can be made again
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil || waChatId == "" {
+		return 0
+	}
+
+	if err = database.ChatThreadDropPairByTg(tgChatId, tgThreadId); err != nil {
+		return 0
+	}
+	// Through the logger, so that the JID is hashed like everywhere else
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	newThreadId, err := utils.TgGetOrMakeThreadFromWa(waChatId, tgChatId, utils.TgGetTopicNameForWa(waChatId))
+	if err != nil || newThreadId == 0 {
+		logger.Error("failed to recreate deleted topic",
+			zap.String("chat_jid", waChatId),
+			zap.Int64("thread_id", tgThreadId),
+			zap.Error(err),
+		)
+		return 0
+	}
+	logger.Info("recreated deleted topic",
+		zap.String("chat_jid", waChatId),
+		zap.Int64("thread_id", tgThreadId),
+		zap.Int64("new_thread_id", newThreadId),
+	)
+
+	recreatedTopics.Store(key, newThreadId)
+	return newThreadId
+}
+
+// rewindFiles seeks the uploaded files back to their start so the request can
+// be sent again, returns false if any of them can't be
+func rewindFiles(data map[string]gotgbot.NamedReader) bool {
+	for _, file := range data {
+		var reader io.Reader = file
+		switch namedFile := file.(type) {
+		case gotgbot.NamedFile:
+			reader = namedFile.File
+		case *gotgbot.NamedFile:
+			reader = namedFile.File
+		}
+
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return false
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func RecreateDeletedTopics(b gotgbot.BotClient) gotgbot.BotClient {
+	return &recreateDeletedTopicsBotClient{b}
+}
@@ -0,0 +1,291 @@
+// Package mtproto connects the bridge to Telegram as a user account over
+// MTProto (with gotd) instead of as a bot over the Bot API.
+//
+// Client implements gotgbot.BotClient by translating the Bot API methods used
+// by the bridge into MTProto calls and their results back into Bot API
+// objects, so the handlers and middlewares work the same on both transports.
+// The updates of the account are handed to the dispatcher as Bot API updates.
+package mtproto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// ErrNotLoggedIn is returned when the session file has no authorized user
+var ErrNotLoggedIn = errors.New("the telegram user session is not logged in, run 'watgbridge telegram-login' first")
+
+type Options struct {
+	APIID       int
+	APIHash     string
+	SessionFile string
+	// Files fetched with getFile are downloaded to this directory, and
+	// deleted after an hour
+	FilesDirectory string
+	Logger         *zap.Logger
+}
+
+type Client struct {
+	opts Options
+
+	client *telegram.Client
+	api    *tg.Client
+	peers  *peers.Manager
+	gaps   *updates.Manager
+	// storage has the access hashes saved by peers, looked up before
+	// resolving a chat over the network
+	storage       *peers.InmemoryStorage
+	updateHandler telegram.UpdateHandler
+
+	self *tg.User
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	updatesLock sync.RWMutex
+	updates     chan json.RawMessage
+	updateId    atomic.Int64
+}
+
+var _ gotgbot.BotClient = (*Client)(nil)
+
+func New(opts Options) *Client {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	c := &Client{opts: opts, storage: &peers.InmemoryStorage{}}
+
+	dispatcher := tg.NewUpdateDispatcher()
+	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+		return c.handleMessage(ctx, e, u.Message, false)
+	})
+	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+		return c.handleMessage(ctx, e, u.Message, false)
+	})
+	dispatcher.OnEditMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateEditMessage) error {
+		return c.handleMessage(ctx, e, u.Message, true)
+	})
+	dispatcher.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateEditChannelMessage) error {
+		return c.handleMessage(ctx, e, u.Message, true)
+	})
+
+	c.client = telegram.NewClient(opts.APIID, opts.APIHash, telegram.Options{
+		SessionStorage: &session.FileStorage{Path: opts.SessionFile},
+		Logger:         opts.Logger.Named("mtproto"),
+		UpdateHandler: telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+			return c.updateHandler.Handle(ctx, u)
+		}),
+	})
+	c.api = c.client.API()
+	c.peers = peers.Options{
+		Logger:  opts.Logger.Named("mtproto_peers"),
+		Storage: c.storage,
+		Cache:   &peers.InmemoryCache{},
+	}.Build(c.api)
+	c.gaps = updates.New(updates.Config{
+		Handler:      dispatcher,
+		AccessHasher: c.peers,
+		Logger:       opts.Logger.Named("mtproto_updates"),
+	})
+	c.updateHandler = c.peers.UpdateHook(c.gaps)
+
+	return c
+}
+
+// Connect connects to Telegram in the background and returns once the
+// session is ready to be used, or with ErrNotLoggedIn if it was never logged
+// into
+func (c *Client) Connect() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.stopped = make(chan struct{})
+
+	var (
+		ready = make(chan struct{})
+		errCh = make(chan error, 1)
+	)
+	go func() {
+		defer close(c.stopped)
+		errCh <- c.client.Run(ctx, func(ctx context.Context) error {
+			status, err := c.client.Auth().Status(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to get session status : %w", err)
+			}
+			if !status.Authorized {
+				return ErrNotLoggedIn
+			}
+			c.self = status.User
+
+			if err := c.peers.Init(ctx); err != nil {
+				return fmt.Errorf("failed to initialize peers : %w", err)
+			}
+			// A user account only knows the access hashes of the chats it has
+			// seen, which are in its dialogs
+			if err := c.loadDialogs(ctx); err != nil {
+				c.opts.Logger.Warn("failed to load telegram dialogs",
+					zap.Error(err),
+				)
+			}
+			close(ready)
+
+			return c.gaps.Run(ctx, c.api, c.self.ID, updates.AuthOptions{})
+		})
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case err := <-errCh:
+		cancel()
+		if err == nil {
+			err = errors.New("telegram client stopped while connecting")
+		}
+		return err
+	}
+}
+
+func (c *Client) loadDialogs(ctx context.Context) error {
+	dialogs, err := c.api.MessagesGetDialogs(ctx, &tg.MessagesGetDialogsRequest{
+		OffsetPeer: &tg.InputPeerEmpty{},
+		Limit:      100,
+	})
+	if err != nil {
+		return err
+	}
+	modified, ok := dialogs.AsModified()
+	if !ok {
+		return nil
+	}
+	return c.peers.Apply(ctx, modified.GetUsers(), modified.GetChats())
+}
+
+// Self returns the Bot API user of the logged in account
+func (c *Client) Self() *gotgbot.User {
+	return convertUser(c.self)
+}
+
+// Listen makes the dispatcher handle the updates of the account, the same way
+// ext.Updater does for a bot
+func (c *Client) Listen(bot *gotgbot.Bot, dispatcher *ext.Dispatcher) {
+	c.updatesLock.Lock()
+	defer c.updatesLock.Unlock()
+
+	c.updates = make(chan json.RawMessage)
+	go dispatcher.Start(bot, c.updates)
+}
+
+// StopListening stops handing updates to the dispatcher, and waits for the
+// ones being handled to finish
+func (c *Client) StopListening(dispatcher *ext.Dispatcher) {
+	c.updatesLock.Lock()
+	if c.updates != nil {
+		close(c.updates)
+		c.updates = nil
+	}
+	c.updatesLock.Unlock()
+
+	dispatcher.Stop()
+}
+
+// Close disconnects from Telegram
+func (c *Client) Close() error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	<-c.stopped
+	return nil
+}
+
+func (c *Client) dispatch(update *gotgbot.Update) error {
+	update.UpdateId = c.updateId.Add(1)
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	c.updatesLock.RLock()
+	defer c.updatesLock.RUnlock()
+	if c.updates != nil {
+		c.updates <- data
+	}
+	return nil
+}
+
+func (c *Client) handleMessage(ctx context.Context, e tg.Entities, msgClass tg.MessageClass, edited bool) error {
+	msg, ok := msgClass.(*tg.Message)
+	// Messages sent by the bridge itself come back as updates too
+	if !ok || msg.Out {
+		return nil
+	}
+
+	converted := c.convertMessage(ctx, msg, e, true)
+	update := &gotgbot.Update{}
+	isChannel := converted.Chat.Type == "channel"
+	switch {
+	case edited && isChannel:
+		update.EditedChannelPost = converted
+	case edited:
+		update.EditedMessage = converted
+	case isChannel:
+		update.ChannelPost = converted
+	default:
+		update.Message = converted
+	}
+	return c.dispatch(update)
+}
+
+// TimeoutContext implements gotgbot.BotClient. A negative timeout means no
+// timeout, as with the Bot API client.
+func (c *Client) TimeoutContext(opts *gotgbot.RequestOpts) (context.Context, context.CancelFunc) {
+	timeout := gotgbot.DefaultTimeout
+	if opts != nil && opts.Timeout != 0 {
+		timeout = opts.Timeout
+	}
+	if timeout < 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// GetAPIURL implements gotgbot.BotClient, there is no Bot API server
+func (c *Client) GetAPIURL(opts *gotgbot.RequestOpts) string {
+	return ""
+}
+
+// FileURL implements gotgbot.BotClient. The files are downloaded by getFile,
+// so their path is local.
+func (c *Client) FileURL(token string, tgFilePath string, opts *gotgbot.RequestOpts) string {
+	return "file://" + tgFilePath
+}
+
+// cleanupFiles deletes the files downloaded more than an hour ago
+func (c *Client) cleanupFiles() {
+	entries, err := os.ReadDir(c.opts.FilesDirectory)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > time.Hour {
+			_ = os.Remove(filepath.Join(c.opts.FilesDirectory, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package mtproto
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/tg"
+)
+
+// botAPIChatId returns the ID the Bot API uses for a peer, -100 prefixed for
+// channels and supergroups and negative for basic groups
+func botAPIChatId(peer tg.PeerClass) int64 {
+	var id constant.TDLibPeerID
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		id.User(p.UserID)
+	case *tg.PeerChat:
+		id.Chat(p.ChatID)
+	case *tg.PeerChannel:
+		id.Channel(p.ChannelID)
+	}
+	return int64(id)
+}
+
+func convertUser(user *tg.User) *gotgbot.User {
+	if user == nil {
+		return nil
+	}
+	return &gotgbot.User{
+		Id:           user.ID,
+		IsBot:        user.Bot,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Username:     user.Username,
+		LanguageCode: user.LangCode,
+		IsPremium:    user.Premium,
+	}
+}
+
+func convertPeerUser(peer tg.PeerClass, e tg.Entities) *gotgbot.User {
+	p, ok := peer.(*tg.PeerUser)
+	if !ok {
+		return nil
+	}
+	if user, found := e.Users[p.UserID]; found {
+		return convertUser(user)
+	}
+	return &gotgbot.User{Id: p.UserID}
+}
+
+func convertChat(peer tg.PeerClass, e tg.Entities) gotgbot.Chat {
+	chat := gotgbot.Chat{Id: botAPIChatId(peer)}
+
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		chat.Type = "private"
+		if user, found := e.Users[p.UserID]; found {
+			chat.FirstName = user.FirstName
+			chat.LastName = user.LastName
+			chat.Username = user.Username
+		}
+	case *tg.PeerChat:
+		chat.Type = "group"
+		if group, found := e.Chats[p.ChatID]; found {
+			chat.Title = group.Title
+		}
+	case *tg.PeerChannel:
+		chat.Type = "supergroup"
+		if channel, found := e.Channels[p.ChannelID]; found {
+			chat.Title = channel.Title
+			chat.Username = channel.Username
+			chat.IsForum = channel.Forum
+			if channel.Broadcast {
+				chat.Type = "channel"
+			}
+		}
+	}
+
+	return chat
+}
+
+// convertMessage returns the Bot API message for a message. With withReply,
+// the message it replies to is fetched as well.
+func (c *Client) convertMessage(ctx context.Context, msg *tg.Message, e tg.Entities, withReply bool) *gotgbot.Message {
+	converted := &gotgbot.Message{
+		MessageId:           int64(msg.ID),
+		Date:                int64(msg.Date),
+		Chat:                convertChat(msg.PeerID, e),
+		EditDate:            int64(msg.EditDate),
+		HasProtectedContent: msg.Noforwards,
+		AuthorSignature:     msg.PostAuthor,
+	}
+
+	if msg.FromID != nil {
+		if _, isChannel := msg.FromID.(*tg.PeerChannel); isChannel {
+			senderChat := convertChat(msg.FromID, e)
+			converted.SenderChat = &senderChat
+		} else {
+			converted.From = convertPeerUser(msg.FromID, e)
+		}
+	} else if msg.Out {
+		converted.From = convertUser(c.self)
+	} else if converted.Chat.Type == "private" {
+		converted.From = convertPeerUser(msg.PeerID, e)
+	} else if converted.Chat.Type == "channel" {
+		converted.SenderChat = &converted.Chat
+	}
+
+	if msg.GroupedID != 0 {
+		converted.MediaGroupId = strconv.FormatInt(msg.GroupedID, 10)
+	}
+
+	if header, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok {
+		replyToId := int64(header.ReplyToMsgID)
+		if header.ForumTopic {
+			converted.IsTopicMessage = true
+			converted.MessageThreadId = int64(header.ReplyToTopID)
+			if converted.MessageThreadId == 0 {
+				converted.MessageThreadId = replyToId
+			}
+		}
+
+		if header.ForumTopic && header.ReplyToTopID == 0 {
+			// Like the Bot API, messages in a topic which are not replies
+			// reply to the message which created the topic
+			converted.ReplyToMessage = &gotgbot.Message{
+				MessageId:         replyToId,
+				Chat:              converted.Chat,
+				IsTopicMessage:    true,
+				MessageThreadId:   replyToId,
+				ForumTopicCreated: &gotgbot.ForumTopicCreated{},
+			}
+		} else if replyToId != 0 && withReply && header.ReplyToPeerID == nil {
+			converted.ReplyToMessage = c.fetchMessage(ctx, msg.PeerID, header.ReplyToMsgID)
+		}
+	}
+
+	text, entities := msg.Message, convertEntities(msg.Entities, e)
+	if c.convertMedia(converted, msg.Media) {
+		converted.Caption, converted.CaptionEntities = text, entities
+	} else {
+		converted.Text, converted.Entities = text, entities
+	}
+
+	return converted
+}
+
+// fetchMessage gets a message of a chat, without the message it replies to
+func (c *Client) fetchMessage(ctx context.Context, peer tg.PeerClass, id int) *gotgbot.Message {
+	var (
+		result tg.MessagesMessagesClass
+		err    error
+		ids    = []tg.InputMessageClass{&tg.InputMessageID{ID: id}}
+	)
+
+	if channel, ok := peer.(*tg.PeerChannel); ok {
+		inputChannel, chErr := c.inputChannel(ctx, botAPIChatId(channel))
+		if chErr != nil {
+			return nil
+		}
+		result, err = c.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: inputChannel,
+			ID:      ids,
+		})
+	} else {
+		result, err = c.api.MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil
+	}
+
+	modified, ok := result.AsModified()
+	if !ok {
+		return nil
+	}
+	for _, msgClass := range modified.GetMessages() {
+		if msg, ok := msgClass.(*tg.Message); ok && msg.ID == id {
+			return c.convertMessage(ctx, msg, entitiesFrom(modified.GetUsers(), modified.GetChats()), false)
+		}
+	}
+	return nil
+}
+
+func entitiesFrom(users []tg.UserClass, chats []tg.ChatClass) tg.Entities {
+	e := tg.Entities{
+		Users:    map[int64]*tg.User{},
+		Chats:    map[int64]*tg.Chat{},
+		Channels: map[int64]*tg.Channel{},
+	}
+	for _, userClass := range users {
+		if user, ok := userClass.(*tg.User); ok {
+			e.Users[user.ID] = user
+		}
+	}
+	for _, chatClass := range chats {
+		switch chat := chatClass.(type) {
+		case *tg.Chat:
+			e.Chats[chat.ID] = chat
+		case *tg.Channel:
+			e.Channels[chat.ID] = chat
+		}
+	}
+	return e
+}
+
+// convertMedia fills in the media of the message, and reports whether the
+// text of the message is a caption
+func (c *Client) convertMedia(converted *gotgbot.Message, media tg.MessageMediaClass) bool {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := m.Photo.(*tg.Photo)
+		if !ok {
+			return false
+		}
+		converted.Photo = photoSizes(photo)
+		converted.HasMediaSpoiler = m.Spoiler
+		return true
+
+	case *tg.MessageMediaDocument:
+		document, ok := m.Document.(*tg.Document)
+		if !ok {
+			return false
+		}
+		converted.HasMediaSpoiler = m.Spoiler
+		convertDocument(converted, document)
+		return true
+
+	case *tg.MessageMediaContact:
+		converted.Contact = &gotgbot.Contact{
+			PhoneNumber: m.PhoneNumber,
+			FirstName:   m.FirstName,
+			LastName:    m.LastName,
+			UserId:      m.UserID,
+			Vcard:       m.Vcard,
+		}
+
+	case *tg.MessageMediaGeo:
+		if point, ok := m.Geo.(*tg.GeoPoint); ok {
+			converted.Location = &gotgbot.Location{
+				Latitude:           point.Lat,
+				Longitude:          point.Long,
+				HorizontalAccuracy: float64(point.AccuracyRadius),
+			}
+		}
+
+	case *tg.MessageMediaPoll:
+		poll := &gotgbot.Poll{
+			Id:                    strconv.FormatInt(m.Poll.ID, 10),
+			Question:              m.Poll.Question,
+			IsClosed:              m.Poll.Closed,
+			IsAnonymous:           !m.Poll.PublicVoters,
+			AllowsMultipleAnswers: m.Poll.MultipleChoice,
+			Type:                  "regular",
+			TotalVoterCount:       int64(m.Results.TotalVoters),
+		}
+		if m.Poll.Quiz {
+			poll.Type = "quiz"
+		}
+		for _, answer := range m.Poll.Answers {
+			option := gotgbot.PollOption{Text: answer.Text}
+			for _, result := range m.Results.Results {
+				if string(result.Option) == string(answer.Option) {
+					option.VoterCount = int64(result.Voters)
+				}
+			}
+			poll.Options = append(poll.Options, option)
+		}
+		converted.Poll = poll
+	}
+
+	return false
+}
+
+func photoSizes(photo *tg.Photo) []gotgbot.PhotoSize {
+	var sizes []gotgbot.PhotoSize
+	for _, sizeClass := range photo.Sizes {
+		var (
+			sizeType      string
+			width, height int
+			size          int
+		)
+		switch s := sizeClass.(type) {
+		case *tg.PhotoSize:
+			sizeType, width, height, size = s.Type, s.W, s.H, s.Size
+		case *tg.PhotoSizeProgressive:
+			sizeType, width, height = s.Type, s.W, s.H
+			if len(s.Sizes) > 0 {
+				size = s.Sizes[len(s.Sizes)-1]
+			}
+		default:
+			continue
+		}
+
+		sizes = append(sizes, gotgbot.PhotoSize{
+			FileId:       photoFileId(photo, sizeType, int64(size)).encode(),
+			FileUniqueId: uniqueFileId("p", photo.ID, sizeType),
+			Width:        int64(width),
+			Height:       int64(height),
+			FileSize:     int64(size),
+		})
+	}
+	return sizes
+}
+
+func convertDocument(converted *gotgbot.Message, document *tg.Document) {
+	var (
+		fileId       = documentFileId(document).encode()
+		fileUniqueId = uniqueFileId("d", document.ID, "")
+
+		fileName   string
+		video      *tg.DocumentAttributeVideo
+		audio      *tg.DocumentAttributeAudio
+		sticker    *tg.DocumentAttributeSticker
+		imageSize  *tg.DocumentAttributeImageSize
+		isAnimated bool
+	)
+	for _, attribute := range document.Attributes {
+		switch a := attribute.(type) {
+		case *tg.DocumentAttributeFilename:
+			fileName = a.FileName
+		case *tg.DocumentAttributeVideo:
+			video = a
+		case *tg.DocumentAttributeAudio:
+			audio = a
+		case *tg.DocumentAttributeSticker:
+			sticker = a
+		case *tg.DocumentAttributeImageSize:
+			imageSize = a
+		case *tg.DocumentAttributeAnimated:
+			isAnimated = true
+		}
+	}
+
+	switch {
+	case sticker != nil:
+		converted.Sticker = &gotgbot.Sticker{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Type:         "regular",
+			IsAnimated:   document.MimeType == "application/x-tgsticker",
+			IsVideo:      document.MimeType == "video/webm",
+			Emoji:        sticker.Alt,
+			FileSize:     document.Size,
+		}
+		if imageSize != nil {
+			converted.Sticker.Width, converted.Sticker.Height = int64(imageSize.W), int64(imageSize.H)
+		} else if video != nil {
+			converted.Sticker.Width, converted.Sticker.Height = int64(video.W), int64(video.H)
+		}
+
+	case video != nil && video.RoundMessage:
+		converted.VideoNote = &gotgbot.VideoNote{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Length:       int64(video.W),
+			Duration:     int64(video.Duration),
+			FileSize:     document.Size,
+		}
+
+	case video != nil && isAnimated:
+		converted.Animation = &gotgbot.Animation{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Width:        int64(video.W),
+			Height:       int64(video.H),
+			Duration:     int64(video.Duration),
+			FileName:     fileName,
+			MimeType:     document.MimeType,
+			FileSize:     document.Size,
+		}
+
+	case video != nil:
+		converted.Video = &gotgbot.Video{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Width:        int64(video.W),
+			Height:       int64(video.H),
+			Duration:     int64(video.Duration),
+			FileName:     fileName,
+			MimeType:     document.MimeType,
+			FileSize:     document.Size,
+		}
+
+	case audio != nil && audio.Voice:
+		converted.Voice = &gotgbot.Voice{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Duration:     int64(audio.Duration),
+			MimeType:     document.MimeType,
+			FileSize:     document.Size,
+		}
+
+	case audio != nil:
+		converted.Audio = &gotgbot.Audio{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			Duration:     int64(audio.Duration),
+			Performer:    audio.Performer,
+			Title:        audio.Title,
+			FileName:     fileName,
+			MimeType:     document.MimeType,
+			FileSize:     document.Size,
+		}
+
+	default:
+		converted.Document = &gotgbot.Document{
+			FileId:       fileId,
+			FileUniqueId: fileUniqueId,
+			FileName:     fileName,
+			MimeType:     document.MimeType,
+			FileSize:     document.Size,
+		}
+	}
+}
+
+func convertEntities(entities []tg.MessageEntityClass, e tg.Entities) []gotgbot.MessageEntity {
+	var converted []gotgbot.MessageEntity
+	for _, entityClass := range entities {
+		entity := gotgbot.MessageEntity{
+			Offset: int64(entityClass.GetOffset()),
+			Length: int64(entityClass.GetLength()),
+		}
+		switch v := entityClass.(type) {
+		case *tg.MessageEntityMention:
+			entity.Type = "mention"
+		case *tg.MessageEntityHashtag:
+			entity.Type = "hashtag"
+		case *tg.MessageEntityCashtag:
+			entity.Type = "cashtag"
+		case *tg.MessageEntityBotCommand:
+			entity.Type = "bot_command"
+		case *tg.MessageEntityURL:
+			entity.Type = "url"
+		case *tg.MessageEntityEmail:
+			entity.Type = "email"
+		case *tg.MessageEntityPhone:
+			entity.Type = "phone_number"
+		case *tg.MessageEntityBold:
+			entity.Type = "bold"
+		case *tg.MessageEntityItalic:
+			entity.Type = "italic"
+		case *tg.MessageEntityUnderline:
+			entity.Type = "underline"
+		case *tg.MessageEntityStrike:
+			entity.Type = "strikethrough"
+		case *tg.MessageEntitySpoiler:
+			entity.Type = "spoiler"
+		case *tg.MessageEntityBlockquote:
+			entity.Type = "blockquote"
+		case *tg.MessageEntityCode:
+			entity.Type = "code"
+		case *tg.MessageEntityPre:
+			entity.Type, entity.Language = "pre", v.Language
+		case *tg.MessageEntityTextURL:
+			entity.Type, entity.Url = "text_link", v.URL
+		case *tg.MessageEntityMentionName:
+			entity.Type = "text_mention"
+			entity.User = convertPeerUser(&tg.PeerUser{UserID: v.UserID}, e)
+		case *tg.MessageEntityCustomEmoji:
+			entity.Type, entity.CustomEmojiId = "custom_emoji", strconv.FormatInt(v.DocumentID, 10)
+		default:
+			continue
+		}
+		converted = append(converted, entity)
+	}
+	return converted
+}
+
+// inputEntities converts the entities given to a Bot API method
+func inputEntities(entities []gotgbot.MessageEntity) []tg.MessageEntityClass {
+	var converted []tg.MessageEntityClass
+	for _, entity := range entities {
+		var (
+			offset = int(entity.Offset)
+			length = int(entity.Length)
+		)
+		switch entity.Type {
+		case "mention":
+			converted = append(converted, &tg.MessageEntityMention{Offset: offset, Length: length})
+		case "hashtag":
+			converted = append(converted, &tg.MessageEntityHashtag{Offset: offset, Length: length})
+		case "cashtag":
+			converted = append(converted, &tg.MessageEntityCashtag{Offset: offset, Length: length})
+		case "bot_command":
+			converted = append(converted, &tg.MessageEntityBotCommand{Offset: offset, Length: length})
+		case "url":
+			converted = append(converted, &tg.MessageEntityURL{Offset: offset, Length: length})
+		case "email":
+			converted = append(converted, &tg.MessageEntityEmail{Offset: offset, Length: length})
+		case "phone_number":
+			converted = append(converted, &tg.MessageEntityPhone{Offset: offset, Length: length})
+		case "bold":
+			converted = append(converted, &tg.MessageEntityBold{Offset: offset, Length: length})
+		case "italic":
+			converted = append(converted, &tg.MessageEntityItalic{Offset: offset, Length: length})
+		case "underline":
+			converted = append(converted, &tg.MessageEntityUnderline{Offset: offset, Length: length})
+		case "strikethrough":
+			converted = append(converted, &tg.MessageEntityStrike{Offset: offset, Length: length})
+		case "spoiler":
+			converted = append(converted, &tg.MessageEntitySpoiler{Offset: offset, Length: length})
+		case "blockquote":
+			converted = append(converted, &tg.MessageEntityBlockquote{Offset: offset, Length: length})
+		case "code":
+			converted = append(converted, &tg.MessageEntityCode{Offset: offset, Length: length})
+		case "pre":
+			converted = append(converted, &tg.MessageEntityPre{Offset: offset, Length: length, Language: entity.Language})
+		case "text_link":
+			converted = append(converted, &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: entity.Url})
+		case "custom_emoji":
+			documentId, _ := strconv.ParseInt(entity.CustomEmojiId, 10, 64)
+			converted = append(converted, &tg.MessageEntityCustomEmoji{Offset: offset, Length: length, DocumentID: documentId})
+		}
+	}
+	return converted
+}
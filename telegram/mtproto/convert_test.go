@@ -0,0 +1,57 @@
+package mtproto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/gotd/td/tg"
+)
+
+func TestBotAPIChatId(t *testing.T) {
+	for _, tc := range []struct {
+		peer tg.PeerClass
+		want int64
+	}{
+		{&tg.PeerUser{UserID: 704338780}, 704338780},
+		{&tg.PeerChat{ChatID: 423424}, -423424},
+		{&tg.PeerChannel{ChannelID: 1423424}, -1000001423424},
+	} {
+		if got := botAPIChatId(tc.peer); got != tc.want {
+			t.Errorf("botAPIChatId(%v) = %d, want %d", tc.peer, got, tc.want)
+		}
+	}
+}
+
+func TestFileIdRoundTrip(t *testing.T) {
+	for _, id := range []fileId{
+		photoFileId(&tg.Photo{ID: 1, AccessHash: 2, FileReference: []byte{3, 4}}, "y", 1234),
+		documentFileId(&tg.Document{ID: 5, AccessHash: 6, FileReference: []byte{7}, Size: 5678}),
+	} {
+		decoded, err := decodeFileId(id.encode())
+		if err != nil {
+			t.Fatalf("decodeFileId: %s", err)
+		}
+		if !reflect.DeepEqual(decoded, id) {
+			t.Errorf("decoded %+v, want %+v", decoded, id)
+		}
+	}
+
+	if _, err := decodeFileId("AgACAgQAAxkBAAIBY2V"); err == nil {
+		t.Error("a Bot API file ID was decoded")
+	}
+}
+
+func TestEntitiesRoundTrip(t *testing.T) {
+	entities := []gotgbot.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 4},
+		{Type: "pre", Offset: 5, Length: 3, Language: "go"},
+		{Type: "text_link", Offset: 9, Length: 2, Url: "https://example.com"},
+		{Type: "custom_emoji", Offset: 12, Length: 2, CustomEmojiId: "5368324170671202286"},
+	}
+
+	converted := convertEntities(inputEntities(entities), tg.Entities{})
+	if !reflect.DeepEqual(converted, entities) {
+		t.Errorf("converted back to %+v, want %+v", converted, entities)
+	}
+}
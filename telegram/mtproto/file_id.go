@@ -0,0 +1,95 @@
+package mtproto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// fileId is what the file IDs given out by this transport hold: enough to
+// download the file or to send it again. They are not Bot API file IDs and
+// can't be used with a bot.
+type fileId struct {
+	Kind          string `json:"k"` // "p" for photos, "d" for documents
+	ID            int64  `json:"i"`
+	AccessHash    int64  `json:"h"`
+	FileReference []byte `json:"r"`
+	ThumbSize     string `json:"t,omitempty"`
+	Size          int64  `json:"s,omitempty"`
+}
+
+func photoFileId(photo *tg.Photo, sizeType string, size int64) fileId {
+	return fileId{
+		Kind:          "p",
+		ID:            photo.ID,
+		AccessHash:    photo.AccessHash,
+		FileReference: photo.FileReference,
+		ThumbSize:     sizeType,
+		Size:          size,
+	}
+}
+
+func documentFileId(document *tg.Document) fileId {
+	return fileId{
+		Kind:          "d",
+		ID:            document.ID,
+		AccessHash:    document.AccessHash,
+		FileReference: document.FileReference,
+		Size:          document.Size,
+	}
+}
+
+func uniqueFileId(kind string, id int64, sizeType string) string {
+	return fmt.Sprintf("%s%d%s", kind, id, sizeType)
+}
+
+func (f fileId) encode() string {
+	data, _ := json.Marshal(f)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFileId(encoded string) (fileId, error) {
+	var f fileId
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(data, &f)
+	}
+	if err != nil || (f.Kind != "p" && f.Kind != "d") {
+		return f, fmt.Errorf("invalid file id '%s'", encoded)
+	}
+	return f, nil
+}
+
+func (f fileId) location() tg.InputFileLocationClass {
+	if f.Kind == "p" {
+		return &tg.InputPhotoFileLocation{
+			ID:            f.ID,
+			AccessHash:    f.AccessHash,
+			FileReference: f.FileReference,
+			ThumbSize:     f.ThumbSize,
+		}
+	}
+	return &tg.InputDocumentFileLocation{
+		ID:            f.ID,
+		AccessHash:    f.AccessHash,
+		FileReference: f.FileReference,
+	}
+}
+
+// inputMedia returns the media to send the file again
+func (f fileId) inputMedia() tg.InputMediaClass {
+	if f.Kind == "p" {
+		return &tg.InputMediaPhoto{ID: &tg.InputPhoto{
+			ID:            f.ID,
+			AccessHash:    f.AccessHash,
+			FileReference: f.FileReference,
+		}}
+	}
+	return &tg.InputMediaDocument{ID: &tg.InputDocument{
+		ID:            f.ID,
+		AccessHash:    f.AccessHash,
+		FileReference: f.FileReference,
+	}}
+}
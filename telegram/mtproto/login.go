@@ -0,0 +1,85 @@
+package mtproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+)
+
+// Prompt asks the person logging in for a value, like the login code
+type Prompt func(question string) (string, error)
+
+// Login logs the account with the phone number into Telegram, asking for the
+// login code (and the password with two-step verification) with prompt, and
+// saves the session to the session file. New accounts can't sign up.
+func Login(opts Options, phone string, prompt Prompt) (*User, error) {
+	client := telegram.NewClient(opts.APIID, opts.APIHash, telegram.Options{
+		SessionStorage: &session.FileStorage{Path: opts.SessionFile},
+	})
+
+	var self *tg.User
+	err := client.Run(context.Background(), func(ctx context.Context) error {
+		flow := auth.NewFlow(promptAuth{phone: phone, prompt: prompt}, auth.SendCodeOptions{})
+		if err := client.Auth().IfNecessary(ctx, flow); err != nil {
+			return err
+		}
+
+		status, err := client.Auth().Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get session status : %w", err)
+		}
+		self = status.User
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: self.ID, Name: fullName(self), Username: self.Username}, nil
+}
+
+// User is the account a session is logged in as
+type User struct {
+	ID       int64
+	Name     string
+	Username string
+}
+
+func fullName(user *tg.User) string {
+	if user.LastName == "" {
+		return user.FirstName
+	}
+	return user.FirstName + " " + user.LastName
+}
+
+type promptAuth struct {
+	phone  string
+	prompt Prompt
+}
+
+func (a promptAuth) Phone(ctx context.Context) (string, error) {
+	if a.phone != "" {
+		return a.phone, nil
+	}
+	return a.prompt("Phone number (with the country code)")
+}
+
+func (a promptAuth) Password(ctx context.Context) (string, error) {
+	return a.prompt("Two-step verification password")
+}
+
+func (a promptAuth) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	return a.prompt("Login code sent by Telegram")
+}
+
+func (a promptAuth) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
+	return errors.New("the account has to accept the terms of service in an official app first")
+}
+
+func (a promptAuth) SignUp(ctx context.Context) (auth.UserInfo, error) {
+	return auth.UserInfo{}, errors.New("the phone number has no telegram account, sign up in an official app first")
+}
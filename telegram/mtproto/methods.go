@@ -0,0 +1,1043 @@
+package mtproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/html"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// The keys gotd's peers manager saves the access hashes of users and
+// channels with
+const (
+	usersKeyPrefix   = "users_"
+	channelKeyPrefix = "channel_"
+)
+
+type request struct {
+	params map[string]string
+	data   map[string]gotgbot.NamedReader
+}
+
+func (r *request) int64(key string) int64 {
+	value, _ := strconv.ParseInt(r.params[key], 10, 64)
+	return value
+}
+
+func (r *request) int(key string) int {
+	return int(r.int64(key))
+}
+
+func (r *request) bool(key string) bool {
+	value, _ := strconv.ParseBool(r.params[key])
+	return value
+}
+
+func (r *request) float(key string) float64 {
+	value, _ := strconv.ParseFloat(r.params[key], 64)
+	return value
+}
+
+// replyTo returns where to send the message, in a topic (message_thread_id)
+// and/or as a reply
+func (r *request) replyTo() tg.InputReplyToClass {
+	var (
+		threadId  = r.int("message_thread_id")
+		replyToId = r.int("reply_to_message_id")
+	)
+	switch {
+	case replyToId != 0 && threadId != 0:
+		return &tg.InputReplyToMessage{ReplyToMsgID: replyToId, TopMsgID: threadId}
+	case replyToId != 0:
+		return &tg.InputReplyToMessage{ReplyToMsgID: replyToId}
+	case threadId != 0:
+		return &tg.InputReplyToMessage{ReplyToMsgID: threadId}
+	}
+	return nil
+}
+
+type method func(c *Client, ctx context.Context, r *request) (any, error)
+
+var methods = map[string]method{
+	"getMe":              getMe,
+	"setMyCommands":      setMyCommands,
+	"sendMessage":        sendMessage,
+	"editMessageText":    editMessageText,
+	"editMessageCaption": editMessageCaption,
+	"deleteMessage":      deleteMessage,
+	"copyMessage":        copyMessage,
+	"sendPhoto":          sendPhoto,
+	"sendVideo":          sendVideo,
+	"sendAnimation":      sendAnimation,
+	"sendVideoNote":      sendVideoNote,
+	"sendAudio":          sendAudio,
+	"sendVoice":          sendVoice,
+	"sendDocument":       sendDocument,
+	"sendSticker":        sendSticker,
+	"sendContact":        sendContact,
+	"sendLocation":       sendLocation,
+	"sendPoll":           sendPoll,
+	"sendMediaGroup":     sendMediaGroup,
+	"sendChatAction":     sendChatAction,
+	"setMessageReaction": setMessageReaction,
+	"pinChatMessage":     pinChatMessage,
+	"unpinChatMessage":   unpinChatMessage,
+	"createForumTopic":   createForumTopic,
+	"editForumTopic":     editForumTopic,
+	"closeForumTopic":    closeForumTopic,
+	"reopenForumTopic":   reopenForumTopic,
+	"getFile":            getFile,
+}
+
+// RequestWithContext implements gotgbot.BotClient. Methods only bots have,
+// like the ones for inline keyboards, fail with a 400 error.
+func (c *Client) RequestWithContext(ctx context.Context, token string, method string,
+	params map[string]string, data map[string]gotgbot.NamedReader,
+	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+
+	handler, found := methods[method]
+	if !found {
+		return nil, &gotgbot.TelegramError{
+			Method:      method,
+			Params:      params,
+			Code:        400,
+			Description: fmt.Sprintf("Bad Request: %s is not available with the mtproto transport", method),
+		}
+	}
+
+	result, err := handler(c, ctx, &request{params: params, data: data})
+	if err != nil {
+		return nil, telegramError(method, params, err)
+	}
+	return json.Marshal(result)
+}
+
+// telegramError makes the errors of Telegram look like the ones of the Bot
+// API, which the middlewares and handlers check for
+func telegramError(method string, params map[string]string, err error) error {
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		return &gotgbot.TelegramError{
+			Method:      method,
+			Params:      params,
+			Code:        429,
+			Description: fmt.Sprintf("Too Many Requests: retry after %d", int(wait.Seconds())),
+		}
+	}
+	if rpcErr, ok := tgerr.As(err); ok {
+		return &gotgbot.TelegramError{
+			Method:      method,
+			Params:      params,
+			Code:        rpcErr.Code,
+			Description: "Bad Request: " + rpcErr.Message,
+		}
+	}
+	return err
+}
+
+func (c *Client) inputPeer(ctx context.Context, chatId int64) (tg.InputPeerClass, error) {
+	peerId := constant.TDLibPeerID(chatId)
+	switch {
+	case peerId.IsUser():
+		if c.self != nil && peerId.ToPlain() == c.self.ID {
+			return &tg.InputPeerSelf{}, nil
+		}
+		if value, found, _ := c.storage.Find(ctx, peersKey(usersKeyPrefix, peerId.ToPlain())); found {
+			return &tg.InputPeerUser{UserID: peerId.ToPlain(), AccessHash: value.AccessHash}, nil
+		}
+	case peerId.IsChat():
+		return &tg.InputPeerChat{ChatID: peerId.ToPlain()}, nil
+	case peerId.IsChannel():
+		if value, found, _ := c.storage.Find(ctx, peersKey(channelKeyPrefix, peerId.ToPlain())); found {
+			return &tg.InputPeerChannel{ChannelID: peerId.ToPlain(), AccessHash: value.AccessHash}, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid chat id %d", chatId)
+	}
+
+	peer, err := c.peers.ResolveTDLibID(ctx, peerId)
+	if err != nil {
+		return nil, err
+	}
+	return peer.InputPeer(), nil
+}
+
+func peersKey(prefix string, id int64) peers.Key {
+	return peers.Key{Prefix: prefix, ID: id}
+}
+
+func (c *Client) inputChannel(ctx context.Context, chatId int64) (tg.InputChannelClass, error) {
+	peer, err := c.inputPeer(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	channel, ok := peer.(*tg.InputPeerChannel)
+	if !ok {
+		return nil, fmt.Errorf("chat %d is not a supergroup", chatId)
+	}
+	return &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash}, nil
+}
+
+func (c *Client) inputUser(ctx context.Context, userId int64) (tg.InputUserClass, error) {
+	peer, err := c.inputPeer(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	switch p := peer.(type) {
+	case *tg.InputPeerSelf:
+		return &tg.InputUserSelf{}, nil
+	case *tg.InputPeerUser:
+		return &tg.InputUser{UserID: p.UserID, AccessHash: p.AccessHash}, nil
+	}
+	return nil, fmt.Errorf("%d is not a user", userId)
+}
+
+// parseText returns the text and its entities, from the given entities or the
+// HTML markup of the text
+func (c *Client) parseText(ctx context.Context, text, parseMode string, entities []gotgbot.MessageEntity) (string, []tg.MessageEntityClass, error) {
+	if len(entities) > 0 {
+		return text, inputEntities(entities), nil
+	}
+	if !strings.EqualFold(parseMode, "html") {
+		return text, nil, nil
+	}
+
+	var builder entity.Builder
+	err := html.HTML(strings.NewReader(text), &builder, html.Options{
+		UserResolver: func(id int64) (tg.InputUserClass, error) {
+			return c.inputUser(ctx, id)
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	parsed, parsedEntities := builder.Complete()
+	return parsed, parsedEntities, nil
+}
+
+func (c *Client) requestText(ctx context.Context, r *request, textKey, entitiesKey string) (string, []tg.MessageEntityClass, error) {
+	var entities []gotgbot.MessageEntity
+	if raw := r.params[entitiesKey]; raw != "" && raw != "null" {
+		if err := json.Unmarshal([]byte(raw), &entities); err != nil {
+			return "", nil, fmt.Errorf("invalid %s : %w", entitiesKey, err)
+		}
+	}
+	return c.parseText(ctx, r.params[textKey], r.params["parse_mode"], entities)
+}
+
+// sentMessages returns the messages in the result of sending or editing, and
+// passes the result on to the updates manager
+func (c *Client) sentMessages(ctx context.Context, peer tg.InputPeerClass, sent tg.UpdatesClass, text string) ([]*gotgbot.Message, error) {
+	var (
+		updates  []tg.UpdateClass
+		entities = entitiesFrom(nil, nil)
+	)
+	switch u := sent.(type) {
+	case *tg.UpdateShortSentMessage:
+		// Only private chats get this short form, which lacks the message
+		msg := &tg.Message{
+			ID:       u.ID,
+			Date:     u.Date,
+			Out:      true,
+			PeerID:   c.peerOf(peer),
+			Message:  text,
+			Media:    u.Media,
+			Entities: u.Entities,
+		}
+		updates = []tg.UpdateClass{&tg.UpdateNewMessage{Message: msg}}
+	case *tg.UpdateShort:
+		updates = []tg.UpdateClass{u.Update}
+	case *tg.Updates:
+		updates = u.Updates
+		entities = entitiesFrom(u.Users, u.Chats)
+	case *tg.UpdatesCombined:
+		updates = u.Updates
+		entities = entitiesFrom(u.Users, u.Chats)
+	}
+	_ = c.updateHandler.Handle(ctx, sent)
+
+	var messages []*gotgbot.Message
+	for _, update := range updates {
+		var msgClass tg.MessageClass
+		switch v := update.(type) {
+		case *tg.UpdateNewMessage:
+			msgClass = v.Message
+		case *tg.UpdateNewChannelMessage:
+			msgClass = v.Message
+		case *tg.UpdateEditMessage:
+			msgClass = v.Message
+		case *tg.UpdateEditChannelMessage:
+			msgClass = v.Message
+		}
+		if msg, ok := msgClass.(*tg.Message); ok {
+			messages = append(messages, c.convertMessage(ctx, msg, entities, true))
+		}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no message in the result %T", sent)
+	}
+	return messages, nil
+}
+
+func (c *Client) sentMessage(ctx context.Context, peer tg.InputPeerClass, sent tg.UpdatesClass, text string) (*gotgbot.Message, error) {
+	messages, err := c.sentMessages(ctx, peer, sent, text)
+	if err != nil {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (c *Client) peerOf(peer tg.InputPeerClass) tg.PeerClass {
+	switch p := peer.(type) {
+	case *tg.InputPeerUser:
+		return &tg.PeerUser{UserID: p.UserID}
+	case *tg.InputPeerChat:
+		return &tg.PeerChat{ChatID: p.ChatID}
+	case *tg.InputPeerChannel:
+		return &tg.PeerChannel{ChannelID: p.ChannelID}
+	}
+	return &tg.PeerUser{UserID: c.self.ID}
+}
+
+func getMe(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.Self(), nil
+}
+
+// setMyCommands does nothing, users have no commands
+func setMyCommands(c *Client, ctx context.Context, r *request) (any, error) {
+	return true, nil
+}
+
+func sendMessage(c *Client, ctx context.Context, r *request) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	text, entities, err := c.requestText(ctx, r, "text", "entities")
+	if err != nil {
+		return nil, err
+	}
+	randomId, err := c.client.RandInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := c.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:       peer,
+		ReplyTo:    r.replyTo(),
+		Message:    text,
+		Entities:   entities,
+		RandomID:   randomId,
+		NoWebpage:  r.bool("disable_web_page_preview"),
+		Silent:     r.bool("disable_notification"),
+		Noforwards: r.bool("protect_content"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.sentMessage(ctx, peer, sent, text)
+}
+
+func editMessageText(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.editMessage(ctx, r, "text", "entities")
+}
+
+func editMessageCaption(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.editMessage(ctx, r, "caption", "caption_entities")
+}
+
+func (c *Client) editMessage(ctx context.Context, r *request, textKey, entitiesKey string) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	text, entities, err := c.requestText(ctx, r, textKey, entitiesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	edited, err := c.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+		Peer:      peer,
+		ID:        r.int("message_id"),
+		Message:   text,
+		Entities:  entities,
+		NoWebpage: r.bool("disable_web_page_preview"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.sentMessage(ctx, peer, edited, text)
+}
+
+func deleteMessage(c *Client, ctx context.Context, r *request) (any, error) {
+	chatId := r.int64("chat_id")
+	ids := []int{r.int("message_id")}
+
+	if constant.TDLibPeerID(chatId).IsChannel() {
+		channel, err := c.inputChannel(ctx, chatId)
+		if err != nil {
+			return nil, err
+		}
+		_, err = c.api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: channel,
+			ID:      ids,
+		})
+		return err == nil, err
+	}
+
+	_, err := c.api.MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
+		Revoke: true,
+		ID:     ids,
+	})
+	return err == nil, err
+}
+
+// copyMessage forwards the message without its author. Unlike the Bot API, it
+// can't be sent as a reply.
+func copyMessage(c *Client, ctx context.Context, r *request) (any, error) {
+	fromPeer, err := c.inputPeer(ctx, r.int64("from_chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	toPeer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	randomId, err := c.client.RandInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := c.api.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+		DropAuthor: true,
+		FromPeer:   fromPeer,
+		ID:         []int{r.int("message_id")},
+		RandomID:   []int64{randomId},
+		ToPeer:     toPeer,
+		TopMsgID:   r.int("message_thread_id"),
+		Silent:     r.bool("disable_notification"),
+		Noforwards: r.bool("protect_content"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.sentMessage(ctx, toPeer, sent, "")
+	if err != nil {
+		return nil, err
+	}
+	return &gotgbot.MessageId{MessageId: msg.MessageId}, nil
+}
+
+// mediaSource is a file given to a Bot API method: uploaded, a URL for
+// Telegram to fetch, or a file ID of this transport
+type mediaSource struct {
+	file     tg.InputFileClass
+	name     string
+	mimeType string
+
+	url      string
+	existing tg.InputMediaClass
+}
+
+func (c *Client) mediaSource(ctx context.Context, r *request, value string) (*mediaSource, error) {
+	switch {
+	case strings.HasPrefix(value, "attach://"):
+		key := strings.TrimPrefix(value, "attach://")
+		reader, found := r.data[key]
+		if !found {
+			return nil, fmt.Errorf("no file was attached as %s", key)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		return c.upload(ctx, reader.Name(), content)
+
+	case strings.HasPrefix(value, "file://"):
+		path := strings.TrimPrefix(value, "file://")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return c.upload(ctx, filepath.Base(path), content)
+
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		return &mediaSource{url: value}, nil
+	}
+
+	id, err := decodeFileId(value)
+	if err != nil {
+		return nil, err
+	}
+	return &mediaSource{existing: id.inputMedia()}, nil
+}
+
+func (c *Client) upload(ctx context.Context, name string, content []byte) (*mediaSource, error) {
+	if name == "" {
+		name = "file"
+	}
+	file, err := uploader.NewUploader(c.api).FromBytes(ctx, name, content)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+	return &mediaSource{file: file, name: name, mimeType: mimeType}, nil
+}
+
+// thumbnail uploads the thumbnail given with the file, if any
+func (c *Client) thumbnail(ctx context.Context, r *request, value string) tg.InputFileClass {
+	if !strings.HasPrefix(value, "attach://") {
+		return nil
+	}
+	source, err := c.mediaSource(ctx, r, value)
+	if err != nil {
+		return nil
+	}
+	return source.file
+}
+
+func (s *mediaSource) photo(spoiler bool) tg.InputMediaClass {
+	switch {
+	case s.existing != nil:
+		return s.existing
+	case s.url != "":
+		return &tg.InputMediaPhotoExternal{URL: s.url, Spoiler: spoiler}
+	}
+	return &tg.InputMediaUploadedPhoto{File: s.file, Spoiler: spoiler}
+}
+
+func (s *mediaSource) document(mimeType string, forceFile, spoiler bool, thumb tg.InputFileClass, attributes ...tg.DocumentAttributeClass) tg.InputMediaClass {
+	switch {
+	case s.existing != nil:
+		return s.existing
+	case s.url != "":
+		return &tg.InputMediaDocumentExternal{URL: s.url, Spoiler: spoiler}
+	}
+
+	if s.mimeType != "" && (mimeType == "" || !strings.HasPrefix(s.mimeType, "application/octet-stream")) {
+		mimeType = s.mimeType
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	attributes = append(attributes, &tg.DocumentAttributeFilename{FileName: s.name})
+
+	media := &tg.InputMediaUploadedDocument{
+		File:       s.file,
+		MimeType:   mimeType,
+		Attributes: attributes,
+		ForceFile:  forceFile,
+		Spoiler:    spoiler,
+	}
+	if thumb != nil {
+		media.Thumb = thumb
+	}
+	return media
+}
+
+func (c *Client) sendMedia(ctx context.Context, r *request, media tg.InputMediaClass) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	caption, entities, err := c.requestText(ctx, r, "caption", "caption_entities")
+	if err != nil {
+		return nil, err
+	}
+	randomId, err := c.client.RandInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := c.api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer:       peer,
+		ReplyTo:    r.replyTo(),
+		Media:      media,
+		Message:    caption,
+		Entities:   entities,
+		RandomID:   randomId,
+		Silent:     r.bool("disable_notification"),
+		Noforwards: r.bool("protect_content"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.sentMessage(ctx, peer, sent, caption)
+}
+
+// sendFile sends the file given as key, with the media made by build
+func (c *Client) sendFile(ctx context.Context, r *request, key string, build func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass) (any, error) {
+	source, err := c.mediaSource(ctx, r, r.params[key])
+	if err != nil {
+		return nil, err
+	}
+	return c.sendMedia(ctx, r, build(source, c.thumbnail(ctx, r, r.params["thumbnail"])))
+}
+
+func sendPhoto(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "photo", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.photo(r.bool("has_spoiler"))
+	})
+}
+
+func sendVideo(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "video", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("video/mp4", false, r.bool("has_spoiler"), thumb, &tg.DocumentAttributeVideo{
+			SupportsStreaming: r.bool("supports_streaming"),
+			Duration:          r.float("duration"),
+			W:                 r.int("width"),
+			H:                 r.int("height"),
+		})
+	})
+}
+
+func sendAnimation(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "animation", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("video/mp4", false, r.bool("has_spoiler"), thumb, &tg.DocumentAttributeAnimated{}, &tg.DocumentAttributeVideo{
+			Duration: r.float("duration"),
+			W:        r.int("width"),
+			H:        r.int("height"),
+		})
+	})
+}
+
+func sendVideoNote(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "video_note", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("video/mp4", false, false, thumb, &tg.DocumentAttributeVideo{
+			RoundMessage: true,
+			Duration:     r.float("duration"),
+			W:            r.int("length"),
+			H:            r.int("length"),
+		})
+	})
+}
+
+func sendAudio(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "audio", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("audio/mpeg", false, false, thumb, &tg.DocumentAttributeAudio{
+			Duration:  r.int("duration"),
+			Title:     r.params["title"],
+			Performer: r.params["performer"],
+		})
+	})
+}
+
+func sendVoice(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "voice", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("audio/ogg", false, false, nil, &tg.DocumentAttributeAudio{
+			Voice:    true,
+			Duration: r.int("duration"),
+		})
+	})
+}
+
+func sendDocument(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "document", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		return source.document("", true, false, thumb)
+	})
+}
+
+func sendSticker(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendFile(ctx, r, "sticker", func(source *mediaSource, thumb tg.InputFileClass) tg.InputMediaClass {
+		mimeType := "image/webp"
+		switch strings.ToLower(filepath.Ext(source.name)) {
+		case ".tgs":
+			mimeType = "application/x-tgsticker"
+		case ".webm":
+			mimeType = "video/webm"
+		}
+		source.mimeType = mimeType
+		return source.document(mimeType, false, false, nil, &tg.DocumentAttributeSticker{
+			Alt:        r.params["emoji"],
+			Stickerset: &tg.InputStickerSetEmpty{},
+		})
+	})
+}
+
+func sendContact(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendMedia(ctx, r, &tg.InputMediaContact{
+		PhoneNumber: r.params["phone_number"],
+		FirstName:   r.params["first_name"],
+		LastName:    r.params["last_name"],
+		Vcard:       r.params["vcard"],
+	})
+}
+
+func sendLocation(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.sendMedia(ctx, r, &tg.InputMediaGeoPoint{
+		GeoPoint: &tg.InputGeoPoint{
+			Lat:  r.float("latitude"),
+			Long: r.float("longitude"),
+		},
+	})
+}
+
+// sendPoll sends a regular poll, quizzes are not supported. The votes of
+// users are not reported to user accounts, only the counts.
+func sendPoll(c *Client, ctx context.Context, r *request) (any, error) {
+	var options []string
+	if err := json.Unmarshal([]byte(r.params["options"]), &options); err != nil {
+		return nil, fmt.Errorf("invalid options : %w", err)
+	}
+	pollId, err := c.client.RandInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	poll := tg.Poll{
+		ID:             pollId,
+		Question:       r.params["question"],
+		MultipleChoice: r.bool("allows_multiple_answers"),
+		// Polls are anonymous unless told otherwise
+		PublicVoters: r.params["is_anonymous"] != "" && !r.bool("is_anonymous"),
+	}
+	for i, option := range options {
+		poll.Answers = append(poll.Answers, tg.PollAnswer{Text: option, Option: []byte{byte(i)}})
+	}
+	return c.sendMedia(ctx, r, &tg.InputMediaPoll{Poll: poll})
+}
+
+type inputMediaParams struct {
+	Type              string                  `json:"type"`
+	Media             string                  `json:"media"`
+	Thumbnail         string                  `json:"thumbnail"`
+	Caption           string                  `json:"caption"`
+	ParseMode         string                  `json:"parse_mode"`
+	CaptionEntities   []gotgbot.MessageEntity `json:"caption_entities"`
+	HasSpoiler        bool                    `json:"has_spoiler"`
+	Width             int                     `json:"width"`
+	Height            int                     `json:"height"`
+	Duration          float64                 `json:"duration"`
+	SupportsStreaming bool                    `json:"supports_streaming"`
+	Performer         string                  `json:"performer"`
+	Title             string                  `json:"title"`
+}
+
+// sendMediaGroup uploads each file of the album first, as albums can only be
+// made of files which are on Telegram already
+func sendMediaGroup(c *Client, ctx context.Context, r *request) (any, error) {
+	var items []inputMediaParams
+	if err := json.Unmarshal([]byte(r.params["media"]), &items); err != nil {
+		return nil, fmt.Errorf("invalid media : %w", err)
+	}
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	multiMedia := make([]tg.InputSingleMedia, 0, len(items))
+	for _, item := range items {
+		source, err := c.mediaSource(ctx, r, item.Media)
+		if err != nil {
+			return nil, err
+		}
+		thumb := c.thumbnail(ctx, r, item.Thumbnail)
+
+		var media tg.InputMediaClass
+		switch item.Type {
+		case "photo":
+			media = source.photo(item.HasSpoiler)
+		case "video":
+			media = source.document("video/mp4", false, item.HasSpoiler, thumb, &tg.DocumentAttributeVideo{
+				SupportsStreaming: item.SupportsStreaming,
+				Duration:          item.Duration,
+				W:                 item.Width,
+				H:                 item.Height,
+			})
+		case "audio":
+			media = source.document("audio/mpeg", false, false, thumb, &tg.DocumentAttributeAudio{
+				Duration:  int(item.Duration),
+				Title:     item.Title,
+				Performer: item.Performer,
+			})
+		default:
+			media = source.document("", true, false, thumb)
+		}
+
+		uploaded, err := c.api.MessagesUploadMedia(ctx, &tg.MessagesUploadMediaRequest{
+			Peer:  peer,
+			Media: media,
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch m := uploaded.(type) {
+		case *tg.MessageMediaPhoto:
+			if photo, ok := m.Photo.(*tg.Photo); ok {
+				media = photoFileId(photo, "", 0).inputMedia()
+			}
+		case *tg.MessageMediaDocument:
+			if document, ok := m.Document.(*tg.Document); ok {
+				media = documentFileId(document).inputMedia()
+			}
+		}
+
+		caption, entities, err := c.parseText(ctx, item.Caption, item.ParseMode, item.CaptionEntities)
+		if err != nil {
+			return nil, err
+		}
+		randomId, err := c.client.RandInt64()
+		if err != nil {
+			return nil, err
+		}
+		multiMedia = append(multiMedia, tg.InputSingleMedia{
+			Media:    media,
+			RandomID: randomId,
+			Message:  caption,
+			Entities: entities,
+		})
+	}
+
+	sent, err := c.api.MessagesSendMultiMedia(ctx, &tg.MessagesSendMultiMediaRequest{
+		Peer:       peer,
+		ReplyTo:    r.replyTo(),
+		MultiMedia: multiMedia,
+		Silent:     r.bool("disable_notification"),
+		Noforwards: r.bool("protect_content"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages, err := c.sentMessages(ctx, peer, sent, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]gotgbot.Message, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, *msg)
+	}
+	return result, nil
+}
+
+func sendChatAction(c *Client, ctx context.Context, r *request) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	var action tg.SendMessageActionClass = &tg.SendMessageTypingAction{}
+	switch r.params["action"] {
+	case "upload_photo":
+		action = &tg.SendMessageUploadPhotoAction{}
+	case "upload_video":
+		action = &tg.SendMessageUploadVideoAction{}
+	case "upload_document":
+		action = &tg.SendMessageUploadDocumentAction{}
+	case "record_voice":
+		action = &tg.SendMessageRecordAudioAction{}
+	}
+
+	return c.api.MessagesSetTyping(ctx, &tg.MessagesSetTypingRequest{
+		Peer:     peer,
+		TopMsgID: r.int("message_thread_id"),
+		Action:   action,
+	})
+}
+
+func setMessageReaction(c *Client, ctx context.Context, r *request) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	var reactions []struct {
+		Type  string `json:"type"`
+		Emoji string `json:"emoji"`
+	}
+	if raw := r.params["reaction"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &reactions); err != nil {
+			return nil, fmt.Errorf("invalid reaction : %w", err)
+		}
+	}
+
+	request := &tg.MessagesSendReactionRequest{
+		Peer:  peer,
+		MsgID: r.int("message_id"),
+		Big:   r.bool("is_big"),
+	}
+	for _, reaction := range reactions {
+		if reaction.Type == "emoji" {
+			request.Reaction = append(request.Reaction, &tg.ReactionEmoji{Emoticon: reaction.Emoji})
+		}
+	}
+	// Without any reaction, the ones set before are removed
+	request.Flags.Set(0)
+
+	updates, err := c.api.MessagesSendReaction(ctx, request)
+	if err == nil {
+		_ = c.updateHandler.Handle(ctx, updates)
+	}
+	return err == nil, err
+}
+
+func pinChatMessage(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.updatePinnedMessage(ctx, r, false)
+}
+
+// unpinChatMessage needs message_id, a user can't unpin "the most recent
+// pinned message" as a bot can
+func unpinChatMessage(c *Client, ctx context.Context, r *request) (any, error) {
+	if r.int("message_id") == 0 {
+		return nil, fmt.Errorf("message_id is needed to unpin with the mtproto transport")
+	}
+	return c.updatePinnedMessage(ctx, r, true)
+}
+
+func (c *Client) updatePinnedMessage(ctx context.Context, r *request, unpin bool) (any, error) {
+	peer, err := c.inputPeer(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	updates, err := c.api.MessagesUpdatePinnedMessage(ctx, &tg.MessagesUpdatePinnedMessageRequest{
+		Peer:   peer,
+		ID:     r.int("message_id"),
+		Silent: r.bool("disable_notification"),
+		Unpin:  unpin,
+	})
+	if err == nil {
+		_ = c.updateHandler.Handle(ctx, updates)
+	}
+	return err == nil, err
+}
+
+func createForumTopic(c *Client, ctx context.Context, r *request) (any, error) {
+	channel, err := c.inputChannel(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	randomId, err := c.client.RandInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.api.ChannelsCreateForumTopic(ctx, &tg.ChannelsCreateForumTopicRequest{
+		Channel:   channel,
+		Title:     r.params["name"],
+		IconColor: r.int("icon_color"),
+		RandomID:  randomId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = c.updateHandler.Handle(ctx, created)
+
+	// The topic is identified by the ID of the message which created it
+	updates, ok := created.(*tg.Updates)
+	if ok {
+		for _, update := range updates.Updates {
+			newMsg, ok := update.(*tg.UpdateNewChannelMessage)
+			if !ok {
+				continue
+			}
+			serviceMsg, ok := newMsg.Message.(*tg.MessageService)
+			if !ok {
+				continue
+			}
+			if action, ok := serviceMsg.Action.(*tg.MessageActionTopicCreate); ok {
+				return &gotgbot.ForumTopic{
+					MessageThreadId: int64(serviceMsg.ID),
+					Name:            action.Title,
+					IconColor:       int64(action.IconColor),
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no topic in the result %T", created)
+}
+
+func editForumTopic(c *Client, ctx context.Context, r *request) (any, error) {
+	request := &tg.ChannelsEditForumTopicRequest{
+		TopicID: r.int("message_thread_id"),
+		Title:   r.params["name"],
+	}
+	if emojiId := r.params["icon_custom_emoji_id"]; emojiId != "" {
+		request.IconEmojiID, _ = strconv.ParseInt(emojiId, 10, 64)
+	}
+	return c.editForumTopic(ctx, r, request)
+}
+
+func closeForumTopic(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.setForumTopicClosed(ctx, r, true)
+}
+
+func reopenForumTopic(c *Client, ctx context.Context, r *request) (any, error) {
+	return c.setForumTopicClosed(ctx, r, false)
+}
+
+func (c *Client) setForumTopicClosed(ctx context.Context, r *request, closed bool) (any, error) {
+	request := &tg.ChannelsEditForumTopicRequest{
+		TopicID: r.int("message_thread_id"),
+		Closed:  closed,
+	}
+	// The flag is only set for true by itself, and reopening sends false
+	request.Flags.Set(2)
+	return c.editForumTopic(ctx, r, request)
+}
+
+func (c *Client) editForumTopic(ctx context.Context, r *request, request *tg.ChannelsEditForumTopicRequest) (any, error) {
+	channel, err := c.inputChannel(ctx, r.int64("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+	request.Channel = channel
+
+	updates, err := c.api.ChannelsEditForumTopic(ctx, request)
+	if err == nil {
+		_ = c.updateHandler.Handle(ctx, updates)
+	}
+	return err == nil, err
+}
+
+// getFile downloads the file, and gives its local path as the file path
+func getFile(c *Client, ctx context.Context, r *request) (any, error) {
+	encodedId := r.params["file_id"]
+	id, err := decodeFileId(encodedId)
+	if err != nil {
+		return nil, err
+	}
+
+	directory, err := filepath.Abs(c.opts.FilesDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(directory, 0o700); err != nil {
+		return nil, err
+	}
+	c.cleanupFiles()
+
+	path := filepath.Join(directory, fmt.Sprintf("%s_%d", uniqueFileId(id.Kind, id.ID, id.ThumbSize), time.Now().UnixNano()))
+	if _, err = downloader.NewDownloader().Download(c.api, id.location()).ToPath(ctx, path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gotgbot.File{
+		FileId:       encodedId,
+		FileUniqueId: uniqueFileId(id.Kind, id.ID, id.ThumbSize),
+		FileSize:     info.Size(),
+		FilePath:     path,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"go.uber.org/zap"
+)
+
+// Minimum time between two reports to the owner about the same sender
+const unauthorizedReportInterval = time.Hour
+
+var unauthorizedReports = struct {
+	lock sync.Mutex
+	last map[int64]time.Time
+}{last: make(map[int64]time.Time)}
+
+// tgDescribeUnauthorized returns what the sender of the update tried to do,
+// empty for updates nobody needs to hear about like members joining
+func tgDescribeUnauthorized(c *ext.Context) string {
+	if c.CallbackQuery != nil {
+		return "pressed a button of the bridge"
+	}
+
+	msg := c.EffectiveMessage
+	if msg == nil {
+		return ""
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		return fmt.Sprintf("used <code>%s</code>", html.EscapeString(strings.Fields(msg.Text)[0]))
+	}
+	if msg.Text == "" && msg.Caption == "" && msg.Photo == nil && msg.Document == nil && msg.Video == nil &&
+		msg.Audio == nil && msg.Voice == nil && msg.VideoNote == nil && msg.Sticker == nil &&
+		msg.Animation == nil && msg.Contact == nil && msg.Location == nil && msg.Poll == nil {
+		return ""
+	}
+	if msg.Chat.Id == state.State.Config.Telegram.TargetChatID {
+		return "sent a message to be bridged to WhatsApp"
+	}
+	return "sent a message to the bot"
+}
+
+// tgReportUnauthorized logs the update of someone who is neither the owner nor
+// a sudo user and tells the owner about it, at most once an hour per sender
+func tgReportUnauthorized(c *ext.Context) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	action := tgDescribeUnauthorized(c)
+	if action == "" || c.EffectiveSender == nil {
+		return
+	}
+	senderId := c.EffectiveSender.Id()
+
+	var chatId int64
+	chatTitle := "a private chat"
+	if c.EffectiveChat != nil {
+		chatId = c.EffectiveChat.Id
+		if c.EffectiveChat.Title != "" {
+			chatTitle = c.EffectiveChat.Title
+		}
+	}
+
+	logger.Warn("update from unauthorized telegram user",
+		zap.Int64("sender_id", senderId),
+		zap.String("sender_name", c.EffectiveSender.Name()),
+		zap.Int64("chat_id", chatId),
+		zap.String("action", action),
+	)
+
+	unauthorizedReports.lock.Lock()
+	if time.Since(unauthorizedReports.last[senderId]) < unauthorizedReportInterval {
+		unauthorizedReports.lock.Unlock()
+		return
+	}
+	unauthorizedReports.last[senderId] = time.Now()
+	unauthorizedReports.lock.Unlock()
+
+	if cfg.Telegram.OwnerID == 0 || senderId == cfg.Telegram.OwnerID {
+		return
+	}
+
+	senderName := html.EscapeString(c.EffectiveSender.Name())
+	if c.EffectiveSender.IsUser() {
+		senderName = fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, senderId, senderName)
+	}
+	report := fmt.Sprintf("⛔ <b>%s</b> (<code>%d</code>) %s in <b>%s</b> but is not allowed to use the bridge\n\n"+
+		"Add their ID to <code>sudo_users_id</code> in the config file to allow them",
+		senderName, senderId, action, html.EscapeString(chatTitle))
+	if err := TgSendTextById(state.State.TelegramSender, cfg.Telegram.OwnerID, 0, report); err != nil {
+		logger.Warn("failed to report unauthorized user to owner",
+			zap.Int64("sender_id", senderId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"regexp"
+	"sync"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+type alertRule struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+var (
+	alertRulesLock   sync.Mutex
+	alertRules       []alertRule
+	alertRulesLoaded bool
+)
+
+// AlertRulesReload recompiles the alert rules from the config file and the
+// database, invalid patterns are logged and skipped
+func AlertRulesReload() error {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	patterns := append([]string{}, cfg.WhatsApp.Alerts.Rules...)
+
+	dbRules, err := database.AlertRuleGetAll()
+	if err != nil {
+		return err
+	}
+	for _, rule := range dbRules {
+		patterns = append(patterns, rule.Pattern)
+	}
+
+	var compiled []alertRule
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("skipping invalid alert rule",
+				zap.String("pattern", pattern),
+				zap.Error(err),
+			)
+			continue
+		}
+		compiled = append(compiled, alertRule{pattern: pattern, regex: regex})
+	}
+
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+
+	alertRules = compiled
+	alertRulesLoaded = true
+
+	return nil
+}
+
+// AlertMatch returns the first alert rule pattern matching the text
+func AlertMatch(text string) (string, bool) {
+	alertRulesLock.Lock()
+	loaded := alertRulesLoaded
+	alertRulesLock.Unlock()
+
+	if !loaded {
+		if err := AlertRulesReload(); err != nil {
+			return "", false
+		}
+	}
+
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+
+	for _, rule := range alertRules {
+		if rule.regex.MatchString(text) {
+			return rule.pattern, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+)
+
+const tgTopicPinnedPrefix = "📌 "
+
+// TgTopicNameWithPin prefixes the name of the topic of a chat pinned on the
+// phone, as the Bot API can't pin topics themselves
+func TgTopicNameWithPin(waChatId, name string) string {
+	if !state.State.Config.WhatsApp.MirrorAppState.Pin {
+		return name
+	}
+	if appState, found, _ := database.ChatAppStateGet(waChatId); found && appState.Pinned {
+		return tgTopicPinnedPrefix + name
+	}
+	return name
+}
+
+// WaChatIsMuted reports whether the chat is muted on the phone
+func WaChatIsMuted(waChatId string) bool {
+	if !state.State.Config.WhatsApp.MirrorAppState.Mute {
+		return false
+	}
+	appState, found, err := database.ChatAppStateGet(waChatId)
+	if err != nil || !found || !appState.Muted {
+		return false
+	}
+	return appState.MutedUntil.IsZero() || time.Now().Before(appState.MutedUntil)
+}
@@ -0,0 +1,433 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+type SearchResult struct {
+	Message database.ArchivedMessage
+	Text    string
+	Link    string
+}
+
+func archiveTokenize(text string) []string {
+	var (
+		cfg    = state.State.Config
+		tokens []string
+		seen   = make(map[string]bool)
+	)
+
+	if !cfg.MessageArchive.SearchIndex {
+		return nil
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		if len([]rune(word)) < 2 {
+			continue
+		}
+
+		token := SubString(word, 0, 64)
+		if key := cfg.MessageArchive.EncryptionKey; key != "" {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write([]byte(word))
+			token = hex.EncodeToString(mac.Sum(nil))[:32]
+		}
+
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
+
+// archiveEncrypt encrypts data with the archive key, returns it as it is and
+// false if no key is configured
+func archiveEncrypt(data []byte) ([]byte, bool, error) {
+	if key := state.State.Config.MessageArchive.EncryptionKey; key != "" {
+		encrypted, err := AtRestEncrypt(key, data)
+		return encrypted, true, err
+	}
+	return data, false, nil
+}
+
+// archiveDecrypt opens data encrypted with archiveEncrypt
+func archiveDecrypt(data []byte, encrypted bool) ([]byte, error) {
+	if !encrypted {
+		return data, nil
+	}
+
+	key := state.State.Config.MessageArchive.EncryptionKey
+	if key == "" {
+		return nil, errors.New("the message archive is encrypted but message_archive.encryption_key is not set")
+	}
+	return AtRestDecrypt(key, data)
+}
+
+func archiveEncryptBody(text string) ([]byte, bool, error) {
+	return archiveEncrypt([]byte(text))
+}
+
+func archiveEncryptFileName(fileName string) (string, error) {
+	if fileName == "" {
+		return fileName, nil
+	}
+	encrypted, isEncrypted, err := archiveEncrypt([]byte(fileName))
+	if err != nil || !isEncrypted {
+		return fileName, err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// ArchiveDecrypt returns the text and the file name of the media of an
+// archived message
+func ArchiveDecrypt(msg database.ArchivedMessage) (string, string, error) {
+	text, err := archiveDecrypt(msg.Body, msg.Encrypted)
+	if err != nil {
+		return "", "", err
+	}
+	fileName := msg.MediaFileName
+	if fileName != "" && msg.Encrypted {
+		encrypted, err := base64.StdEncoding.DecodeString(fileName)
+		if err != nil {
+			return "", "", err
+		}
+		decrypted, err := archiveDecrypt(encrypted, true)
+		if err != nil {
+			return "", "", err
+		}
+		fileName = string(decrypted)
+	}
+	return string(text), fileName, nil
+}
+
+// ArchiveEncryptQueued encrypts the text and the content of a message queued
+// during quiet hours with the archive key, if one is configured
+func ArchiveEncryptQueued(msg *database.QueuedMessage) error {
+	if msg.Encrypted {
+		return nil
+	}
+
+	text, encrypted, err := archiveEncrypt([]byte(msg.Text))
+	if err != nil || !encrypted {
+		return err
+	}
+	message, _, err := archiveEncrypt(msg.Message)
+	if err != nil {
+		return err
+	}
+	msg.Text, msg.Message, msg.Encrypted = base64.StdEncoding.EncodeToString(text), message, true
+	return nil
+}
+
+// ArchiveDecryptQueued returns the message queued during quiet hours with its
+// text and content decrypted
+func ArchiveDecryptQueued(msg database.QueuedMessage) (database.QueuedMessage, error) {
+	if !msg.Encrypted {
+		return msg, nil
+	}
+
+	encryptedText, err := base64.StdEncoding.DecodeString(msg.Text)
+	if err != nil {
+		return msg, err
+	}
+	text, err := archiveDecrypt(encryptedText, true)
+	if err != nil {
+		return msg, err
+	}
+	message, err := archiveDecrypt(msg.Message, true)
+	if err != nil {
+		return msg, err
+	}
+	msg.Text, msg.Message, msg.Encrypted = string(text), message, false
+	return msg, nil
+}
+
+// ArchiveEncryptExisting encrypts the messages archived, stored for forwarding
+// and queued during quiet hours before the encryption key was set, so that
+// setting it protects all of them and not only the messages to come. Returns
+// the number of messages encrypted.
+func ArchiveEncryptExisting() (int, error) {
+	if state.State.Config.MessageArchive.EncryptionKey == "" {
+		return 0, nil
+	}
+
+	encrypted := 0
+	for {
+		msgs, err := database.ArchivedMessageGetUnencrypted(500)
+		if err != nil {
+			return encrypted, err
+		} else if len(msgs) == 0 {
+			break
+		}
+
+		for _, msg := range msgs {
+			text := string(msg.Body)
+			if msg.Body, msg.Encrypted, err = archiveEncryptBody(text); err != nil {
+				return encrypted, err
+			}
+			if msg.MediaFileName, err = archiveEncryptFileName(msg.MediaFileName); err != nil {
+				return encrypted, err
+			}
+
+			if err = database.ArchivedMessageReplace(&msg, archiveTokenize(text)); err != nil {
+				return encrypted, err
+			}
+			encrypted++
+		}
+	}
+
+	for {
+		msgs, err := database.ForwardableMessageGetUnencrypted(500)
+		if err != nil {
+			return encrypted, err
+		} else if len(msgs) == 0 {
+			break
+		}
+
+		for _, msg := range msgs {
+			if msg.Message, msg.Encrypted, err = archiveEncrypt(msg.Message); err != nil {
+				return encrypted, err
+			}
+			if err = database.ForwardableMessageReplace(&msg); err != nil {
+				return encrypted, err
+			}
+			encrypted++
+		}
+	}
+
+	for {
+		msgs, err := database.QueuedMessageGetUnencrypted(500)
+		if err != nil {
+			return encrypted, err
+		} else if len(msgs) == 0 {
+			break
+		}
+
+		for _, msg := range msgs {
+			if err = ArchiveEncryptQueued(&msg); err != nil {
+				return encrypted, err
+			}
+			if err = database.QueuedMessageReplace(&msg); err != nil {
+				return encrypted, err
+			}
+			encrypted++
+		}
+	}
+
+	return encrypted, nil
+}
+
+func waGetMediaInfo(msg *waProto.Message) (mediaType, mimetype, fileName string, size uint64) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		m := msg.GetImageMessage()
+		return "image", m.GetMimetype(), "", m.GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		m := msg.GetVideoMessage()
+		if m.GetGifPlayback() {
+			return "gif", m.GetMimetype(), "", m.GetFileLength()
+		}
+		return "video", m.GetMimetype(), "", m.GetFileLength()
+	case msg.GetPtvMessage() != nil:
+		m := msg.GetPtvMessage()
+		return "video_note", m.GetMimetype(), "", m.GetFileLength()
+	case msg.GetAudioMessage() != nil:
+		m := msg.GetAudioMessage()
+		if m.GetPtt() {
+			return "voice_note", m.GetMimetype(), "", m.GetFileLength()
+		}
+		return "audio", m.GetMimetype(), "", m.GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		m := msg.GetDocumentMessage()
+		return "document", m.GetMimetype(), m.GetFileName(), m.GetFileLength()
+	case msg.GetStickerMessage() != nil:
+		m := msg.GetStickerMessage()
+		return "sticker", m.GetMimetype(), "", m.GetFileLength()
+	case msg.GetContactMessage() != nil, msg.GetContactsArrayMessage() != nil:
+		return "contact", "", "", 0
+	case msg.GetLocationMessage() != nil, msg.GetLiveLocationMessage() != nil:
+		return "location", "", "", 0
+	}
+	return "", "", "", 0
+}
+
+// ArchiveMessage stores the content of a bridged message in the message archive
+func ArchiveMessage(waMsgId string, chat, sender types.JID, senderName string, isFromMe bool,
+	msg *waProto.Message, text string, timestamp time.Time) {
+
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.MessageArchive.Enabled {
+		return
+	}
+
+	if text == "" {
+		text = WaGetMessageText(msg)
+	}
+	mediaType, mimetype, fileName, size := waGetMediaInfo(msg)
+	if text == "" && mediaType == "" {
+		return
+	}
+
+	body, encrypted, err := archiveEncryptBody(text)
+	if err == nil {
+		fileName, err = archiveEncryptFileName(fileName)
+	}
+	if err != nil {
+		logger.Error("failed to encrypt message for archive",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	err = database.ArchivedMessageAdd(&database.ArchivedMessage{
+		WaMsgId:       waMsgId,
+		WaChatId:      chat.ToNonAD().String(),
+		SenderId:      sender.ToNonAD().String(),
+		SenderName:    senderName,
+		IsFromMe:      isFromMe,
+		Body:          body,
+		MediaType:     mediaType,
+		MediaMimetype: mimetype,
+		MediaFileName: fileName,
+		MediaSize:     size,
+		Timestamp:     timestamp,
+		Encrypted:     encrypted,
+	}, archiveTokenize(text))
+	if err != nil {
+		logger.Error("failed to add message to archive",
+			zap.String("msg_id", waMsgId),
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// ArchiveMessageEdit replaces the text of an archived message after it was edited
+func ArchiveMessageEdit(waMsgId string, chat types.JID, text string, editedAt time.Time) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.MessageArchive.Enabled || text == "" {
+		return
+	}
+
+	body, encrypted, err := archiveEncryptBody(text)
+	if err == nil {
+		err = database.ArchivedMessageUpdateBody(waMsgId, chat.ToNonAD().String(), body, encrypted, editedAt, archiveTokenize(text))
+	}
+	if err != nil {
+		logger.Error("failed to update edited message in archive",
+			zap.String("msg_id", waMsgId),
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+func ArchiveMessageRevoked(waMsgId string, chat types.JID) {
+	if !state.State.Config.MessageArchive.Enabled {
+		return
+	}
+	database.ArchivedMessageMarkRevoked(waMsgId, chat.ToNonAD().String())
+}
+
+// ArchiveMessageStarred records the message being starred or unstarred
+func ArchiveMessageStarred(waMsgId string, chat types.JID, starred bool) {
+	if !state.State.Config.MessageArchive.Enabled {
+		return
+	}
+	database.ArchivedMessageSetStarred(waMsgId, chat.ToNonAD().String(), starred)
+}
+
+// ArchivePrune deletes the archived messages older than the retention period
+func ArchivePrune() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.MessageArchive.Enabled || cfg.MessageArchive.RetentionDays <= 0 {
+		return
+	}
+
+	before := time.Now().UTC().AddDate(0, 0, -cfg.MessageArchive.RetentionDays)
+	if err := database.ArchivedMessageDeleteBefore(before); err != nil {
+		logger.Error("failed to prune message archive", zap.Error(err))
+	}
+}
+
+// SearchMessages returns the latest archived messages containing all the
+// words of the query, optionally only from the given chat
+func SearchMessages(query, waChatId string, limit int) ([]SearchResult, error) {
+	tokens := archiveTokenize(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	msgs, err := database.ArchivedMessageSearch(tokens, waChatId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveSearchResults(msgs)
+}
+
+// StarredMessages returns the latest starred messages, of all chats if the
+// chat is empty
+func StarredMessages(waChatId string, limit int) ([]SearchResult, error) {
+	msgs, err := database.ArchivedMessageGetStarred(waChatId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveSearchResults(msgs)
+}
+
+func archiveSearchResults(msgs []database.ArchivedMessage) ([]SearchResult, error) {
+	results := make([]SearchResult, 0, len(msgs))
+	for _, msg := range msgs {
+		text, fileName, err := ArchiveDecrypt(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg.MediaFileName = fileName
+
+		result := SearchResult{Message: msg, Text: text}
+		tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(msg.WaMsgId, msg.WaChatId)
+		if err == nil && tgChatId != 0 && tgMsgId != 0 {
+			result.Link = TgBuildMessageLink(tgChatId, tgThreadId, tgMsgId)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
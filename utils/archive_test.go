@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"watgbridge/database"
+	"watgbridge/fakes"
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const testArchiveKey = "archive test key"
+
+func newArchiveTestHarness(t *testing.T) {
+	t.Helper()
+
+	if _, err := fakes.NewHarness(); err != nil {
+		t.Fatal(err)
+	}
+	state.State.Config.MessageArchive.EncryptionKey = testArchiveKey
+	state.State.Config.WhatsApp.ForwardableMessagesDays = 7
+}
+
+func TestForwardableEncrypted(t *testing.T) {
+	newArchiveTestHarness(t)
+
+	chat := types.NewJID("10000000002", types.DefaultUserServer)
+	msg := &waProto.Message{Conversation: proto.String("Forward me")}
+	ForwardableStore("WAFWD", chat, msg)
+
+	stored, found, err := database.ForwardableMessageGet("WAFWD", chat.String())
+	if err != nil || !found {
+		t.Fatalf("not stored (%v)", err)
+	}
+	if !stored.Encrypted || bytes.Contains(stored.Message, []byte("Forward me")) {
+		t.Errorf("stored without encryption")
+	}
+
+	got, found, err := ForwardableGet("WAFWD", chat)
+	if err != nil || !found {
+		t.Fatalf("not found (%v)", err)
+	}
+	if !proto.Equal(got, msg) {
+		t.Errorf("ForwardableGet() = %v, want %v", got, msg)
+	}
+}
+
+func TestQueuedEncrypted(t *testing.T) {
+	newArchiveTestHarness(t)
+
+	msg := database.QueuedMessage{
+		MsgId:   "WAQUEUED",
+		Text:    "Quiet please",
+		Message: []byte("serialized message"),
+	}
+	queued := msg
+	if err := ArchiveEncryptQueued(&queued); err != nil {
+		t.Fatal(err)
+	}
+	if !queued.Encrypted || strings.Contains(queued.Text, "Quiet") || bytes.Contains(queued.Message, []byte("serialized")) {
+		t.Errorf("queued without encryption as %+v", queued)
+	}
+
+	decrypted, err := ArchiveDecryptQueued(queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Encrypted || decrypted.Text != msg.Text || !bytes.Equal(decrypted.Message, msg.Message) {
+		t.Errorf("decrypted as %+v, want %+v", decrypted, msg)
+	}
+}
+
+// Setting the key encrypts what was stored before it
+func TestArchiveEncryptExisting(t *testing.T) {
+	newArchiveTestHarness(t)
+	state.State.Config.MessageArchive.EncryptionKey = ""
+
+	chat := types.NewJID("10000000002", types.DefaultUserServer)
+	msg := &waProto.Message{Conversation: proto.String("Forward me")}
+	ForwardableStore("WAFWD", chat, msg)
+	if err := database.QueuedMessageAdd(&database.QueuedMessage{MsgId: "WAQUEUED", Text: "Quiet please"}); err != nil {
+		t.Fatal(err)
+	}
+
+	state.State.Config.MessageArchive.EncryptionKey = testArchiveKey
+	encrypted, err := ArchiveEncryptExisting()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted != 2 {
+		t.Errorf("encrypted %d messages, want 2", encrypted)
+	}
+
+	if got, _, err := ForwardableGet("WAFWD", chat); err != nil || !proto.Equal(got, msg) {
+		t.Errorf("ForwardableGet() = %v (%v), want %v", got, err, msg)
+	}
+	queued, err := database.QueuedMessageGetAll()
+	if err != nil || len(queued) != 1 || !queued[0].Encrypted {
+		t.Fatalf("queued as %+v (%v)", queued, err)
+	}
+	if decrypted, err := ArchiveDecryptQueued(queued[0]); err != nil || decrypted.Text != "Quiet please" {
+		t.Errorf("decrypted as %+v (%v)", decrypted, err)
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// AvatarArchive records a change of the profile picture of a contact or group,
+// the new picture is kept in the media store if it is enabled
+func AvatarArchive(chat, author types.JID, picture []byte, removed bool, changedAt time.Time) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	change := &database.AvatarChange{
+		WaChatId:  chat.ToNonAD().String(),
+		Removed:   removed,
+		ChangedAt: changedAt,
+	}
+	if !author.IsEmpty() {
+		change.Author = author.ToNonAD().String()
+	}
+
+	if len(picture) > 0 && state.State.Config.MediaStore.Enabled {
+		MediaStoreSave(picture, "image/jpeg")
+		sum := sha256.Sum256(picture)
+		change.MediaHash = hex.EncodeToString(sum[:])
+	}
+
+	if err := database.AvatarChangeAdd(change); err != nil {
+		logger.Error("failed to save profile picture change",
+			zap.String("chat", change.WaChatId),
+			zap.Error(err),
+		)
+	}
+}
+
+// AvatarLoad returns a picture saved by AvatarArchive, if it is still stored
+func AvatarLoad(change database.AvatarChange) ([]byte, bool) {
+	if change.MediaHash == "" {
+		return nil, false
+	}
+	hash, err := hex.DecodeString(change.MediaHash)
+	if err != nil {
+		return nil, false
+	}
+	return MediaStoreLoad(hash)
+}
+
+// TgTopicAvatarUpdate posts the profile picture of the chat to its topic and
+// pins it, as topics have no pictures of their own. The previously pinned
+// picture is unpinned. The picture is fetched from WhatsApp if it is not
+// given, and nothing is posted if the chat has none. The note is added to the
+// caption. Returns whether the picture was posted.
+func TgTopicAvatarUpdate(chat types.JID, threadId int64, picture []byte, removed bool, note string) bool {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = TgSenderFor(chat.String())
+	)
+	defer logger.Sync()
+
+	if !cfg.Telegram.TopicAvatars || threadId == 0 ||
+		(chat.Server != types.DefaultUserServer && chat.Server != types.GroupServer) {
+		return false
+	}
+
+	if !removed && len(picture) == 0 {
+		var err error
+		picture, err = avatarFetch(chat)
+		if err != nil {
+			logger.Warn("failed to fetch profile picture for topic",
+				zap.String("chat", chat.String()),
+				zap.Error(err),
+			)
+			return false
+		}
+		removed = len(picture) == 0
+	}
+
+	waChatId := chat.ToNonAD().String()
+	if previous, found, _ := database.TopicAvatarGet(waChatId); found {
+		_, err := tgBot.UnpinChatMessage(previous.TgChatId, &gotgbot.UnpinChatMessageOpts{
+			MessageId: &previous.TgMsgId,
+		})
+		if err != nil {
+			logger.Debug("failed to unpin previous profile picture of topic",
+				zap.String("chat", waChatId),
+				zap.Error(err),
+			)
+		}
+		database.TopicAvatarDelete(waChatId)
+	}
+	if removed {
+		return false
+	}
+
+	caption := "<b>" + html.EscapeString(WaGetChatName(chat)) + "</b>"
+	if note != "" {
+		caption += "\n" + note
+	}
+	sentMsg, err := tgBot.SendPhoto(cfg.Telegram.TargetChatID, picture, &gotgbot.SendPhotoOpts{
+		MessageThreadId:     threadId,
+		Caption:             caption,
+		DisableNotification: note == "",
+	})
+	if err != nil {
+		logger.Warn("failed to post profile picture to topic",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	_, err = tgBot.PinChatMessage(cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{
+		DisableNotification: true,
+	})
+	if err != nil {
+		logger.Warn("failed to pin profile picture in topic, the bot needs to be allowed to pin messages",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+		return true
+	}
+
+	if err = database.TopicAvatarSet(waChatId, cfg.Telegram.TargetChatID, sentMsg.MessageId); err != nil {
+		logger.Error("failed to save pinned profile picture of topic",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+	}
+	return true
+}
+
+// avatarFetch downloads the full size profile picture of the chat, nil is
+// returned if it has none or it is hidden from you
+func avatarFetch(chat types.JID) ([]byte, error) {
+	pictureInfo, err := state.State.WhatsAppSender.GetProfilePictureInfo(chat, &whatsmeow.GetProfilePictureParams{
+		Preview: false,
+	})
+	if errors.Is(err, whatsmeow.ErrProfilePictureNotSet) || errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if pictureInfo == nil {
+		return nil, nil
+	}
+
+	return DownloadFileBytesByURL(pictureInfo.URL)
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	BackupTelegram = "telegram"
+	BackupS3       = "s3"
+
+	backupBridgeFile  = "bridge.db"
+	backupSessionFile = "session.db"
+)
+
+// backupSQLitePath returns the file of a sqlite DSN like the ones used for the
+// login database, which can be in the file: URI form with query parameters
+func backupSQLitePath(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "file:")
+	if idx := strings.IndexByte(dsn, '?'); idx >= 0 {
+		dsn = dsn[:idx]
+	}
+	return dsn
+}
+
+// backupSources returns the databases to back up, by the name they are stored
+// as in the archive, along with the files they are restored to
+func backupSources(withSession bool) (map[string]string, error) {
+	cfg := state.State.Config
+
+	if cfg.Database["type"] != "sqlite" {
+		return nil, fmt.Errorf("backups are only supported for sqlite databases, use the tools of '%s' instead", cfg.Database["type"])
+	}
+	sources := map[string]string{
+		backupBridgeFile: backupSQLitePath(cfg.Database["path"]),
+	}
+
+	if withSession {
+		if cfg.WhatsApp.LoginDatabase.Type != "sqlite3" {
+			return nil, fmt.Errorf("backups are only supported for sqlite login databases, use the tools of '%s' instead",
+				cfg.WhatsApp.LoginDatabase.Type)
+		}
+		sources[backupSessionFile] = backupSQLitePath(cfg.WhatsApp.LoginDatabase.URL)
+	}
+
+	return sources, nil
+}
+
+// BackupCreate makes a consistent copy of the databases while the bridge keeps
+// running and returns them as a gzipped tar encrypted with the backup key,
+// along with the file name to store it as
+func BackupCreate() ([]byte, string, error) {
+	cfg := state.State.Config
+
+	if cfg.Backup.EncryptionKey == "" {
+		return nil, "", errors.New("backup.encryption_key is not set in the config file")
+	}
+	sources, err := backupSources(cfg.Backup.IncludeSession)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tempDir, err := os.MkdirTemp("", "watgbridge-backup-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temporary directory : %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var archive bytes.Buffer
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, name := range []string{backupBridgeFile, backupSessionFile} {
+		if _, found := sources[name]; !found {
+			continue
+		}
+
+		snapshotPath := filepath.Join(tempDir, name)
+		if name == backupBridgeFile {
+			// The message ID pairs still waiting for their batch belong in it
+			if err = database.MsgIdFlushPending(); err == nil {
+				err = state.State.Database.Exec("VACUUM INTO ?", snapshotPath).Error
+			}
+		} else {
+			err = backupSnapshotSession(snapshotPath)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to copy %s : %s", name, err)
+		}
+
+		data, err := os.ReadFile(snapshotPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read copy of %s : %s", name, err)
+		}
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o600,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = tarWriter.Write(data)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to add %s to archive : %s", name, err)
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return nil, "", err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, "", err
+	}
+
+	encrypted, err := AtRestEncrypt(cfg.Backup.EncryptionKey, archive.Bytes())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt archive : %s", err)
+	}
+
+	fileName := fmt.Sprintf("watgbridge-backup-%s.tar.gz.enc", time.Now().In(state.State.LocalLocation).Format("20060102-150405"))
+	return encrypted, fileName, nil
+}
+
+func backupSnapshotSession(snapshotPath string) error {
+	loginDb := state.State.Config.WhatsApp.LoginDatabase
+
+	db, err := sql.Open(loginDb.Type, loginDb.URL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("VACUUM INTO ?", snapshotPath)
+	return err
+}
+
+// BackupUpload sends the backup to the configured destination and returns a
+// description of where it went
+func BackupUpload(data []byte, fileName string) (string, error) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	var (
+		where string
+		err   error
+	)
+	switch cfg.Backup.Destination {
+	case BackupTelegram, "":
+		_, err = state.State.TelegramSender.SendDocument(cfg.Telegram.OwnerID, gotgbot.NamedFile{
+			FileName: fileName,
+			File:     bytes.NewReader(data),
+		}, &gotgbot.SendDocumentOpts{
+			Caption: "Backup of the bridge, restore it with <code>watgbridge restore</code>",
+			RequestOpts: &gotgbot.RequestOpts{
+				Timeout: -1,
+			},
+		})
+		where = "your private chat with the bot"
+	case BackupS3:
+		where, err = S3PutObject(cfg.Backup.S3, fileName, data, "application/octet-stream")
+	default:
+		err = fmt.Errorf("unknown backup destination '%s'", cfg.Backup.Destination)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("uploaded backup",
+		zap.String("destination", cfg.Backup.Destination),
+		zap.String("file_name", fileName),
+		zap.Int("size", len(data)),
+	)
+	return where, nil
+}
+
+// BackupRestore decrypts the backup and writes the databases in it over the
+// configured ones, which must not be in use. Existing databases are only
+// replaced with force, and the login database only with withSession if the
+// backup has it. Returns the files written.
+func BackupRestore(backupPath string, withSession, force bool) ([]string, error) {
+	cfg := state.State.Config
+
+	if cfg.Backup.EncryptionKey == "" {
+		return nil, errors.New("backup.encryption_key is not set in the config file")
+	}
+	sources, err := backupSources(withSession)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := os.ReadFile(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup : %s", err)
+	}
+	decrypted, err := AtRestDecrypt(cfg.Backup.EncryptionKey, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup, is the encryption key the same? : %s", err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(decrypted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup archive : %s", err)
+	}
+	files := make(map[string][]byte)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read backup archive : %s", err)
+		}
+		if _, found := sources[header.Name]; !found {
+			continue
+		}
+		if files[header.Name], err = io.ReadAll(tarReader); err != nil {
+			return nil, fmt.Errorf("failed to read %s from backup archive : %s", header.Name, err)
+		}
+	}
+
+	if _, found := files[backupBridgeFile]; !found {
+		return nil, errors.New("the backup doesn't contain the bridge database")
+	}
+
+	for name := range files {
+		if _, err := os.Stat(sources[name]); err == nil && !force {
+			return nil, fmt.Errorf("'%s' already exists, stop the bridge and use -force to replace it", sources[name])
+		}
+	}
+
+	var restored []string
+	for name, data := range files {
+		target := sources[name]
+		if err = os.WriteFile(target+".restore", data, 0o600); err != nil {
+			return restored, fmt.Errorf("failed to write %s : %s", name, err)
+		}
+		// A journal left behind by the replaced database would be replayed
+		// over the restored one
+		for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+			os.Remove(target + suffix)
+		}
+		if err = os.Rename(target+".restore", target); err != nil {
+			return restored, fmt.Errorf("failed to replace %s : %s", target, err)
+		}
+		restored = append(restored, target)
+	}
+
+	return restored, nil
+}
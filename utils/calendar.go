@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func waEventLocation(location *waProto.LocationMessage) string {
+	var parts []string
+	for _, part := range []string{location.GetName(), location.GetAddress()} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WaFormatEventMessage renders a group event with its name, time, location and
+// call link as text for Telegram
+func WaFormatEventMessage(eventMsg *waProto.EventMessage) string {
+	text := fmt.Sprintf("<b>Event: %s</b>\n", html.EscapeString(eventMsg.GetName()))
+	if eventMsg.GetIsCanceled() {
+		text += "<i>This event was cancelled</i>\n"
+	}
+
+	if startTime := eventMsg.GetStartTime(); startTime != 0 {
+		text += fmt.Sprintf("🕛: <i>%s</i>\n",
+			html.EscapeString(time.Unix(startTime, 0).In(state.State.LocalLocation).Format(state.State.Config.TimeFormat)))
+	}
+
+	if location := eventMsg.GetLocation(); location != nil {
+		locationText := waEventLocation(location)
+		if location.DegreesLatitude != nil && location.DegreesLongitude != nil {
+			mapsURL := fmt.Sprintf("https://maps.google.com/?q=%f,%f", location.GetDegreesLatitude(), location.GetDegreesLongitude())
+			if locationText == "" {
+				locationText = "Open in maps"
+			}
+			text += fmt.Sprintf("📍: <a href=\"%s\">%s</a>\n", mapsURL, html.EscapeString(locationText))
+		} else if locationText != "" {
+			text += fmt.Sprintf("📍: %s\n", html.EscapeString(locationText))
+		}
+	}
+
+	if joinLink := eventMsg.GetJoinLink(); joinLink != "" {
+		text += fmt.Sprintf("🔗: %s\n", html.EscapeString(joinLink))
+	}
+
+	if description := eventMsg.GetDescription(); description != "" {
+		text += "\n" + html.EscapeString(description) + "\n"
+	}
+
+	return text
+}
+
+// WaBuildEventICS generates an iCalendar file for a group event so it can be
+// added to a calendar, WhatsApp events have no end so they last an hour
+func WaBuildEventICS(eventMsg *waProto.EventMessage, msgId string) []byte {
+	var (
+		startTime = time.Unix(eventMsg.GetStartTime(), 0).UTC()
+		lines     []string
+	)
+
+	lines = append(lines,
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//WaTgBridge//WhatsApp Event//EN",
+		"BEGIN:VEVENT",
+		"UID:"+msgId+"@watgbridge",
+		"DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"),
+		"DTSTART:"+startTime.Format("20060102T150405Z"),
+		"DURATION:PT1H",
+		"SUMMARY:"+icsTextEscaper.Replace(eventMsg.GetName()),
+	)
+
+	if description := eventMsg.GetDescription(); description != "" {
+		lines = append(lines, "DESCRIPTION:"+icsTextEscaper.Replace(description))
+	}
+	if location := eventMsg.GetLocation(); location != nil {
+		if locationText := waEventLocation(location); locationText != "" {
+			lines = append(lines, "LOCATION:"+icsTextEscaper.Replace(locationText))
+		}
+		if location.DegreesLatitude != nil && location.DegreesLongitude != nil {
+			lines = append(lines, fmt.Sprintf("GEO:%f;%f", location.GetDegreesLatitude(), location.GetDegreesLongitude()))
+		}
+	}
+	if joinLink := eventMsg.GetJoinLink(); joinLink != "" {
+		lines = append(lines, "URL:"+joinLink)
+	}
+	if eventMsg.GetIsCanceled() {
+		lines = append(lines, "STATUS:CANCELLED")
+	}
+
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
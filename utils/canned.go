@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// CannedReplyRender executes the text of a canned reply as a text/template
+// with the details of the contact being replied to and of the chat available
+// as {{.Name}}, {{.FirstName}}, {{.Number}}, {{.Chat}}, {{.Me}}, {{.Date}} and
+// {{.Time}}
+func CannedReplyRender(text string, chat, contact types.JID) (string, error) {
+	tmpl, err := template.New("canned").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		now       = time.Now().In(state.State.LocalLocation)
+		name      = WaGetChatName(contact)
+		firstName string
+		me        string
+	)
+
+	if contact.Server != types.GroupServer {
+		dbFirstName, fullName, pushName, _, err := database.ContactNameGet(contact.User)
+		if err == nil {
+			for _, candidate := range []string{dbFirstName, fullName, pushName} {
+				if fields := strings.Fields(candidate); len(fields) > 0 {
+					firstName = fields[0]
+					break
+				}
+			}
+		}
+		// Names of unsaved contacts end with their number in brackets
+		name = strings.TrimSuffix(name, " ("+contact.User+")")
+	}
+	if firstName == "" {
+		firstName = name
+	}
+	if waClient := state.State.WhatsAppClient; waClient != nil {
+		me = waClient.Store.PushName
+	}
+
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, struct {
+		Name      string
+		FirstName string
+		Number    string
+		Chat      string
+		Me        string
+		Date      string
+		Time      string
+	}{
+		Name:      name,
+		FirstName: firstName,
+		Number:    contact.User,
+		Chat:      WaGetChatName(chat),
+		Me:        me,
+		Date:      now.Format("02 Jan 2006"),
+		Time:      now.Format("15:04"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+func atRestCipher(key string) (cipher.AEAD, error) {
+	derivedKey := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(derivedKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AtRestEncrypt encrypts the data with AES-GCM using a key derived from the
+// given secret, the nonce is prepended to the returned ciphertext
+func AtRestEncrypt(key string, plaintext []byte) ([]byte, error) {
+	gcm, err := atRestCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func AtRestDecrypt(key string, ciphertext []byte) ([]byte, error) {
+	gcm, err := atRestCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// WaDeliveryBlocked reports whether sending to the chat is currently blocked
+// by a delivery blackout, returning the time at which the blackout ends
+func WaDeliveryBlocked(chat types.JID) (bool, string) {
+	cfg := state.State.Config
+
+	blackout, found := cfg.WhatsApp.DeliveryBlackouts[chat.ToNonAD().String()]
+	if !found {
+		blackout, found = cfg.WhatsApp.DeliveryBlackouts[chat.User]
+	}
+	if !found || blackout.Start == "" || blackout.End == "" {
+		return false, ""
+	}
+
+	blocked, err := TimeIsInWindow(time.Now().In(state.State.LocalLocation), blackout.Start, blackout.End)
+	return err == nil && blocked, blackout.End
+}
+
+// TgQueueForDelivery stores a message to be sent to WhatsApp once the delivery
+// blackout of the chat is over
+func TgQueueForDelivery(b *gotgbot.Bot, c *ext.Context,
+	msgToForward, msgToReplyTo *gotgbot.Message,
+	waChatJID types.JID, participant, stanzaId string,
+	isReply bool, blockedUntil string) error {
+
+	contextBytes, err := json.Marshal(c.EffectiveMessage)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to queue the message", err)
+	}
+	msgToForwardBytes, err := json.Marshal(msgToForward)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to queue the message", err)
+	}
+	var msgToReplyToBytes []byte
+	if msgToReplyTo != nil {
+		if msgToReplyToBytes, err = json.Marshal(msgToReplyTo); err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to queue the message", err)
+		}
+	}
+
+	err = database.PendingDeliveryAdd(&database.PendingDelivery{
+		WaChatId:     waChatJID.String(),
+		Context:      contextBytes,
+		MsgToForward: msgToForwardBytes,
+		MsgToReplyTo: msgToReplyToBytes,
+		Participant:  participant,
+		StanzaId:     stanzaId,
+		IsReply:      isReply,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to queue the message", err)
+	}
+
+	_, err = TgReplyTextByContext(b, c,
+		fmt.Sprintf("Queued, the message will be delivered after <b>%s</b>", html.EscapeString(blockedUntil)), nil)
+	return err
+}
+
+// pendingDeliveryMaxAttempts is how many times a queued message is tried to be
+// sent before giving up on it
+const pendingDeliveryMaxAttempts = 5
+
+// TgFlushQueuedDeliveries sends the queued messages of the chats whose
+// delivery blackout is over. Messages which fail to be sent are tried again
+// the next time, along with the ones queued after them in the same chat.
+func TgFlushQueuedDeliveries() {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	deliveries, err := database.PendingDeliveryGetAll()
+	if err != nil {
+		logger.Error("failed to get queued deliveries", zap.Error(err))
+		return
+	}
+
+	failedChats := make(map[string]bool)
+	for _, delivery := range deliveries {
+		waChatJID, _ := WaParseJID(delivery.WaChatId)
+		if blocked, _ := WaDeliveryBlocked(waChatJID); blocked || failedChats[delivery.WaChatId] {
+			continue
+		}
+
+		var (
+			contextMsg, msgToForward gotgbot.Message
+			msgToReplyTo             *gotgbot.Message
+		)
+		err := json.Unmarshal(delivery.Context, &contextMsg)
+		if err == nil {
+			err = json.Unmarshal(delivery.MsgToForward, &msgToForward)
+		}
+		if err == nil && len(delivery.MsgToReplyTo) > 0 {
+			msgToReplyTo = &gotgbot.Message{}
+			err = json.Unmarshal(delivery.MsgToReplyTo, msgToReplyTo)
+		}
+		if err != nil {
+			logger.Error("failed to parse queued delivery, dropping it",
+				zap.String("chat_jid", delivery.WaChatId),
+				zap.Error(err),
+			)
+			database.PendingDeliveryDelete(delivery.ID)
+			continue
+		}
+
+		c := ext.NewContext(&gotgbot.Update{Message: &contextMsg}, map[string]interface{}{})
+		err = TgSendToWhatsApp(tgBot, c, &msgToForward, msgToReplyTo, waChatJID,
+			delivery.Participant, delivery.StanzaId, delivery.IsReply)
+		if err != nil {
+			logger.Error("failed to send queued delivery",
+				zap.String("chat_jid", delivery.WaChatId),
+				zap.Error(err),
+			)
+		}
+
+		if sendErr, failed := c.Data[tgSendFailedKey].(error); failed {
+			delivery.Attempts += 1
+			logger.Warn("queued delivery not sent",
+				zap.String("chat_jid", delivery.WaChatId),
+				zap.Int("attempts", delivery.Attempts),
+				zap.Error(sendErr),
+			)
+
+			if delivery.Attempts < pendingDeliveryMaxAttempts {
+				failedChats[delivery.WaChatId] = true
+				if err = database.PendingDeliverySetAttempts(delivery.ID, delivery.Attempts); err != nil {
+					logger.Error("failed to save attempts of queued delivery", zap.Error(err))
+				}
+				continue
+			}
+
+			TgReplyTextByContext(tgBot, c,
+				fmt.Sprintf("Gave up delivering the queued message after %d attempts", delivery.Attempts), nil)
+		}
+
+		if err = database.PendingDeliveryDelete(delivery.ID); err != nil {
+			logger.Error("failed to remove sent delivery from queue", zap.Error(err))
+		}
+	}
+}
+
+// recentReceipts are the messages whose receipt arrived before they were
+// tracked, as WhatsApp can deliver them before SendMessage returns
+var recentReceipts = struct {
+	sync.Mutex
+	ids map[string]time.Time
+}{ids: make(map[string]time.Time)}
+
+// WaTrackDelivery waits for the delivery receipt of a message sent from
+// Telegram, to warn about it if none arrives in delivery_timeout_minutes
+func WaTrackDelivery(waMsgId string, waChatJID types.JID, tgMsg *gotgbot.Message) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if cfg.WhatsApp.DeliveryTimeoutMinutes <= 0 || tgMsg == nil {
+		return
+	}
+	// Newsletters, broadcasts and the chat with yourself don't get delivery
+	// receipts
+	if waChatJID.Server == types.NewsletterServer || waChatJID.Server == types.BroadcastServer {
+		return
+	}
+	if waClient := state.State.WhatsAppClient; waClient != nil && waClient.Store.ID != nil &&
+		waChatJID.User == waClient.Store.ID.User {
+		return
+	}
+
+	recentReceipts.Lock()
+	_, delivered := recentReceipts.ids[waMsgId]
+	delete(recentReceipts.ids, waMsgId)
+	recentReceipts.Unlock()
+	if delivered {
+		return
+	}
+
+	err := database.PendingReceiptAdd(&database.PendingReceipt{
+		ID:         waMsgId,
+		WaChatId:   waChatJID.String(),
+		TgChatId:   tgMsg.Chat.Id,
+		TgThreadId: tgMsg.MessageThreadId,
+		TgMsgId:    tgMsg.MessageId,
+		SentAt:     time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Error("failed to track delivery of message",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+	}
+}
+
+// WaDeliveryReceived stops waiting for the receipts of the messages, and
+// updates the warnings posted about the ones that took too long
+func WaDeliveryReceived(waMsgIds []string, deliveredAt time.Time) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if cfg.WhatsApp.DeliveryTimeoutMinutes <= 0 {
+		return
+	}
+
+	receipts, err := database.PendingReceiptDeliver(waMsgIds)
+	if err != nil {
+		logger.Error("failed to mark messages as delivered",
+			zap.Strings("msg_ids", waMsgIds),
+			zap.Error(err),
+		)
+		return
+	}
+
+	tracked := make(map[string]bool, len(receipts))
+	for _, receipt := range receipts {
+		tracked[receipt.ID] = true
+		if receipt.WarningMsgId <= 0 {
+			continue
+		}
+
+		_, _, err := state.State.TelegramSender.EditMessageText(
+			fmt.Sprintf("✅ Delivered after %s", deliveredAt.Sub(receipt.SentAt).Round(time.Second)),
+			&gotgbot.EditMessageTextOpts{
+				ChatId:    receipt.TgChatId,
+				MessageId: receipt.WarningMsgId,
+			})
+		if err != nil {
+			logger.Warn("failed to update delivery warning",
+				zap.String("msg_id", receipt.ID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	recentReceipts.Lock()
+	defer recentReceipts.Unlock()
+	now := time.Now()
+	for msgId, receivedAt := range recentReceipts.ids {
+		if now.Sub(receivedAt) > time.Minute {
+			delete(recentReceipts.ids, msgId)
+		}
+	}
+	for _, msgId := range waMsgIds {
+		if !tracked[msgId] {
+			recentReceipts.ids[msgId] = now
+		}
+	}
+}
+
+// WaWarnUndelivered replies to the messages sent from Telegram that haven't
+// been delivered in delivery_timeout_minutes, which is how a WhatsApp session
+// that stopped working without being logged out shows up
+func WaWarnUndelivered() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	timeout := time.Duration(cfg.WhatsApp.DeliveryTimeoutMinutes) * time.Minute
+	receipts, err := database.PendingReceiptGetOverdue(time.Now().UTC().Add(-timeout))
+	if err != nil {
+		logger.Error("failed to get undelivered messages", zap.Error(err))
+		return
+	}
+
+	for _, receipt := range receipts {
+		warning, err := state.State.TelegramSender.SendMessage(receipt.TgChatId,
+			fmt.Sprintf("⚠ Not delivered yet, WhatsApp hasn't confirmed the delivery in %d minutes.\n"+
+				"The phone of the recipient may be offline, or the WhatsApp session of the bridge may have stopped working.",
+				cfg.WhatsApp.DeliveryTimeoutMinutes),
+			&gotgbot.SendMessageOpts{
+				MessageThreadId:          receipt.TgThreadId,
+				ReplyToMessageId:         receipt.TgMsgId,
+				AllowSendingWithoutReply: true,
+			})
+		// Not retried on failure, so a deleted topic doesn't make it try
+		// every minute
+		warningMsgId := int64(-1)
+		if err != nil {
+			logger.Error("failed to warn about undelivered message",
+				zap.String("msg_id", receipt.ID),
+				zap.String("chat_jid", receipt.WaChatId),
+				zap.Error(err),
+			)
+		} else {
+			warningMsgId = warning.MessageId
+		}
+		if err = database.PendingReceiptSetWarning(receipt.ID, warningMsgId); err != nil {
+			logger.Error("failed to save delivery warning", zap.Error(err))
+		}
+	}
+
+	// Messages that are never delivered, like the ones sent to numbers that
+	// aren't on WhatsApp anymore, are not waited for forever
+	if _, err = database.PendingReceiptDeleteBefore(time.Now().UTC().AddDate(0, 0, -7)); err != nil {
+		logger.Error("failed to remove old undelivered messages", zap.Error(err))
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/fakes"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func queueTestDelivery(t *testing.T, chat types.JID, msgId int64, text string) {
+	t.Helper()
+
+	msg, err := json.Marshal(gotgbot.Message{
+		MessageId: msgId,
+		Chat:      gotgbot.Chat{Id: fakes.HarnessTargetChatID},
+		From:      &gotgbot.User{Id: fakes.HarnessOwnerID},
+		Text:      text,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.PendingDeliveryAdd(&database.PendingDelivery{
+		WaChatId:     chat.String(),
+		Context:      msg,
+		MsgToForward: msg,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushQueuedDeliveriesRetries(t *testing.T) {
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := types.NewJID("10000000002", types.DefaultUserServer)
+	queueTestDelivery(t, chat, 1, "First")
+	queueTestDelivery(t, chat, 2, "Second")
+
+	h.WhatsApp.Err = errors.New("not connected")
+	TgFlushQueuedDeliveries()
+	deliveries, err := database.PendingDeliveryGetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Attempts != 1 || deliveries[1].Attempts != 0 {
+		t.Fatalf("queue after the failure is %+v, want both with one attempt on the first", deliveries)
+	}
+
+	h.WhatsApp.Err = nil
+	TgFlushQueuedDeliveries()
+	if deliveries, _ = database.PendingDeliveryGetAll(); len(deliveries) != 0 {
+		t.Errorf("%d deliveries left after sending them", len(deliveries))
+	}
+	if len(h.WhatsApp.Sent) != 2 || h.WhatsApp.Sent[0].Message.GetConversation() != "First" ||
+		h.WhatsApp.Sent[1].Message.GetConversation() != "Second" {
+		t.Errorf("sent %+v, want First and Second in order", h.WhatsApp.Sent)
+	}
+}
+
+func TestFlushQueuedDeliveriesGivesUp(t *testing.T) {
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queueTestDelivery(t, types.NewJID("10000000002", types.DefaultUserServer), 1, "Never")
+
+	h.WhatsApp.Err = errors.New("not connected")
+	for i := 0; i < pendingDeliveryMaxAttempts; i++ {
+		TgFlushQueuedDeliveries()
+	}
+	if deliveries, _ := database.PendingDeliveryGetAll(); len(deliveries) != 0 {
+		t.Errorf("still queued after %d attempts: %+v", pendingDeliveryMaxAttempts, deliveries)
+	}
+}
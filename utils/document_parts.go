@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Parts are named like the ones made for WhatsApp documents, "name.001"
+var documentPartRegex = regexp.MustCompile(`^(.+)\.(\d{3})$`)
+
+type documentPart struct {
+	number int
+	data   []byte
+	msg    *gotgbot.Message
+}
+
+type documentParts struct {
+	fileName    string
+	waChatJID   waTypes.JID
+	caption     string
+	contextInfo *waProto.ContextInfo
+	parts       []documentPart
+	timer       *time.Timer
+}
+
+var (
+	documentPartsLock sync.Mutex
+	documentPartsSets = make(map[string]*documentParts)
+)
+
+// TgQueueDocumentPart holds a document named like a part ("name.001") back
+// until no more parts of it are uploaded for the configured window, then joins
+// them and sends the whole document to WhatsApp. The caption and the context
+// info of the first part are used. Returns false if the document is not a
+// part and should be sent as it is.
+func TgQueueDocumentPart(b *gotgbot.Bot, msg *gotgbot.Message, waChatJID waTypes.JID,
+	data []byte, caption string, contextInfo *waProto.ContextInfo) bool {
+
+	cfg := state.State.Config
+	if !cfg.Telegram.DocumentParts.Enabled || msg.Document == nil {
+		return false
+	}
+
+	match := documentPartRegex.FindStringSubmatch(msg.Document.FileName)
+	if match == nil {
+		return false
+	}
+	number, _ := strconv.Atoi(match[2])
+
+	window := time.Duration(cfg.Telegram.DocumentParts.JoinWindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	key := fmt.Sprintf("%d|%d|%s|%s", msg.Chat.Id, msg.MessageThreadId, waChatJID.String(), match[1])
+
+	documentPartsLock.Lock()
+	defer documentPartsLock.Unlock()
+
+	current, found := documentPartsSets[key]
+	if !found {
+		current = &documentParts{
+			fileName:  match[1],
+			waChatJID: waChatJID,
+		}
+		documentPartsSets[key] = current
+		current.timer = time.AfterFunc(window, func() {
+			documentPartsFlush(b, key, current)
+		})
+	} else {
+		current.timer.Reset(window)
+	}
+
+	if number == 1 || current.contextInfo == nil {
+		current.caption = caption
+		current.contextInfo = contextInfo
+	}
+	current.parts = append(current.parts, documentPart{number: number, data: data, msg: msg})
+	return true
+}
+
+func documentPartsFlush(b *gotgbot.Bot, key string, flushed *documentParts) {
+	documentPartsLock.Lock()
+	if documentPartsSets[key] != flushed {
+		documentPartsLock.Unlock()
+		return
+	}
+	delete(documentPartsSets, key)
+	documentPartsLock.Unlock()
+
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		waClient = state.State.WhatsAppClient
+		waSender = state.State.WhatsAppSender
+	)
+	defer logger.Sync()
+
+	sort.Slice(flushed.parts, func(i, j int) bool {
+		return flushed.parts[i].number < flushed.parts[j].number
+	})
+	firstMsg := flushed.parts[0].msg
+
+	reply := func(text string, buttons *gotgbot.InlineKeyboardMarkup) (*gotgbot.Message, error) {
+		sendOpts := &gotgbot.SendMessageOpts{
+			ReplyToMessageId: firstMsg.MessageId,
+		}
+		if firstMsg.IsTopicMessage {
+			sendOpts.MessageThreadId = firstMsg.MessageThreadId
+		}
+		if buttons != nil {
+			sendOpts.ReplyMarkup = buttons
+		}
+		return b.SendMessage(firstMsg.Chat.Id, text, sendOpts)
+	}
+
+	var (
+		joined  bytes.Buffer
+		missing []string
+	)
+	expected := 1
+	for _, part := range flushed.parts {
+		if part.number < expected {
+			// Uploaded twice
+			continue
+		}
+		for ; expected < part.number; expected++ {
+			missing = append(missing, fmt.Sprintf("%03d", expected))
+		}
+		joined.Write(part.data)
+		expected++
+	}
+	if len(missing) > 0 {
+		reply(fmt.Sprintf("Not sending %s to WhatsApp, parts %v are missing. Upload all the parts again.",
+			html.EscapeString(flushed.fileName), missing), nil)
+		return
+	}
+
+	documentBytes := joined.Bytes()
+	uploadedDocument, err := waSender.Upload(context.Background(), documentBytes, whatsmeow.MediaDocument)
+	if err != nil {
+		logger.Error("failed to upload joined document to WhatsApp",
+			zap.String("file_name", flushed.fileName),
+			zap.Error(err),
+		)
+		reply(fmt.Sprintf("Failed to upload %s to WhatsApp : %s", html.EscapeString(flushed.fileName), html.EscapeString(err.Error())), nil)
+		return
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(flushed.fileName))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	msgToSend := &waProto.Message{
+		DocumentMessage: &waProto.DocumentMessage{
+			Caption:       proto.String(flushed.caption),
+			FileName:      proto.String(flushed.fileName),
+			Title:         proto.String(flushed.fileName),
+			Url:           proto.String(uploadedDocument.URL),
+			DirectPath:    proto.String(uploadedDocument.DirectPath),
+			MediaKey:      uploadedDocument.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSha256: uploadedDocument.FileEncSHA256,
+			FileSha256:    uploadedDocument.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(documentBytes))),
+			ContextInfo:   flushed.contextInfo,
+		},
+	}
+
+	sentMsg, err := waSender.SendMessage(context.Background(), flushed.waChatJID, msgToSend)
+	if err != nil {
+		logger.Error("failed to send joined document to WhatsApp",
+			zap.String("file_name", flushed.fileName),
+			zap.Error(err),
+		)
+		reply(fmt.Sprintf("Failed to send %s to WhatsApp : %s", html.EscapeString(flushed.fileName), html.EscapeString(err.Error())), nil)
+		return
+	}
+	ArchiveMessage(sentMsg.ID, flushed.waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+
+	revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, flushed.waChatJID.String(), false)
+	reply(fmt.Sprintf("Successfully sent %s joined from %d parts (%s)", html.EscapeString(flushed.fileName), len(flushed.parts),
+		HumanizeBytes(int64(len(documentBytes)))), revokeKeyboard)
+
+	err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), flushed.waChatJID.String(),
+		cfg.Telegram.TargetChatID, firstMsg.MessageId, firstMsg.MessageThreadId)
+	if err != nil {
+		logger.Error("failed to add joined document to database",
+			zap.String("msg_id", sentMsg.ID),
+			zap.Error(err),
+		)
+	}
+}
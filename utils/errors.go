@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+type reportedError struct {
+	text      string
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	lastEdit  time.Time
+	chatId    int64
+	messageId int64
+}
+
+type errorReporter struct {
+	lock       sync.Mutex
+	errors     map[string]*reportedError
+	postTimes  []time.Time
+	suppressed int
+}
+
+var reporter = &errorReporter{
+	errors: make(map[string]*reportedError),
+}
+
+// Minimum time between two edits of the occurrence count of an error
+const errorReportEditInterval = time.Minute
+
+// errorStackContext returns the bridge functions which led to the error being
+// reported, innermost first
+func errorStackContext(skip int) string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var lines []string
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "watgbridge/") {
+			fileName := frame.File
+			if idx := strings.LastIndex(fileName, "/"); idx >= 0 {
+				if parent := strings.LastIndex(fileName[:idx], "/"); parent >= 0 {
+					fileName = fileName[parent+1:]
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s (%s:%d)",
+				strings.TrimPrefix(frame.Function, "watgbridge/"), fileName, frame.Line))
+		}
+		if !more || len(lines) == 5 {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *reportedError) format() string {
+	text := r.text
+	if r.count > 1 {
+		text += fmt.Sprintf("\n\n<i>Occurred %d times, first at %s, last at %s</i>", r.count,
+			r.firstSeen.In(state.State.LocalLocation).Format(state.State.Config.TimeFormat),
+			r.lastSeen.In(state.State.LocalLocation).Format(state.State.Config.TimeFormat))
+	}
+	return text
+}
+
+// TgReportError posts an error to the '#Errors' topic. An error identical to
+// one posted recently only increases the occurrence count shown on the earlier
+// post, and no more than the configured number of new errors are posted per
+// minute
+func TgReportError(eMessage string, e error) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+		now    = time.Now()
+	)
+	defer logger.Sync()
+
+	database.ActivityEventAdd(database.ActivityFailure, "", 0)
+	logger.Error("reporting error",
+		zap.String("message", eMessage),
+		zap.Error(e),
+	)
+
+	key := eMessage + "\x00" + e.Error()
+	window := time.Duration(cfg.ErrorReporting.DedupWindowMinutes) * time.Minute
+
+	reporter.lock.Lock()
+	if reported, found := reporter.errors[key]; found && now.Sub(reported.lastSeen) < window {
+		reported.count += 1
+		reported.lastSeen = now
+		if reported.messageId == 0 || now.Sub(reported.lastEdit) < errorReportEditInterval {
+			reporter.lock.Unlock()
+			return
+		}
+		reported.lastEdit = now
+		text, chatId, messageId := reported.format(), reported.chatId, reported.messageId
+		reporter.lock.Unlock()
+
+		tgBot.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+			ChatId:    chatId,
+			MessageId: messageId,
+		})
+		return
+	}
+
+	for errKey, reported := range reporter.errors {
+		if now.Sub(reported.lastSeen) >= window {
+			delete(reporter.errors, errKey)
+		}
+	}
+
+	recentPosts := reporter.postTimes[:0]
+	for _, postTime := range reporter.postTimes {
+		if now.Sub(postTime) < time.Minute {
+			recentPosts = append(recentPosts, postTime)
+		}
+	}
+	reporter.postTimes = recentPosts
+	if cfg.ErrorReporting.MaxPerMinute > 0 && len(reporter.postTimes) >= cfg.ErrorReporting.MaxPerMinute {
+		reporter.suppressed += 1
+		reporter.lock.Unlock()
+		return
+	}
+	reporter.postTimes = append(reporter.postTimes, now)
+
+	reported := &reportedError{
+		text:      fmt.Sprintf("%s:\n\n<code>%s</code>", eMessage, html.EscapeString(e.Error())),
+		count:     1,
+		firstSeen: now,
+		lastSeen:  now,
+		lastEdit:  now,
+	}
+	if stack := errorStackContext(2); stack != "" {
+		reported.text += "\n\n<pre>" + html.EscapeString(stack) + "</pre>"
+	}
+	if reporter.suppressed > 0 {
+		reported.text += fmt.Sprintf("\n\n<i>%d other errors were not posted because of the rate limit</i>", reporter.suppressed)
+		reporter.suppressed = 0
+	}
+	reporter.errors[key] = reported
+	text := reported.text
+	reporter.lock.Unlock()
+
+	// The error might be about creating topics, in which case it goes to the
+	// General topic instead
+	threadId, err := TgGetOrMakeThreadFromWa("#Errors", cfg.Telegram.TargetChatID, "#Errors")
+	if err != nil {
+		threadId = 0
+	}
+
+	sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, text, &gotgbot.SendMessageOpts{
+		MessageThreadId: threadId,
+	})
+	if err != nil {
+		logger.Error("failed to post error to Telegram", zap.Error(err))
+		return
+	}
+
+	reporter.lock.Lock()
+	reported.chatId = sentMsg.Chat.Id
+	reported.messageId = sentMsg.MessageId
+	reporter.lock.Unlock()
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+type ExportedMedia struct {
+	Type     string `json:"type"`
+	Mimetype string `json:"mimetype,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Size     uint64 `json:"size,omitempty"`
+}
+
+type ExportedMessage struct {
+	ID         string         `json:"id"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Sender     string         `json:"sender"`
+	SenderName string         `json:"sender_name"`
+	FromMe     bool           `json:"from_me"`
+	Text       string         `json:"text,omitempty"`
+	Media      *ExportedMedia `json:"media,omitempty"`
+	EditedAt   *time.Time     `json:"edited_at,omitempty"`
+	Revoked    bool           `json:"revoked,omitempty"`
+}
+
+type ExportedChat struct {
+	Chat       string            `json:"chat"`
+	ChatName   string            `json:"chat_name"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Messages   []ExportedMessage `json:"messages"`
+}
+
+// ExportBuildChat converts the archived messages of a chat to the export format
+func ExportBuildChat(chat types.JID, msgs []database.ArchivedMessage) (*ExportedChat, error) {
+	loc := state.State.LocalLocation
+
+	exported := &ExportedChat{
+		Chat:       chat.String(),
+		ChatName:   WaGetChatName(chat),
+		ExportedAt: time.Now().In(loc),
+		Messages:   make([]ExportedMessage, 0, len(msgs)),
+	}
+
+	for _, msg := range msgs {
+		text, fileName, err := ArchiveDecrypt(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		senderName := msg.SenderName
+		if sender, ok := WaParseJID(msg.SenderId); ok && !msg.IsFromMe {
+			senderName = WaGetContactName(sender)
+		}
+
+		exportedMsg := ExportedMessage{
+			ID:         msg.WaMsgId,
+			Timestamp:  msg.Timestamp.In(loc),
+			Sender:     msg.SenderId,
+			SenderName: senderName,
+			FromMe:     msg.IsFromMe,
+			Text:       text,
+			Revoked:    msg.Revoked,
+		}
+		if msg.MediaType != "" {
+			exportedMsg.Media = &ExportedMedia{
+				Type:     msg.MediaType,
+				Mimetype: msg.MediaMimetype,
+				FileName: fileName,
+				Size:     msg.MediaSize,
+			}
+		}
+		if msg.EditedAt.Valid {
+			editedAt := msg.EditedAt.Time.In(loc)
+			exportedMsg.EditedAt = &editedAt
+		}
+
+		exported.Messages = append(exported.Messages, exportedMsg)
+	}
+
+	return exported, nil
+}
+
+func ExportChatJSON(exported *ExportedChat) ([]byte, error) {
+	return json.MarshalIndent(exported, "", "  ")
+}
+
+var exportHTMLTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"humanize": func(size uint64) string { return HumanizeBytes(int64(size)) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.ChatName}}</title>
+<style>
+body { background: #efeae2; font-family: sans-serif; margin: 0; padding: 16px; }
+h1 { font-size: 18px; background: #075e54; color: #fff; margin: -16px -16px 16px; padding: 16px; }
+.msg { max-width: 65%; margin: 4px 0; padding: 6px 10px; border-radius: 8px; background: #fff; clear: both; float: left; white-space: pre-wrap; word-wrap: break-word; }
+.me { background: #d9fdd3; float: right; }
+.sender { font-weight: bold; font-size: 13px; color: #075e54; }
+.media { font-style: italic; color: #555; }
+.meta { font-size: 11px; color: #667781; text-align: right; }
+.revoked { text-decoration: line-through; }
+</style>
+</head>
+<body>
+<h1>{{.ChatName}}<br><small>{{.Chat}} &middot; exported {{.ExportedAt.Format "2006-01-02 15:04"}}</small></h1>
+{{range .Messages}}<div class="msg{{if .FromMe}} me{{end}}{{if .Revoked}} revoked{{end}}">
+<div class="sender">{{.SenderName}}</div>
+{{with .Media}}<div class="media">&lt;{{.Type}}{{if .FileName}}: {{.FileName}}{{end}}{{if .Size}}, {{humanize .Size}}{{end}}&gt;</div>{{end}}
+{{- if .Text}}<div>{{.Text}}</div>{{end}}
+<div class="meta">{{.Timestamp.Format "2006-01-02 15:04:05"}}{{if .EditedAt}} &middot; edited{{end}}{{if .Revoked}} &middot; deleted{{end}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+func ExportChatHTML(exported *ExportedChat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := exportHTMLTemplate.Execute(&buf, exported); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// tgFanOutEnabled reports whether the messages of the chat are copied to any
+// of the fan_out destinations
+func tgFanOutEnabled(waChatId string) bool {
+	jid, ok := WaParseJID(waChatId)
+	if !ok {
+		return false
+	}
+	for _, dest := range state.State.Config.Telegram.FanOut {
+		if slices.Contains(dest.Chats, jid.User) {
+			return true
+		}
+	}
+	return false
+}
+
+// tgFanOutThread returns the topic of the chat in the destination, which is
+// created the first time and again if it was deleted
+func tgFanOutThread(waChatId string, destChatId int64) (int64, error) {
+	threadId, found, err := database.FanOutThreadGet(waChatId, destChatId)
+	if err != nil || found {
+		return threadId, err
+	}
+
+	newForum, err := state.State.TelegramSender.CreateForumTopic(destChatId, TgGetTopicNameForWa(waChatId), &gotgbot.CreateForumTopicOpts{
+		IconColor: TgTopicIconColor(waChatId),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newForum.MessageThreadId, database.FanOutThreadSet(waChatId, destChatId, newForum.MessageThreadId)
+}
+
+// tgFanOutInThread sends to the topic of the chat in the destination, making
+// it again if it was deleted
+func tgFanOutInThread(waChatId string, destChatId int64, singleThread bool, send func(threadId int64) error) error {
+	sendOnce := func() (int64, error) {
+		var threadId int64
+		if !singleThread {
+			var err error
+			if threadId, err = tgFanOutThread(waChatId, destChatId); err != nil {
+				return 0, err
+			}
+		}
+		return threadId, send(threadId)
+	}
+
+	threadId, err := sendOnce()
+	if err != nil && threadId != 0 && TgIsThreadNotFoundError(err) {
+		if err = database.FanOutThreadDrop(waChatId, destChatId); err != nil {
+			return err
+		}
+		_, err = sendOnce()
+	}
+	return err
+}
+
+// tgFanOutReplyTo returns the copy in the destination of the message replied
+// to, zero if there is none
+func tgFanOutReplyTo(msg *gotgbot.Message, destChatId int64) int64 {
+	if replyTo := msg.ReplyToMessage; replyTo != nil && replyTo.ForumTopicCreated == nil {
+		destMsgId, _ := database.FanOutMessageGet(replyTo.MessageId, destChatId)
+		return destMsgId
+	}
+	return 0
+}
+
+func tgFanOutCopy(b state.TelegramAPI, waChatId string, destChatId int64, singleThread bool, msg *gotgbot.Message, silent bool) error {
+	return tgFanOutInThread(waChatId, destChatId, singleThread, func(threadId int64) error {
+		copied, err := b.CopyMessage(destChatId, msg.Chat.Id, msg.MessageId, &gotgbot.CopyMessageOpts{
+			MessageThreadId:          threadId,
+			DisableNotification:      silent,
+			ReplyToMessageId:         tgFanOutReplyTo(msg, destChatId),
+			AllowSendingWithoutReply: true,
+		})
+		if err != nil {
+			return err
+		}
+		return database.FanOutMessageAdd(msg.MessageId, destChatId, copied.MessageId)
+	})
+}
+
+// tgFanOutAlbumMedia rebuilds an album from the files of its messages, which
+// Telegram already has, nil if one of them can't be part of an album
+func tgFanOutAlbumMedia(msgs []gotgbot.Message) []gotgbot.InputMedia {
+	media := make([]gotgbot.InputMedia, 0, len(msgs))
+	for _, msg := range msgs {
+		switch {
+		case len(msg.Photo) > 0:
+			media = append(media, gotgbot.InputMediaPhoto{
+				Media:           msg.Photo[len(msg.Photo)-1].FileId,
+				Caption:         msg.Caption,
+				CaptionEntities: msg.CaptionEntities,
+				HasSpoiler:      msg.HasMediaSpoiler,
+			})
+		case msg.Video != nil:
+			media = append(media, gotgbot.InputMediaVideo{
+				Media:           msg.Video.FileId,
+				Caption:         msg.Caption,
+				CaptionEntities: msg.CaptionEntities,
+				HasSpoiler:      msg.HasMediaSpoiler,
+			})
+		case msg.Document != nil:
+			media = append(media, gotgbot.InputMediaDocument{
+				Media:           msg.Document.FileId,
+				Caption:         msg.Caption,
+				CaptionEntities: msg.CaptionEntities,
+			})
+		case msg.Audio != nil:
+			media = append(media, gotgbot.InputMediaAudio{
+				Media:           msg.Audio.FileId,
+				Caption:         msg.Caption,
+				CaptionEntities: msg.CaptionEntities,
+			})
+		default:
+			return nil
+		}
+	}
+	return media
+}
+
+func tgFanOutSendAlbum(b state.TelegramAPI, waChatId string, destChatId int64, singleThread bool, msgs []gotgbot.Message, media []gotgbot.InputMedia, silent bool) error {
+	return tgFanOutInThread(waChatId, destChatId, singleThread, func(threadId int64) error {
+		copied, err := b.SendMediaGroup(destChatId, media, &gotgbot.SendMediaGroupOpts{
+			MessageThreadId:          threadId,
+			DisableNotification:      silent,
+			ReplyToMessageId:         tgFanOutReplyTo(&msgs[0], destChatId),
+			AllowSendingWithoutReply: true,
+		})
+		if err != nil {
+			return err
+		}
+		for idx := range copied {
+			if idx >= len(msgs) {
+				break
+			}
+			if err = database.FanOutMessageAdd(msgs[idx].MessageId, destChatId, copied[idx].MessageId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tgFanOutEach runs do for every fan_out destination of the WhatsApp chat,
+// logging failure if it fails
+func tgFanOutEach(waChatId string, msgId int64, failure string, do func(destChatId int64, singleThread bool) error) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	jid, ok := WaParseJID(waChatId)
+	if !ok {
+		return
+	}
+
+	for _, dest := range cfg.Telegram.FanOut {
+		if dest.ChatID == 0 || dest.ChatID == cfg.Telegram.TargetChatID || !slices.Contains(dest.Chats, jid.User) {
+			continue
+		}
+		if err := do(dest.ChatID, dest.SingleThread); err != nil {
+			logger.Warn(failure,
+				zap.String("chat_jid", waChatId),
+				zap.Int64("dest_chat_id", dest.ChatID),
+				zap.Int64("msg_id", msgId),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// tgFanOut copies a message bridged to the target chat to the fan_out
+// destinations of its WhatsApp chat
+func tgFanOut(b state.TelegramAPI, waChatId string, msg *gotgbot.Message, silent bool) {
+	if msg == nil || msg.Chat.Id != state.State.Config.Telegram.TargetChatID {
+		return
+	}
+	tgFanOutEach(waChatId, msg.MessageId, "failed to copy message to fan out destination", func(destChatId int64, singleThread bool) error {
+		return tgFanOutCopy(b, waChatId, destChatId, singleThread, msg, silent)
+	})
+}
+
+// tgFanOutAlbum sends an album bridged to the target chat to the fan_out
+// destinations of its WhatsApp chat as one album again, falling back to
+// copying its messages one by one
+func tgFanOutAlbum(b state.TelegramAPI, waChatId string, msgs []gotgbot.Message, silent bool) {
+	if len(msgs) == 0 || msgs[0].Chat.Id != state.State.Config.Telegram.TargetChatID {
+		return
+	}
+	media := tgFanOutAlbumMedia(msgs)
+	if len(msgs) == 1 || media == nil {
+		for idx := range msgs {
+			tgFanOut(b, waChatId, &msgs[idx], silent)
+		}
+		return
+	}
+	tgFanOutEach(waChatId, msgs[0].MessageId, "failed to copy album to fan out destination", func(destChatId int64, singleThread bool) error {
+		return tgFanOutSendAlbum(b, waChatId, destChatId, singleThread, msgs, media, silent)
+	})
+}
+
+// tgFanOutEdit applies an edit of a message of the target chat to its copies
+// in the fan_out destinations of its WhatsApp chat
+func tgFanOutEdit(waChatId string, chatId, msgId int64, edit func(destChatId, destMsgId int64) error) {
+	if chatId != state.State.Config.Telegram.TargetChatID {
+		return
+	}
+	tgFanOutEach(waChatId, msgId, "failed to edit message in fan out destination", func(destChatId int64, _ bool) error {
+		destMsgId, err := database.FanOutMessageGet(msgId, destChatId)
+		if err != nil || destMsgId == 0 {
+			return err
+		}
+		return edit(destChatId, destMsgId)
+	})
+}
+
+// tgFanOutSender copies everything it sends to the target chat to the
+// fan_out destinations of the WhatsApp chat, and edits the copies along with
+// the messages
+type tgFanOutSender struct {
+	state.TelegramAPI
+	waChatId string
+	silent   bool
+}
+
+func (s tgFanOutSender) fanOut(msg *gotgbot.Message, err error) (*gotgbot.Message, error) {
+	if err == nil {
+		tgFanOut(s.TelegramAPI, s.waChatId, msg, s.silent)
+	}
+	return msg, err
+}
+
+func (s tgFanOutSender) SendMessage(chatId int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendMessage(chatId, text, opts))
+}
+
+func (s tgFanOutSender) SendPhoto(chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendPhoto(chatId, photo, opts))
+}
+
+func (s tgFanOutSender) SendMediaGroup(chatId int64, media []gotgbot.InputMedia, opts *gotgbot.SendMediaGroupOpts) ([]gotgbot.Message, error) {
+	msgs, err := s.TelegramAPI.SendMediaGroup(chatId, media, opts)
+	if err == nil {
+		tgFanOutAlbum(s.TelegramAPI, s.waChatId, msgs, s.silent)
+	}
+	return msgs, err
+}
+
+func (s tgFanOutSender) SendVideo(chatId int64, video gotgbot.InputFile, opts *gotgbot.SendVideoOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendVideo(chatId, video, opts))
+}
+
+func (s tgFanOutSender) SendVideoNote(chatId int64, videoNote gotgbot.InputFile, opts *gotgbot.SendVideoNoteOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendVideoNote(chatId, videoNote, opts))
+}
+
+func (s tgFanOutSender) SendAnimation(chatId int64, animation gotgbot.InputFile, opts *gotgbot.SendAnimationOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendAnimation(chatId, animation, opts))
+}
+
+func (s tgFanOutSender) SendAudio(chatId int64, audio gotgbot.InputFile, opts *gotgbot.SendAudioOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendAudio(chatId, audio, opts))
+}
+
+func (s tgFanOutSender) SendDocument(chatId int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendDocument(chatId, document, opts))
+}
+
+func (s tgFanOutSender) SendSticker(chatId int64, sticker gotgbot.InputFile, opts *gotgbot.SendStickerOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendSticker(chatId, sticker, opts))
+}
+
+func (s tgFanOutSender) SendContact(chatId int64, phoneNumber string, firstName string, opts *gotgbot.SendContactOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendContact(chatId, phoneNumber, firstName, opts))
+}
+
+func (s tgFanOutSender) SendLocation(chatId int64, latitude float64, longitude float64, opts *gotgbot.SendLocationOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendLocation(chatId, latitude, longitude, opts))
+}
+
+func (s tgFanOutSender) SendPoll(chatId int64, question string, options []string, opts *gotgbot.SendPollOpts) (*gotgbot.Message, error) {
+	return s.fanOut(s.TelegramAPI.SendPoll(chatId, question, options, opts))
+}
+
+func (s tgFanOutSender) EditMessageText(text string, opts *gotgbot.EditMessageTextOpts) (*gotgbot.Message, bool, error) {
+	msg, ok, err := s.TelegramAPI.EditMessageText(text, opts)
+	if err == nil && opts != nil {
+		tgFanOutEdit(s.waChatId, opts.ChatId, opts.MessageId, func(destChatId, destMsgId int64) error {
+			editOpts := *opts
+			editOpts.ChatId, editOpts.MessageId = destChatId, destMsgId
+			_, _, err := s.TelegramAPI.EditMessageText(text, &editOpts)
+			return err
+		})
+	}
+	return msg, ok, err
+}
+
+func (s tgFanOutSender) EditMessageCaption(opts *gotgbot.EditMessageCaptionOpts) (*gotgbot.Message, bool, error) {
+	msg, ok, err := s.TelegramAPI.EditMessageCaption(opts)
+	if err == nil && opts != nil {
+		tgFanOutEdit(s.waChatId, opts.ChatId, opts.MessageId, func(destChatId, destMsgId int64) error {
+			editOpts := *opts
+			editOpts.ChatId, editOpts.MessageId = destChatId, destMsgId
+			_, _, err := s.TelegramAPI.EditMessageCaption(&editOpts)
+			return err
+		})
+	}
+	return msg, ok, err
+}
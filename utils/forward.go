@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// ForwardableStore keeps the content of a message received from WhatsApp so
+// that it can be forwarded to another chat with /wa_forward later
+func ForwardableStore(waMsgId string, chat types.JID, msg *waProto.Message) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if cfg.WhatsApp.ForwardableMessagesDays <= 0 {
+		return
+	}
+
+	serialized, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Error("failed to serialize message for forwarding",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// The content is kept as private as the message archive
+	serialized, encrypted, err := archiveEncrypt(serialized)
+	if err != nil {
+		logger.Error("failed to encrypt message for forwarding",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if err = database.ForwardableMessageAdd(waMsgId, chat.String(), serialized, encrypted); err != nil {
+		logger.Error("failed to store message for forwarding",
+			zap.String("msg_id", waMsgId),
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// ForwardableGet returns the stored content of a message received from
+// WhatsApp, if it is still stored
+func ForwardableGet(waMsgId string, chat types.JID) (*waProto.Message, bool, error) {
+	forwardable, found, err := database.ForwardableMessageGet(waMsgId, chat.String())
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	serialized, err := archiveDecrypt(forwardable.Message, forwardable.Encrypted)
+	if err != nil {
+		return nil, true, err
+	}
+	var msg waProto.Message
+	if err = proto.Unmarshal(serialized, &msg); err != nil {
+		return nil, true, err
+	}
+	return &msg, true, nil
+}
+
+// ForwardablesCleanup deletes the stored messages which can no longer be
+// forwarded
+func ForwardablesCleanup() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if cfg.WhatsApp.ForwardableMessagesDays <= 0 {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -cfg.WhatsApp.ForwardableMessagesDays)
+	deleted, err := database.ForwardableMessageDeleteOlderThan(cutoff)
+	if err != nil {
+		logger.Error("failed to delete old forwardable messages", zap.Error(err))
+		return
+	}
+	logger.Debug("deleted old forwardable messages", zap.Int64("count", deleted))
+}
+
+// WaForwardMessage sends a copy of a message received from the source chat to
+// the target chat, marked as forwarded. Media is downloaded and uploaded again
+// as WhatsApp ties the uploaded files to the chats they were sent in.
+func WaForwardMessage(source, target types.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	waClient := state.State.WhatsAppClient
+	waSender := state.State.WhatsAppSender
+
+	if msg.GetViewOnceMessage() != nil || msg.GetViewOnceMessageV2() != nil || msg.GetViewOnceMessageV2Extension() != nil {
+		return whatsmeow.SendResponse{}, fmt.Errorf("view once messages cannot be forwarded")
+	}
+	msg = proto.Clone(WaUnwrapMessage(msg)).(*waProto.Message)
+
+	contextInfo := &waProto.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(WaGetContextInfo(msg).GetForwardingScore() + 1),
+	}
+
+	msgToSend := &waProto.Message{}
+	switch {
+	case msg.GetConversation() != "":
+		msgToSend.ExtendedTextMessage = &waProto.ExtendedTextMessage{
+			Text:        proto.String(msg.GetConversation()),
+			ContextInfo: contextInfo,
+		}
+
+	case msg.GetExtendedTextMessage() != nil:
+		msgToSend.ExtendedTextMessage = msg.GetExtendedTextMessage()
+		msgToSend.ExtendedTextMessage.ContextInfo = contextInfo
+
+	case msg.GetImageMessage() != nil:
+		m := msg.GetImageMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaImage)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.ImageMessage = m
+
+	case msg.GetVideoMessage() != nil:
+		m := msg.GetVideoMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaVideo)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.VideoMessage = m
+
+	case msg.GetPtvMessage() != nil:
+		m := msg.GetPtvMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaVideo)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.PtvMessage = m
+
+	case msg.GetAudioMessage() != nil:
+		m := msg.GetAudioMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaAudio)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.AudioMessage = m
+
+	case msg.GetDocumentMessage() != nil:
+		m := msg.GetDocumentMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaDocument)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.DocumentMessage = m
+
+	case msg.GetStickerMessage() != nil:
+		m := msg.GetStickerMessage()
+		uploaded, err := waReuploadMedia(source, m, whatsmeow.MediaImage)
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+		m.Url, m.DirectPath, m.MediaKey = proto.String(uploaded.URL), proto.String(uploaded.DirectPath), uploaded.MediaKey
+		m.FileEncSha256, m.FileSha256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, proto.Uint64(uploaded.FileLength)
+		m.ContextInfo = contextInfo
+		msgToSend.StickerMessage = m
+
+	case msg.GetLocationMessage() != nil:
+		msgToSend.LocationMessage = msg.GetLocationMessage()
+		msgToSend.LocationMessage.ContextInfo = contextInfo
+
+	case msg.GetContactMessage() != nil:
+		msgToSend.ContactMessage = msg.GetContactMessage()
+		msgToSend.ContactMessage.ContextInfo = contextInfo
+
+	case msg.GetContactsArrayMessage() != nil:
+		msgToSend.ContactsArrayMessage = msg.GetContactsArrayMessage()
+		msgToSend.ContactsArrayMessage.ContextInfo = contextInfo
+
+	default:
+		return whatsmeow.SendResponse{}, fmt.Errorf("this kind of message cannot be forwarded")
+	}
+
+	sentMsg, err := waSender.SendMessage(context.Background(), target, msgToSend)
+	if err != nil {
+		return sentMsg, err
+	}
+	ArchiveMessage(sentMsg.ID, target, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+	return sentMsg, nil
+}
+
+func waReuploadMedia(source types.JID, media whatsmeow.DownloadableMessage, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	data, err := WaDownloadMedia(source, media)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("failed to download media : %s", err)
+	}
+
+	uploaded, err := state.State.WhatsAppSender.Upload(context.Background(), data, mediaType)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("failed to upload media : %s", err)
+	}
+	return uploaded, nil
+}
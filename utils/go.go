@@ -1,5 +1,13 @@
 package utils
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+)
+
 func SubString(s string, start, length int) string {
 	asRunes := []rune(s)
 
@@ -11,5 +19,132 @@ func SubString(s string, start, length int) string {
 		length = len(asRunes) - start
 	}
 
-	return string(s[start : start+length])
+	return string(asRunes[start : start+length])
+}
+
+// TimeIsInWindow reports whether the clock time of t lies within the window
+// given as "HH:MM" start and end values, wrapping around midnight if the end
+// is before the start. An empty window always matches.
+func TimeIsInWindow(t time.Time, start, end string) (bool, error) {
+	if strings.TrimSpace(start) == "" || strings.TrimSpace(end) == "" {
+		return true, nil
+	}
+
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return false, fmt.Errorf("invalid window start '%s' : %s", start, err)
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return false, fmt.Errorf("invalid window end '%s' : %s", end, err)
+	}
+
+	var (
+		current   = t.Hour()*60 + t.Minute()
+		startMins = startTime.Hour()*60 + startTime.Minute()
+		endMins   = endTime.Hour()*60 + endTime.Minute()
+	)
+
+	if startMins <= endMins {
+		return current >= startMins && current < endMins, nil
+	}
+	return current >= startMins || current < endMins, nil
+}
+
+// CronInLocalTime makes the cron expression run in the configured time zone,
+// the scheduler itself runs in UTC
+func CronInLocalTime(cron string) string {
+	if strings.HasPrefix(cron, "TZ=") || strings.HasPrefix(cron, "CRON_TZ=") {
+		return cron
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", state.State.LocalLocation.String(), cron)
+}
+
+// CronDailyAt returns the cron expression of a job run every day at the
+// "HH:MM" time in the configured time zone
+func CronDailyAt(at string) (string, error) {
+	atTime, err := time.Parse("15:04", strings.TrimSpace(at))
+	if err != nil {
+		return "", fmt.Errorf("invalid time '%s' : %s", at, err)
+	}
+	return CronInLocalTime(fmt.Sprintf("%d %d * * *", atTime.Minute(), atTime.Hour())), nil
+}
+
+// SplitText splits the text into chunks of at most limit characters, breaking
+// at a newline or space in the latter half of the chunk where possible
+func SplitText(text string, limit int) []string {
+	asRunes := []rune(text)
+	if limit <= 0 || len(asRunes) <= limit {
+		return []string{text}
+	}
+
+	var chunks []string
+	for len(asRunes) > limit {
+		cut := limit
+		if idx := lastRuneIndex(asRunes[limit/2:limit], "\n"); idx >= 0 {
+			cut = limit/2 + idx + 1
+		} else if idx := lastRuneIndex(asRunes[limit/2:limit], " \t"); idx >= 0 {
+			cut = limit/2 + idx + 1
+		}
+
+		chunks = append(chunks, strings.TrimRight(string(asRunes[:cut]), " \t\n"))
+		asRunes = asRunes[cut:]
+	}
+	if rest := string(asRunes); strings.TrimSpace(rest) != "" {
+		chunks = append(chunks, rest)
+	}
+
+	return chunks
+}
+
+func lastRuneIndex(runes []rune, chars string) int {
+	for idx := len(runes) - 1; idx >= 0; idx-- {
+		if strings.ContainsRune(chars, runes[idx]) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// TimeRelative describes how long ago a duration is in a compact form like
+// "5 min ago" or "2 h ago"
+func TimeRelative(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d h ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d d ago", int(d.Hours()/24))
+	}
+}
+
+func HumanizeBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp += 1
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// SplitBytes splits the data into chunks of at most size bytes
+func SplitBytes(data []byte, size int) [][]byte {
+	if size <= 0 || len(data) <= size {
+		return [][]byte{data}
+	}
+
+	chunks := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
 }
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+// DefaultHeaderTemplate renders the same header the bridge always used, it is
+// used when no header_template is configured
+const DefaultHeaderTemplate = `{{if .IsContinuation}}` +
+	`{{else if .SkipChatDetails}}` +
+	`{{if .IsBroadcast}}<b>#Broadcast</b>
+{{else if .IsFromMe}}<b>{{.Sender}}</b>
+{{else if .IsGroup}}<b>{{.Sender}}</b>
+{{end}}` +
+	`{{else}}<b>{{.Sender}}</b>
+<b>{{.Chat}}</b>
+{{end}}` +
+	`{{if .IsEdited}}<b>Edited</b>
+{{end}}` +
+	`{{if .IsBackfilled}}<b>Backfilled</b>
+{{end}}` +
+	`{{if .IsDelayed}}<b>{{.Time}}</b>
+{{end}}` +
+	`{{if .IsForwarded}}<b>Forwarded ({{.ForwardingScore}})</b>
+{{end}}`
+
+// BridgeHeader holds what is known about a WhatsApp message for rendering the
+// header of its bridged Telegram message. The values are escaped by the
+// template, so they are plain text here.
+type BridgeHeader struct {
+	Sender          string // Name of the sender, my_messages_label for your own messages
+	SenderNumber    string
+	Chat            string // Name of the group (empty with SkipChatDetails), or one of #Private, #Channel and #Broadcast
+	Time            string // Time the message was sent at, as configured in time_header
+	LocalTime       string // Time in time_format and time_zone
+	RelativeTime    string // Time like "2 h ago"
+	IsFromMe        bool
+	IsGroup         bool
+	IsChannel       bool
+	IsBroadcast     bool
+	IsPrivate       bool
+	IsEdited        bool
+	IsBackfilled    bool
+	IsDelayed       bool // The message arrived later than the delay threshold after it was sent
+	IsForwarded     bool
+	ForwardingScore uint32
+	SkipChatDetails bool
+	IsContinuation  bool // The sender also sent the previous message of the topic, see HeaderIsContinuation
+}
+
+// SetTime fills the time fields of the header for when the message was sent
+func (header *BridgeHeader) SetTime(sentAt time.Time) {
+	var (
+		cfg   = state.State.Config
+		delay = time.Since(sentAt)
+	)
+
+	header.LocalTime = sentAt.In(state.State.LocalLocation).Format(cfg.TimeFormat)
+	header.RelativeTime = TimeRelative(delay)
+	header.IsDelayed = delay.Seconds() > float64(cfg.TimeHeader.DelayThresholdSeconds)
+
+	times := []string{header.LocalTime}
+	if cfg.TimeHeader.Relative {
+		times[0] = header.RelativeTime
+	}
+	for _, loc := range state.State.ExtraLocations {
+		times = append(times, fmt.Sprintf("%s (%s)", sentAt.In(loc).Format(cfg.TimeFormat), loc.String()))
+	}
+	header.Time = strings.Join(times, " | ")
+}
+
+var (
+	headerTemplateLock   sync.Mutex
+	headerTemplateSource string
+	headerTemplate       *template.Template
+)
+
+func headerGetTemplate() (*template.Template, error) {
+	source := state.State.Config.Telegram.HeaderTemplate
+	if source == "" {
+		source = DefaultHeaderTemplate
+	}
+
+	headerTemplateLock.Lock()
+	defer headerTemplateLock.Unlock()
+
+	if headerTemplate != nil && headerTemplateSource == source {
+		return headerTemplate, nil
+	}
+
+	tmpl, err := template.New("header").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	headerTemplate, headerTemplateSource = tmpl, source
+	return tmpl, nil
+}
+
+// RenderBridgeHeader executes the configured header template, falling back to
+// the default one if it is invalid
+func RenderBridgeHeader(header BridgeHeader) string {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var rendered strings.Builder
+
+	tmpl, err := headerGetTemplate()
+	if err == nil {
+		err = tmpl.Execute(&rendered, header)
+	}
+	if err != nil {
+		logger.Error("failed to render header template, using the default one",
+			zap.Error(err),
+		)
+		rendered.Reset()
+		template.Must(template.New("header").Parse(DefaultHeaderTemplate)).Execute(&rendered, header)
+	}
+
+	return rendered.String()
+}
+
+type headerLastMessage struct {
+	chat   string
+	sender string
+	sentAt time.Time
+}
+
+type headerDestination struct {
+	tgChatId   int64
+	tgThreadId int64
+}
+
+var (
+	headerLastMessagesLock sync.Mutex
+	headerLastMessages     = make(map[headerDestination]headerLastMessage)
+)
+
+// HeaderIsContinuation reports whether the sender also sent the previous
+// message bridged to the Telegram topic from the same chat, within
+// collapse_headers_seconds before this one, so that its header can leave out
+// who sent it. The message is remembered for the next one. Only templates
+// which check .IsContinuation leave anything out, as the default one does.
+func HeaderIsContinuation(tgChatId, tgThreadId int64, chat, sender string, sentAt time.Time) bool {
+	window := time.Duration(state.State.Config.Telegram.CollapseHeadersSeconds) * time.Second
+	if window <= 0 {
+		return false
+	}
+
+	headerLastMessagesLock.Lock()
+	defer headerLastMessagesLock.Unlock()
+
+	key := headerDestination{tgChatId, tgThreadId}
+	last, found := headerLastMessages[key]
+	headerLastMessages[key] = headerLastMessage{chat, sender, sentAt}
+
+	return found && last.chat == chat && last.sender == sender &&
+		sentAt.Sub(last.sentAt) <= window && !sentAt.Before(last.sentAt)
+}
+
+// HeaderBreakContinuation makes the next message bridged to the Telegram topic
+// get a full header, as something else was posted to it in between
+func HeaderBreakContinuation(tgChatId, tgThreadId int64) {
+	headerLastMessagesLock.Lock()
+	defer headerLastMessagesLock.Unlock()
+
+	delete(headerLastMessages, headerDestination{tgChatId, tgThreadId})
+}
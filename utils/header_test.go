@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"watgbridge/fakes"
+	"watgbridge/state"
+)
+
+func TestHeaderIsContinuation(t *testing.T) {
+	if _, err := fakes.NewHarness(); err != nil {
+		t.Fatal(err)
+	}
+	state.State.Config.Telegram.CollapseHeadersSeconds = 60
+
+	var (
+		target = fakes.HarnessTargetChatID
+		now    = time.Now()
+	)
+	for _, tc := range []struct {
+		name     string
+		threadId int64
+		chat     string
+		sender   string
+		sentAt   time.Time
+		breaks   bool
+		want     bool
+	}{
+		{"first", 7, "chat1", "alice", now, false, false},
+		{"same_sender", 7, "chat1", "alice", now.Add(time.Second), false, true},
+		{"other_topic", 8, "chat1", "alice", now.Add(2 * time.Second), false, false},
+		{"back_to_topic", 7, "chat1", "alice", now.Add(3 * time.Second), false, true},
+		{"other_sender", 7, "chat1", "bob", now.Add(4 * time.Second), false, false},
+		{"other_chat_same_topic", 7, "chat2", "bob", now.Add(5 * time.Second), false, false},
+		{"after_window", 7, "chat2", "bob", now.Add(2 * time.Minute), false, false},
+		{"after_break", 7, "chat2", "bob", now.Add(2*time.Minute + time.Second), true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.breaks {
+				HeaderBreakContinuation(target, tc.threadId)
+			}
+			if got := HeaderIsContinuation(target, tc.threadId, tc.chat, tc.sender, tc.sentAt); got != tc.want {
+				t.Errorf("HeaderIsContinuation() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+type HealthStatus struct {
+	Healthy            bool    `json:"healthy"`
+	WhatsAppConnected  bool    `json:"whatsapp_connected"`
+	WhatsAppLoggedIn   bool    `json:"whatsapp_logged_in"`
+	TelegramReachable  bool    `json:"telegram_reachable"`
+	DatabaseWritable   bool    `json:"database_writable"`
+	OldestEventSeconds int64   `json:"oldest_event_seconds"`
+	DatabaseLatencyMs  float64 `json:"database_latency_ms"`
+	DatabasePending    int     `json:"database_pending_writes"`
+	Error              string  `json:"error,omitempty"`
+}
+
+func HealthCheck() HealthStatus {
+	var (
+		waClient = state.State.WhatsAppClient
+		tgBot    = state.State.TelegramBot
+		status   HealthStatus
+		errs     []string
+	)
+
+	if waClient != nil {
+		status.WhatsAppConnected = waClient.IsConnected()
+		status.WhatsAppLoggedIn = waClient.IsLoggedIn()
+	}
+
+	if tgBot != nil {
+		if _, err := tgBot.GetMe(nil); err == nil {
+			status.TelegramReachable = true
+		} else {
+			errs = append(errs, "telegram: "+err.Error())
+		}
+	}
+
+	if err := database.HealthCheckWrite(time.Now().UTC()); err == nil {
+		status.DatabaseWritable = true
+	} else {
+		errs = append(errs, "database: "+err.Error())
+	}
+
+	status.OldestEventSeconds = int64(LagGetReport().OldestEventAge.Seconds())
+	dbLatency := database.LatencyGetReport()
+	status.DatabaseLatencyMs = float64(dbLatency.RecentAverage.Microseconds()) / 1000
+	status.DatabasePending = dbLatency.PendingWrites
+	status.Healthy = status.WhatsAppConnected && status.WhatsAppLoggedIn &&
+		status.TelegramReachable && status.DatabaseWritable
+	if len(errs) > 0 {
+		status.Error = fmt.Sprint(errs)
+	}
+
+	return status
+}
+
+// HealthServe starts the HTTP server answering on /healthz, if an address to
+// listen on is configured
+func HealthServe() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+
+	if cfg.Health.ListenAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := HealthCheck()
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	})
+
+	go func() {
+		err := http.ListenAndServe(cfg.Health.ListenAddress, mux)
+		logger.Error("health check server stopped",
+			zap.String("address", cfg.Health.ListenAddress),
+			zap.Error(err),
+		)
+		_ = logger.Sync()
+	}()
+}
+
+// healthKeepAliveFailingSince is the unix time in nanoseconds of the first
+// keepalive ping WhatsApp did not answer, 0 while they are answered
+var healthKeepAliveFailingSince atomic.Int64
+
+// HealthRecordKeepAlive records whether WhatsApp answers the keepalive pings,
+// the connection counts as stalled if it stops answering for too long
+func HealthRecordKeepAlive(answered bool) {
+	if answered {
+		healthKeepAliveFailingSince.Store(0)
+	} else {
+		healthKeepAliveFailingSince.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+// healthWatchdogMaxBackoff caps the wait between two reconnect attempts
+const healthWatchdogMaxBackoff = 30 * time.Minute
+
+var watchdog struct {
+	lock        sync.Mutex
+	downSince   time.Time
+	attempts    int
+	nextAttempt time.Time
+}
+
+// HealthWatchdog reconnects to WhatsApp if the socket got disconnected or it
+// stopped answering the keepalive pings for too long, waiting longer after
+// every failed attempt, and posts to the '#System' topic once the connection
+// is back
+func HealthWatchdog() {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		waClient = state.State.WhatsAppClient
+	)
+	defer logger.Sync()
+
+	watchdog.lock.Lock()
+	defer watchdog.lock.Unlock()
+
+	stalled := false
+	if failingSince := healthKeepAliveFailingSince.Load(); failingSince != 0 && cfg.Health.StallTimeoutSeconds > 0 {
+		stalled = time.Since(time.Unix(0, failingSince)) > time.Duration(cfg.Health.StallTimeoutSeconds)*time.Second
+	}
+
+	if waClient.IsConnected() && !stalled {
+		if !watchdog.downSince.IsZero() {
+			downtime := time.Since(watchdog.downSince).Round(time.Second)
+			watchdog.downSince, watchdog.attempts, watchdog.nextAttempt = time.Time{}, 0, time.Time{}
+			logger.Info("whatsapp connection recovered",
+				zap.Duration("downtime", downtime),
+			)
+			TgSendSystemNotice(fmt.Sprintf("WhatsApp connection is back after %s", downtime))
+		}
+		return
+	}
+
+	// Leave whatsmeow's own reconnect logic a chance on the first check
+	if watchdog.downSince.IsZero() {
+		watchdog.downSince = time.Now()
+		TgSendSystemNotice(fmt.Sprintf("WhatsApp connection lost (stalled: %t), trying to reconnect", stalled))
+		return
+	}
+
+	if time.Now().Before(watchdog.nextAttempt) {
+		return
+	}
+	watchdog.attempts += 1
+	backoff := time.Duration(cfg.Health.WatchdogIntervalSeconds) * time.Second << min(watchdog.attempts-1, 6)
+	watchdog.nextAttempt = time.Now().Add(min(backoff, healthWatchdogMaxBackoff))
+
+	logger.Warn("watchdog reconnecting to whatsapp",
+		zap.Bool("connected", waClient.IsConnected()),
+		zap.Bool("stalled", stalled),
+		zap.Int("attempt", watchdog.attempts),
+	)
+
+	waClient.Disconnect()
+	HealthRecordKeepAlive(true)
+	if err := waClient.Connect(); err != nil {
+		logger.Error("watchdog failed to reconnect to whatsapp",
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+type ignoreRules struct {
+	senders   []*regexp.Regexp
+	chats     []*regexp.Regexp
+	texts     []*regexp.Regexp
+	onlyChats []*regexp.Regexp
+}
+
+var (
+	ignoreRulesOnce     sync.Once
+	ignoreRulesCompiled ignoreRules
+
+	onlyChatsPlainRegex = regexp.MustCompile(`^[0-9A-Za-z.:_-]+(@[0-9A-Za-z.]+)?$`)
+)
+
+func compileIgnorePatterns(kind string, patterns []string) []*regexp.Regexp {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("skipping invalid ignore rule",
+				zap.String("kind", kind),
+				zap.String("pattern", pattern),
+				zap.Error(err),
+			)
+			continue
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled
+}
+
+func loadIgnoreRules() {
+	cfg := state.State.Config
+
+	ignoreRulesCompiled = ignoreRules{
+		senders: compileIgnorePatterns("sender", cfg.WhatsApp.IgnoreRules.SenderPatterns),
+		chats:   compileIgnorePatterns("chat", cfg.WhatsApp.IgnoreRules.ChatPatterns),
+		texts:   compileIgnorePatterns("text", cfg.WhatsApp.IgnoreRules.TextPatterns),
+	}
+
+	// Plain numbers and JIDs are matched exactly, anything else as a pattern
+	for _, chat := range cfg.WhatsApp.OnlyChats {
+		if !onlyChatsPlainRegex.MatchString(chat) {
+			ignoreRulesCompiled.onlyChats = append(ignoreRulesCompiled.onlyChats,
+				compileIgnorePatterns("only_chats", []string{chat})...)
+		}
+	}
+}
+
+func matchAny(regexes []*regexp.Regexp, s string) (string, bool) {
+	for _, regex := range regexes {
+		if regex.MatchString(s) {
+			return regex.String(), true
+		}
+	}
+	return "", false
+}
+
+func waGetForwardingScore(msg *waProto.Message) uint32 {
+	return WaGetContextInfo(msg).GetForwardingScore()
+}
+
+// WaChatIsBridged reports whether the chat is bridged at all, which is only
+// the case for the chats in only_chats if any are listed
+func WaChatIsBridged(chat types.JID) bool {
+	cfg := state.State.Config
+	if len(cfg.WhatsApp.OnlyChats) == 0 {
+		return true
+	}
+
+	if slices.Contains(cfg.WhatsApp.OnlyChats, chat.User) || slices.Contains(cfg.WhatsApp.OnlyChats, chat.String()) {
+		return true
+	}
+
+	ignoreRulesOnce.Do(loadIgnoreRules)
+	_, matched := matchAny(ignoreRulesCompiled.onlyChats, chat.String())
+	return matched
+}
+
+// WaMessageIsIgnored checks the message against the ignore rules from the
+// config file and returns the reason if it should not be bridged
+func WaMessageIsIgnored(v *events.Message, text string) (bool, string) {
+	cfg := state.State.Config
+	ignoreRulesOnce.Do(loadIgnoreRules)
+
+	if pattern, ok := matchAny(ignoreRulesCompiled.senders, v.Info.MessageSource.Sender.ToNonAD().User); ok {
+		return true, fmt.Sprintf("sender pattern '%s'", pattern)
+	}
+	if pattern, ok := matchAny(ignoreRulesCompiled.chats, v.Info.Chat.String()); ok {
+		return true, fmt.Sprintf("chat pattern '%s'", pattern)
+	}
+	if text == "" {
+		text = WaGetMessageText(v.Message)
+	}
+	if pattern, ok := matchAny(ignoreRulesCompiled.texts, text); ok {
+		return true, fmt.Sprintf("text pattern '%s'", pattern)
+	}
+	if maxScore := cfg.WhatsApp.IgnoreRules.MaxForwardingScore; maxScore > 0 {
+		if score := waGetForwardingScore(v.Message); score > maxScore {
+			return true, fmt.Sprintf("forwarding score %d", score)
+		}
+	}
+
+	return false, ""
+}
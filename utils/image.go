@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	_ "image/png"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+// ImageToSquareJPEG crops the image around its center to a square, scales it
+// down to at most maxSize pixels on a side and encodes it as a JPEG
+func ImageToSquareJPEG(data []byte, maxSize int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	offsetX := bounds.Min.X + (bounds.Dx()-side)/2
+	offsetY := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	size := side
+	if maxSize > 0 && size > maxSize {
+		size = maxSize
+	}
+
+	square := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			square.Set(x, y, img.At(offsetX+x*side/size, offsetY+y*side/size))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, square, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ImageDownscaleJPEG scales the image down, keeping its aspect ratio, so that
+// neither side is larger than maxSize pixels and encodes it as a JPEG. Every
+// output pixel is the average of the source pixels it covers.
+func ImageDownscaleJPEG(data []byte, maxSize, quality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxSize > 0 && (width > maxSize || height > maxSize) {
+		if width >= height {
+			width, height = maxSize, height*maxSize/width
+		} else {
+			width, height = width*maxSize/height, maxSize
+		}
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+
+	if quality <= 0 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		srcY0 := bounds.Min.Y + y*bounds.Dy()/height
+		srcY1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if srcY1 <= srcY0 {
+			srcY1 = srcY0 + 1
+		}
+		for x := 0; x < width; x++ {
+			srcX0 := bounds.Min.X + x*bounds.Dx()/width
+			srcX1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if srcX1 <= srcX0 {
+				srcX1 = srcX0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := srcY0; sy < srcY1; sy++ {
+				for sx := srcX0; sx < srcX1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n += 1
+				}
+			}
+			scaled.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n),
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ImageProcessForTelegram downsizes images larger than the configured maximum
+// dimension before they are uploaded to Telegram. The original data is
+// returned if processing is disabled, not needed or doesn't save anything.
+func ImageProcessForTelegram(data []byte) []byte {
+	var (
+		cfg    = state.State.Config.Telegram.ImageProcessing
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.Enabled || cfg.MaxDimension <= 0 {
+		return data
+	}
+
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (imgCfg.Width <= cfg.MaxDimension && imgCfg.Height <= cfg.MaxDimension) {
+		return data
+	}
+
+	processed, err := ImageDownscaleJPEG(data, cfg.MaxDimension, cfg.Quality)
+	if err != nil {
+		logger.Warn("failed to downsize image",
+			zap.Error(err),
+		)
+		return data
+	}
+	if len(processed) >= len(data) {
+		return data
+	}
+
+	logger.Debug("downsized image before uploading to telegram",
+		zap.Int("original_size", len(data)),
+		zap.Int("new_size", len(processed)),
+	)
+	return processed
+}
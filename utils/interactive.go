@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	InteractiveButtons  = "buttons"
+	InteractiveList     = "list"
+	InteractiveTemplate = "template"
+)
+
+// WaInteractiveOption is a choice offered by a buttons, list or template
+// message. Options with a URL only open the link and nothing is sent back.
+type WaInteractiveOption struct {
+	Id          string
+	DisplayText string
+	Url         string
+	Index       uint32
+}
+
+// WaParseInteractiveMessage renders the body of a buttons, list or template
+// message along with its options as text, and returns the options which can
+// be chosen. The last value is false if the message is none of these.
+func WaParseInteractiveMessage(msg *waProto.Message) (string, string, []WaInteractiveOption, bool) {
+	var (
+		text    string
+		options []WaInteractiveOption
+	)
+
+	if buttonsMsg := msg.GetButtonsMessage(); buttonsMsg != nil {
+		if header := buttonsMsg.GetText(); header != "" {
+			text += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(header))
+		}
+		text += html.EscapeString(buttonsMsg.GetContentText()) + "\n"
+		if footer := buttonsMsg.GetFooterText(); footer != "" {
+			text += fmt.Sprintf("<i>%s</i>\n", html.EscapeString(footer))
+		}
+		for idx, button := range buttonsMsg.GetButtons() {
+			if button.GetType() == waProto.ButtonsMessage_Button_NATIVE_FLOW {
+				continue
+			}
+			options = append(options, WaInteractiveOption{
+				Id:          button.GetButtonId(),
+				DisplayText: button.GetButtonText().GetDisplayText(),
+				Index:       uint32(idx),
+			})
+		}
+		return text, InteractiveButtons, options, true
+
+	} else if listMsg := msg.GetListMessage(); listMsg != nil {
+		if title := listMsg.GetTitle(); title != "" {
+			text += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(title))
+		}
+		text += html.EscapeString(listMsg.GetDescription()) + "\n"
+		if footer := listMsg.GetFooterText(); footer != "" {
+			text += fmt.Sprintf("<i>%s</i>\n", html.EscapeString(footer))
+		}
+		for _, section := range listMsg.GetSections() {
+			if title := section.GetTitle(); title != "" {
+				text += fmt.Sprintf("\n<b>%s</b>\n", html.EscapeString(title))
+			}
+			for _, row := range section.GetRows() {
+				text += "- " + html.EscapeString(row.GetTitle())
+				if description := row.GetDescription(); description != "" {
+					text += ": <i>" + html.EscapeString(description) + "</i>"
+				}
+				text += "\n"
+				options = append(options, WaInteractiveOption{
+					Id:          row.GetRowId(),
+					DisplayText: row.GetTitle(),
+					Index:       uint32(len(options)),
+				})
+			}
+		}
+		return text, InteractiveList, options, true
+
+	} else if templateMsg := msg.GetTemplateMessage(); templateMsg != nil {
+		template := templateMsg.GetHydratedTemplate()
+		if template == nil {
+			template = templateMsg.GetHydratedFourRowTemplate()
+		}
+		if title := template.GetHydratedTitleText(); title != "" {
+			text += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(title))
+		}
+		text += html.EscapeString(template.GetHydratedContentText()) + "\n"
+		if footer := template.GetHydratedFooterText(); footer != "" {
+			text += fmt.Sprintf("<i>%s</i>\n", html.EscapeString(footer))
+		}
+		for _, button := range template.GetHydratedButtons() {
+			if quickReply := button.GetQuickReplyButton(); quickReply != nil {
+				options = append(options, WaInteractiveOption{
+					Id:          quickReply.GetId(),
+					DisplayText: quickReply.GetDisplayText(),
+					Index:       button.GetIndex(),
+				})
+			} else if urlButton := button.GetUrlButton(); urlButton != nil {
+				options = append(options, WaInteractiveOption{
+					DisplayText: urlButton.GetDisplayText(),
+					Url:         urlButton.GetUrl(),
+					Index:       button.GetIndex(),
+				})
+			} else if callButton := button.GetCallButton(); callButton != nil {
+				// Telegram doesn't allow phone number links on buttons
+				text += fmt.Sprintf("%s: <code>%s</code>\n",
+					html.EscapeString(callButton.GetDisplayText()), html.EscapeString(callButton.GetPhoneNumber()))
+			}
+		}
+		return text, InteractiveTemplate, options, true
+	}
+
+	return "", "", nil, false
+}
+
+// TgMakeInteractiveKeyboard stores the options of an interactive message and
+// builds the keyboard to choose them from Telegram
+func TgMakeInteractiveKeyboard(msgId string, chat, sender types.JID, kind string, options []WaInteractiveOption) (*gotgbot.InlineKeyboardMarkup, error) {
+	keyboard := &gotgbot.InlineKeyboardMarkup{}
+
+	for _, option := range options {
+		if option.Url != "" {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []gotgbot.InlineKeyboardButton{{
+				Text: option.DisplayText,
+				Url:  option.Url,
+			}})
+			continue
+		}
+
+		stored := &database.InteractiveOption{
+			MsgId:       msgId,
+			ChatJid:     chat.String(),
+			Sender:      sender.ToNonAD().String(),
+			Kind:        kind,
+			OptionId:    option.Id,
+			DisplayText: option.DisplayText,
+			Index:       option.Index,
+		}
+		if err := database.InteractiveOptionAdd(stored); err != nil {
+			return nil, err
+		}
+
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []gotgbot.InlineKeyboardButton{{
+			Text:         option.DisplayText,
+			CallbackData: "interactive_" + strconv.FormatUint(uint64(stored.ID), 10),
+		}})
+	}
+
+	if len(keyboard.InlineKeyboard) == 0 {
+		return nil, nil
+	}
+	return keyboard, nil
+}
+
+// WaSendInteractiveResponse sends the choice of an option back to the chat as
+// the response message matching the kind of the original message
+func WaSendInteractiveResponse(option database.InteractiveOption) error {
+	waSender := state.State.WhatsAppSender
+
+	chat, err := types.ParseJID(option.ChatJid)
+	if err != nil {
+		return err
+	}
+
+	contextInfo := &waProto.ContextInfo{
+		StanzaId: proto.String(option.MsgId),
+	}
+	if chat.Server == types.GroupServer {
+		contextInfo.Participant = proto.String(option.Sender)
+	}
+
+	var msg *waProto.Message
+	switch option.Kind {
+	case InteractiveButtons:
+		msg = &waProto.Message{
+			ButtonsResponseMessage: &waProto.ButtonsResponseMessage{
+				SelectedButtonId: proto.String(option.OptionId),
+				Response: &waProto.ButtonsResponseMessage_SelectedDisplayText{
+					SelectedDisplayText: option.DisplayText,
+				},
+				Type:        waProto.ButtonsResponseMessage_DISPLAY_TEXT.Enum(),
+				ContextInfo: contextInfo,
+			},
+		}
+	case InteractiveList:
+		msg = &waProto.Message{
+			ListResponseMessage: &waProto.ListResponseMessage{
+				Title:    proto.String(option.DisplayText),
+				ListType: waProto.ListResponseMessage_SINGLE_SELECT.Enum(),
+				SingleSelectReply: &waProto.ListResponseMessage_SingleSelectReply{
+					SelectedRowId: proto.String(option.OptionId),
+				},
+				ContextInfo: contextInfo,
+			},
+		}
+	case InteractiveTemplate:
+		msg = &waProto.Message{
+			TemplateButtonReplyMessage: &waProto.TemplateButtonReplyMessage{
+				SelectedId:          proto.String(option.OptionId),
+				SelectedDisplayText: proto.String(option.DisplayText),
+				SelectedIndex:       proto.Uint32(option.Index),
+				ContextInfo:         contextInfo,
+			},
+		}
+	default:
+		return fmt.Errorf("unknown kind of interactive message '%s'", option.Kind)
+	}
+
+	_, err = waSender.SendMessage(context.Background(), chat, msg)
+	return err
+}
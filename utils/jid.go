@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"strings"
+
+	"watgbridge/database"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// WaParseJID parses a JID given by the user or stored in the database, a plain
+// phone number (optionally starting with a '+') is taken as a contact. The JID
+// is returned normalized with WaNormalizeJID.
+func WaParseJID(s string) (types.JID, bool) {
+	if s == "" {
+		return types.EmptyJID, false
+	}
+	if s[0] == '+' {
+		s = SubString(s, 1, len(s)-1)
+	}
+
+	if !strings.ContainsRune(s, '@') {
+		return types.NewJID(s, types.DefaultUserServer), true
+	}
+
+	recipient, err := types.ParseJID(s)
+	if err != nil || recipient.User == "" {
+		return recipient.ToNonAD(), false
+	}
+
+	return WaNormalizeJID(recipient), true
+}
+
+// WaNormalizeJID returns the form of a JID chats and senders are stored and
+// looked up by: without the device (the same for all the linked devices of a
+// user), on s.whatsapp.net instead of the legacy c.us, and with LIDs replaced
+// by the phone number if it is known
+func WaNormalizeJID(jid types.JID) types.JID {
+	if jid.Server == types.LegacyUserServer {
+		jid.Server = types.DefaultUserServer
+	}
+	return WaResolveLID(jid).ToNonAD()
+}
+
+// WaNormalizeChatId normalizes a chat ID stored in the database with
+// WaNormalizeJID. IDs which are not JIDs, like the ones of the special topics
+// or the stories of a contact, are returned as they are.
+func WaNormalizeChatId(waChatId string) string {
+	if !strings.ContainsRune(waChatId, '@') || strings.ContainsRune(waChatId, '/') {
+		return waChatId
+	}
+
+	jid, err := types.ParseJID(waChatId)
+	if err != nil || jid.User == "" {
+		return waChatId
+	}
+	return WaNormalizeJID(jid).String()
+}
+
+// WaNormalizeSenderId normalizes a sender JID stored in the database like
+// WaNormalizeChatId, but keeps the device
+func WaNormalizeSenderId(senderId string) string {
+	if !strings.ContainsRune(senderId, '@') {
+		return senderId
+	}
+
+	jid, err := types.ParseJID(senderId)
+	if err != nil || jid.User == "" {
+		return senderId
+	}
+	if jid.Server == types.LegacyUserServer {
+		jid.Server = types.DefaultUserServer
+	}
+	return WaResolveLID(jid).String()
+}
+
+// WaResolveLID returns the phone number JID of a LID (hidden user JID) if the
+// mapping is known, so that contacts WhatsApp addresses by their LID keep
+// their name and topic. Other JIDs are returned as they are.
+func WaResolveLID(jid types.JID) types.JID {
+	if jid.Server != types.HiddenUserServer {
+		return jid
+	}
+
+	contact, found, err := database.ContactGetByLID(jid.User)
+	if err != nil || !found {
+		return jid
+	}
+	return types.JID{
+		User:   contact.ID,
+		Device: jid.Device,
+		Server: types.DefaultUserServer,
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"testing"
+
+	"watgbridge/database"
+	"watgbridge/fakes"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// newJIDTestHarness sets up a database knowing the phone number of one LID
+func newJIDTestHarness(t *testing.T) {
+	t.Helper()
+
+	if _, err := fakes.NewHarness(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.ContactLIDSet("10000000002", "200000000000002"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaNormalizeJID(t *testing.T) {
+	newJIDTestHarness(t)
+
+	for _, tc := range []struct {
+		name string
+		jid  types.JID
+		want string
+	}{
+		{"user", types.NewJID("10000000002", types.DefaultUserServer), "10000000002@s.whatsapp.net"},
+		{"device", types.NewADJID("10000000002", 0, 12), "10000000002@s.whatsapp.net"},
+		{"legacy", types.NewJID("10000000002", types.LegacyUserServer), "10000000002@s.whatsapp.net"},
+		{"lid_known", types.NewJID("200000000000002", types.HiddenUserServer), "10000000002@s.whatsapp.net"},
+		{"lid_known_device", types.JID{User: "200000000000002", Device: 3, Server: types.HiddenUserServer}, "10000000002@s.whatsapp.net"},
+		{"lid_unknown", types.NewJID("200000000000009", types.HiddenUserServer), "200000000000009@lid"},
+		{"group", types.NewJID("120363000000000001", types.GroupServer), "120363000000000001@g.us"},
+		{"legacy_group", types.NewJID("10000000002-1600000000", types.GroupServer), "10000000002-1600000000@g.us"},
+		{"broadcast", types.NewJID("1600000000", types.BroadcastServer), "1600000000@broadcast"},
+		{"status", types.StatusBroadcastJID, "status@broadcast"},
+		{"newsletter", types.NewJID("120363000000000002", types.NewsletterServer), "120363000000000002@newsletter"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := WaNormalizeJID(tc.jid).String(); got != tc.want {
+				t.Errorf("WaNormalizeJID(%s) = %s, want %s", tc.jid, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWaNormalizeChatId(t *testing.T) {
+	newJIDTestHarness(t)
+
+	for _, tc := range []struct {
+		name     string
+		waChatId string
+		want     string
+	}{
+		{"user", "10000000002@s.whatsapp.net", "10000000002@s.whatsapp.net"},
+		{"device", "10000000002:12@s.whatsapp.net", "10000000002@s.whatsapp.net"},
+		{"legacy", "10000000002@c.us", "10000000002@s.whatsapp.net"},
+		{"lid_known", "200000000000002@lid", "10000000002@s.whatsapp.net"},
+		{"lid_unknown", "200000000000009@lid", "200000000000009@lid"},
+		{"group", "120363000000000001@g.us", "120363000000000001@g.us"},
+		{"broadcast", "1600000000@broadcast", "1600000000@broadcast"},
+		{"special_topic", "#System", "#System"},
+		{"stories", "10000000002@s.whatsapp.net/stories", "10000000002@s.whatsapp.net/stories"},
+		{"no_user", "@s.whatsapp.net", "@s.whatsapp.net"},
+		{"malformed", "10000000002:x@s.whatsapp.net", "10000000002:x@s.whatsapp.net"},
+		{"empty", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := WaNormalizeChatId(tc.waChatId); got != tc.want {
+				t.Errorf("WaNormalizeChatId(%q) = %q, want %q", tc.waChatId, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWaParseJID(t *testing.T) {
+	newJIDTestHarness(t)
+
+	for _, tc := range []struct {
+		name   string
+		input  string
+		want   string
+		wantOk bool
+	}{
+		{"phone", "10000000002", "10000000002@s.whatsapp.net", true},
+		{"phone_plus", "+10000000002", "10000000002@s.whatsapp.net", true},
+		{"user", "10000000002@s.whatsapp.net", "10000000002@s.whatsapp.net", true},
+		{"device", "10000000002:12@s.whatsapp.net", "10000000002@s.whatsapp.net", true},
+		{"legacy", "10000000002@c.us", "10000000002@s.whatsapp.net", true},
+		{"lid_known", "200000000000002@lid", "10000000002@s.whatsapp.net", true},
+		{"lid_unknown", "200000000000009@lid", "200000000000009@lid", true},
+		{"group", "120363000000000001@g.us", "120363000000000001@g.us", true},
+		{"broadcast", "1600000000@broadcast", "1600000000@broadcast", true},
+		{"empty", "", "", false},
+		{"no_user", "@s.whatsapp.net", "", false},
+		{"malformed_device", "10000000002:x@s.whatsapp.net", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := WaParseJID(tc.input)
+			if ok != tc.wantOk {
+				t.Fatalf("WaParseJID(%q) ok = %v, want %v", tc.input, ok, tc.wantOk)
+			}
+			if ok && got.String() != tc.want {
+				t.Errorf("WaParseJID(%q) = %s, want %s", tc.input, got, tc.want)
+			}
+		})
+	}
+}
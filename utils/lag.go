@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type lagEntry struct {
+	chat    string
+	started time.Time
+}
+
+type lagTracker struct {
+	lock    sync.Mutex
+	nextId  uint64
+	events  map[uint64]lagEntry
+	sends   map[uint64]lagEntry
+	media   map[uint64]lagEntry
+	lastLag time.Duration
+}
+
+var lag = &lagTracker{
+	events: make(map[uint64]lagEntry),
+	sends:  make(map[uint64]lagEntry),
+	media:  make(map[uint64]lagEntry),
+}
+
+type LagReport struct {
+	PendingEvents   int
+	OldestEventAge  time.Duration
+	PendingSends    map[string]int
+	OldestSendAge   time.Duration
+	PendingMedia    int
+	OldestMediaAge  time.Duration
+	LastDeliveryLag time.Duration
+}
+
+func (t *lagTracker) track(entries map[uint64]lagEntry, chat string) func() {
+	t.lock.Lock()
+	id := t.nextId
+	t.nextId += 1
+	entries[id] = lagEntry{chat: chat, started: time.Now()}
+	t.lock.Unlock()
+
+	return func() {
+		t.lock.Lock()
+		delete(entries, id)
+		t.lock.Unlock()
+	}
+}
+
+// LagTrackEvent marks a WhatsApp event as taken in for processing, the returned
+// function must be called once the event has been handled
+func LagTrackEvent() func() {
+	return lag.track(lag.events, "")
+}
+
+// LagTrackSend marks a message for the given chat as being bridged
+func LagTrackSend(chat string) func() {
+	return lag.track(lag.sends, chat)
+}
+
+// LagTrackMedia marks a media download/upload as in progress
+func LagTrackMedia() func() {
+	return lag.track(lag.media, "")
+}
+
+// LagRecordDelivery records how far behind the original message timestamp
+// a message was picked up by the bridge
+func LagRecordDelivery(msgTime time.Time) {
+	lag.lock.Lock()
+	defer lag.lock.Unlock()
+
+	lag.lastLag = time.Since(msgTime)
+}
+
+func oldestEntryAge(entries map[uint64]lagEntry, now time.Time) time.Duration {
+	var oldest time.Duration
+	for _, entry := range entries {
+		if age := now.Sub(entry.started); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+func LagGetReport() LagReport {
+	lag.lock.Lock()
+	defer lag.lock.Unlock()
+
+	now := time.Now()
+	report := LagReport{
+		PendingEvents:   len(lag.events),
+		OldestEventAge:  oldestEntryAge(lag.events, now),
+		PendingSends:    make(map[string]int),
+		OldestSendAge:   oldestEntryAge(lag.sends, now),
+		PendingMedia:    len(lag.media),
+		OldestMediaAge:  oldestEntryAge(lag.media, now),
+		LastDeliveryLag: lag.lastLag,
+	}
+	for _, entry := range lag.sends {
+		report.PendingSends[entry.chat] += 1
+	}
+
+	return report
+}
+
+// LagInFlight returns the number of events, sends and media transfers which
+// are currently being processed
+func LagInFlight() int {
+	lag.lock.Lock()
+	defer lag.lock.Unlock()
+
+	return len(lag.events) + len(lag.sends) + len(lag.media)
+}
@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+const (
+	LargeMediaS3     = "s3"
+	LargeMediaWebDAV = "webdav"
+	LargeMediaLocal  = "local"
+)
+
+var (
+	largeMediaUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	// largeMediaServedPath matches the paths of the files named by
+	// largeMediaObjectName, nothing else is served
+	largeMediaServedPath = regexp.MustCompile(`^/[0-9]{4}-[0-9]{2}/[0-9a-f]{16}-[A-Za-z0-9._-]+$`)
+
+	largeMediaClient = &http.Client{Timeout: 10 * time.Minute}
+	// largeMediaUploads limits the uploads running in the background
+	largeMediaUploads = make(chan struct{}, 2)
+)
+
+// LargeMediaEnabled returns whether oversized media should be uploaded to the
+// configured external storage instead of being dropped
+func LargeMediaEnabled() bool {
+	switch state.State.Config.Telegram.LargeMediaHandler.Type {
+	case LargeMediaS3, LargeMediaWebDAV, LargeMediaLocal:
+		return true
+	}
+	return false
+}
+
+func largeMediaObjectName(fileName, mimetype string) string {
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+
+	fileName = largeMediaUnsafeChars.ReplaceAllString(filepath.Base(fileName), "_")
+	if fileName == "" || fileName == "." || fileName == "_" {
+		fileName = "file"
+		if exts, err := mime.ExtensionsByType(mimetype); err == nil && len(exts) > 0 {
+			fileName += exts[0]
+		}
+	}
+
+	return time.Now().UTC().Format("2006-01") + "/" + hex.EncodeToString(random) + "-" + fileName
+}
+
+func largeMediaPublicURL(name string) string {
+	publicURL := strings.TrimSuffix(state.State.Config.Telegram.LargeMediaHandler.PublicURL, "/")
+	return publicURL + "/" + name
+}
+
+// LargeMediaUpload downloads the media from WhatsApp and stores it on the
+// configured external storage, returning a link to download it from
+func LargeMediaUpload(chat types.JID, msg whatsmeow.DownloadableMessage, fileName, mimetype string) (string, error) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	data, err := WaDownloadMedia(chat, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to download media : %s", err)
+	}
+
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+	name := largeMediaObjectName(fileName, mimetype)
+
+	var link string
+	switch cfg.Telegram.LargeMediaHandler.Type {
+	case LargeMediaS3:
+		link, err = largeMediaUploadS3(name, data, mimetype)
+	case LargeMediaWebDAV:
+		link, err = largeMediaUploadWebDAV(name, data, mimetype)
+	case LargeMediaLocal:
+		link, err = largeMediaSaveLocal(name, data)
+	default:
+		err = fmt.Errorf("unknown large media handler '%s'", cfg.Telegram.LargeMediaHandler.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("uploaded oversized media to external storage",
+		zap.String("chat_jid", chat.String()),
+		zap.String("handler", cfg.Telegram.LargeMediaHandler.Type),
+		zap.Int("size", len(data)),
+	)
+	return link, nil
+}
+
+func largeMediaSaveLocal(name string, data []byte) (string, error) {
+	localCfg := state.State.Config.Telegram.LargeMediaHandler.Local
+
+	filePath := filepath.Join(localCfg.Directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory : %s", err)
+	}
+	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write file : %s", err)
+	}
+
+	return largeMediaPublicURL(name), nil
+}
+
+func largeMediaUploadWebDAV(name string, data []byte, mimetype string) (string, error) {
+	davCfg := state.State.Config.Telegram.LargeMediaHandler.WebDAV
+	baseURL := strings.TrimSuffix(davCfg.URL, "/")
+
+	doRequest := func(method, target string, body []byte) (int, error) {
+		req, err := http.NewRequest(method, target, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		if davCfg.Username != "" {
+			req.SetBasicAuth(davCfg.Username, davCfg.Password)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", mimetype)
+		}
+		res, err := largeMediaClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	// Collections have to exist before a file can be put inside them, servers
+	// answer 405 if it was already created
+	if dir := path.Dir(name); dir != "." {
+		if status, err := doRequest("MKCOL", baseURL+"/"+dir, nil); err != nil {
+			return "", fmt.Errorf("failed to create WebDAV collection : %s", err)
+		} else if status >= 300 && status != http.StatusMethodNotAllowed {
+			return "", fmt.Errorf("failed to create WebDAV collection : status %d", status)
+		}
+	}
+
+	status, err := doRequest(http.MethodPut, baseURL+"/"+name, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to WebDAV : %s", err)
+	} else if status >= 300 {
+		return "", fmt.Errorf("failed to upload to WebDAV : status %d", status)
+	}
+
+	if state.State.Config.Telegram.LargeMediaHandler.PublicURL != "" {
+		return largeMediaPublicURL(name), nil
+	}
+	return baseURL + "/" + name, nil
+}
+
+func largeMediaHmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func largeMediaUploadS3(name string, data []byte, mimetype string) (string, error) {
+	objectURL, err := S3PutObject(state.State.Config.Telegram.LargeMediaHandler.S3, name, data, mimetype)
+	if err != nil {
+		return "", err
+	}
+
+	if state.State.Config.Telegram.LargeMediaHandler.PublicURL != "" {
+		return largeMediaPublicURL(name), nil
+	}
+	return objectURL, nil
+}
+
+// S3PutObject puts the object using a request signed with AWS Signature
+// Version 4, which is understood by most S3 compatible services, and returns
+// its URL
+func S3PutObject(s3Cfg state.S3Config, name string, data []byte, mimetype string) (string, error) {
+	endpoint, err := url.Parse(s3Cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return "", fmt.Errorf("invalid S3 endpoint '%s'", s3Cfg.Endpoint)
+	}
+
+	region := s3Cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	objectPath := "/" + name
+	host := endpoint.Host
+	if s3Cfg.PathStyle {
+		objectPath = "/" + s3Cfg.Bucket + objectPath
+	} else {
+		host = s3Cfg.Bucket + "." + host
+	}
+	objectURL := endpoint.Scheme + "://" + host + objectPath
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+
+	payloadSum := sha256.Sum256(data)
+	payloadHash := hex.EncodeToString(payloadSum[:])
+
+	canonicalHeaders := "content-type:" + mimetype + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		(&url.URL{Path: objectPath}).EscapedPath(),
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalSum := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := shortDate + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	signingKey := largeMediaHmac([]byte("AWS4"+s3Cfg.SecretKey), shortDate)
+	signingKey = largeMediaHmac(signingKey, region)
+	signingKey = largeMediaHmac(signingKey, "s3")
+	signingKey = largeMediaHmac(signingKey, "aws4_request")
+	signature := hex.EncodeToString(largeMediaHmac(signingKey, stringToSign))
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, objectURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mimetype)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Cfg.AccessKey, scope, signedHeaders, signature))
+
+	res, err := largeMediaClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3 : %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to upload to S3 : status %d", res.StatusCode)
+	}
+
+	return objectURL, nil
+}
+
+// largeMediaServeFile serves the uploaded file at the path of the request,
+// without listing directories or serving anything else in the directory
+func largeMediaServeFile(directory string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !largeMediaServedPath.MatchString(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+
+		filePath := filepath.Join(directory, filepath.FromSlash(r.URL.Path))
+		if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filePath)
+	}
+}
+
+// LargeMediaServe starts the HTTP file server for the local large media
+// handler, if it is configured to listen on an address
+func LargeMediaServe() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+
+	localCfg := cfg.Telegram.LargeMediaHandler.Local
+	if cfg.Telegram.LargeMediaHandler.Type != LargeMediaLocal || localCfg.ListenAddress == "" {
+		return
+	}
+
+	go func() {
+		err := http.ListenAndServe(localCfg.ListenAddress, largeMediaServeFile(localCfg.Directory))
+		logger.Error("large media file server stopped",
+			zap.String("address", localCfg.ListenAddress),
+			zap.Error(err),
+		)
+		_ = logger.Sync()
+	}()
+}
+
+// LargeMediaBridgeText returns the line to append to a bridged message whose
+// media is too big to be sent to Telegram, the media is uploaded once the
+// message is sent by LargeMediaUploadLater
+func LargeMediaBridgeText(kind string) string {
+	if !LargeMediaEnabled() {
+		return largeMediaDroppedText(kind)
+	}
+	return fmt.Sprintf("\nThe %s exceeds Telegram size restrictions, uploading it to external storage...", kind)
+}
+
+func largeMediaDroppedText(kind string) string {
+	return fmt.Sprintf("\nCouldn't send the %s as it exceeds Telegram size restrictions.", kind)
+}
+
+// LargeMediaUploadLater uploads the media of the bridged message in the
+// background and edits the message to link it. The media is counted as
+// skipped if it could not be uploaded.
+func LargeMediaUploadLater(b state.TelegramAPI, sentMsg *gotgbot.Message, text string, chat types.JID,
+	msg whatsmeow.DownloadableMessage, size int64, kind, fileName, mimetype string) {
+
+	if !LargeMediaEnabled() || sentMsg == nil || sentMsg.MessageId == 0 {
+		database.ActivityEventAdd(database.ActivityMediaSkipped, chat.String(), size)
+		return
+	}
+
+	go func() {
+		logger := state.State.Logger
+		defer logger.Sync()
+
+		largeMediaUploads <- struct{}{}
+		defer func() { <-largeMediaUploads }()
+
+		uploading := LargeMediaBridgeText(kind)
+		link, err := LargeMediaUpload(chat, msg, fileName, mimetype)
+		if err != nil {
+			logger.Error("failed to upload oversized media to external storage",
+				zap.String("chat_jid", chat.String()),
+				zap.Error(err),
+			)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, chat.String(), size)
+			text = strings.Replace(text, uploading, largeMediaDroppedText(kind), 1)
+		} else {
+			text = strings.Replace(text, uploading, fmt.Sprintf("\nThe %s exceeds Telegram size restrictions, <a href=\"%s\">download it here</a>.",
+				kind, html.EscapeString(link)), 1)
+		}
+
+		if err = TgEditBridgedText(b, sentMsg, text); err != nil {
+			logger.Error("failed to link uploaded media in the bridged message",
+				zap.String("chat_jid", chat.String()),
+				zap.Int64("msg_id", sentMsg.MessageId),
+				zap.Error(err),
+			)
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLargeMediaServeFile(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(directory, "2024-05"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2024-05/0123456789abcdef-video.mp4", "secret.txt"} {
+		if err := os.WriteFile(filepath.Join(directory, filepath.FromSlash(name)), []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(largeMediaServeFile(directory))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/2024-05/0123456789abcdef-video.mp4", http.StatusOK},
+		{"/2024-05/0123456789abcdef-other.mp4", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+		{"/2024-05/", http.StatusNotFound},
+		{"/secret.txt", http.StatusNotFound},
+		{"/2024-05/../secret.txt", http.StatusNotFound},
+	} {
+		res, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, res.StatusCode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// WaLearnLIDs saves the LIDs of the participants of a group, group info being
+// the only place WhatsApp sends them together with the phone numbers
+func WaLearnLIDs(participants []types.GroupParticipant) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	lids := make(map[string]string)
+	waCollectLIDs(lids, participants)
+	if _, err := database.ContactLIDBulkSet(lids); err != nil {
+		logger.Warn("failed to save LIDs of contacts", zap.Error(err))
+	}
+}
+
+// WaSyncLIDs learns the LIDs of the participants of all the joined groups
+func WaSyncLIDs() {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	groups, err := state.State.WhatsAppClient.GetJoinedGroups()
+	if err != nil {
+		logger.Warn("failed to get joined groups to learn LIDs", zap.Error(err))
+		return
+	}
+
+	lids := make(map[string]string)
+	for _, group := range groups {
+		waCollectLIDs(lids, group.Participants)
+	}
+
+	written, err := database.ContactLIDBulkSet(lids)
+	if err != nil {
+		logger.Warn("failed to save LIDs of contacts", zap.Error(err))
+		return
+	}
+	logger.Debug("learnt LIDs of group participants",
+		zap.Int("participants", len(lids)),
+		zap.Int("written", written),
+	)
+}
+
+// waCollectLIDs adds the phone number to LID mapping of the participants that
+// have both to lids
+func waCollectLIDs(lids map[string]string, participants []types.GroupParticipant) {
+	for _, participant := range participants {
+		if participant.JID.Server != types.DefaultUserServer || participant.LID.IsEmpty() {
+			continue
+		}
+		lids[participant.JID.User] = participant.LID.User
+	}
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// Links are sent by anyone, so they aren't followed to the machine of the
+	// bridge or its network. No proxy from the environment either, it would
+	// do the dialing.
+	linkPreviewClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         linkPreviewDialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: linkPreviewCheckRedirect,
+	}
+
+	linkPreviewURLRegex   = regexp.MustCompile(`https?://[^\s<>"']+`)
+	linkPreviewMetaRegex  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	linkPreviewAttrRegex  = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	linkPreviewTitleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Only the start of the page is read, the head is all that is needed
+const linkPreviewMaxBytes = 512 * 1024
+
+// LinkPreview holds what is shown for a link in a message
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// LinkPreviewEnabledFor reports whether link previews are fetched for the
+// chat. Fetching a page reveals the IP address of the bridge to the host of
+// the link, so it is only done for the configured chats.
+func LinkPreviewEnabledFor(chat types.JID) bool {
+	cfg := state.State.Config
+	return cfg.WhatsApp.LinkPreviews.AllChats || slices.Contains(cfg.WhatsApp.LinkPreviews.Chats, chat.User)
+}
+
+// LinkPreviewFindURL returns the first link in the text
+func LinkPreviewFindURL(text string) (string, bool) {
+	url := linkPreviewURLRegex.FindString(text)
+	url = strings.TrimRight(url, ".,;:!?)]}")
+	return url, url != ""
+}
+
+// Ranges not covered by the net.IP checks: shared addresses of carrier-grade
+// NAT and "this network"
+var linkPreviewBlockedNets = []*net.IPNet{
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+}
+
+// linkPreviewAllowedIP reports whether the address is a public one
+func linkPreviewAllowedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, blocked := range linkPreviewBlockedNets {
+		if blocked.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// linkPreviewResolve returns the addresses of the host, failing if any of them
+// isn't public
+func linkPreviewResolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !linkPreviewAllowedIP(addr.IP) {
+			return nil, fmt.Errorf("%s resolves to the non-public address %s", host, addr.IP)
+		}
+	}
+	return addrs, nil
+}
+
+// linkPreviewDialContext connects to the addresses the host was resolved to
+// and checked against, so that it can't resolve to another one in between
+func linkPreviewDialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := linkPreviewResolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("%s has no addresses", host)
+	}
+	return nil, err
+}
+
+// linkPreviewCheckRedirect checks every redirect like the link itself
+func linkPreviewCheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirected to a %s link", req.URL.Scheme)
+	}
+	_, err := linkPreviewResolve(req.Context(), req.URL.Hostname())
+	return err
+}
+
+// LinkPreviewFetch downloads the start of the page and takes its title and
+// description from the OpenGraph tags, or the title tag if there are none
+func LinkPreviewFetch(url string) (*LinkPreview, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; watgbridge)")
+	req.Header.Set("Accept", "text/html")
+
+	res, err := linkPreviewClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("received non-200 status code : %s", res.Status)
+	}
+	if contentType := res.Header.Get("Content-Type"); !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("not a web page : %s", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, linkPreviewMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	page := string(body)
+
+	preview := &LinkPreview{URL: url}
+	for _, tag := range linkPreviewMetaRegex.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range linkPreviewAttrRegex.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3]
+		}
+
+		property := attrs["property"]
+		if property == "" {
+			property = attrs["name"]
+		}
+		content := strings.TrimSpace(html.UnescapeString(attrs["content"]))
+
+		switch strings.ToLower(property) {
+		case "og:title":
+			preview.Title = content
+		case "og:description":
+			preview.Description = content
+		case "description":
+			if preview.Description == "" {
+				preview.Description = content
+			}
+		case "og:image":
+			// The image can be relative to the page
+			if imageURL, err := res.Request.URL.Parse(content); err == nil {
+				preview.ImageURL = imageURL.String()
+			}
+		}
+	}
+	if preview.Title == "" {
+		if match := linkPreviewTitleRegex.FindStringSubmatch(page); match != nil {
+			preview.Title = strings.TrimSpace(html.UnescapeString(match[1]))
+		}
+	}
+
+	if preview.Title == "" && preview.Description == "" {
+		return nil, fmt.Errorf("page has no title or description")
+	}
+	return preview, nil
+}
+
+// LinkPreviewRender formats the preview as a block to append to the bridged
+// message
+func LinkPreviewRender(preview *LinkPreview) string {
+	block := "\n\n🔗 "
+	if preview.Title != "" {
+		title := preview.Title
+		if len([]rune(title)) > 200 {
+			title = SubString(title, 0, 200) + "..."
+		}
+		block += fmt.Sprintf("<a href=\"%s\"><b>%s</b></a>", html.EscapeString(preview.URL), html.EscapeString(title))
+	} else {
+		block += fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(preview.URL), html.EscapeString(preview.URL))
+	}
+	if preview.Description != "" {
+		description := preview.Description
+		if len([]rune(description)) > 300 {
+			description = SubString(description, 0, 300) + "..."
+		}
+		block += "\n<i>" + html.EscapeString(description) + "</i>"
+	}
+	return block
+}
+
+// LinkPreviewAttach fills the preview fields of an outgoing WhatsApp message,
+// along with a thumbnail of the image of the page if it has one
+func LinkPreviewAttach(msg *waProto.ExtendedTextMessage, preview *LinkPreview) {
+	msg.MatchedText = proto.String(preview.URL)
+	msg.CanonicalUrl = proto.String(preview.URL)
+	msg.Title = proto.String(preview.Title)
+	msg.Description = proto.String(preview.Description)
+	msg.PreviewType = waProto.ExtendedTextMessage_NONE.Enum()
+
+	if preview.ImageURL == "" {
+		return
+	}
+	if parsedURL, err := neturl.Parse(preview.ImageURL); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return
+	}
+
+	res, err := linkPreviewClient.Get(preview.ImageURL)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return
+	}
+
+	image, err := io.ReadAll(io.LimitReader(res.Body, 5*1024*1024))
+	if err != nil {
+		return
+	}
+	if thumbnail, err := ImageDownscaleJPEG(image, 300, 70); err == nil {
+		msg.JpegThumbnail = thumbnail
+	}
+}
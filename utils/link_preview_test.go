@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkPreviewFetchPrivate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Internal</title>"))
+	}))
+	defer server.Close()
+
+	if preview, err := LinkPreviewFetch(server.URL); err == nil {
+		t.Errorf("fetched %+v from the loopback address", preview)
+	}
+}
+
+func TestLinkPreviewAllowedIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"100.64.0.0", false},
+		{"100.100.100.100", false},
+		{"0.0.0.1", false},
+		{"127.0.0.1", false},
+		{"fd00::1", false},
+	} {
+		if got := linkPreviewAllowedIP(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("linkPreviewAllowedIP(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestLinkPreviewCheckRedirect(t *testing.T) {
+	for _, url := range []string{
+		"http://127.0.0.1/",
+		"http://localhost:8080/admin",
+		"http://10.0.0.1/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.64.0.1/",
+		"http://100.127.255.254/",
+		"http://0.1.2.3/",
+		"http://[::ffff:100.64.0.1]/",
+		"http://[::1]/",
+		"http://[fe80::1]/",
+		"file:///etc/passwd",
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = linkPreviewCheckRedirect(req, nil); err == nil {
+			t.Errorf("redirect to %s allowed", url)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// LocalAPIUploadSizeLimit is how large files a local Bot API server
+	// accepts, instead of UploadSizeLimit
+	LocalAPIUploadSizeLimit uint64 = 2097152000
+
+	// Smaller files are uploaded as usual, it is not worth the disk writes
+	localFileMinSize = 10 * 1024 * 1024
+
+	localFileRetention = time.Hour
+)
+
+// TgLargeFiles reports whether files up to LocalAPIUploadSizeLimit can be
+// sent to and downloaded from Telegram, with a local Bot API server or a user
+// account (the mtproto transport)
+func TgLargeFiles() bool {
+	cfg := state.State.Config
+	return cfg.Telegram.SelfHostedAPI || cfg.Telegram.Transport == "mtproto"
+}
+
+// TgUploadSizeLimit returns the size of the largest file that can be sent to
+// Telegram with the configured Bot API server or transport
+func TgUploadSizeLimit() uint64 {
+	if TgLargeFiles() {
+		return LocalAPIUploadSizeLimit
+	}
+	return UploadSizeLimit
+}
+
+// TgInputFile returns the file to send to Telegram. With a local Bot API
+// server and local_files_directory set, large files are written there and
+// sent as a file:// URI for the server to read from the disk, instead of
+// being uploaded to it over HTTP.
+func TgInputFile(data []byte, fileName string) gotgbot.InputFile {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.Telegram.SelfHostedAPI || cfg.Telegram.LocalFilesDirectory == "" || len(data) < localFileMinSize {
+		return gotgbot.NamedFile{FileName: fileName, File: bytes.NewReader(data)}
+	}
+
+	path, err := tgWriteLocalFile(data, fileName)
+	if err != nil {
+		logger.Warn("failed to write file for the local bot api server, uploading it instead",
+			zap.String("file_name", fileName),
+			zap.Error(err),
+		)
+		return gotgbot.NamedFile{FileName: fileName, File: bytes.NewReader(data)}
+	}
+	return "file://" + path
+}
+
+// tgWriteLocalFile writes the file to a directory of its own, so that it is
+// sent with its name
+func tgWriteLocalFile(data []byte, fileName string) (string, error) {
+	directory, err := filepath.Abs(state.State.Config.Telegram.LocalFilesDirectory)
+	if err != nil {
+		return "", err
+	}
+
+	random := make([]byte, 8)
+	if _, err = rand.Read(random); err != nil {
+		return "", err
+	}
+	directory = filepath.Join(directory, hex.EncodeToString(random))
+	if err = os.MkdirAll(directory, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(directory, filepath.Base(fileName))
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		os.RemoveAll(directory)
+		return "", err
+	}
+	return path, nil
+}
+
+// TgLocalFilesCleanup deletes the files written for the local Bot API server
+// which it must have read by now
+func TgLocalFilesCleanup() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if cfg.Telegram.LocalFilesDirectory == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(cfg.Telegram.LocalFilesDirectory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to list local bot api files", zap.Error(err))
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < localFileRetention {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(cfg.Telegram.LocalFilesDirectory, entry.Name())); err != nil {
+			logger.Warn("failed to delete local bot api file",
+				zap.String("name", entry.Name()),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Modules whose log level can be set separately, "default" covers the code
+// outside of the other modules and "whatsmeow" the WhatsApp library itself
+var LogModules = []string{"default", "whatsapp", "telegram", "database", "utils", "whatsmeow"}
+
+var (
+	logLevels = func() map[string]zap.AtomicLevel {
+		levels := make(map[string]zap.AtomicLevel, len(LogModules))
+		for _, module := range LogModules {
+			levels[module] = zap.NewAtomicLevel()
+		}
+		return levels
+	}()
+
+	logFile *lumberjack.Logger
+)
+
+// LogLevelsInit sets the starting level of every module, overridden by the
+// levels given in the config
+func LogLevelsInit(defaultLevel, whatsmeowLevel zapcore.Level, levels map[string]string) error {
+	for module, level := range logLevels {
+		if module == "whatsmeow" {
+			level.SetLevel(whatsmeowLevel)
+		} else {
+			level.SetLevel(defaultLevel)
+		}
+	}
+
+	for module, level := range levels {
+		if err := LogLevelSet(module, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogLevelSet changes the level of a module at runtime
+func LogLevelSet(module, level string) error {
+	atomicLevel, found := logLevels[strings.ToLower(module)]
+	if !found {
+		return fmt.Errorf("unknown module '%s', must be one of: %s", module, strings.Join(LogModules, ", "))
+	}
+
+	var parsed zapcore.Level
+	if err := parsed.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+		return fmt.Errorf("unknown level '%s'", level)
+	}
+
+	atomicLevel.SetLevel(parsed)
+	return nil
+}
+
+// LogLevelGet returns the current level of a module
+func LogLevelGet(module string) zapcore.Level {
+	return logLevels[module].Level()
+}
+
+// LogLevelCore wraps a zap core so that entries are filtered by the level of
+// the module they were logged from, to be used with zap.WrapCore
+func LogLevelCore(core zapcore.Core) zapcore.Core {
+	return levelCore{core}
+}
+
+func logEntryModule(entry zapcore.Entry) string {
+	if strings.Contains(entry.LoggerName, "WhatsMeow") {
+		return "whatsmeow"
+	}
+	if entry.Caller.Defined {
+		module := path.Base(path.Dir(entry.Caller.File))
+		if _, found := logLevels[module]; found {
+			return module
+		}
+	}
+	return "default"
+}
+
+type levelCore struct {
+	zapcore.Core
+}
+
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	for _, atomicLevel := range logLevels {
+		if atomicLevel.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{c.Core.With(fields)}
+}
+
+// Check also leaves it to the wrapped core whether the entry is logged (its
+// level and sampling), but the entry has to be written through this core to
+// be filtered by its module
+func (c levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) && c.Core.Check(entry, nil) != nil {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write does the actual filtering, as the caller of an entry is only known
+// after it has been checked
+func (c levelCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if !logLevels[logEntryModule(entry)].Enabled(entry.Level) {
+		return nil
+	}
+	return c.Core.Write(entry, fields)
+}
+
+// LogFileInit sets the file the logs are additionally written to, it is
+// rotated once it grows over maxSizeMB
+func LogFileInit(fileName string, maxSizeMB, maxBackups int) error {
+	file := &lumberjack.Logger{
+		Filename:   fileName,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}
+	// Open the file right away so that a bad path fails the startup
+	if _, err := file.Write(nil); err != nil {
+		return fmt.Errorf("failed to open log file : %s", err)
+	}
+	logFile = file
+	return nil
+}
+
+// LogFileCore tees a zap core with one writing JSON entries to the log file,
+// obfuscated if LogObfuscationInit was called, to be used with zap.WrapCore
+func LogFileCore(core zapcore.Core) zapcore.Core {
+	if logFile == nil {
+		return core
+	}
+	var fileCore zapcore.Core = zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(logFile),
+		zapcore.DebugLevel,
+	)
+	if logObfuscationKey != nil {
+		fileCore = LogObfuscateCore(fileCore)
+	}
+	return zapcore.NewTee(core, fileCore)
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	logObfuscationKey []byte
+
+	// Log fields whose values are JIDs or message IDs
+	logObfuscatedFields = map[string]bool{
+		"jid":      true,
+		"chat_jid": true,
+		"chat":     true,
+		"group":    true,
+		"sender":   true,
+		"event_id": true,
+		"msg_id":   true,
+		"msg_ids":  true,
+	}
+
+	// Legacy group JIDs are <creator phone>-<creation timestamp>@g.us
+	logJIDRegex   = regexp.MustCompile(`\b[0-9]+(?:-[0-9]+)?((?:[.:][0-9]+)*@(?:s\.whatsapp\.net|g\.us|lid|broadcast|newsletter|c\.us))\b`)
+	logMsgIdRegex = regexp.MustCompile(`\b[0-9A-F]{16,}\b`)
+)
+
+// LogObfuscationInit sets the HMAC key used for hashing IDs in the logs, a
+// random key is generated if the given one is empty
+func LogObfuscationInit(key string) error {
+	if key != "" {
+		logObfuscationKey = []byte(key)
+		return nil
+	}
+
+	logObfuscationKey = make([]byte, 32)
+	_, err := rand.Read(logObfuscationKey)
+	return err
+}
+
+// LogObfuscateCore wraps a zap core so that JIDs and message IDs are replaced
+// with their keyed hashes before being written, to be used with zap.WrapCore.
+// It has to wrap the core writing the entries, as everything written to it
+// goes to the wrapped core whatever its level.
+func LogObfuscateCore(core zapcore.Core) zapcore.Core {
+	return obfuscatingCore{core}
+}
+
+func logHashID(id string) string {
+	mac := hmac.New(sha256.New, logObfuscationKey)
+	mac.Write([]byte(id))
+	return "h:" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// LogObfuscateID returns the hashed form of a JID or message ID, for JIDs only
+// the user part is hashed so the server and device stay readable
+func LogObfuscateID(id string) string {
+	if id == "" {
+		return id
+	}
+
+	user, server, found := strings.Cut(id, "@")
+	if !found {
+		return logHashID(id)
+	}
+	if idx := strings.IndexAny(user, ".:"); idx >= 0 {
+		return logHashID(user[:idx]) + user[idx:] + "@" + server
+	}
+	return logHashID(user) + "@" + server
+}
+
+// LogObfuscateText hashes every JID and message ID found in free form text
+func LogObfuscateText(text string) string {
+	text = logJIDRegex.ReplaceAllStringFunc(text, LogObfuscateID)
+	return logMsgIdRegex.ReplaceAllStringFunc(text, logHashID)
+}
+
+func logObfuscateField(field zapcore.Field) zapcore.Field {
+	switch field.Type {
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok && err != nil {
+			return zap.String(field.Key, LogObfuscateText(err.Error()))
+		}
+		return field
+	case zapcore.StringType:
+		if logObfuscatedFields[field.Key] {
+			return zap.String(field.Key, LogObfuscateID(field.String))
+		}
+		return zap.String(field.Key, LogObfuscateText(field.String))
+	}
+
+	if !logObfuscatedFields[field.Key] {
+		return field
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	field.AddTo(enc)
+	switch value := enc.Fields[field.Key].(type) {
+	case string:
+		return zap.String(field.Key, LogObfuscateID(value))
+	case []interface{}:
+		hashed := make([]string, 0, len(value))
+		for _, elem := range value {
+			if str, ok := elem.(string); ok {
+				hashed = append(hashed, LogObfuscateID(str))
+			}
+		}
+		return zap.Strings(field.Key, hashed)
+	}
+	return zap.String(field.Key, "<obfuscated>")
+}
+
+func logObfuscateFields(fields []zapcore.Field) []zapcore.Field {
+	obfuscated := make([]zapcore.Field, len(fields))
+	for idx, field := range fields {
+		obfuscated[idx] = logObfuscateField(field)
+	}
+	return obfuscated
+}
+
+type obfuscatingCore struct {
+	zapcore.Core
+}
+
+func (c obfuscatingCore) With(fields []zapcore.Field) zapcore.Core {
+	return obfuscatingCore{c.Core.With(logObfuscateFields(fields))}
+}
+
+// Check leaves it to the wrapped core whether the entry is logged (its level
+// and sampling), but the entry has to be written through this core to be
+// obfuscated
+func (c obfuscatingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Check(entry, nil) != nil {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c obfuscatingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = LogObfuscateText(entry.Message)
+	return c.Core.Write(entry, logObfuscateFields(fields))
+}
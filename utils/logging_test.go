@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogObfuscateText(t *testing.T) {
+	if err := LogObfuscationInit("test key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		text   string
+		hidden string
+		kept   string
+	}{
+		{"user", "from 10000000002@s.whatsapp.net", "10000000002", "@s.whatsapp.net"},
+		{"device", "from 10000000002:3@s.whatsapp.net", "10000000002", ":3@s.whatsapp.net"},
+		{"group", "in 120363000000000001@g.us", "120363000000000001", "@g.us"},
+		{"legacy_group", "in 10000000002-1600000000@g.us", "10000000002", "@g.us"},
+		{"legacy_group_timestamp", "in 10000000002-1600000000@g.us", "1600000000", "@g.us"},
+		{"message_id", "message 3EB0C767D26A1D4A2B8F", "3EB0C767D26A1D4A2B8F", "message h:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := LogObfuscateText(tc.text)
+			if strings.Contains(got, tc.hidden) || !strings.Contains(got, tc.kept) {
+				t.Errorf("LogObfuscateText(%q) = %q", tc.text, got)
+			}
+		})
+	}
+}
+
+func TestLogObfuscateCore(t *testing.T) {
+	if err := LogObfuscationInit("test key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sampler of the wrapped core lets only the first of the same
+	// messages through in a minute
+	inner, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(LogObfuscateCore(zapcore.NewSamplerWithOptions(inner, time.Minute, 1, 0)))
+
+	logger.Debug("below the level of the wrapped core")
+	for i := 0; i < 3; i++ {
+		logger.Info("message 3EB0C767D26A1D4A2B8F", zap.String("chat_jid", "10000000002@s.whatsapp.net"))
+	}
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("%d entries written, want 1: %v", len(entries), entries)
+	}
+	if strings.Contains(entries[0].Message, "3EB0C767D26A1D4A2B8F") {
+		t.Errorf("message written as %q", entries[0].Message)
+	}
+	if jid := entries[0].ContextMap()["chat_jid"]; strings.Contains(jid.(string), "10000000002") {
+		t.Errorf("chat_jid written as %q", jid)
+	}
+}
+
+func TestLogLevelCore(t *testing.T) {
+	if err := LogLevelsInit(zapcore.DebugLevel, zapcore.DebugLevel, map[string]string{"utils": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	defer LogLevelsInit(zapcore.InfoLevel, zapcore.WarnLevel, nil)
+
+	// The sampler of the wrapped core lets only the first of the same
+	// messages through in a minute
+	inner, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(LogLevelCore(zapcore.NewSamplerWithOptions(inner, time.Minute, 1, 0)), zap.AddCaller())
+
+	logger.Info("below the level of the utils module")
+	for i := 0; i < 3; i++ {
+		logger.Warn("same message")
+	}
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 || entries[0].Message != "same message" {
+		t.Fatalf("%d entries written, want only the first warning: %v", len(entries), entries)
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.uber.org/zap"
+	"rsc.io/qr"
+)
+
+var waLoginLock sync.Mutex
+
+// WaPairCodeText is shown with the pairing code wherever it is sent
+const WaPairCodeText = "Enter this code in WhatsApp > Linked devices > Link a device > Link with phone number instead:"
+
+// WaLogin connects the client, which must not be logged in, and links it to
+// WhatsApp. If a phone number is given the pairing code to enter on the phone
+// is passed to showPairCode, otherwise every QR code is passed to showQR. It
+// blocks until the login succeeds or the codes run out.
+func WaLogin(client *whatsmeow.Client, phone string, showPairCode func(code string),
+	showQR func(code string, timeout time.Duration)) error {
+
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	qrChan, err := client.GetQRChannel(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get QR channel : %s", err)
+	}
+	if err = client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect : %s", err)
+	}
+
+	codeSent := false
+	for evt := range qrChan {
+		switch {
+		case evt.Event == whatsmeow.QRChannelEventCode && phone != "":
+			if codeSent {
+				continue
+			}
+			code, err := client.PairPhone(phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+			if err != nil {
+				client.Disconnect()
+				return fmt.Errorf("failed to get pairing code for %s : %s", phone, err)
+			}
+			codeSent = true
+			showPairCode(code)
+
+		case evt.Event == whatsmeow.QRChannelEventCode:
+			showQR(evt.Code, evt.Timeout)
+
+		case evt == whatsmeow.QRChannelSuccess:
+			return nil
+
+		case evt == whatsmeow.QRChannelTimeout:
+			return fmt.Errorf("the login codes expired")
+
+		default:
+			logger.Info("received WhatsApp login event",
+				zap.Any("event", evt.Event),
+				zap.Error(evt.Error),
+			)
+		}
+	}
+
+	return fmt.Errorf("login ended unexpectedly")
+}
+
+// WaLoginViaTelegram links the bridge to WhatsApp again after being logged
+// out. The QR codes are sent to the owner as images, or if a phone number is
+// given a pairing code to enter on the phone is sent instead. It blocks until
+// the login succeeds or the codes run out.
+func WaLoginViaTelegram(phone string) error {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramSender
+		waClient = state.State.WhatsAppClient
+		ownerId  = cfg.Telegram.OwnerID
+	)
+	defer logger.Sync()
+
+	if !waLoginLock.TryLock() {
+		return fmt.Errorf("a login is already in progress")
+	}
+	defer waLoginLock.Unlock()
+
+	if waClient.Store.ID != nil {
+		return fmt.Errorf("already logged in as %s", waClient.Store.ID.ToNonAD().String())
+	}
+
+	var lastQrMsg *gotgbot.Message
+	deleteLastQr := func() {
+		if lastQrMsg != nil {
+			tgBot.DeleteMessage(lastQrMsg.Chat.Id, lastQrMsg.MessageId, nil)
+		}
+	}
+
+	waClient.Disconnect()
+	err := WaLogin(waClient, phone, func(code string) {
+		TgSendTextById(tgBot, ownerId, 0, WaPairCodeText+"\n\n<code>"+html.EscapeString(code)+"</code>")
+	}, func(code string, timeout time.Duration) {
+		qrCode, err := qr.Encode(code, qr.L)
+		if err != nil {
+			logger.Error("failed to render QR code",
+				zap.Error(err),
+			)
+			return
+		}
+		deleteLastQr()
+		qrCode.Scale = 8
+		lastQrMsg, _ = tgBot.SendPhoto(ownerId, qrCode.PNG(), &gotgbot.SendPhotoOpts{
+			Caption: fmt.Sprintf("Scan this QR code in WhatsApp > Linked devices > Link a device, it expires in %s.\n\n"+
+				"Send <code>/relogin &lt;phone number&gt;</code> to link using a code instead", timeout),
+		})
+	})
+	deleteLastQr()
+	if err != nil {
+		return fmt.Errorf("%s, send /relogin to try again", err)
+	}
+
+	logger.Info("logged back into WhatsApp",
+		zap.String("jid", waClient.Store.ID.String()),
+	)
+	return TgSendTextById(tgBot, ownerId, 0, "Successfully logged back into WhatsApp")
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+func mediaStorePath(hash, mimetype string) string {
+	ext := ".bin"
+	if exts, err := mime.ExtensionsByType(mimetype); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	return filepath.Join(state.State.Config.MediaStore.Directory, hash[:2], hash+ext)
+}
+
+// MediaStoreLoad returns the stored media with the given SHA-256 hash, if any
+func MediaStoreLoad(fileSha256 []byte) ([]byte, bool) {
+	cfg := state.State.Config
+
+	if !cfg.MediaStore.Enabled || len(fileSha256) == 0 {
+		return nil, false
+	}
+
+	hash := hex.EncodeToString(fileSha256)
+	media, found, err := database.StoredMediaGet(hash)
+	if err != nil || !found {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(media.Path)
+	if err != nil {
+		return nil, false
+	}
+
+	database.StoredMediaTouch(hash, time.Now().UTC())
+	return data, true
+}
+
+// MediaStoreSave writes the media to the store directory, named by the hash of
+// its content, and records it in the database
+func MediaStoreSave(data []byte, mimetype string) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.MediaStore.Enabled || len(data) == 0 {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if _, found, err := database.StoredMediaGet(hash); err == nil && found {
+		database.StoredMediaTouch(hash, time.Now().UTC())
+		return
+	}
+
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+	path := mediaStorePath(hash, mimetype)
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err == nil {
+		now := time.Now().UTC()
+		err = database.StoredMediaAdd(&database.StoredMedia{
+			ID:        hash,
+			Path:      path,
+			Mimetype:  mimetype,
+			Size:      int64(len(data)),
+			CreatedAt: now,
+			LastUsed:  now,
+		})
+	}
+	if err != nil {
+		logger.Error("failed to save media to the store",
+			zap.String("path", path),
+			zap.Error(err),
+		)
+	}
+}
+
+// MediaStoreCleanup deletes the stored media which was not used within the
+// retention period
+func MediaStoreCleanup() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	if !cfg.MediaStore.Enabled || cfg.MediaStore.RetentionDays <= 0 {
+		return
+	}
+
+	before := time.Now().UTC().AddDate(0, 0, -cfg.MediaStore.RetentionDays)
+	expired, err := database.StoredMediaGetUnusedSince(before)
+	if err != nil {
+		logger.Error("failed to get expired media from the store", zap.Error(err))
+		return
+	}
+
+	for _, media := range expired {
+		if err := os.Remove(media.Path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to delete expired media",
+				zap.String("path", media.Path),
+				zap.Error(err),
+			)
+			continue
+		}
+		database.StoredMediaDelete(media.ID)
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Names and invite codes of channels, fetching them needs a request each time
+var newsletterCache sync.Map
+
+const newsletterLinkPrefix = "https://whatsapp.com/channel/"
+
+func WaNewsletterInviteKey(invite string) string {
+	invite = strings.TrimSpace(invite)
+	for _, prefix := range []string{newsletterLinkPrefix, "http://whatsapp.com/channel/", "whatsapp.com/channel/"} {
+		invite = strings.TrimPrefix(invite, prefix)
+	}
+	return strings.TrimSuffix(invite, "/")
+}
+
+func waGetNewsletterInfo(jid types.JID) (*types.NewsletterMetadata, error) {
+	if cached, found := newsletterCache.Load(jid.String()); found {
+		return cached.(*types.NewsletterMetadata), nil
+	}
+
+	waClient := state.State.WhatsAppClient
+	if waClient == nil {
+		return nil, fmt.Errorf("WhatsApp client is not initialized")
+	}
+
+	info, err := waClient.GetNewsletterInfo(jid)
+	if err != nil {
+		return nil, err
+	}
+	newsletterCache.Store(jid.String(), info)
+	return info, nil
+}
+
+// WaGetNewsletterName returns the name of a channel, or the user part of its
+// JID if it can't be fetched
+func WaGetNewsletterName(jid types.JID) string {
+	info, err := waGetNewsletterInfo(jid)
+	if err != nil || info.ThreadMeta.Name.Text == "" {
+		return jid.User
+	}
+	return info.ThreadMeta.Name.Text
+}
+
+// WaGetNewsletterLink returns the invite link of a channel, or the link to the
+// channels page if it can't be fetched
+func WaGetNewsletterLink(jid types.JID) string {
+	info, err := waGetNewsletterInfo(jid)
+	if err != nil || info.ThreadMeta.InviteCode == "" {
+		return newsletterLinkPrefix
+	}
+	return newsletterLinkPrefix + info.ThreadMeta.InviteCode
+}
+
+// WaNewsletterFollow follows the channel of an invite link or code and creates
+// the topic its posts are bridged to
+func WaNewsletterFollow(invite string) (*types.NewsletterMetadata, error) {
+	var (
+		cfg      = state.State.Config
+		waClient = state.State.WhatsAppClient
+	)
+
+	info, err := waClient.GetNewsletterInfoWithInvite(WaNewsletterInviteKey(invite))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel info : %s", err)
+	}
+	newsletterCache.Store(info.ID.String(), info)
+
+	if err = waClient.FollowNewsletter(info.ID); err != nil {
+		return nil, fmt.Errorf("failed to follow channel : %s", err)
+	}
+
+	if _, err = TgGetOrMakeThreadFromWa(info.ID.String(), cfg.Telegram.TargetChatID, WaGetNewsletterName(info.ID)); err != nil {
+		return info, fmt.Errorf("followed the channel but failed to create its topic : %s", err)
+	}
+	return info, nil
+}
+
+func WaNewsletterUnfollow(jid types.JID) error {
+	if jid.Server != types.NewsletterServer {
+		return fmt.Errorf("'%s' is not a channel", jid.String())
+	}
+
+	if err := state.State.WhatsAppClient.UnfollowNewsletter(jid); err != nil {
+		return err
+	}
+	newsletterCache.Delete(jid.String())
+	return nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func waFormatAmount1000(amount1000 int64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", float64(amount1000)/1000, currency))
+}
+
+func waFormatMoney(money *waProto.Money) string {
+	value := float64(money.GetValue()) / math.Pow10(int(money.GetOffset()))
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", value, money.GetCurrencyCode()))
+}
+
+func waNoteText(note *waProto.Message) string {
+	if text := note.GetExtendedTextMessage().GetText(); text != "" {
+		return text
+	}
+	return note.GetConversation()
+}
+
+func waFormatTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).In(state.State.LocalLocation).Format(state.State.Config.TimeFormat)
+}
+
+// WaFormatPaymentMessage renders payment, payment request, order and invoice
+// messages as text for Telegram, also returning the thumbnail to send it with.
+// The last value is false if the message is none of these.
+func WaFormatPaymentMessage(msg *waProto.Message) (string, []byte, bool) {
+	var (
+		text      string
+		note      string
+		thumbnail []byte
+	)
+
+	if paymentMsg := msg.GetSendPaymentMessage(); paymentMsg != nil {
+		text = "<b>Sent a payment</b>\n"
+		note = waNoteText(paymentMsg.GetNoteMessage())
+
+	} else if requestMsg := msg.GetRequestPaymentMessage(); requestMsg != nil {
+		text = "<b>Requested a payment</b>\n"
+		if requestMsg.GetAmount() != nil {
+			text += fmt.Sprintf("Amount: <code>%s</code>\n", html.EscapeString(waFormatMoney(requestMsg.GetAmount())))
+		} else {
+			text += fmt.Sprintf("Amount: <code>%s</code>\n",
+				html.EscapeString(waFormatAmount1000(int64(requestMsg.GetAmount1000()), requestMsg.GetCurrencyCodeIso4217())))
+		}
+		if requestFrom := requestMsg.GetRequestFrom(); requestFrom != "" {
+			if jid, err := types.ParseJID(requestFrom); err == nil {
+				text += fmt.Sprintf("From: %s\n", html.EscapeString(WaGetContactName(jid)))
+			}
+		}
+		if expiry := requestMsg.GetExpiryTimestamp(); expiry != 0 {
+			text += fmt.Sprintf("Expires: <i>%s</i>\n", html.EscapeString(waFormatTime(expiry)))
+		}
+		note = waNoteText(requestMsg.GetNoteMessage())
+
+	} else if msg.GetDeclinePaymentRequestMessage() != nil {
+		text = "<b>Declined the payment request</b>\n"
+
+	} else if msg.GetCancelPaymentRequestMessage() != nil {
+		text = "<b>Cancelled the payment request</b>\n"
+
+	} else if inviteMsg := msg.GetPaymentInviteMessage(); inviteMsg != nil {
+		text = "<b>Invited you to use WhatsApp payments</b>\n"
+		if expiry := inviteMsg.GetExpiryTimestamp(); expiry != 0 {
+			text += fmt.Sprintf("Expires: <i>%s</i>\n", html.EscapeString(waFormatTime(expiry)))
+		}
+
+	} else if orderMsg := msg.GetOrderMessage(); orderMsg != nil {
+		text = "<b>Order</b>"
+		if title := orderMsg.GetOrderTitle(); title != "" {
+			text += fmt.Sprintf(": %s", html.EscapeString(title))
+		}
+		text += "\n"
+		text += fmt.Sprintf("Items: %d\n", orderMsg.GetItemCount())
+		if orderMsg.TotalAmount1000 != nil {
+			text += fmt.Sprintf("Total: <code>%s</code>\n",
+				html.EscapeString(waFormatAmount1000(orderMsg.GetTotalAmount1000(), orderMsg.GetTotalCurrencyCode())))
+		}
+		if orderMsg.Status != nil {
+			status := strings.ToLower(strings.ReplaceAll(orderMsg.GetStatus().String(), "_", " "))
+			text += fmt.Sprintf("Status: %s\n", html.EscapeString(status))
+		}
+		note = orderMsg.GetMessage()
+		thumbnail = orderMsg.GetThumbnail()
+
+	} else if invoiceMsg := msg.GetInvoiceMessage(); invoiceMsg != nil {
+		text = "<b>Invoice</b>\n"
+		if invoiceMsg.GetAttachmentDirectPath() != "" {
+			text += fmt.Sprintf("Attachment: %s\n", strings.ToLower(invoiceMsg.GetAttachmentType().String()))
+		}
+		note = invoiceMsg.GetNote()
+		thumbnail = invoiceMsg.GetAttachmentJpegThumbnail()
+
+	} else {
+		return "", nil, false
+	}
+
+	if note != "" {
+		text += "\n" + html.EscapeString(note)
+	}
+	return text, thumbnail, true
+}
+
+// WaFormatProductMessage renders a product shared from a business catalog as
+// a caption with its title, price and links
+func WaFormatProductMessage(productMsg *waProto.ProductMessage) string {
+	var (
+		product = productMsg.GetProduct()
+		text    string
+	)
+
+	text += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(product.GetTitle()))
+	if description := product.GetDescription(); description != "" {
+		text += html.EscapeString(description) + "\n"
+	}
+
+	if product.PriceAmount1000 != nil {
+		price := waFormatAmount1000(product.GetPriceAmount1000(), product.GetCurrencyCode())
+		if product.SalePriceAmount1000 != nil {
+			text += fmt.Sprintf("Price: <s>%s</s> <code>%s</code>\n", html.EscapeString(price),
+				html.EscapeString(waFormatAmount1000(product.GetSalePriceAmount1000(), product.GetCurrencyCode())))
+		} else {
+			text += fmt.Sprintf("Price: <code>%s</code>\n", html.EscapeString(price))
+		}
+	}
+
+	if body := productMsg.GetBody(); body != "" {
+		text += "\n" + html.EscapeString(body) + "\n"
+	}
+	if footer := productMsg.GetFooter(); footer != "" {
+		text += fmt.Sprintf("<i>%s</i>\n", html.EscapeString(footer))
+	}
+
+	if owner, err := types.ParseJID(productMsg.GetBusinessOwnerJid()); err == nil && owner.User != "" {
+		productURL := product.GetUrl()
+		if productURL == "" && product.GetProductId() != "" {
+			productURL = fmt.Sprintf("https://wa.me/p/%s/%s", product.GetProductId(), owner.User)
+		}
+		if productURL != "" {
+			text += fmt.Sprintf("\n<a href=\"%s\">View product</a> | ", html.EscapeString(productURL))
+		} else {
+			text += "\n"
+		}
+		text += fmt.Sprintf("<a href=\"https://wa.me/c/%s\">View catalog</a>", owner.User)
+	}
+
+	return text
+}
@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// Limits of native Telegram polls, polls beyond them are bridged as text
+const (
+	tgPollMaxQuestionLength = 300
+	tgPollMaxOptionLength   = 100
+	tgPollMinOptions        = 2
+	tgPollMaxOptions        = 10
+)
+
+// WaPollOptionNames returns the names of the options of the poll, which is
+// what votes refer to
+func WaPollOptionNames(pollMsg *waProto.PollCreationMessage) []string {
+	options := make([]string, 0, len(pollMsg.GetOptions()))
+	for _, option := range pollMsg.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	return options
+}
+
+func tgPollFitsNative(name string, options []string) bool {
+	if name == "" || utf8.RuneCountInString(name) > tgPollMaxQuestionLength ||
+		len(options) < tgPollMinOptions || len(options) > tgPollMaxOptions {
+		return false
+	}
+	for _, option := range options {
+		if option == "" || utf8.RuneCountInString(option) > tgPollMaxOptionLength {
+			return false
+		}
+	}
+	return true
+}
+
+// TgMakePollKeyboard builds the keyboard to show the results of a poll
+func TgMakePollKeyboard(waMsgId string) gotgbot.InlineKeyboardMarkup {
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+			Text:         "📊 Results",
+			CallbackData: "pollresults_" + waMsgId,
+		}}},
+	}
+}
+
+// TgBridgePoll sends a WhatsApp poll to Telegram, as a native poll which can
+// be voted on from Telegram if it fits in one and as text otherwise, and keeps
+// it for counting the votes. bridgedText is the header of the message.
+func TgBridgePoll(tgBot state.TelegramAPI, info *types.MessageInfo, pollMsg *waProto.PollCreationMessage,
+	bridgedText string, threadId, replyToMsgId int64) (*gotgbot.Message, error) {
+
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		options  = WaPollOptionNames(pollMsg)
+		keyboard = TgMakePollKeyboard(info.ID)
+		sentMsg  *gotgbot.Message
+		err      error
+	)
+	defer logger.Sync()
+
+	if tgPollFitsNative(pollMsg.GetName(), options) {
+		var headerSent bool
+		if replyToMsgId, headerSent = TgSendHeader(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId, bridgedText); headerSent {
+			bridgedText = ""
+		}
+
+		sentMsg, err = tgBot.SendPoll(cfg.Telegram.TargetChatID, pollMsg.GetName(), options, &gotgbot.SendPollOpts{
+			MessageThreadId:       threadId,
+			IsAnonymous:           false,
+			Type:                  "regular",
+			AllowsMultipleAnswers: pollMsg.GetSelectableOptionsCount() != 1,
+			ReplyToMessageId:      replyToMsgId,
+			ReplyMarkup:           keyboard,
+		})
+		if err != nil {
+			logger.Warn("failed to send native poll, sending it as text",
+				zap.String("msg_id", info.ID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if sentMsg == nil {
+		bridgedText += fmt.Sprintf("%s(<b>%v</b>)\n",
+			html.EscapeString(pollMsg.GetName()), pollMsg.GetSelectableOptionsCount())
+		for optionNum, option := range options {
+			if len(bridgedText) > 4000 {
+				bridgedText += "\n..."
+				break
+			}
+			bridgedText += fmt.Sprintf("%v. %s\n", optionNum+1, html.EscapeString(option))
+		}
+
+		sentMsg, err = tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			ReplyToMessageId: replyToMsgId,
+			MessageThreadId:  threadId,
+			ReplyMarkup:      keyboard,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	optionsBytes, _ := json.Marshal(options)
+	poll := &database.WaPoll{
+		ID:              info.ID,
+		WaChatId:        info.Chat.String(),
+		SenderId:        info.Sender.ToNonAD().String(),
+		IsFromMe:        info.IsFromMe,
+		Name:            pollMsg.GetName(),
+		Options:         optionsBytes,
+		SelectableCount: pollMsg.GetSelectableOptionsCount(),
+		TgChatId:        sentMsg.Chat.Id,
+		TgThreadId:      sentMsg.MessageThreadId,
+		TgMsgId:         sentMsg.MessageId,
+		CreatedAt:       time.Now().UTC(),
+	}
+	if sentMsg.Poll != nil {
+		poll.TgPollId = sentMsg.Poll.Id
+	}
+	return sentMsg, database.WaPollAdd(poll)
+}
+
+func waPollOptions(poll database.WaPoll) []string {
+	var options []string
+	_ = json.Unmarshal(poll.Options, &options)
+	return options
+}
+
+// WaPollRecordVote stores the vote of someone on a poll, given as the hashes
+// of the chosen options like they are in a PollUpdate message
+func WaPollRecordVote(pollId string, voter types.JID, optionHashes [][]byte, votedAt time.Time) error {
+	poll, found, err := database.WaPollGet(pollId)
+	if err != nil {
+		return err
+	} else if !found {
+		return nil
+	}
+
+	var chosen []string
+	for _, option := range waPollOptions(poll) {
+		optionHash := sha256.Sum256([]byte(option))
+		for _, hash := range optionHashes {
+			if bytes.Equal(hash, optionHash[:]) {
+				chosen = append(chosen, option)
+				break
+			}
+		}
+	}
+
+	chosenBytes, _ := json.Marshal(chosen)
+	return database.WaPollVoteSave(&database.WaPollVote{
+		PollId:  pollId,
+		VoterId: voter.ToNonAD().String(),
+		Options: chosenBytes,
+		VotedAt: votedAt,
+	})
+}
+
+// WaSendPollVote votes on WhatsApp with the options chosen in the Telegram
+// poll, an empty choice retracts the vote. Returns the poll, the last value is
+// false if the Telegram poll isn't a bridged one.
+func WaSendPollVote(tgPollId string, optionIds []int64) (database.WaPoll, bool, error) {
+	waClient := state.State.WhatsAppClient
+	waSender := state.State.WhatsAppSender
+
+	poll, found, err := database.WaPollGetByTgPoll(tgPollId)
+	if err != nil || !found {
+		return poll, found, err
+	}
+
+	if poll.SelectableCount > 1 && len(optionIds) > int(poll.SelectableCount) {
+		return poll, true, fmt.Errorf("only %d options can be chosen in this poll", poll.SelectableCount)
+	}
+
+	var (
+		options = waPollOptions(poll)
+		chosen  []string
+	)
+	for _, optionId := range optionIds {
+		if optionId < 0 || int(optionId) >= len(options) {
+			return poll, true, errors.New("the chosen option doesn't exist in the WhatsApp poll")
+		}
+		chosen = append(chosen, options[optionId])
+	}
+
+	chat, _ := WaParseJID(poll.WaChatId)
+	sender, _ := WaParseJID(poll.SenderId)
+	voteMsg, err := waClient.BuildPollVote(&types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   sender,
+			IsFromMe: poll.IsFromMe,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+		ID: poll.ID,
+	}, chosen)
+	if err != nil {
+		return poll, true, err
+	}
+	if _, err = waSender.SendMessage(context.Background(), chat, voteMsg); err != nil {
+		return poll, true, err
+	}
+
+	// WhatsApp doesn't send your own votes back to you
+	chosenBytes, _ := json.Marshal(chosen)
+	err = database.WaPollVoteSave(&database.WaPollVote{
+		PollId:  poll.ID,
+		VoterId: waClient.Store.ID.ToNonAD().String(),
+		Options: chosenBytes,
+		VotedAt: time.Now().UTC(),
+	})
+	return poll, true, err
+}
+
+// WaPollResults renders the number of votes of each option of the poll, as
+// counted from the latest vote of everyone, along with who voted for it if
+// show_voters is on and they fit in a message
+func WaPollResults(poll database.WaPoll) (string, error) {
+	cfg := state.State.Config
+
+	votes, err := database.WaPollVoteGetAll(poll.ID)
+	if err != nil {
+		return "", err
+	}
+
+	chat, _ := WaParseJID(poll.WaChatId)
+	if cfg.WhatsApp.PollResults.ShowVoters && chat.Server != types.NewsletterServer {
+		if results := waPollRenderResults(poll, votes, true); utf8.RuneCountInString(results) <= 4096 {
+			return results, nil
+		}
+	}
+	return waPollRenderResults(poll, votes, false), nil
+}
+
+func waPollRenderResults(poll database.WaPoll, votes []database.WaPollVote, showVoters bool) string {
+	var (
+		cfg        = state.State.Config
+		waClient   = state.State.WhatsAppClient
+		options    = waPollOptions(poll)
+		voterNames = make(map[string][]string, len(options))
+		voters     int
+	)
+
+	for _, vote := range votes {
+		var chosen []string
+		if json.Unmarshal(vote.Options, &chosen) != nil || len(chosen) == 0 {
+			continue
+		}
+		voters += 1
+
+		voterName := cfg.WhatsApp.MyMessagesLabel
+		if voter, _ := WaParseJID(vote.VoterId); waClient == nil || waClient.Store.ID == nil ||
+			voter.User != waClient.Store.ID.User {
+			voterName = WaGetContactName(voter)
+		}
+		for _, option := range chosen {
+			voterNames[option] = append(voterNames[option], voterName)
+		}
+	}
+
+	results := fmt.Sprintf("📊 <b>Results of</b> %s\n\n", html.EscapeString(poll.Name))
+	for _, option := range options {
+		count, percentage := len(voterNames[option]), 0
+		if voters > 0 {
+			percentage = count * 100 / voters
+		}
+		results += fmt.Sprintf("<b>%s</b>: %d (%d%%)\n", html.EscapeString(option), count, percentage)
+		if showVoters && count > 0 {
+			results += fmt.Sprintf("<i>%s</i>\n", html.EscapeString(strings.Join(voterNames[option], ", ")))
+		}
+	}
+	results += fmt.Sprintf("\n<i>%d voted</i>", voters)
+
+	return results
+}
+
+// TgUpdatePollResults edits the results message of the poll with the current
+// results, posting it as a reply to the poll if there is none yet
+func TgUpdatePollResults(tgBot state.TelegramAPI, poll database.WaPoll) error {
+	results, err := WaPollResults(poll)
+	if err != nil {
+		return err
+	}
+
+	if poll.ResultsMsgId != 0 {
+		_, _, err = tgBot.EditMessageText(results, &gotgbot.EditMessageTextOpts{
+			ChatId:    poll.TgChatId,
+			MessageId: poll.ResultsMsgId,
+		})
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			return nil
+		}
+	}
+
+	resultsMsg, err := tgBot.SendMessage(poll.TgChatId, results, &gotgbot.SendMessageOpts{
+		MessageThreadId:     poll.TgThreadId,
+		ReplyToMessageId:    poll.TgMsgId,
+		DisableNotification: true,
+	})
+	if err != nil {
+		return err
+	}
+	return database.WaPollSetResultsMsg(poll.ID, resultsMsg.MessageId)
+}
+
+// TgRefreshPollResults updates the results of the polls which got votes since
+// they were last updated
+func TgRefreshPollResults() {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	polls, err := database.WaPollGetStale()
+	if err != nil {
+		logger.Error("failed to get polls with new votes", zap.Error(err))
+		return
+	}
+
+	for _, poll := range polls {
+		// Cleared first, so that votes arriving meanwhile mark it again
+		if err = database.WaPollSetResultsStale(poll.ID, false); err != nil {
+			logger.Error("failed to mark poll results as updated", zap.Error(err))
+			continue
+		}
+		if err = TgUpdatePollResults(tgBot, poll); err != nil {
+			logger.Warn("failed to update poll results",
+				zap.String("poll_id", poll.ID),
+				zap.String("chat_jid", poll.WaChatId),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// ReminderParseChat parses the chat of a reminder, which can also be given
+// without the server like in the rest of the config. Phone numbers have at
+// most 15 digits, longer IDs and ones with a dash are groups.
+func ReminderParseChat(chat string) (types.JID, bool) {
+	chat = strings.TrimSpace(chat)
+	if chat == "" {
+		return types.EmptyJID, false
+	}
+	if !strings.Contains(chat, "@") && (strings.Contains(chat, "-") || len(strings.TrimPrefix(chat, "+")) > 15) {
+		return types.NewJID(chat, types.GroupServer), true
+	}
+	return WaParseJID(chat)
+}
+
+func reminderConfigTag(idx int) string {
+	return fmt.Sprintf("reminder_config_%d", idx)
+}
+
+func reminderTag(id uint) string {
+	return fmt.Sprintf("reminder_%d", id)
+}
+
+// RemindersSchedule adds the reminders of the config and the ones added with
+// /remind to the scheduler
+func RemindersSchedule() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	for idx, reminder := range cfg.Reminders {
+		chat, ok := ReminderParseChat(reminder.Chat)
+		if !ok {
+			logger.Error("invalid chat of reminder in config",
+				zap.Int("index", idx),
+				zap.String("chat", reminder.Chat),
+			)
+			continue
+		}
+		if err := reminderSchedule(reminderConfigTag(idx), reminder.Cron, chat, reminder.Text); err != nil {
+			logger.Error("failed to schedule reminder from config",
+				zap.Int("index", idx),
+				zap.String("cron", reminder.Cron),
+				zap.Error(err),
+			)
+		}
+	}
+
+	reminders, err := database.ReminderGetAll()
+	if err != nil {
+		logger.Error("failed to get reminders from database", zap.Error(err))
+		return
+	}
+	for _, reminder := range reminders {
+		if err := ReminderSchedule(reminder); err != nil {
+			logger.Error("failed to schedule reminder",
+				zap.Uint("id", reminder.ID),
+				zap.String("cron", reminder.Cron),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// ReminderSchedule adds a reminder stored in the database to the scheduler
+func ReminderSchedule(reminder database.Reminder) error {
+	chat, ok := WaParseJID(reminder.WaChatId)
+	if !ok {
+		return fmt.Errorf("invalid chat : %s", reminder.WaChatId)
+	}
+	return reminderSchedule(reminderTag(reminder.ID), reminder.Cron, chat, reminder.Text)
+}
+
+// ReminderUnschedule removes a reminder stored in the database from the
+// scheduler
+func ReminderUnschedule(id uint) error {
+	return state.State.Scheduler.RemoveByTag(reminderTag(id))
+}
+
+// ReminderNextRun returns when the reminder is sent next, formatted for
+// showing to the user
+func ReminderNextRun(id uint) string {
+	jobs, err := state.State.Scheduler.FindJobsByTag(reminderTag(id))
+	if err != nil || len(jobs) == 0 {
+		return "not scheduled"
+	}
+	return jobs[0].NextRun().In(state.State.LocalLocation).Format(state.State.Config.TimeFormat)
+}
+
+func reminderSchedule(tag, cron string, chat types.JID, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("empty text")
+	}
+	_, err := state.State.Scheduler.Cron(CronInLocalTime(cron)).Tag(tag).Do(reminderSend, chat, text)
+	return err
+}
+
+// reminderSend sends the reminder and posts it to the topic of the chat, so
+// that it shows up there like any other message sent from Telegram
+func reminderSend(chat types.JID, text string) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = TgSenderFor(chat.String())
+		waClient = state.State.WhatsAppClient
+	)
+	defer logger.Sync()
+
+	sentMsg, err := WaSendText(chat, text, "", "", nil, false)
+	if err != nil {
+		logger.Error("failed to send reminder",
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+		TgReportError(fmt.Sprintf("Failed to send a reminder to <code>%s</code>", html.EscapeString(chat.String())), err)
+		return
+	}
+	ArchiveMessage(sentMsg.ID, chat, *waClient.Store.ID, waClient.Store.PushName, true, nil, text, sentMsg.Timestamp)
+
+	threadId, err := TgGetOrMakeThreadFromWa(chat.String(), cfg.Telegram.TargetChatID, TgGetTopicNameForWa(chat.String()))
+	if err != nil {
+		logger.Warn("failed to get topic to post sent reminder to",
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	sentNotice, err := tgBot.SendMessage(cfg.Telegram.TargetChatID,
+		fmt.Sprintf("⏰ <b>Reminder sent</b>\n\n%s", html.EscapeString(text)),
+		&gotgbot.SendMessageOpts{MessageThreadId: threadId})
+	if err != nil {
+		logger.Warn("failed to post sent reminder",
+			zap.String("chat_jid", chat.String()),
+			zap.Error(err),
+		)
+		return
+	}
+	database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), chat.String(),
+		cfg.Telegram.TargetChatID, sentNotice.MessageId, sentNotice.MessageThreadId)
+}
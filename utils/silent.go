@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"golang.org/x/exp/slices"
+)
+
+// WaChatIsSilent reports whether the chat is in silent_chats or was made
+// silent with /silent
+func WaChatIsSilent(waChatId string) bool {
+	if jid, ok := WaParseJID(waChatId); ok && slices.Contains(state.State.Config.WhatsApp.SilentChats, jid.User) {
+		return true
+	}
+	_, found, _ := database.SilentChatGet(waChatId)
+	return found
+}
+
+// TgSenderFor returns the Telegram sender to bridge the messages of the chat
+// with, which sends them without a notification if the chat is silent or
+// muted on the phone, and copies them to its fan_out destinations. Everything
+// posted to the topic of a chat, updates about it included, is sent with it.
+func TgSenderFor(waChatId string) state.TelegramAPI {
+	var (
+		sender = state.State.TelegramSender
+		silent = WaChatIsSilent(waChatId) || WaChatIsMuted(waChatId)
+	)
+	if silent {
+		sender = tgSilentSender{sender}
+	}
+	if tgFanOutEnabled(waChatId) {
+		sender = tgFanOutSender{sender, waChatId, silent}
+	}
+	return sender
+}
+
+// tgSilentSender sets disable_notification on everything it sends
+type tgSilentSender struct {
+	state.TelegramAPI
+}
+
+func (s tgSilentSender) SendMessage(chatId int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendMessageOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendMessage(chatId, text, &sendOpts)
+}
+
+func (s tgSilentSender) SendPhoto(chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendPhotoOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendPhoto(chatId, photo, &sendOpts)
+}
+
+func (s tgSilentSender) SendMediaGroup(chatId int64, media []gotgbot.InputMedia, opts *gotgbot.SendMediaGroupOpts) ([]gotgbot.Message, error) {
+	sendOpts := gotgbot.SendMediaGroupOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendMediaGroup(chatId, media, &sendOpts)
+}
+
+func (s tgSilentSender) SendVideo(chatId int64, video gotgbot.InputFile, opts *gotgbot.SendVideoOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendVideoOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendVideo(chatId, video, &sendOpts)
+}
+
+func (s tgSilentSender) SendVideoNote(chatId int64, videoNote gotgbot.InputFile, opts *gotgbot.SendVideoNoteOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendVideoNoteOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendVideoNote(chatId, videoNote, &sendOpts)
+}
+
+func (s tgSilentSender) SendAnimation(chatId int64, animation gotgbot.InputFile, opts *gotgbot.SendAnimationOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendAnimationOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendAnimation(chatId, animation, &sendOpts)
+}
+
+func (s tgSilentSender) SendAudio(chatId int64, audio gotgbot.InputFile, opts *gotgbot.SendAudioOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendAudioOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendAudio(chatId, audio, &sendOpts)
+}
+
+func (s tgSilentSender) SendDocument(chatId int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendDocumentOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendDocument(chatId, document, &sendOpts)
+}
+
+func (s tgSilentSender) SendSticker(chatId int64, sticker gotgbot.InputFile, opts *gotgbot.SendStickerOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendStickerOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendSticker(chatId, sticker, &sendOpts)
+}
+
+func (s tgSilentSender) SendContact(chatId int64, phoneNumber string, firstName string, opts *gotgbot.SendContactOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendContactOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendContact(chatId, phoneNumber, firstName, &sendOpts)
+}
+
+func (s tgSilentSender) SendLocation(chatId int64, latitude float64, longitude float64, opts *gotgbot.SendLocationOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendLocationOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendLocation(chatId, latitude, longitude, &sendOpts)
+}
+
+func (s tgSilentSender) SendPoll(chatId int64, question string, options []string, opts *gotgbot.SendPollOpts) (*gotgbot.Message, error) {
+	sendOpts := gotgbot.SendPollOpts{}
+	if opts != nil {
+		sendOpts = *opts
+	}
+	sendOpts.DisableNotification = true
+	return s.TelegramAPI.SendPoll(chatId, question, options, &sendOpts)
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/database"
+)
+
+// chatStats holds the counters recorded since they were last written to the
+// database
+var (
+	chatStatsLock sync.Mutex
+	chatStats     = make(map[string]*database.ChatStat)
+)
+
+func statsUpdate(chat string, update func(*database.ChatStat)) {
+	chatStatsLock.Lock()
+	defer chatStatsLock.Unlock()
+
+	stats, found := chatStats[chat]
+	if !found {
+		stats = &database.ChatStat{ID: chat, Since: time.Now().UTC()}
+		chatStats[chat] = stats
+	}
+	update(stats)
+}
+
+// StatsRecordUndecryptable counts a message that could not be decrypted,
+// isUnavailable is set when no ciphertext was received for this device at all
+func StatsRecordUndecryptable(chat string, isUnavailable bool) {
+	statsUpdate(chat, func(s *database.ChatStat) {
+		if isUnavailable {
+			s.Unavailable += 1
+		} else {
+			s.Undecryptable += 1
+		}
+	})
+}
+
+// StatsRecordRetryRecovered counts a message that was decrypted only after
+// sending retry receipts
+func StatsRecordRetryRecovered(chat string) {
+	statsUpdate(chat, func(s *database.ChatStat) {
+		s.RetryRecovered += 1
+	})
+}
+
+func StatsRecordMediaDownload(chat string, failed bool) {
+	statsUpdate(chat, func(s *database.ChatStat) {
+		s.MediaDownloads += 1
+		if failed {
+			s.MediaFailures += 1
+		}
+	})
+}
+
+// StatsRecordMediaStoreHit counts media loaded from the media store, which
+// was not downloaded again
+func StatsRecordMediaStoreHit(chat string) {
+	statsUpdate(chat, func(s *database.ChatStat) {
+		s.MediaStoreHits += 1
+	})
+}
+
+// StatsFlushPending adds the counters recorded since the last flush to the
+// database, they are kept for the next flush if the write fails
+func StatsFlushPending() error {
+	chatStatsLock.Lock()
+	pending := chatStats
+	chatStats = make(map[string]*database.ChatStat)
+	chatStatsLock.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	stats := make([]database.ChatStat, 0, len(pending))
+	for _, stat := range pending {
+		stats = append(stats, *stat)
+	}
+	err := database.ChatStatAdd(stats)
+	if err == nil {
+		return nil
+	}
+
+	chatStatsLock.Lock()
+	defer chatStatsLock.Unlock()
+
+	for chat, stat := range pending {
+		if newer, found := chatStats[chat]; found {
+			statsAdd(stat, *newer)
+		}
+		chatStats[chat] = stat
+	}
+	return err
+}
+
+// StatsGetAll returns the counters of every chat, those in the database along
+// with the ones not written yet
+func StatsGetAll() (map[string]database.ChatStat, error) {
+	stored, err := database.ChatStatGetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]database.ChatStat, len(stored))
+	for _, stat := range stored {
+		all[stat.ID] = stat
+	}
+
+	chatStatsLock.Lock()
+	defer chatStatsLock.Unlock()
+
+	for chat, pending := range chatStats {
+		stat, found := all[chat]
+		if !found {
+			stat = database.ChatStat{ID: chat, Since: pending.Since}
+		}
+		statsAdd(&stat, *pending)
+		all[chat] = stat
+	}
+	return all, nil
+}
+
+func statsAdd(to *database.ChatStat, stat database.ChatStat) {
+	to.Undecryptable += stat.Undecryptable
+	to.Unavailable += stat.Unavailable
+	to.RetryRecovered += stat.RetryRecovered
+	to.MediaDownloads += stat.MediaDownloads
+	to.MediaFailures += stat.MediaFailures
+	to.MediaStoreHits += stat.MediaStoreHits
+}
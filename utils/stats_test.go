@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"watgbridge/fakes"
+)
+
+func TestStatsPersisted(t *testing.T) {
+	if _, err := fakes.NewHarness(); err != nil {
+		t.Fatal(err)
+	}
+	const chat = "10000000002@s.whatsapp.net"
+
+	StatsRecordUndecryptable(chat, false)
+	StatsRecordMediaDownload(chat, true)
+	if err := StatsFlushPending(); err != nil {
+		t.Fatal(err)
+	}
+	StatsRecordUndecryptable(chat, false)
+	StatsRecordRetryRecovered(chat)
+	StatsRecordMediaStoreHit(chat)
+
+	all, err := StatsGetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := all[chat]; stats.Undecryptable != 2 || stats.RetryRecovered != 1 || stats.MediaFailures != 1 || stats.MediaStoreHits != 1 {
+		t.Errorf("stats before the second flush are %+v", stats)
+	}
+
+	if err = StatsFlushPending(); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = StatsGetAll(); all[chat].Undecryptable != 2 || all[chat].MediaDownloads != 1 {
+		t.Errorf("stats after the second flush are %+v", all[chat])
+	}
+}
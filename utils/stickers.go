@@ -95,6 +95,86 @@ func WebmConvertToWebp(webmStickerData []byte, scale, pad string, updateId int64
 	return outputData, nil
 }
 
+// AnimationConvertToMp4 converts an animation in another format, like a GIF,
+// to the silent H.264 MP4 that WhatsApp plays as a GIF
+func AnimationConvertToMp4(animationData []byte, updateId int64) ([]byte, error) {
+
+	var (
+		currTime   = strconv.FormatInt(updateId, 10)
+		currPath   = path.Join("downloads", currTime)
+		inputPath  = path.Join(currPath, "input")
+		outputPath = path.Join(currPath, "output.mp4")
+	)
+
+	if state.State.Config.FfmpegExecutable == "" {
+		return nil, fmt.Errorf("ffmpeg executable is not set")
+	}
+
+	if err := os.MkdirAll(currPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(currPath)
+
+	if err := os.WriteFile(inputPath, animationData, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(state.State.Config.FfmpegExecutable,
+		"-i", inputPath,
+		"-an",
+		"-c:v", "libx264",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		outputPath,
+	)
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+
+	return os.ReadFile(outputPath)
+}
+
+// VideoConvertToSquare crops a video to a square in the middle and scales it
+// down to at most maxSize pixels, as Telegram only plays square video notes
+func VideoConvertToSquare(videoData []byte, maxSize int, updateId string) ([]byte, error) {
+
+	var (
+		currPath   = path.Join("downloads", updateId)
+		inputPath  = path.Join(currPath, "input")
+		outputPath = path.Join(currPath, "output.mp4")
+	)
+
+	if state.State.Config.FfmpegExecutable == "" {
+		return nil, fmt.Errorf("ffmpeg executable is not set")
+	}
+
+	if err := os.MkdirAll(currPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(currPath)
+
+	if err := os.WriteFile(inputPath, videoData, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(state.State.Config.FfmpegExecutable,
+		"-i", inputPath,
+		"-c:v", "libx264",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		"-vf", fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale='min(%d,iw)':-2", maxSize),
+		outputPath,
+	)
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+
+	return os.ReadFile(outputPath)
+}
+
 func WebpImagePad(inputData []byte, wPad, hPad int, updateId int64) ([]byte, error) {
 	webpDecoder, err := decoder.NewDecoder(bytes.NewBuffer(inputData), &decoder.Options{NoFancyUpsampling: true})
 	if err != nil {
@@ -1,15 +1,22 @@
 package utils
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"html"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"watgbridge/database"
 	"watgbridge/state"
@@ -38,15 +45,56 @@ func TgRegisterBotCommands(b *gotgbot.Bot, commands ...gotgbot.BotCommand) error
 	return err
 }
 
+// TgChatRoutedToGeneral reports whether the messages of a WhatsApp chat (or of
+// special topics like #Calls) are configured to go to the General topic
+func TgChatRoutedToGeneral(waChatId string) bool {
+	var (
+		cfg      = state.State.Config
+		waClient = state.State.WhatsAppClient
+		chatUser = strings.SplitN(waChatId, "@", 2)[0]
+	)
+
+	for _, chat := range cfg.Telegram.GeneralTopicChats {
+		if chat == waChatId || chat == chatUser {
+			return true
+		}
+		if chat == "self" && waClient != nil && waClient.Store.ID != nil &&
+			chatUser == waClient.Store.ID.User && strings.HasSuffix(waChatId, "@"+waTypes.DefaultUserServer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The only colors Telegram allows for the icons of topics
+var tgTopicIconColors = []int64{0x6FB9F0, 0xFFD67E, 0xCB86DB, 0x8EEE98, 0xFF93B2, 0xFB6F5F}
+
+// TgTopicIconColor picks the icon color of a chat's topic from its ID, so the
+// topic of a chat gets the same color whenever it is created
+func TgTopicIconColor(waChatId string) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(waChatId))
+	return tgTopicIconColors[hash.Sum32()%uint32(len(tgTopicIconColors))]
+}
+
 func TgGetOrMakeThreadFromWa(waChatId string, tgChatId int64, threadName string) (int64, error) {
+	waChatId = WaNormalizeChatId(waChatId)
+
+	if state.State.Config.Telegram.SingleStream || TgChatRoutedToGeneral(waChatId) {
+		return 0, nil
+	}
+
 	threadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, tgChatId)
 	if err != nil {
 		return 0, err
 	}
 
 	if !threadFound {
-		tgBot := state.State.TelegramBot
-		newForum, err := tgBot.CreateForumTopic(tgChatId, threadName, &gotgbot.CreateForumTopicOpts{})
+		tgBot := state.State.TelegramSender
+		newForum, err := tgBot.CreateForumTopic(tgChatId, TgTopicNameWithPin(waChatId, threadName), &gotgbot.CreateForumTopicOpts{
+			IconColor: TgTopicIconColor(waChatId),
+		})
 		if err != nil {
 			return 0, err
 		}
@@ -54,15 +102,89 @@ func TgGetOrMakeThreadFromWa(waChatId string, tgChatId int64, threadName string)
 		if err != nil {
 			return newForum.MessageThreadId, err
 		}
+		if jid, ok := WaParseJID(waChatId); ok && strings.Contains(waChatId, "@") && jid.Server != waTypes.BroadcastServer {
+			database.ActivityEventAdd(database.ActivityNewChat, waChatId, 0)
+			TgTopicAvatarUpdate(jid, newForum.MessageThreadId, nil, false, "")
+		}
 		return newForum.MessageThreadId, nil
 	}
 
 	return threadId, nil
 }
 
+// TgGetTopicNameForWa returns the name a topic is created with for the given
+// key of the chat thread pairs
+func TgGetTopicNameForWa(waChatId string) string {
+	if strings.HasPrefix(waChatId, "#") {
+		return waChatId
+	}
+	if waChatId == "status@broadcast" {
+		return "#Stories"
+	}
+	if poster, isStoryTopic := WaStoryTopicPoster(waChatId); isStoryTopic {
+		return WaGetStoryTopicName(poster)
+	}
+
+	jid, _ := WaParseJID(waChatId)
+	switch jid.Server {
+	case waTypes.GroupServer:
+		return WaGetGroupTopicName(jid)
+	case waTypes.NewsletterServer:
+		return WaGetNewsletterName(jid)
+	default:
+		return WaGetContactName(jid)
+	}
+}
+
+// TgTopicExists checks whether a topic is still there, as admins can delete
+// them without the bot being told. The Bot API has no method to get a topic,
+// so a chat action is sent to it instead.
+func TgTopicExists(b *gotgbot.Bot, tgChatId, tgThreadId int64) (bool, error) {
+	if tgThreadId == 0 {
+		return false, nil
+	}
+
+	_, err := b.SendChatAction(tgChatId, "typing", &gotgbot.SendChatActionOpts{
+		MessageThreadId: tgThreadId,
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	if TgIsThreadNotFoundError(err) {
+		return false, nil
+	}
+	if strings.Contains(err.Error(), "TOPIC_CLOSED") {
+		return true, nil
+	}
+	return false, err
+}
+
+// TgIsThreadNotFoundError reports whether the error is Telegram complaining
+// about a topic that doesn't exist anymore
+func TgIsThreadNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errText := err.Error()
+	return strings.Contains(errText, "thread not found") || strings.Contains(errText, "TOPIC_DELETED") ||
+		strings.Contains(errText, "TOPIC_ID_INVALID")
+}
+
 func TgDownloadByFilePath(b *gotgbot.Bot, filePath string) ([]byte, error) {
-	if state.State.Config.Telegram.SelfHostedAPI {
-		return os.ReadFile(filePath)
+	defer LagTrackMedia()()
+
+	// A local Bot API server gives the absolute path of the file on its disk
+	if state.State.Config.Telegram.SelfHostedAPI || filepath.IsAbs(filePath) {
+		data, err := os.ReadFile(filePath)
+		if err == nil {
+			MediaStoreSave(data, "")
+		}
+		if err == nil || !filepath.IsAbs(filePath) || !os.IsNotExist(err) {
+			return data, err
+		}
+		// The server runs somewhere the file can't be read from, ask it
+		// for the file instead
 	}
 
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/file/bot%s/%s",
@@ -85,9 +207,21 @@ func TgDownloadByFilePath(b *gotgbot.Bot, filePath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	MediaStoreSave(bodyBytes, "")
 	return bodyBytes, nil
 }
 
+// TgCutWord splits the text at its first whitespace into the first word and
+// the rest, with the whitespace around the rest trimmed
+func TgCutWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	idx := strings.IndexFunc(text, unicode.IsSpace)
+	if idx < 0 {
+		return text, ""
+	}
+	return text[:idx], strings.TrimSpace(text[idx:])
+}
+
 func TgReplyTextByContext(b *gotgbot.Bot, c *ext.Context, text string, buttons *gotgbot.InlineKeyboardMarkup) (*gotgbot.Message, error) {
 	sendOpts := &gotgbot.SendMessageOpts{
 		ReplyToMessageId: c.EffectiveMessage.MessageId,
@@ -102,25 +236,26 @@ func TgReplyTextByContext(b *gotgbot.Bot, c *ext.Context, text string, buttons *
 	return msg, err
 }
 
-func TgSendTextById(b *gotgbot.Bot, chatId int64, threadId int64, text string) error {
+func TgSendTextById(b state.TelegramAPI, chatId int64, threadId int64, text string) error {
 	_, err := b.SendMessage(chatId, text, &gotgbot.SendMessageOpts{
 		MessageThreadId: threadId})
 	return err
 }
 
+// TgUserIsAuthorized reports whether the user is the owner or a sudo user
+func TgUserIsAuthorized(userId int64) bool {
+	cfg := state.State.Config
+	return userId == cfg.Telegram.OwnerID || slices.Contains(cfg.Telegram.SudoUsersID, userId)
+}
+
 func TgUpdateIsAuthorized(b *gotgbot.Bot, c *ext.Context) bool {
-	var (
-		cfg         = state.State.Config
-		sender      = c.EffectiveSender.User
-		ownerID     = cfg.Telegram.OwnerID
-		sudoUsersID = cfg.Telegram.SudoUsersID
-	)
+	sender := c.EffectiveSender.User
 
-	if sender != nil &&
-		(slices.Contains(sudoUsersID, sender.Id) || sender.Id == ownerID) {
+	if sender != nil && TgUserIsAuthorized(sender.Id) {
 		return true
 	}
 
+	tgReportUnauthorized(c)
 	if c.CallbackQuery != nil {
 		c.CallbackQuery.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
 			Text:      "Not authorized to use this bot",
@@ -133,6 +268,8 @@ func TgUpdateIsAuthorized(b *gotgbot.Bot, c *ext.Context) bool {
 }
 
 func TgReplyWithErrorByContext(b *gotgbot.Bot, c *ext.Context, eMessage string, e error) error {
+	database.ActivityEventAdd(database.ActivityFailure, "", 0)
+
 	if c.CallbackQuery != nil {
 		_, err := c.CallbackQuery.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
 			Text:      eMessage + ":\n\n" + e.Error(),
@@ -153,17 +290,379 @@ func TgReplyWithErrorByContext(b *gotgbot.Bot, c *ext.Context, eMessage string,
 	return err
 }
 
-func TgSendErrorById(b *gotgbot.Bot, chatId, threadId int64, eMessage string, e error) error {
-	_, err := b.SendMessage(
-		chatId,
-		fmt.Sprintf("%s:\n\n<code>%s</code>", eMessage, html.EscapeString(e.Error())),
-		&gotgbot.SendMessageOpts{
-			MessageThreadId: threadId,
-		},
+// TgSendTextWithThumbnail sends the text as the caption of the given JPEG
+// thumbnail, used in place of media which could not be bridged. It falls back
+// to a plain text message if there is no thumbnail or sending it fails
+func TgSendTextWithThumbnail(b state.TelegramAPI, chatId, threadId, replyToMsgId int64, text string, thumbnail []byte) (*gotgbot.Message, error) {
+	if len(thumbnail) > 0 && utf8.RuneCountInString(text) <= 1024 {
+		sentMsg, err := b.SendPhoto(chatId, thumbnail, &gotgbot.SendPhotoOpts{
+			Caption:          text,
+			ReplyToMessageId: replyToMsgId,
+			MessageThreadId:  threadId,
+		})
+		if err == nil {
+			return sentMsg, nil
+		}
+	}
+
+	return b.SendMessage(chatId, text, &gotgbot.SendMessageOpts{
+		ReplyToMessageId: replyToMsgId,
+		MessageThreadId:  threadId,
+	})
+}
+
+// TgEditBridgedText replaces the text of a message sent by
+// TgSendTextWithThumbnail, which is its caption if it was sent with the
+// thumbnail
+func TgEditBridgedText(b state.TelegramAPI, sentMsg *gotgbot.Message, text string) error {
+	if len(sentMsg.Photo) > 0 {
+		_, _, err := b.EditMessageCaption(&gotgbot.EditMessageCaptionOpts{
+			ChatId:    sentMsg.Chat.Id,
+			MessageId: sentMsg.MessageId,
+			Caption:   text,
+		})
+		return err
+	}
+	_, _, err := b.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+		ChatId:    sentMsg.Chat.Id,
+		MessageId: sentMsg.MessageId,
+	})
+	return err
+}
+
+// tgDownloadThumbnail downloads the JPEG thumbnail of Telegram media to embed
+// in the WhatsApp message, nil if there is none or it can't be downloaded
+func tgDownloadThumbnail(b *gotgbot.Bot, thumbnail *gotgbot.PhotoSize) []byte {
+	if thumbnail == nil {
+		return nil
+	}
+	thumbnailFile, err := b.GetFile(thumbnail.FileId, nil)
+	if err != nil {
+		return nil
+	}
+	thumbnailBytes, err := TgDownloadByFilePath(b, thumbnailFile.FilePath)
+	if err != nil {
+		return nil
+	}
+	return thumbnailBytes
+}
+
+// TgSendHeader sends the header of a bridged message on its own, for media
+// which can't have a caption. Returns the message to reply to with the media,
+// which is the header if it was sent.
+func TgSendHeader(b state.TelegramAPI, chatId, threadId, replyToMsgId int64, header string) (int64, bool) {
+	if strings.TrimSpace(header) == "" {
+		return replyToMsgId, false
+	}
+	sentMsg, err := b.SendMessage(chatId, header, &gotgbot.SendMessageOpts{
+		ReplyToMessageId: replyToMsgId,
+		MessageThreadId:  threadId,
+	})
+	if err != nil {
+		return replyToMsgId, false
+	}
+	return sentMsg.MessageId, true
+}
+
+// TgThumbnailFile wraps a JPEG thumbnail embedded in a WhatsApp message to be
+// sent along with the media, nil is returned if there is none
+func TgThumbnailFile(thumbnail []byte) gotgbot.InputFile {
+	if len(thumbnail) == 0 {
+		return nil
+	}
+	return gotgbot.NamedFile{
+		FileName: "thumbnail.jpg",
+		File:     bytes.NewReader(thumbnail),
+	}
+}
+
+// TgCaptionText escapes a WhatsApp caption to be added to the caption of the
+// media on Telegram, cutting it if it is too long. If it is too long and
+// caption_overflow is "reply" it is left out instead, and returned as the
+// second value to be sent with TgSendCaptionOverflow.
+func TgCaptionText(caption string) (string, string) {
+	if len([]rune(caption)) <= 1020 {
+		return html.EscapeString(caption), ""
+	}
+	if state.State.Config.Telegram.CaptionOverflow == "reply" {
+		return "", caption
+	}
+	return html.EscapeString(SubString(caption, 0, 1020)) + "...", ""
+}
+
+// TgSendCaptionOverflow sends the full caption left out of the media message
+// as replies to it, split to fit in Telegram messages
+func TgSendCaptionOverflow(b state.TelegramAPI, mediaMsg *gotgbot.Message, caption string) error {
+	if mediaMsg == nil || mediaMsg.MessageId == 0 || caption == "" {
+		return nil
+	}
+	for _, chunk := range SplitText(caption, 4096) {
+		_, err := b.SendMessage(mediaMsg.Chat.Id, html.EscapeString(chunk), &gotgbot.SendMessageOpts{
+			ReplyToMessageId: mediaMsg.MessageId,
+			MessageThreadId:  mediaMsg.MessageThreadId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tgPhotoRejectedReasons are the errors of Telegram refusing the photo itself,
+// which a smaller photo or a document may get past
+var tgPhotoRejectedReasons = []string{
+	"PHOTO_INVALID_DIMENSIONS",
+	"PHOTO_SAVE_FILE_INVALID",
+	"IMAGE_PROCESS_FAILED",
+	"too big",
+}
+
+func tgIsPhotoRejected(err error) bool {
+	var tgErr *gotgbot.TelegramError
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	if tgErr.Code == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	for _, reason := range tgPhotoRejectedReasons {
+		if strings.Contains(tgErr.Description, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// TgSendPhotoWithFallback sends the photo, and if Telegram rejects it (for
+// extreme dimensions or size) retries with a scaled down copy and lastly as
+// a document of the original, which is the photo before it was processed
+// for Telegram. Other errors are returned without retrying.
+func TgSendPhotoWithFallback(b state.TelegramAPI, chatId int64, photo, original []byte, opts *gotgbot.SendPhotoOpts, mimetype string) (*gotgbot.Message, error) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	sentMsg, err := b.SendPhoto(chatId, photo, opts)
+	if err == nil || !tgIsPhotoRejected(err) {
+		return sentMsg, err
+	}
+	logger.Warn("telegram rejected photo, retrying with fallback",
+		zap.Int("size", len(photo)),
+		zap.Error(err),
+	)
+
+	if cfg.Telegram.PhotoFallbackSize > 0 {
+		if scaled, scaleErr := ImageDownscaleJPEG(photo, cfg.Telegram.PhotoFallbackSize, 0); scaleErr == nil {
+			sentMsg, err = b.SendPhoto(chatId, scaled, opts)
+			if err == nil || !tgIsPhotoRejected(err) {
+				return sentMsg, err
+			}
+		}
+	}
+
+	if len(original) == 0 {
+		original = photo
+	}
+
+	fileName := "photo.jpg"
+	if ext := strings.TrimPrefix(mimetype, "image/"); ext != mimetype && ext != "jpeg" && ext != "" {
+		fileName = "photo." + ext
+	}
+
+	return b.SendDocument(chatId, gotgbot.NamedFile{
+		FileName: fileName,
+		File:     bytes.NewReader(original),
+	}, &gotgbot.SendDocumentOpts{
+		Caption:          opts.Caption,
+		ReplyToMessageId: opts.ReplyToMessageId,
+		MessageThreadId:  opts.MessageThreadId,
+	})
+}
+
+// TgSendSystemNotice posts a notice about the bridge itself to the '#System'
+// topic
+func TgSendSystemNotice(text string) error {
+	var (
+		cfg   = state.State.Config
+		tgBot = state.State.TelegramSender
 	)
+
+	threadId, err := TgGetOrMakeThreadFromWa("#System", cfg.Telegram.TargetChatID, "#System")
+	if err != nil {
+		return err
+	}
+
+	return TgSendTextById(tgBot, cfg.Telegram.TargetChatID, threadId, text)
+}
+
+// TgSendStatusReply sends a text reply to a story to its poster, or reacts to
+// the story if the text is a single emoji
+func TgSendStatusReply(b *gotgbot.Bot, c *ext.Context, msgToForward *gotgbot.Message, poster waTypes.JID, stanzaId string) error {
+	var (
+		cfg       = state.State.Config
+		waClient  = state.State.WhatsAppClient
+		waSender  = state.State.WhatsAppSender
+		text      = msgToForward.Text
+		replyMsg  string
+		msgToSend *waProto.Message
+	)
+
+	if emojis := gomoji.CollectAll(text); len(emojis) == 1 && gomoji.RemoveEmojis(text) == "" {
+		replyMsg = "Successfully reacted to the story"
+		msgToSend = &waProto.Message{
+			ReactionMessage: &waProto.ReactionMessage{
+				Text:              proto.String(text),
+				SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+				Key: &waProto.MessageKey{
+					RemoteJid:   proto.String(waTypes.StatusBroadcastJID.String()),
+					FromMe:      proto.Bool(false),
+					Id:          proto.String(stanzaId),
+					Participant: proto.String(poster.String()),
+				},
+			},
+		}
+	} else {
+		replyMsg = "Successfully replied to the story"
+		msgToSend = &waProto.Message{
+			ExtendedTextMessage: &waProto.ExtendedTextMessage{
+				Text: proto.String(text),
+				ContextInfo: &waProto.ContextInfo{
+					StanzaId:      proto.String(stanzaId),
+					Participant:   proto.String(poster.String()),
+					RemoteJid:     proto.String(waTypes.StatusBroadcastJID.String()),
+					QuotedMessage: WaQuotedMessage(stanzaId, waTypes.StatusBroadcastJID, ""),
+				},
+			},
+		}
+	}
+
+	sentMsg, err := waSender.SendMessage(context.Background(), poster, msgToSend)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to send the reply to the story", err)
+	}
+
+	if msgToSend.ExtendedTextMessage != nil {
+		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), poster.String(),
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+		}
+		ArchiveMessage(sentMsg.ID, poster, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, text, sentMsg.Timestamp)
+	}
+
+	msg, err := TgReplyTextByContext(b, c, replyMsg, nil)
+	if err == nil {
+		go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
+			time.Sleep(15 * time.Second)
+			_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
+		}(b, msg)
+	}
 	return err
 }
 
+// TgTranslateMentions rewrites @mentions and text mentions of WhatsApp contacts
+// to the @number form WhatsApp expects, and returns the JIDs to mention
+func TgTranslateMentions(text string, entities []gotgbot.ParsedMessageEntity, chat waTypes.JID) (string, []string) {
+	mentions := []string{}
+
+	// Go backwards so the offsets of earlier entities stay valid
+	for idx := len(entities) - 1; idx >= 0; idx-- {
+		entity := entities[idx]
+		if entity.Type != "mention" && entity.Type != "text_mention" {
+			continue
+		}
+
+		jid, found := WaResolveMention(entity.Text, chat)
+		if !found {
+			continue
+		}
+
+		start, end := int(entity.Offset), int(entity.Offset+entity.Length)
+		if start < 0 || end > len(text) {
+			continue
+		}
+		text = text[:start] + "@" + jid.User + text[end:]
+		mentions = append([]string{jid.String()}, mentions...)
+	}
+
+	return text, mentions
+}
+
+// TgSetReaction reacts to the message with the emoji, which must be one of the
+// reactions Telegram allows
+func TgSetReaction(b *gotgbot.Bot, chatId, msgId int64, emoji string) error {
+	reaction, err := json.Marshal([]map[string]string{{"type": "emoji", "emoji": emoji}})
+	if err != nil {
+		return err
+	}
+	_, err = b.Request("setMessageReaction", map[string]string{
+		"chat_id":    strconv.FormatInt(chatId, 10),
+		"message_id": strconv.FormatInt(msgId, 10),
+		"reaction":   string(reaction),
+	}, nil, nil)
+	return err
+}
+
+// TgReactSendResult reacts to the message being sent to WhatsApp with the
+// success or failure reaction of send_confirmation
+func TgReactSendResult(b *gotgbot.Bot, c *ext.Context, sent bool) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		emoji  = cfg.Telegram.SendConfirmation.FailureReaction
+	)
+	defer logger.Sync()
+
+	if sent {
+		emoji = cfg.Telegram.SendConfirmation.SuccessReaction
+	}
+	if emoji == "" || c.EffectiveMessage == nil {
+		return
+	}
+
+	if err := TgSetReaction(b, c.EffectiveMessage.Chat.Id, c.EffectiveMessage.MessageId, emoji); err != nil {
+		logger.Warn("failed to react to message sent to WhatsApp",
+			zap.Int64("msg_id", c.EffectiveMessage.MessageId),
+			zap.Bool("sent", sent),
+			zap.Error(err),
+		)
+	}
+}
+
+// TgConfirmSent marks the message as sent to WhatsApp with the success
+// reaction and a reply deleted after a while, as set in send_confirmation
+func TgConfirmSent(b *gotgbot.Bot, c *ext.Context, text string, buttons *gotgbot.InlineKeyboardMarkup) {
+	TgReactSendResult(b, c, true)
+	if !state.State.Config.Telegram.SendConfirmation.SuccessReply {
+		return
+	}
+
+	msg, err := TgReplyTextByContext(b, c, text, buttons)
+	if err == nil {
+		go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
+			time.Sleep(15 * time.Second)
+			_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
+		}(b, msg)
+	}
+}
+
+// tgSendFailedKey holds the error in the data of the context when nothing of
+// the message was sent, so that it can be tried again. The error returned is
+// the one of the reply.
+const tgSendFailedKey = "send_failed"
+
+// tgSendFailed reacts to the message that couldn't be sent to WhatsApp with
+// the failure reaction and replies with the error
+func tgSendFailed(b *gotgbot.Bot, c *ext.Context, eMessage string, e error) error {
+	if c.Data != nil {
+		c.Data[tgSendFailedKey] = e
+	}
+	TgReactSendResult(b, c, false)
+	return TgReplyWithErrorByContext(b, c, eMessage, e)
+}
+
 func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	msgToForward, msgToReplyTo *gotgbot.Message,
 	waChatJID waTypes.JID, participant, stanzaId string,
@@ -173,33 +672,44 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		cfg      = state.State.Config
 		logger   = state.State.Logger
 		waClient = state.State.WhatsAppClient
+		waSender = state.State.WhatsAppSender
 		mentions = []string{}
 	)
+	if blocked, blockedUntil := WaDeliveryBlocked(waChatJID); blocked {
+		return TgQueueForDelivery(b, c, msgToForward, msgToReplyTo, waChatJID, participant, stanzaId, isReply, blockedUntil)
+	}
+
+	defer LagTrackSend(waChatJID.String())()
+	HeaderBreakContinuation(msgToForward.Chat.Id, msgToForward.MessageThreadId)
+
+	var quotedMsg *waProto.Message
+	if isReply {
+		// Your own messages sent from Telegram have just their text, the
+		// bridged ones start with the header
+		var fallbackText string
+		if msgToReplyTo != nil && msgToReplyTo.From != nil && msgToReplyTo.From.Id != b.Id {
+			fallbackText = msgToReplyTo.Text
+			if fallbackText == "" {
+				fallbackText = msgToReplyTo.Caption
+			}
+		}
+		quotedMsg = WaQuotedMessage(stanzaId, waChatJID, fallbackText)
+	}
 
-	var entities []gotgbot.ParsedMessageEntity
 	if len(msgToForward.Entities) > 0 {
-		entities = msgToForward.ParseEntities()
+		msgToForward.Text, mentions = TgTranslateMentions(msgToForward.Text, msgToForward.ParseEntities(), waChatJID)
 	} else if len(msgToForward.CaptionEntities) > 0 {
-		entities = msgToForward.ParseCaptionEntities()
+		msgToForward.Caption, mentions = TgTranslateMentions(msgToForward.Caption, msgToForward.ParseCaptionEntities(), waChatJID)
 	}
 
-	for _, entity := range entities {
-		if entity.Type == "mention" {
-			username := entity.Text[1:]
-			// Check if its a number
-			for _, c := range username {
-				if !unicode.IsDigit(c) {
-					continue
-				}
-			}
-
-			parsedJID, _ := WaParseJID(username)
-			mentions = append(mentions, parsedJID.String())
-		}
+	caption, captionOverflow := msgToForward.Caption, ""
+	if limit := cfg.WhatsApp.MaxOutgoingCaptionLength; limit > 0 && len([]rune(caption)) > limit {
+		captionChunks := SplitText(caption, limit)
+		caption, captionOverflow = captionChunks[0], strings.Join(captionChunks[1:], "\n")
 	}
 
 	if cfg.Telegram.SendMyPresence {
-		err := waClient.SendPresence(waTypes.PresenceAvailable)
+		err := waSender.SendPresence(waTypes.PresenceAvailable)
 		if err != nil {
 			logger.Warn("failed to send presence",
 				zap.Error(err),
@@ -209,7 +719,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		go func() {
 			time.Sleep(10 * time.Second)
-			err := waClient.SendPresence(waTypes.PresenceUnavailable)
+			err := waSender.SendPresence(waTypes.PresenceUnavailable)
 			if err != nil {
 				logger.Warn("failed to send presence",
 					zap.Error(err),
@@ -229,7 +739,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	}
 
 	if !ephemeralFound && waChatJID.Server == waTypes.GroupServer {
-		groupInfo, err := waClient.GetGroupInfo(waChatJID)
+		groupInfo, err := waSender.GetGroupInfo(waChatJID)
 		if err != nil {
 			logger.Info(
 				"failed to get group info from WhatsApp",
@@ -259,7 +769,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			}
 		}
 
-		if !cfg.Telegram.SelfHostedAPI && bestPhoto.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && bestPhoto.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send photo as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -270,22 +781,22 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive image file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive image file from Telegram", err)
 		}
 
 		imageBytes, err := TgDownloadByFilePath(b, imageFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download image from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download image from Telegram", err)
 		}
 
-		uploadedImage, err := waClient.Upload(context.Background(), imageBytes, whatsmeow.MediaImage)
+		uploadedImage, err := waSender.Upload(context.Background(), imageBytes, whatsmeow.MediaImage)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload image to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload image to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
 			ImageMessage: &waProto.ImageMessage{
-				Caption:           proto.String(msgToForward.Caption),
+				Caption:           proto.String(caption),
 				Url:               proto.String(uploadedImage.URL),
 				DirectPath:        proto.String(uploadedImage.DirectPath),
 				MediaKey:          uploadedImage.MediaKey,
@@ -303,7 +814,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.ImageMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.ImageMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.ImageMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.ImageMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.ImageMessage.ContextInfo.MentionedJid = mentions
@@ -312,18 +823,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.ImageMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send image to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send image to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -333,7 +839,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 	} else if msgToForward.Video != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Video.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Video.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send video as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -344,22 +851,22 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive video file from Telegram", err)
 		}
 
 		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download video from Telegram", err)
 		}
 
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
+		uploadedVideo, err := waSender.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload video to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
 			VideoMessage: &waProto.VideoMessage{
-				Caption:       proto.String(msgToForward.Caption),
+				Caption:       proto.String(caption),
 				Url:           proto.String(uploadedVideo.URL),
 				DirectPath:    proto.String(uploadedVideo.DirectPath),
 				MediaKey:      uploadedVideo.MediaKey,
@@ -378,7 +885,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.VideoMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.VideoMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.VideoMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.VideoMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.VideoMessage.ContextInfo.MentionedJid = mentions
@@ -387,18 +894,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.VideoMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send video to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -407,7 +909,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.VideoNote != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.VideoNote.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.VideoNote.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send video note as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -418,59 +921,56 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video note file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive video note file from Telegram", err)
 		}
 
 		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video note from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download video note from Telegram", err)
 		}
 
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
+		uploadedVideo, err := waSender.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video note to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload video note to WhatsApp", err)
 		}
 
+		// Sent as a video note (PTV) on WhatsApp too, which has no caption
 		msgToSend := &waProto.Message{
-			VideoMessage: &waProto.VideoMessage{
-				Caption:       proto.String(msgToForward.Caption),
+			PtvMessage: &waProto.VideoMessage{
 				Url:           proto.String(uploadedVideo.URL),
 				DirectPath:    proto.String(uploadedVideo.DirectPath),
 				MediaKey:      uploadedVideo.MediaKey,
-				Mimetype:      proto.String(http.DetectContentType(videoBytes)),
+				Mimetype:      proto.String("video/mp4"),
 				FileEncSha256: uploadedVideo.FileEncSHA256,
 				FileSha256:    uploadedVideo.FileSHA256,
 				FileLength:    proto.Uint64(uint64(len(videoBytes))),
 				ViewOnce:      proto.Bool(msgToForward.HasProtectedContent),
 				Seconds:       proto.Uint32(uint32(msgToForward.VideoNote.Duration)),
-				GifPlayback:   proto.Bool(false),
+				Width:         proto.Uint32(uint32(msgToForward.VideoNote.Length)),
+				Height:        proto.Uint32(uint32(msgToForward.VideoNote.Length)),
+				JpegThumbnail: tgDownloadThumbnail(b, msgToForward.VideoNote.Thumbnail),
 				ContextInfo:   &waProto.ContextInfo{},
 			},
 		}
 		if isReply {
-			msgToSend.VideoMessage.ContextInfo.StanzaId = proto.String(stanzaId)
-			msgToSend.VideoMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.VideoMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.PtvMessage.ContextInfo.StanzaId = proto.String(stanzaId)
+			msgToSend.PtvMessage.ContextInfo.Participant = proto.String(participant)
+			msgToSend.PtvMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
-			msgToSend.VideoMessage.ContextInfo.MentionedJid = mentions
+			msgToSend.PtvMessage.ContextInfo.MentionedJid = mentions
 		}
 		if isEphemeral {
-			msgToSend.VideoMessage.ContextInfo.Expiration = &ephemeralTimer
+			msgToSend.PtvMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video note to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send video note to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -479,7 +979,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.Animation != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Animation.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Animation.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send animation as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -490,26 +991,35 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive animation file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive animation file from Telegram", err)
 		}
 
 		animationBytes, err := TgDownloadByFilePath(b, animationFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download animation from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download animation from Telegram", err)
+		}
+
+		// WhatsApp only plays MP4s as GIFs, Telegram keeps some animations
+		// as actual GIFs
+		if msgToForward.Animation.MimeType != "video/mp4" {
+			animationBytes, err = AnimationConvertToMp4(animationBytes, c.UpdateId)
+			if err != nil {
+				return tgSendFailed(b, c, "Failed to convert animation to MP4", err)
+			}
 		}
 
-		uploadedAnimation, err := waClient.Upload(context.Background(), animationBytes, whatsmeow.MediaVideo)
+		uploadedAnimation, err := waSender.Upload(context.Background(), animationBytes, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload animation to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload animation to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
 			VideoMessage: &waProto.VideoMessage{
-				Caption:        proto.String(msgToForward.Caption),
+				Caption:        proto.String(caption),
 				Url:            proto.String(uploadedAnimation.URL),
 				DirectPath:     proto.String(uploadedAnimation.DirectPath),
 				MediaKey:       uploadedAnimation.MediaKey,
-				Mimetype:       proto.String(msgToForward.Animation.MimeType),
+				Mimetype:       proto.String("video/mp4"),
 				GifPlayback:    proto.Bool(true),
 				FileEncSha256:  uploadedAnimation.FileEncSHA256,
 				FileSha256:     uploadedAnimation.FileSHA256,
@@ -518,6 +1028,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				Height:         proto.Uint32(uint32(msgToForward.Animation.Height)),
 				Width:          proto.Uint32(uint32(msgToForward.Animation.Width)),
 				Seconds:        proto.Uint32(uint32(msgToForward.Animation.Duration)),
+				JpegThumbnail:  tgDownloadThumbnail(b, msgToForward.Animation.Thumbnail),
 				GifAttribution: waProto.VideoMessage_TENOR.Enum(),
 				ContextInfo:    &waProto.ContextInfo{},
 			},
@@ -525,7 +1036,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.VideoMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.VideoMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.VideoMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.VideoMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.VideoMessage.ContextInfo.MentionedJid = mentions
@@ -534,18 +1045,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.VideoMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send animation to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send animation to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -554,7 +1060,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.Audio != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Audio.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Audio.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send audio as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -565,17 +1072,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive audio file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive audio file from Telegram", err)
 		}
 
 		audioBytes, err := TgDownloadByFilePath(b, audioFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download audio from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download audio from Telegram", err)
 		}
 
-		uploadedAudio, err := waClient.Upload(context.Background(), audioBytes, whatsmeow.MediaAudio)
+		uploadedAudio, err := waSender.Upload(context.Background(), audioBytes, whatsmeow.MediaAudio)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload audio to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload audio to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
@@ -595,7 +1102,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.AudioMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.AudioMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.AudioMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.AudioMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.AudioMessage.ContextInfo.MentionedJid = mentions
@@ -604,18 +1111,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.AudioMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send audio to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send audio to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -624,7 +1126,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.Voice != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Voice.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Voice.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send voice as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -635,17 +1138,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive voice file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive voice file from Telegram", err)
 		}
 
 		voiceBytes, err := TgDownloadByFilePath(b, voiceFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download voice from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download voice from Telegram", err)
 		}
 
-		uploadedVoice, err := waClient.Upload(context.Background(), voiceBytes, whatsmeow.MediaAudio)
+		uploadedVoice, err := waSender.Upload(context.Background(), voiceBytes, whatsmeow.MediaAudio)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload voice to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload voice to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
@@ -665,7 +1168,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.AudioMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.AudioMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.AudioMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.AudioMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.AudioMessage.ContextInfo.MentionedJid = mentions
@@ -674,18 +1177,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.AudioMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send voice to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send voice to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -694,7 +1192,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.Document != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Document.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Document.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send document as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -705,17 +1204,35 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive document file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive document file from Telegram", err)
 		}
 
 		documentBytes, err := TgDownloadByFilePath(b, documentFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download document from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download document from Telegram", err)
+		}
+
+		if cfg.Telegram.DocumentParts.Enabled {
+			contextInfo := &waProto.ContextInfo{}
+			if isReply {
+				contextInfo.StanzaId = proto.String(stanzaId)
+				contextInfo.Participant = proto.String(participant)
+				contextInfo.QuotedMessage = quotedMsg
+			}
+			if len(mentions) > 0 {
+				contextInfo.MentionedJid = mentions
+			}
+			if isEphemeral {
+				contextInfo.Expiration = &ephemeralTimer
+			}
+			if TgQueueDocumentPart(b, msgToForward, waChatJID, documentBytes, caption, contextInfo) {
+				return nil
+			}
 		}
 
-		uploadedDocument, err := waClient.Upload(context.Background(), documentBytes, whatsmeow.MediaDocument)
+		uploadedDocument, err := waSender.Upload(context.Background(), documentBytes, whatsmeow.MediaDocument)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload document to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload document to WhatsApp", err)
 		}
 
 		splitName := strings.Split(msgToForward.Document.FileName, ".")
@@ -723,7 +1240,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		msgToSend := &waProto.Message{
 			DocumentMessage: &waProto.DocumentMessage{
-				Caption:       proto.String(msgToForward.Caption),
+				Caption:       proto.String(caption),
 				Title:         proto.String(documentFileName),
 				Url:           proto.String(uploadedDocument.URL),
 				DirectPath:    proto.String(uploadedDocument.DirectPath),
@@ -738,7 +1255,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isReply {
 			msgToSend.DocumentMessage.ContextInfo.StanzaId = proto.String(stanzaId)
 			msgToSend.DocumentMessage.ContextInfo.Participant = proto.String(participant)
-			msgToSend.DocumentMessage.ContextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+			msgToSend.DocumentMessage.ContextInfo.QuotedMessage = quotedMsg
 		}
 		if len(mentions) > 0 {
 			msgToSend.DocumentMessage.ContextInfo.MentionedJid = mentions
@@ -747,18 +1264,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.DocumentMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send document to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send document to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -767,7 +1279,8 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	} else if msgToForward.Sticker != nil {
 
-		if !cfg.Telegram.SelfHostedAPI && msgToForward.Sticker.FileSize > DownloadSizeLimit {
+		if !TgLargeFiles() && msgToForward.Sticker.FileSize > DownloadSizeLimit {
+			TgReactSendResult(b, c, false)
 			_, err := TgReplyTextByContext(b, c, "Unable to send sticker as it exceeds Telegram size restriction", nil)
 			return err
 		}
@@ -778,18 +1291,18 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive sticker file from Telegram", err)
+			return tgSendFailed(b, c, "Failed to retreive sticker file from Telegram", err)
 		}
 
 		stickerBytes, err := TgDownloadByFilePath(b, stickerFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download sticker from Telegram", err)
+			return tgSendFailed(b, c, "Failed to download sticker from Telegram", err)
 		}
 
 		if msgToForward.Sticker.IsAnimated {
 			stickerBytes, err = TGSConvertToWebp(stickerBytes, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert TGS sticker to WebP", err)
+				return tgSendFailed(b, c, "Failed to convert TGS sticker to WebP", err)
 			}
 		} else if msgToForward.Sticker.IsVideo && !cfg.Telegram.SkipVideoStickers {
 
@@ -808,7 +1321,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebmConvertToWebp(stickerBytes, scale, pad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert WEBM sticker to GIF", err)
+				return tgSendFailed(b, c, "Failed to convert WEBM sticker to GIF", err)
 			}
 		} else if !msgToForward.Sticker.IsAnimated || !msgToForward.Sticker.IsVideo {
 
@@ -823,13 +1336,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebpImagePad(stickerBytes, wPad, hPad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to pad WEBP sticker to 512x512", err)
+				return tgSendFailed(b, c, "Failed to pad WEBP sticker to 512x512", err)
 			}
 		}
 
-		uploadedSticker, err := waClient.Upload(context.Background(), stickerBytes, whatsmeow.MediaImage)
+		uploadedSticker, err := waSender.Upload(context.Background(), stickerBytes, whatsmeow.MediaImage)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload sticker to WhatsApp", err)
+			return tgSendFailed(b, c, "Failed to upload sticker to WhatsApp", err)
 		}
 
 		msgToSend := &waProto.Message{
@@ -852,25 +1365,20 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			msgToSend.StickerMessage.ContextInfo = &waProto.ContextInfo{
 				StanzaId:      proto.String(stanzaId),
 				Participant:   proto.String(participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+				QuotedMessage: quotedMsg,
 			}
 		}
 		if isEphemeral {
 			msgToSend.StickerMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			return tgSendFailed(b, c, "Failed to send sticker to WhatsApp", err)
 		}
+		ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false))
+		WaTrackDelivery(sentMsg.ID, waChatJID, c.EffectiveMessage)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
 			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
@@ -880,7 +1388,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	} else if msgToForward.Text != "" {
 
 		if emojis := gomoji.CollectAll(msgToForward.Text); isReply && len(emojis) == 1 && gomoji.RemoveEmojis(msgToForward.Text) == "" {
-			_, err := waClient.SendMessage(context.Background(), waChatJID, &waProto.Message{
+			_, err := waSender.SendMessage(context.Background(), waChatJID, &waProto.Message{
 				ReactionMessage: &waProto.ReactionMessage{
 					Text:              proto.String(msgToForward.Text),
 					SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
@@ -892,66 +1400,102 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				},
 			})
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to send reaction to WhatsApp", err)
+				return tgSendFailed(b, c, "Failed to send reaction to WhatsApp", err)
 			}
-			msg, err := TgReplyTextByContext(b, c, "Successfully reacted", nil)
-			if err == nil {
-				go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-					time.Sleep(15 * time.Second)
-					_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-				}(b, msg)
+			TgConfirmSent(b, c, "Successfully reacted", nil)
+			return nil
+		}
+
+		var linkPreview *LinkPreview
+		if cfg.WhatsApp.SendLinkPreviews {
+			if url, found := LinkPreviewFindURL(msgToForward.Text); found {
+				linkPreview, _ = LinkPreviewFetch(url)
 			}
-			return err
 		}
 
-		msgToSend := &waProto.Message{}
-		if isReply || len(mentions) > 0 || isEphemeral {
-			msgToSend.ExtendedTextMessage = &waProto.ExtendedTextMessage{
-				Text: proto.String(msgToForward.Text),
-				ContextInfo: &waProto.ContextInfo{
-					StanzaId:      proto.String(stanzaId),
-					Participant:   proto.String(participant),
-					QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-				},
+		var (
+			firstMsgToSend *waProto.Message
+			firstSentMsgId string
+		)
+		for idx, textChunk := range SplitText(msgToForward.Text, cfg.WhatsApp.MaxOutgoingTextLength) {
+			msgToSend := &waProto.Message{}
+			hasLinkPreview := linkPreview != nil && strings.Contains(textChunk, linkPreview.URL)
+			if (isReply && idx == 0) || len(mentions) > 0 || isEphemeral || hasLinkPreview {
+				msgToSend.ExtendedTextMessage = &waProto.ExtendedTextMessage{
+					Text:        proto.String(textChunk),
+					ContextInfo: &waProto.ContextInfo{},
+				}
+				if isReply && idx == 0 {
+					msgToSend.ExtendedTextMessage.ContextInfo.StanzaId = proto.String(stanzaId)
+					msgToSend.ExtendedTextMessage.ContextInfo.Participant = proto.String(participant)
+					msgToSend.ExtendedTextMessage.ContextInfo.QuotedMessage = quotedMsg
+				}
+				if len(mentions) > 0 {
+					msgToSend.ExtendedTextMessage.ContextInfo.MentionedJid = mentions
+				}
+				if isEphemeral {
+					msgToSend.ExtendedTextMessage.ContextInfo.Expiration = &ephemeralTimer
+				}
+				if hasLinkPreview {
+					LinkPreviewAttach(msgToSend.ExtendedTextMessage, linkPreview)
+				}
+			} else {
+				msgToSend.Conversation = proto.String(textChunk)
 			}
-			if len(mentions) > 0 {
-				msgToSend.ExtendedTextMessage.ContextInfo.MentionedJid = mentions
+
+			sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, msgToSend)
+			if err != nil {
+				if idx > 0 {
+					// Not a failed send, the parts before it were sent
+					TgReactSendResult(b, c, false)
+					return TgReplyWithErrorByContext(b, c, fmt.Sprintf("Failed to send part %d of the message to WhatsApp", idx+1), err)
+				}
+				return tgSendFailed(b, c, "Failed to send message to WhatsApp", err)
 			}
-			if isEphemeral {
-				msgToSend.ExtendedTextMessage.ContextInfo.Expiration = &ephemeralTimer
+
+			// Only the first part is paired, so that replies, edits and revokes of
+			// the Telegram message always resolve to the same WhatsApp message
+			if idx == 0 {
+				err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+					cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+				if err != nil {
+					return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+				}
 			}
-		} else {
-			msgToSend.Conversation = proto.String(msgToForward.Text)
-		}
+			ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, msgToSend, "", sentMsg.Timestamp)
 
-		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send message to WhatsApp", err)
-		}
-		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard)
-		if err == nil {
-			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-				time.Sleep(15 * time.Second)
-				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-			}(b, msg)
+			if idx == 0 {
+				firstMsgToSend, firstSentMsgId = msgToSend, sentMsg.ID
+			}
 		}
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
-		}
+		TgConfirmSent(b, c, "Successfully sent", TgMakeRevokeKeyboard(firstSentMsgId, waChatJID.String(), false))
+		WaTrackDelivery(firstSentMsgId, waChatJID, c.EffectiveMessage)
 
 		{
 			textSplit := strings.Fields(strings.ToLower(msgToForward.Text))
 			if slices.Contains(textSplit, "@all") || slices.Contains(textSplit, "@everyone") {
-				WaTagAll(waChatJID, msgToSend, sentMsg.ID, waClient.Store.ID.String(), true)
+				WaTagAll(waChatJID, firstMsgToSend, firstSentMsgId, waClient.Store.ID.String(), true)
 			}
 		}
 
 	}
 
+	if captionOverflow != "" {
+		for idx, textChunk := range SplitText(captionOverflow, cfg.WhatsApp.MaxOutgoingTextLength) {
+			sentMsg, err := waSender.SendMessage(context.Background(), waChatJID, &waProto.Message{
+				Conversation: proto.String(textChunk),
+			})
+			if err != nil {
+				// Not a failed send, the media and the parts before it were sent
+				TgReactSendResult(b, c, false)
+				return TgReplyWithErrorByContext(b, c, fmt.Sprintf("Failed to send part %d of the caption to WhatsApp", idx+2), err)
+			}
+			// Not paired, the Telegram message is already paired with the media
+			ArchiveMessage(sentMsg.ID, waChatJID, *waClient.Store.ID, waClient.Store.PushName, true, nil, textChunk, sentMsg.Timestamp)
+		}
+	}
+
 	if cfg.Telegram.SendMyReadReceipts {
 		unreadMsgs, err := database.MsgIdGetUnread(waChatJID.String())
 		if err != nil {
@@ -960,7 +1504,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		for sender, msgIds := range unreadMsgs {
 			senderJID, _ := WaParseJID(sender)
-			err := waClient.MarkRead(msgIds, time.Now(), waChatJID, senderJID)
+			err := waSender.MarkRead(msgIds, time.Now(), waChatJID, senderJID)
 			if err != nil {
 				logger.Warn(
 					"failed to mark messages as read",
@@ -1006,6 +1550,65 @@ func TgMakeRevokeKeyboard(msgId, chatId string, confirm bool) *gotgbot.InlineKey
 	}
 }
 
+// TgMakeJoinRequestKeyboard builds the buttons to approve or reject a request
+// to join a group, users on the default server are stored without it to keep
+// the callback data short
+func TgMakeJoinRequestKeyboard(group, requester waTypes.JID) gotgbot.InlineKeyboardMarkup {
+	requesterId := requester.ToNonAD().String()
+	if requester.Server == waTypes.DefaultUserServer {
+		requesterId = requester.User
+	}
+	data := group.User + "_" + requesterId
+
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+			{
+				Text:         "Approve",
+				CallbackData: "joinreq_a_" + data,
+			},
+			{
+				Text:         "Reject",
+				CallbackData: "joinreq_r_" + data,
+			},
+		}},
+	}
+}
+
+// TgMakeNewChatKeyboard builds the buttons to accept a new chat held in the
+// #NewChats topic or to ignore it, or only the former once it is ignored
+func TgMakeNewChatKeyboard(chat waTypes.JID, ignored bool) gotgbot.InlineKeyboardMarkup {
+	if ignored {
+		return gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text:         "Ignored, accept instead",
+				CallbackData: "newchat_a_" + chat.User,
+			}}},
+		}
+	}
+
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+			{
+				Text:         "Accept",
+				CallbackData: "newchat_a_" + chat.User,
+			},
+			{
+				Text:         "Ignore",
+				CallbackData: "newchat_i_" + chat.User,
+			},
+		}},
+	}
+}
+
+// TgBuildMessageLink returns the t.me link to a message in a supergroup
+func TgBuildMessageLink(chatId, threadId, msgId int64) string {
+	internalId := strings.TrimPrefix(strconv.FormatInt(chatId, 10), "-100")
+	if threadId != 0 {
+		return fmt.Sprintf("https://t.me/c/%s/%d/%d", internalId, threadId, msgId)
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", internalId, msgId)
+}
+
 func TgBuildUrlButton(text, url string) gotgbot.InlineKeyboardMarkup {
 	return gotgbot.InlineKeyboardMarkup{
 		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
@@ -1014,3 +1617,12 @@ func TgBuildUrlButton(text, url string) gotgbot.InlineKeyboardMarkup {
 		}}},
 	}
 }
+
+func TgBuildCallbackButton(text, data string) gotgbot.InlineKeyboardMarkup {
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+			Text:         text,
+			CallbackData: data,
+		}}},
+	}
+}
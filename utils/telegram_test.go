@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"watgbridge/fakes"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// photoRejectingTelegram fails every photo with err
+type photoRejectingTelegram struct {
+	*fakes.Telegram
+	err error
+}
+
+func (t photoRejectingTelegram) SendPhoto(chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	return nil, t.err
+}
+
+func TestTgSendPhotoWithFallback(t *testing.T) {
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	processed, original := []byte("processed"), []byte("original")
+
+	for _, tc := range []struct {
+		name       string
+		err        error
+		asDocument bool
+	}{
+		{"dimensions", &gotgbot.TelegramError{Code: 400, Description: "Bad Request: PHOTO_INVALID_DIMENSIONS"}, true},
+		{"processing", &gotgbot.TelegramError{Code: 400, Description: "Bad Request: IMAGE_PROCESS_FAILED"}, true},
+		{"too_large", &gotgbot.TelegramError{Code: 413, Description: "Request Entity Too Large"}, true},
+		{"not_found", &gotgbot.TelegramError{Code: 400, Description: "Bad Request: message thread not found"}, false},
+		{"network", io.ErrUnexpectedEOF, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h.Telegram.Sent = nil
+			_, err := TgSendPhotoWithFallback(photoRejectingTelegram{h.Telegram, tc.err}, fakes.HarnessTargetChatID,
+				processed, original, &gotgbot.SendPhotoOpts{Caption: "caption"}, "image/jpeg")
+
+			if !tc.asDocument {
+				if err == nil || len(h.Telegram.Sent) != 0 {
+					t.Errorf("retried on %v as %+v", tc.err, h.Telegram.Sent)
+				}
+				return
+			}
+			if err != nil || len(h.Telegram.Sent) != 1 || h.Telegram.Sent[0].Method != "sendDocument" {
+				t.Fatalf("not sent as a document (%v): %+v", err, h.Telegram.Sent)
+			}
+			file := h.Telegram.Sent[0].File.(gotgbot.NamedFile)
+			if data, _ := io.ReadAll(file.File); !bytes.Equal(data, original) {
+				t.Errorf("document has %q, want the original photo", data)
+			}
+		})
+	}
+}
+
+func TestTgCutWord(t *testing.T) {
+	for _, tc := range []struct {
+		text, word, rest string
+	}{
+		{"", "", ""},
+		{"/remind", "/remind", ""},
+		{"/remind ", "/remind", ""},
+		{"/remind @daily | Water", "/remind", "@daily | Water"},
+		{"/remind\n@daily | Water", "/remind", "@daily | Water"},
+		{"/remind\t @daily", "/remind", "@daily"},
+		{"thanks\nThank you\n\nBye ", "thanks", "Thank you\n\nBye"},
+	} {
+		word, rest := TgCutWord(tc.text)
+		if word != tc.word || rest != tc.rest {
+			t.Errorf("TgCutWord(%q) = %q, %q, want %q, %q", tc.text, word, rest, tc.word, tc.rest)
+		}
+	}
+}
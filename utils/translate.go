@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+	"golang.org/x/exp/slices"
+)
+
+var translateClient = &http.Client{Timeout: 15 * time.Second}
+
+// TranslateEnabledFor reports whether incoming messages of the chat are to be
+// translated
+func TranslateEnabledFor(chat types.JID) bool {
+	cfg := state.State.Config
+	return cfg.Translation.Provider != "" && slices.Contains(cfg.Translation.Chats, chat.User)
+}
+
+// Translate translates the text to the configured target language with the
+// configured provider, returning the translation and the detected language of
+// the text. The translation is empty if the text already is in the target
+// language.
+func Translate(text string) (string, string, error) {
+	var (
+		cfg    = state.State.Config
+		target = cfg.Translation.TargetLanguage
+
+		translated, detected string
+		err                  error
+	)
+
+	switch cfg.Translation.Provider {
+	case "libretranslate":
+		translated, detected, err = translateLibreTranslate(text, target)
+	case "deepl":
+		translated, detected, err = translateDeepL(text, target)
+	case "google":
+		translated, detected, err = translateGoogle(text, target)
+	default:
+		return "", "", fmt.Errorf("unknown translation provider : %s", cfg.Translation.Provider)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if strings.EqualFold(detected, target) || strings.TrimSpace(translated) == strings.TrimSpace(text) {
+		return "", detected, nil
+	}
+	return translated, strings.ToLower(detected), nil
+}
+
+func translatePost(url string, headers map[string]string, body, result interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := translateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("received non-200 status code : %s", res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+func translateLibreTranslate(text, target string) (string, string, error) {
+	cfg := state.State.Config
+
+	url := cfg.Translation.URL
+	if url == "" {
+		url = "https://libretranslate.com"
+	}
+
+	var result struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	err := translatePost(strings.TrimSuffix(url, "/")+"/translate", nil, map[string]string{
+		"q":       text,
+		"source":  "auto",
+		"target":  target,
+		"format":  "text",
+		"api_key": cfg.Translation.APIKey,
+	}, &result)
+
+	return result.TranslatedText, result.DetectedLanguage.Language, err
+}
+
+func translateDeepL(text, target string) (string, string, error) {
+	cfg := state.State.Config
+
+	url := cfg.Translation.URL
+	if url == "" {
+		// Keys of the free plan end with ":fx" and only work with the free API
+		if strings.HasSuffix(cfg.Translation.APIKey, ":fx") {
+			url = "https://api-free.deepl.com"
+		} else {
+			url = "https://api.deepl.com"
+		}
+	}
+
+	var result struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	err := translatePost(strings.TrimSuffix(url, "/")+"/v2/translate", map[string]string{
+		"Authorization": "DeepL-Auth-Key " + cfg.Translation.APIKey,
+	}, map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(target),
+	}, &result)
+	if err != nil {
+		return "", "", err
+	}
+	if len(result.Translations) == 0 {
+		return "", "", fmt.Errorf("no translation received")
+	}
+
+	return result.Translations[0].Text, result.Translations[0].DetectedSourceLanguage, nil
+}
+
+func translateGoogle(text, target string) (string, string, error) {
+	cfg := state.State.Config
+
+	url := cfg.Translation.URL
+	if url == "" {
+		url = "https://translation.googleapis.com"
+	}
+
+	var result struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	err := translatePost(strings.TrimSuffix(url, "/")+"/language/translate/v2?key="+cfg.Translation.APIKey, nil,
+		map[string]string{
+			"q":      text,
+			"target": target,
+			"format": "text",
+		}, &result)
+	if err != nil {
+		return "", "", err
+	}
+	if len(result.Data.Translations) == 0 {
+		return "", "", fmt.Errorf("no translation received")
+	}
+
+	return result.Data.Translations[0].TranslatedText, result.Data.Translations[0].DetectedSourceLanguage, nil
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+const updateReleasesURL = "https://api.github.com/repos/akshettrj/watgbridge/releases"
+
+var updateClient = &http.Client{Timeout: 30 * time.Second}
+
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	HTMLURL    string `json:"html_url"`
+	Draft      bool   `json:"draft"`
+	PreRelease bool   `json:"prerelease"`
+}
+
+var (
+	updateLock         sync.Mutex
+	updateLastNotified string
+)
+
+// UpdateCheck looks for a release newer than the running version on GitHub and
+// posts it with its changelog to the '#System' topic, once per release
+func UpdateCheck() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	release, err := updateGetLatestRelease(cfg.UpdateCheck.IncludePreReleases)
+	if err != nil {
+		logger.Warn("failed to check for updates",
+			zap.Error(err),
+		)
+		return
+	}
+	if release == nil || updateCompareVersions(release.TagName, state.WATGBRIDGE_VERSION) <= 0 {
+		return
+	}
+
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	if updateLastNotified == release.TagName {
+		return
+	}
+
+	notice := fmt.Sprintf("<b>watgbridge %s available</b>", html.EscapeString(release.TagName))
+	if release.PreRelease {
+		notice += " (pre-release)"
+	}
+	notice += fmt.Sprintf("\nYou are running v%s\n", state.WATGBRIDGE_VERSION)
+	if changelog := strings.TrimSpace(release.Body); changelog != "" {
+		if len([]rune(changelog)) > 3000 {
+			changelog = SubString(changelog, 0, 3000) + "..."
+		}
+		notice += "\n" + html.EscapeString(changelog) + "\n"
+	}
+	notice += fmt.Sprintf("\n<a href=\"%s\">Release notes</a>", html.EscapeString(release.HTMLURL))
+
+	if err = TgSendSystemNotice(notice); err != nil {
+		logger.Error("failed to post update notice",
+			zap.String("version", release.TagName),
+			zap.Error(err),
+		)
+		return
+	}
+	updateLastNotified = release.TagName
+}
+
+// updateGetLatestRelease returns the newest published release, skipping the
+// pre-releases unless asked for
+func updateGetLatestRelease(includePreReleases bool) (*githubRelease, error) {
+	req, err := http.NewRequest("GET", updateReleasesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := updateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("received non-200 status code : %s", res.Status)
+	}
+
+	var releases []githubRelease
+	if err = json.NewDecoder(res.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+
+	var latest *githubRelease
+	for idx := range releases {
+		release := &releases[idx]
+		if release.Draft || (release.PreRelease && !includePreReleases) {
+			continue
+		}
+		if latest == nil || updateCompareVersions(release.TagName, latest.TagName) > 0 {
+			latest = release
+		}
+	}
+	return latest, nil
+}
+
+// updateCompareVersions compares two versions like "v1.8.0" and "1.9.0-rc1",
+// returning a negative number if a is older, 0 if they are the same and a
+// positive number if a is newer. A release is newer than its pre-releases.
+func updateCompareVersions(a, b string) int {
+	aCore, aPre, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	bCore, bPre, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+
+	aParts, bParts := strings.Split(aCore, "."), strings.Split(bCore, ".")
+	for idx := 0; idx < len(aParts) || idx < len(bParts); idx++ {
+		var aNum, bNum int
+		if idx < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[idx])
+		}
+		if idx < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[idx])
+		}
+		if aNum != bNum {
+			return aNum - bNum
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	default:
+		return strings.Compare(aPre, bPre)
+	}
+}
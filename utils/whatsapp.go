@@ -1,39 +1,96 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"html"
 	"log"
+	"regexp"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"watgbridge/database"
 	"watgbridge/state"
 
+	goVCard "github.com/emersion/go-vcard"
 	"github.com/lithammer/fuzzysearch/fuzzy"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
 	"go.mau.fi/whatsmeow/types"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-func WaParseJID(s string) (types.JID, bool) {
-	if s[0] == '+' {
-		s = SubString(s, 1, len(s)-1)
-	}
+const (
+	MediaTypeImages     = "images"
+	MediaTypeGIFs       = "gifs"
+	MediaTypeVideos     = "videos"
+	MediaTypeVoiceNotes = "voice_notes"
+	MediaTypeAudios     = "audios"
+	MediaTypeDocuments  = "documents"
+	MediaTypeStickers   = "stickers"
+	MediaTypeContacts   = "contacts"
+	MediaTypeLocations  = "locations"
+)
+
+var MediaTypes = []string{
+	MediaTypeImages, MediaTypeGIFs, MediaTypeVideos, MediaTypeVoiceNotes, MediaTypeAudios,
+	MediaTypeDocuments, MediaTypeStickers, MediaTypeContacts, MediaTypeLocations,
+}
 
-	if !strings.ContainsRune(s, '@') {
-		return types.NewJID(s, types.DefaultUserServer).ToNonAD(), true
+func waGlobalSkipsMedia(mediaType string) bool {
+	cfg := state.State.Config
+
+	switch mediaType {
+	case MediaTypeImages:
+		return cfg.WhatsApp.SkipImages
+	case MediaTypeGIFs:
+		return cfg.WhatsApp.SkipGIFs
+	case MediaTypeVideos:
+		return cfg.WhatsApp.SkipVideos
+	case MediaTypeVoiceNotes:
+		return cfg.WhatsApp.SkipVoiceNotes
+	case MediaTypeAudios:
+		return cfg.WhatsApp.SkipAudios
+	case MediaTypeDocuments:
+		return cfg.WhatsApp.SkipDocuments
+	case MediaTypeStickers:
+		return cfg.WhatsApp.SkipStickers
+	case MediaTypeContacts:
+		return cfg.WhatsApp.SkipContacts
+	case MediaTypeLocations:
+		return cfg.WhatsApp.SkipLocations
 	}
+	return false
+}
 
-	recipient, err := types.ParseJID(s)
+// WaChatSkipsMedia resolves whether a media type should be skipped for a chat,
+// checking the overrides in the database, then in the config file and finally
+// falling back to the global skip_* options. The second return value tells
+// where the decision came from.
+func WaChatSkipsMedia(chat types.JID, mediaType string) (bool, string) {
+	var (
+		cfg    = state.State.Config
+		chatId = chat.ToNonAD().String()
+	)
+
+	if skip, found, err := database.ChatMediaPolicyGet(chatId, mediaType); err == nil && found {
+		return skip, "chat policy"
+	}
 
-	recipient = recipient.ToNonAD()
-	if err != nil || recipient.User == "" {
-		return recipient, false
+	for _, key := range []string{chatId, chat.User} {
+		if policy, found := cfg.WhatsApp.ChatMediaPolicies[key]; found {
+			if skip, found := policy[mediaType]; found {
+				return skip, "chat_media_policies"
+			}
+		}
 	}
 
-	return recipient, true
+	return waGlobalSkipsMedia(mediaType), "skip_" + mediaType
 }
 
 func WaFuzzyFindContacts(query string) (map[string]string, int, error) {
@@ -96,19 +153,125 @@ func WaFuzzyFindContacts(query string) (map[string]string, int, error) {
 	return results, resultsCount, nil
 }
 
+// Names and communities of groups, keyed by the JID of the group. They are
+// forgotten when the group info changes.
+var groupInfoCache sync.Map
+
+func waGetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	if cached, found := groupInfoCache.Load(jid.String()); found {
+		return cached.(*types.GroupInfo), nil
+	}
+
+	groupInfo, err := state.State.WhatsAppSender.GetGroupInfo(jid)
+	if err != nil {
+		return nil, err
+	}
+	groupInfoCache.Store(jid.String(), groupInfo)
+	return groupInfo, nil
+}
+
+// WaGroupInfoForget drops the cached info of a group, and of the groups of
+// the community it is the parent of, after it changed
+func WaGroupInfoForget(jid types.JID) {
+	groupInfoCache.Delete(jid.String())
+	groupInfoCache.Range(func(key, value any) bool {
+		if value.(*types.GroupInfo).LinkedParentJID == jid {
+			groupInfoCache.Delete(key)
+		}
+		return true
+	})
+}
+
 func WaGetGroupName(jid types.JID) string {
 	waClient := state.State.WhatsAppClient
+	if waClient == nil {
+		return jid.User
+	}
 
-	groupInfo, err := waClient.GetGroupInfo(jid)
+	groupInfo, err := waGetGroupInfo(jid)
 	if err != nil {
 		return jid.User
 	}
 	return groupInfo.Name
 }
 
+// WaGetGroupTopicName returns the name for the topic of a group, prefixed with
+// the name of its community if it is part of one
+func WaGetGroupTopicName(jid types.JID) string {
+	waClient := state.State.WhatsAppClient
+	if waClient == nil {
+		return jid.User
+	}
+
+	groupInfo, err := waGetGroupInfo(jid)
+	if err != nil {
+		return jid.User
+	}
+	if groupInfo.LinkedParentJID.IsEmpty() {
+		return groupInfo.Name
+	}
+
+	name := groupInfo.Name
+	if groupInfo.IsDefaultSubGroup {
+		name = "Announcements"
+	}
+	return WaGetGroupName(groupInfo.LinkedParentJID) + " › " + name
+}
+
+// WaBuildContactVCard generates a vCard for the contact from the stored names,
+// also returning the first and last name to use for a Telegram contact
+func WaBuildContactVCard(jid types.JID) ([]byte, string, string) {
+	firstName, fullName, pushName, businessName, _ := database.ContactNameGet(jid.User)
+
+	displayName := fullName
+	for _, name := range []string{businessName, pushName, firstName, "+" + jid.User} {
+		if displayName == "" {
+			displayName = name
+		}
+	}
+	var lastName string
+	if firstName != "" && strings.HasPrefix(displayName, firstName) {
+		lastName = strings.TrimSpace(strings.TrimPrefix(displayName, firstName))
+	} else {
+		firstName = displayName
+	}
+
+	card := make(goVCard.Card)
+	card.SetValue(goVCard.FieldVersion, "3.0")
+	card.SetValue(goVCard.FieldFormattedName, displayName)
+	card.SetName(&goVCard.Name{
+		GivenName:  firstName,
+		FamilyName: lastName,
+	})
+	card.Add(goVCard.FieldTelephone, &goVCard.Field{
+		Value:  "+" + jid.User,
+		Params: goVCard.Params{goVCard.ParamType: {goVCard.TypeCell}, "waid": {jid.User}},
+	})
+	if businessName != "" {
+		card.SetValue(goVCard.FieldOrganization, businessName)
+	}
+
+	var buf bytes.Buffer
+	_ = goVCard.NewEncoder(&buf).Encode(card)
+
+	return buf.Bytes(), firstName, lastName
+}
+
+// WaGetChatName returns the name of the group or contact for a chat
+func WaGetChatName(jid types.JID) string {
+	if jid.String() == "status@broadcast" {
+		return "#Stories"
+	} else if jid.Server == types.GroupServer {
+		return WaGetGroupName(jid)
+	}
+	return WaGetContactName(jid)
+}
+
 func WaGetContactName(jid types.JID) string {
 	var name string
 
+	jid = WaResolveLID(jid)
+
 	firstName, fullName, pushName, businessName, err := database.ContactNameGet(jid.User)
 	if err == nil {
 		if fullName != "" {
@@ -120,8 +283,7 @@ func WaGetContactName(jid types.JID) string {
 		} else if firstName != "" {
 			name = firstName + " (" + jid.User + ")"
 		}
-	} else {
-		waClient := state.State.WhatsAppClient
+	} else if waClient := state.State.WhatsAppClient; waClient != nil {
 		contact, err := waClient.Store.Contacts.GetContact(jid)
 		if err == nil && contact.Found {
 			if contact.FullName != "" {
@@ -147,10 +309,11 @@ func WaTagAll(group types.JID, msg *waProto.Message, msgId, msgSender string, ms
 	var (
 		cfg      = state.State.Config
 		waClient = state.State.WhatsAppClient
-		tgBot    = state.State.TelegramBot
+		waSender = state.State.WhatsAppSender
+		tgBot    = TgSenderFor(group.String())
 	)
 
-	groupInfo, err := waClient.GetGroupInfo(group)
+	groupInfo, err := waSender.GetGroupInfo(group)
 	if err != nil {
 		log.Printf("[whatsapp] failed to get group info of '%s': %s\n", group.String(), err)
 		return
@@ -170,7 +333,7 @@ func WaTagAll(group types.JID, msg *waProto.Message, msgId, msgSender string, ms
 		mentioned = append(mentioned, participant.JID.String())
 	}
 
-	_, err = waClient.SendMessage(context.Background(), group, &waProto.Message{
+	_, err = waSender.SendMessage(context.Background(), group, &waProto.Message{
 		ExtendedTextMessage: &waProto.ExtendedTextMessage{
 			Text: proto.String(replyText),
 			ContextInfo: &waProto.ContextInfo{
@@ -189,7 +352,7 @@ func WaTagAll(group types.JID, msg *waProto.Message, msgId, msgSender string, ms
 	if !msgIsFromMe {
 		tagsThreadId, err := TgGetOrMakeThreadFromWa("status@broadcast", cfg.Telegram.TargetChatID, "Status/Calls/Tags [ status@broadcast ]")
 		if err != nil {
-			TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/retreive corresponding thread id for status/calls/tags", err)
+			TgReportError("Failed to create/retreive corresponding thread id for status/calls/tags", err)
 			return
 		}
 
@@ -200,8 +363,135 @@ func WaTagAll(group types.JID, msg *waProto.Message, msgId, msgSender string, ms
 	}
 }
 
+// WaGetMessageText returns the text of a message or the caption of the media
+func WaGetMessageText(msg *waProto.Message) string {
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage().GetText() != "":
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage().GetCaption() != "":
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage().GetCaption() != "":
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage().GetCaption() != "":
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
+
+// WaUnwrapMessage returns the message inside the ephemeral, view once, edit
+// and other wrappers WhatsApp puts around messages
+func WaUnwrapMessage(msg *waProto.Message) *waProto.Message {
+	for {
+		var inner *waProto.Message
+		switch {
+		case msg.GetDeviceSentMessage().GetMessage() != nil:
+			inner = msg.GetDeviceSentMessage().GetMessage()
+		case msg.GetEphemeralMessage().GetMessage() != nil:
+			inner = msg.GetEphemeralMessage().GetMessage()
+		case msg.GetViewOnceMessage().GetMessage() != nil:
+			inner = msg.GetViewOnceMessage().GetMessage()
+		case msg.GetViewOnceMessageV2().GetMessage() != nil:
+			inner = msg.GetViewOnceMessageV2().GetMessage()
+		case msg.GetViewOnceMessageV2Extension().GetMessage() != nil:
+			inner = msg.GetViewOnceMessageV2Extension().GetMessage()
+		case msg.GetDocumentWithCaptionMessage().GetMessage() != nil:
+			inner = msg.GetDocumentWithCaptionMessage().GetMessage()
+		case msg.GetEditedMessage().GetMessage() != nil:
+			inner = msg.GetEditedMessage().GetMessage()
+		}
+		if inner == nil {
+			return msg
+		}
+		msg = inner
+	}
+}
+
+// WaGetContextInfo returns the context info (quoted message, mentions,
+// forwarding) of a message of any type. Reactions have none, the message
+// reacted to is returned as the quoted one for them.
+func WaGetContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	msg = WaUnwrapMessage(msg)
+	if msg == nil {
+		return nil
+	}
+
+	if reaction := msg.GetReactionMessage(); reaction != nil {
+		return &waProto.ContextInfo{
+			StanzaId:    reaction.GetKey().Id,
+			Participant: reaction.GetKey().Participant,
+			RemoteJid:   reaction.GetKey().RemoteJid,
+		}
+	}
+
+	// Every kind of message keeps it in a field of the same name
+	var contextInfo *waProto.ContextInfo
+	msg.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return true
+		}
+		content := value.Message()
+		contextInfoField := content.Descriptor().Fields().ByName("contextInfo")
+		if contextInfoField == nil || !content.Has(contextInfoField) {
+			return true
+		}
+		found, ok := content.Get(contextInfoField).Message().Interface().(*waProto.ContextInfo)
+		if ok {
+			contextInfo = found
+		}
+		return !ok
+	})
+	return contextInfo
+}
+
+// WaStarMessage stars or unstars a message through the app state, the same way
+// the phone does it so that it shows up as starred on all devices. The sender
+// is only needed for messages of others in groups.
+func WaStarMessage(chat, sender types.JID, msgId string, isFromMe, starred bool) error {
+	fromMe, senderId := "0", "0"
+	if isFromMe {
+		fromMe = "1"
+	} else if chat.Server == types.GroupServer && !sender.IsEmpty() {
+		senderId = sender.ToNonAD().String()
+	}
+
+	return state.State.WhatsAppClient.SendAppState(appstate.PatchInfo{
+		Type: appstate.WAPatchRegularHigh,
+		Mutations: []appstate.MutationInfo{{
+			Index:   []string{appstate.IndexStar, chat.ToNonAD().String(), msgId, fromMe, senderId},
+			Version: 2,
+			Value: &waProto.SyncActionValue{
+				StarAction: &waProto.StarAction{
+					Starred: proto.Bool(starred),
+				},
+			},
+		}},
+	})
+}
+
+func WaDownloadMedia(chat types.JID, msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	defer LagTrackMedia()()
+
+	if data, found := MediaStoreLoad(msg.GetFileSha256()); found {
+		StatsRecordMediaStoreHit(chat.String())
+		return data, nil
+	}
+
+	data, err := state.State.WhatsAppSender.Download(msg)
+	StatsRecordMediaDownload(chat.String(), err != nil)
+	if err == nil {
+		var mimetype string
+		if withMimetype, ok := msg.(interface{ GetMimetype() string }); ok {
+			mimetype = withMimetype.GetMimetype()
+		}
+		MediaStoreSave(data, mimetype)
+	}
+	return data, err
+}
+
 func WaSendText(chat types.JID, text, stanzaId, participantId string, quotedMsg *waProto.Message, isReply bool) (whatsmeow.SendResponse, error) {
-	waClient := state.State.WhatsAppClient
+	waSender := state.State.WhatsAppSender
 
 	msgToSend := &waProto.Message{}
 	if isReply {
@@ -217,5 +507,210 @@ func WaSendText(chat types.JID, text, stanzaId, participantId string, quotedMsg
 		msgToSend.Conversation = proto.String(text)
 	}
 
-	return waClient.SendMessage(context.Background(), chat, msgToSend)
+	return waSender.SendMessage(context.Background(), chat, msgToSend)
+}
+
+// WaQuotedMessage rebuilds the message with the ID for quoting it in a reply,
+// from the stored copy of it or else the text in the archive. fallbackText is
+// used if neither has it.
+func WaQuotedMessage(waMsgId string, chat types.JID, fallbackText string) *waProto.Message {
+	if stored, found, err := ForwardableGet(waMsgId, chat); found && err == nil {
+		quoted := proto.Clone(WaUnwrapMessage(stored)).(*waProto.Message)
+		// Quotes don't nest and the secrets of the message stay with it
+		quoted.MessageContextInfo = nil
+		quoted.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+			if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+				return true
+			}
+			content := value.Message()
+			if contextInfoField := content.Descriptor().Fields().ByName("contextInfo"); contextInfoField != nil {
+				content.Clear(contextInfoField)
+			}
+			return true
+		})
+		return quoted
+	}
+
+	if archived, found, _ := database.ArchivedMessageGet(waMsgId, chat.ToNonAD().String()); found {
+		if text, _, err := ArchiveDecrypt(archived); err == nil && text != "" {
+			return &waProto.Message{Conversation: proto.String(text)}
+		}
+	}
+
+	return &waProto.Message{Conversation: proto.String(fallbackText)}
+}
+
+// WaRenderAwayMessage executes the away mode message as a text/template with
+// the contact's details available as {{.Name}}, {{.PushName}} and {{.Number}}
+func WaRenderAwayMessage(message string, sender types.JID, pushName string) (string, error) {
+	tmpl, err := template.New("away").Parse(message)
+	if err != nil {
+		return "", err
+	}
+
+	name := pushName
+	if name == "" {
+		name = WaGetContactName(sender)
+	}
+
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, struct {
+		Name     string
+		PushName string
+		Number   string
+	}{
+		Name:     name,
+		PushName: pushName,
+		Number:   sender.User,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+var waCallLinkRegex = regexp.MustCompile(`https://call\.whatsapp\.com/(voice|video)/[A-Za-z0-9]+`)
+
+// WaFindCallLink returns the first WhatsApp call link found in the text and
+// whether it is for a video call
+func WaFindCallLink(text string) (string, bool, bool) {
+	match := waCallLinkRegex.FindStringSubmatch(text)
+	if match == nil {
+		return "", false, false
+	}
+	return match[0], match[1] == "video", true
+}
+
+// WaRequestHistory asks the phone for count messages sent in the chat before
+// the oldest known one, they arrive later as an ON_DEMAND history sync
+func WaRequestHistory(chat types.JID, count int) error {
+	waClient := state.State.WhatsAppClient
+	waSender := state.State.WhatsAppSender
+
+	anchor, found, err := database.HistoryAnchorGet(chat.ToNonAD().String())
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("no message of the chat is known yet to request history before")
+	}
+
+	lastKnown := &types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chat.ToNonAD(),
+			IsFromMe: anchor.IsFromMe,
+		},
+		ID:        anchor.MsgId,
+		Timestamp: anchor.Timestamp,
+	}
+
+	_, err = waSender.SendMessage(context.Background(), waClient.Store.ID.ToNonAD(),
+		waClient.BuildHistorySyncRequest(lastKnown, count), whatsmeow.SendRequestExtra{Peer: true})
+	return err
+}
+
+// WaPinMessage pins or unpins a message in the chat for everyone, pins last
+// for the 7 days WhatsApp uses by default
+func WaPinMessage(chat, sender types.JID, msgId string, pin bool) error {
+	waClient := state.State.WhatsAppClient
+	waSender := state.State.WhatsAppSender
+
+	pinType := waProto.PinInChatMessage_PIN_FOR_ALL
+	if !pin {
+		pinType = waProto.PinInChatMessage_UNPIN_FOR_ALL
+	}
+
+	msgToSend := &waProto.Message{
+		PinInChatMessage: &waProto.PinInChatMessage{
+			Key:               waClient.BuildMessageKey(chat, sender, msgId),
+			Type:              pinType.Enum(),
+			SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+		},
+		MessageContextInfo: &waProto.MessageContextInfo{
+			MessageAddOnDurationInSecs: proto.Uint32(uint32((7 * 24 * time.Hour).Seconds())),
+		},
+	}
+
+	_, err := waSender.SendMessage(context.Background(), chat, msgToSend)
+	return err
+}
+
+// WaResolveMention finds the contact meant by a mention typed on Telegram,
+// either a phone number or a name where underscores stand for spaces. In
+// groups only the participants are considered, the name has to match exactly
+// one contact.
+func WaResolveMention(name string, chat types.JID) (types.JID, bool) {
+	waSender := state.State.WhatsAppSender
+
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	if name == "" {
+		return types.EmptyJID, false
+	}
+
+	isNumber := true
+	for _, c := range strings.TrimPrefix(name, "+") {
+		if c < '0' || c > '9' {
+			isNumber = false
+			break
+		}
+	}
+	if isNumber {
+		return types.NewJID(strings.TrimPrefix(name, "+"), types.DefaultUserServer), true
+	}
+
+	var participants map[string]bool
+	if chat.Server == types.GroupServer {
+		if groupInfo, err := waSender.GetGroupInfo(chat); err == nil {
+			participants = make(map[string]bool)
+			for _, participant := range groupInfo.Participants {
+				participants[participant.JID.User] = true
+			}
+		}
+	}
+
+	contacts, err := database.ContactGetAll()
+	if err != nil {
+		return types.EmptyJID, false
+	}
+
+	var (
+		wanted  = strings.ToLower(strings.ReplaceAll(name, "_", " "))
+		matched []string
+	)
+	for user, contact := range contacts {
+		if participants != nil && !participants[user] {
+			continue
+		}
+		for _, contactName := range []string{contact.FirstName, contact.FullName, contact.PushName, contact.BusinessName} {
+			if contactName != "" && strings.ToLower(contactName) == wanted {
+				matched = append(matched, user)
+				break
+			}
+		}
+	}
+
+	if len(matched) != 1 {
+		return types.EmptyJID, false
+	}
+	return types.NewJID(matched[0], types.DefaultUserServer), true
+}
+
+const waStoryTopicPrefix = "status@broadcast/"
+
+// WaStoryTopicKey is what the stories topic of a contact is saved as in the
+// chat thread pairs
+func WaStoryTopicKey(poster types.JID) string {
+	return waStoryTopicPrefix + poster.User
+}
+
+// WaStoryTopicPoster returns the contact whose stories topic the key is for
+func WaStoryTopicPoster(key string) (types.JID, bool) {
+	if !strings.HasPrefix(key, waStoryTopicPrefix) {
+		return types.EmptyJID, false
+	}
+	return types.NewJID(strings.TrimPrefix(key, waStoryTopicPrefix), types.DefaultUserServer), true
+}
+
+func WaGetStoryTopicName(poster types.JID) string {
+	return "Stories › " + WaGetContactName(poster)
 }
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"watgbridge/fakes"
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	testContextInfo = &waProto.ContextInfo{
+		StanzaId:    proto.String("QUOTED"),
+		Participant: proto.String("10000000002@s.whatsapp.net"),
+	}
+	testImage = &waProto.ImageMessage{
+		Caption:     proto.String("A photo"),
+		ContextInfo: testContextInfo,
+	}
+)
+
+func TestWaUnwrapMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  *waProto.Message
+		want *waProto.Message
+	}{
+		{"nil", nil, nil},
+		{"plain", &waProto.Message{Conversation: proto.String("Hello")}, &waProto.Message{Conversation: proto.String("Hello")}},
+		{"ephemeral", &waProto.Message{
+			EphemeralMessage: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, &waProto.Message{ImageMessage: testImage}},
+		{"view_once", &waProto.Message{
+			ViewOnceMessage: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, &waProto.Message{ImageMessage: testImage}},
+		{"view_once_v2", &waProto.Message{
+			ViewOnceMessageV2: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, &waProto.Message{ImageMessage: testImage}},
+		{"view_once_v2_extension", &waProto.Message{
+			ViewOnceMessageV2Extension: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, &waProto.Message{ImageMessage: testImage}},
+		{"ephemeral_view_once", &waProto.Message{
+			EphemeralMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+				ViewOnceMessageV2: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+			}},
+		}, &waProto.Message{ImageMessage: testImage}},
+		{"document_with_caption", &waProto.Message{
+			DocumentWithCaptionMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+				DocumentMessage: &waProto.DocumentMessage{Caption: proto.String("The report")},
+			}},
+		}, &waProto.Message{DocumentMessage: &waProto.DocumentMessage{Caption: proto.String("The report")}}},
+		{"edited", &waProto.Message{
+			EditedMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+				Conversation: proto.String("Hello"),
+			}},
+		}, &waProto.Message{Conversation: proto.String("Hello")}},
+		{"device_sent", &waProto.Message{
+			DeviceSentMessage: &waProto.DeviceSentMessage{Message: &waProto.Message{
+				Conversation: proto.String("Hello"),
+			}},
+		}, &waProto.Message{Conversation: proto.String("Hello")}},
+		{"empty_wrapper", &waProto.Message{
+			EphemeralMessage: &waProto.FutureProofMessage{},
+		}, &waProto.Message{EphemeralMessage: &waProto.FutureProofMessage{}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := WaUnwrapMessage(tc.msg); !proto.Equal(got, tc.want) {
+				t.Errorf("WaUnwrapMessage() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWaGetContextInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  *waProto.Message
+		want *waProto.ContextInfo
+	}{
+		{"nil", nil, nil},
+		{"no_context", &waProto.Message{Conversation: proto.String("Hello")}, nil},
+		{"media_without_context", &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{Caption: proto.String("A photo")},
+		}, nil},
+		{"extended_text", &waProto.Message{
+			ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("Hello"), ContextInfo: testContextInfo},
+		}, testContextInfo},
+		{"image", &waProto.Message{ImageMessage: testImage}, testContextInfo},
+		{"ephemeral", &waProto.Message{
+			EphemeralMessage: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, testContextInfo},
+		{"view_once", &waProto.Message{
+			ViewOnceMessage: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: testImage}},
+		}, testContextInfo},
+		{"view_once_v2", &waProto.Message{
+			ViewOnceMessageV2: &waProto.FutureProofMessage{Message: &waProto.Message{
+				VideoMessage: &waProto.VideoMessage{ContextInfo: testContextInfo},
+			}},
+		}, testContextInfo},
+		{"document_with_caption", &waProto.Message{
+			DocumentWithCaptionMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+				DocumentMessage: &waProto.DocumentMessage{ContextInfo: testContextInfo},
+			}},
+		}, testContextInfo},
+		{"edited", &waProto.Message{
+			EditedMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+				ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("Hello"), ContextInfo: testContextInfo},
+			}},
+		}, testContextInfo},
+		{"sticker", &waProto.Message{
+			StickerMessage: &waProto.StickerMessage{ContextInfo: testContextInfo},
+		}, testContextInfo},
+		{"reaction", &waProto.Message{
+			ReactionMessage: &waProto.ReactionMessage{
+				Text: proto.String("👍"),
+				Key: &waProto.MessageKey{
+					Id:          proto.String("REACTED"),
+					Participant: proto.String("10000000003@s.whatsapp.net"),
+					RemoteJid:   proto.String("120363000000000001@g.us"),
+				},
+			},
+		}, &waProto.ContextInfo{
+			StanzaId:    proto.String("REACTED"),
+			Participant: proto.String("10000000003@s.whatsapp.net"),
+			RemoteJid:   proto.String("120363000000000001@g.us"),
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := WaGetContextInfo(tc.msg); !proto.Equal(got, tc.want) {
+				t.Errorf("WaGetContextInfo() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWaGetGroupTopicNameCached(t *testing.T) {
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	community := types.NewJID("120363000000000010", types.GroupServer)
+	group := types.NewJID("120363000000000011", types.GroupServer)
+	h.WhatsApp.Groups[community] = &types.GroupInfo{JID: community, GroupName: types.GroupName{Name: "Community"}}
+	h.WhatsApp.Groups[group] = &types.GroupInfo{
+		JID:               group,
+		GroupName:         types.GroupName{Name: "Group"},
+		GroupLinkedParent: types.GroupLinkedParent{LinkedParentJID: community},
+	}
+
+	if name := WaGetGroupTopicName(group); name != "Community › Group" {
+		t.Fatalf("WaGetGroupTopicName() = %q", name)
+	}
+
+	// Renamed without an event, the cached names are still used
+	h.WhatsApp.Groups[community] = &types.GroupInfo{JID: community, GroupName: types.GroupName{Name: "Renamed"}}
+	if name := WaGetGroupTopicName(group); name != "Community › Group" {
+		t.Errorf("WaGetGroupTopicName() = %q, want the cached name", name)
+	}
+
+	WaGroupInfoForget(community)
+	if name := WaGetGroupTopicName(group); name != "Renamed › Group" {
+		t.Errorf("WaGetGroupTopicName() = %q after the community changed", name)
+	}
+}
+
+func TestWaQuotedMessage(t *testing.T) {
+	newArchiveTestHarness(t)
+	state.State.Config.MessageArchive.Enabled = true
+
+	var (
+		chat   = types.NewJID("10000000002", types.DefaultUserServer)
+		stored = &waProto.Message{
+			ImageMessage:       testImage,
+			MessageContextInfo: &waProto.MessageContextInfo{MessageSecret: []byte("secret")},
+		}
+	)
+	ForwardableStore("STORED", chat, stored)
+	ArchiveMessage("ARCHIVED", chat, chat, "", false, &waProto.Message{
+		Conversation: proto.String("From the archive"),
+	}, "", time.Now())
+
+	for _, tc := range []struct {
+		name  string
+		msgId string
+		want  *waProto.Message
+	}{
+		{"stored", "STORED", &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{Caption: proto.String("A photo")},
+		}},
+		{"archive", "ARCHIVED", &waProto.Message{Conversation: proto.String("From the archive")}},
+		{"fallback", "UNKNOWN", &waProto.Message{Conversation: proto.String("Fallback")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := WaQuotedMessage(tc.msgId, chat, "Fallback"); !proto.Equal(got, tc.want) {
+				t.Errorf("WaQuotedMessage(%s) = %v, want %v", tc.msgId, got, tc.want)
+			}
+		})
+	}
+
+	if testImage.ContextInfo == nil || stored.MessageContextInfo == nil {
+		t.Errorf("the stored message was changed when quoting it")
+	}
+}
@@ -0,0 +1,209 @@
+package whatsapp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+// Telegram doesn't allow more photos than this in a media group
+const albumMaxPhotos = 10
+
+type albumPhoto struct {
+	msgId    string
+	sender   string
+	chat     string
+	photo    []byte
+	original []byte // The photo before it was processed for Telegram
+	mimetype string
+	caption  string // Caption with the header, used for the first photo
+	text     string // Caption of the photo itself, used for the rest
+	bridged  func() // Called once the photo is sent and paired, can be nil
+	done     func() // Marks the photo as no longer in flight
+}
+
+type album struct {
+	threadId int64
+	photos   []albumPhoto
+	timer    *time.Timer
+}
+
+var (
+	albumsLock sync.Mutex
+	albums     = make(map[string]*album)
+)
+
+// AlbumQueuePhoto holds a photo back for a moment in case the same sender sends
+// more, so that they are bridged together as a media group instead of flooding
+// the topic. Returns false if albums are disabled and the photo should be sent
+// right away.
+func AlbumQueuePhoto(threadId int64, photo albumPhoto) bool {
+	window := state.State.Config.Telegram.AlbumWindowSeconds
+	if window <= 0 {
+		return false
+	}
+
+	key := fmt.Sprintf("%s|%s|%d", photo.chat, photo.sender, threadId)
+
+	albumsLock.Lock()
+	defer albumsLock.Unlock()
+
+	current, found := albums[key]
+	if !found {
+		current = &album{threadId: threadId}
+		albums[key] = current
+		current.timer = time.AfterFunc(time.Duration(window)*time.Second, func() {
+			albumFlush(key, current)
+		})
+	} else {
+		current.timer.Reset(time.Duration(window) * time.Second)
+	}
+
+	// Held back photos are still being bridged, shutdown waits for them
+	photo.done = utils.LagTrackSend(photo.chat)
+	current.photos = append(current.photos, photo)
+	if len(current.photos) >= albumMaxPhotos {
+		// The next photo starts a new album, even before this one is sent
+		current.timer.Stop()
+		delete(albums, key)
+		go albumSend(current)
+	}
+	return true
+}
+
+// AlbumFlushAll sends the photos held back from every chat right away and
+// waits for them to be sent
+func AlbumFlushAll() {
+	albumsLock.Lock()
+	flushed := make([]*album, 0, len(albums))
+	for key, current := range albums {
+		current.timer.Stop()
+		delete(albums, key)
+		flushed = append(flushed, current)
+	}
+	albumsLock.Unlock()
+
+	for _, current := range flushed {
+		albumSend(current)
+	}
+}
+
+// AlbumFlushSender sends the photos held back from a sender in a chat right
+// away, before their next message is bridged
+func AlbumFlushSender(chat, sender string) {
+	prefix := chat + "|" + sender + "|"
+
+	albumsLock.Lock()
+	var flushed []*album
+	for key, current := range albums {
+		if strings.HasPrefix(key, prefix) {
+			current.timer.Stop()
+			delete(albums, key)
+			flushed = append(flushed, current)
+		}
+	}
+	albumsLock.Unlock()
+
+	for _, current := range flushed {
+		albumSend(current)
+	}
+}
+
+func albumFlush(key string, flushed *album) {
+	albumsLock.Lock()
+	if albums[key] != flushed {
+		// Already flushed because it got full
+		albumsLock.Unlock()
+		return
+	}
+	delete(albums, key)
+	albumsLock.Unlock()
+
+	albumSend(flushed)
+}
+
+func albumSend(flushed *album) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = utils.TgSenderFor(flushed.photos[0].chat)
+	)
+	defer logger.Sync()
+	defer func() {
+		for _, photo := range flushed.photos {
+			if photo.bridged != nil {
+				photo.bridged()
+			}
+			photo.done()
+		}
+	}()
+
+	if len(flushed.photos) == 1 {
+		photo := flushed.photos[0]
+		sentMsg, _ := utils.TgSendPhotoWithFallback(tgBot, cfg.Telegram.TargetChatID, photo.photo, photo.original, &gotgbot.SendPhotoOpts{
+			Caption:         photo.caption,
+			MessageThreadId: flushed.threadId,
+		}, photo.mimetype)
+		if sentMsg != nil && sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(photo.msgId, photo.sender, photo.chat,
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+	}
+
+	media := make([]gotgbot.InputMedia, 0, len(flushed.photos))
+	for idx, photo := range flushed.photos {
+		caption := photo.text
+		if idx == 0 {
+			caption = photo.caption
+		}
+		media = append(media, gotgbot.InputMediaPhoto{
+			Media:     gotgbot.NamedFile{File: bytes.NewReader(photo.photo), FileName: "photo.jpg"},
+			Caption:   caption,
+			ParseMode: "html",
+		})
+	}
+
+	sentMsgs, err := tgBot.SendMediaGroup(cfg.Telegram.TargetChatID, media, &gotgbot.SendMediaGroupOpts{
+		MessageThreadId: flushed.threadId,
+	})
+	if err != nil {
+		logger.Warn("failed to send album, sending the photos one by one",
+			zap.Int("photos", len(flushed.photos)),
+			zap.Error(err),
+		)
+		for idx, photo := range flushed.photos {
+			caption := photo.text
+			if idx == 0 {
+				caption = photo.caption
+			}
+			sentMsg, _ := utils.TgSendPhotoWithFallback(tgBot, cfg.Telegram.TargetChatID, photo.photo, photo.original, &gotgbot.SendPhotoOpts{
+				Caption:         caption,
+				MessageThreadId: flushed.threadId,
+			}, photo.mimetype)
+			if sentMsg != nil && sentMsg.MessageId != 0 {
+				database.MsgIdAddNewPair(photo.msgId, photo.sender, photo.chat,
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+			}
+		}
+		return
+	}
+
+	for idx, sentMsg := range sentMsgs {
+		if idx >= len(flushed.photos) {
+			break
+		}
+		photo := flushed.photos[idx]
+		database.MsgIdAddNewPair(photo.msgId, photo.sender, photo.chat,
+			cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+	}
+}
@@ -0,0 +1,120 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"watgbridge/fakes"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAlbumFull(t *testing.T) {
+	h := newTestHarness(t)
+	for path, data := range goldenMedia {
+		h.WhatsApp.Media[path] = data
+	}
+	state.State.Config.Telegram.AlbumWindowSeconds = 60
+
+	for i := 1; i <= albumMaxPhotos+1; i++ {
+		WhatsAppEventHandler(testMessage(fmt.Sprintf("PHOTO%d", i), testContact, testContact, &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/image"),
+				DirectPath: proto.String("/golden/image"),
+				Mimetype:   proto.String("image/jpeg"),
+				Caption:    proto.String(fmt.Sprintf("Photo %d", i)),
+				FileLength: goldenMediaLength("/golden/image"),
+			},
+		}))
+	}
+
+	// The full album is sent in the background, the last photo starts the next
+	deadline := time.Now().Add(5 * time.Second)
+	for utils.LagInFlight() > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inFlight := utils.LagInFlight(); inFlight != 1 {
+		t.Fatalf("%d photos in flight, want the last one held back", inFlight)
+	}
+	if len(h.Telegram.Sent) != albumMaxPhotos {
+		t.Fatalf("full album sent as %d messages, want %d:\n%s", len(h.Telegram.Sent), albumMaxPhotos,
+			renderTelegramSent(h.Telegram.Sent))
+	}
+	for _, sent := range h.Telegram.Sent {
+		if sent.Method != "sendMediaGroup" {
+			t.Errorf("full album sent with %s", sent.Method)
+		}
+	}
+
+	AlbumFlushAll()
+	if inFlight := utils.LagInFlight(); inFlight != 0 {
+		t.Errorf("%d photos in flight after the flush, want 0", inFlight)
+	}
+	if len(h.Telegram.Sent) != albumMaxPhotos+1 || h.Telegram.Sent[albumMaxPhotos].Method != "sendPhoto" {
+		t.Errorf("last photo not sent on its own:\n%s", renderTelegramSent(h.Telegram.Sent[albumMaxPhotos:]))
+	}
+}
+
+func testAlbumPhoto(caption string, mentioned ...string) *waProto.Message {
+	return &waProto.Message{
+		ImageMessage: &waProto.ImageMessage{
+			Url:         proto.String("https://mmg.whatsapp.net/golden/image"),
+			DirectPath:  proto.String("/golden/image"),
+			Mimetype:    proto.String("image/jpeg"),
+			Caption:     proto.String(caption),
+			FileLength:  goldenMediaLength("/golden/image"),
+			ContextInfo: &waProto.ContextInfo{MentionedJid: mentioned},
+		},
+	}
+}
+
+func TestAlbumFlushedBeforeNextMessage(t *testing.T) {
+	h := newTestHarness(t)
+	for path, data := range goldenMedia {
+		h.WhatsApp.Media[path] = data
+	}
+	state.State.Config.Telegram.AlbumWindowSeconds = 60
+	defer AlbumFlushAll()
+
+	WhatsAppEventHandler(testMessage("PHOTO1", testContact, testContact, testAlbumPhoto("Photo 1")))
+	WhatsAppEventHandler(testMessage("PHOTO2", testContact, testContact, testAlbumPhoto("Photo 2")))
+	WhatsAppEventHandler(testMessage("TEXT", testContact, testContact, &waProto.Message{
+		Conversation: proto.String("After the photos"),
+	}))
+
+	sent := h.Telegram.SentCopy()
+	if len(sent) != 3 || sent[0].Method != "sendMediaGroup" || sent[1].Method != "sendMediaGroup" ||
+		sent[2].Method != "sendMessage" {
+		t.Fatalf("album not sent before the next message:\n%s", renderTelegramSent(sent))
+	}
+}
+
+func TestAlbumMentionLinked(t *testing.T) {
+	h := newTestHarness(t)
+	for path, data := range goldenMedia {
+		h.WhatsApp.Media[path] = data
+	}
+	state.State.Config.Telegram.AlbumWindowSeconds = 60
+
+	WhatsAppEventHandler(testMessage("PHOTO1", testGroup, testMember,
+		testAlbumPhoto("Photo for @10000000001", fakes.HarnessOwnJID.String())))
+	if sent := h.Telegram.SentCopy(); len(sent) != 0 {
+		t.Fatalf("sent before the album was flushed:\n%s", renderTelegramSent(sent))
+	}
+	AlbumFlushAll()
+
+	var notification string
+	for _, sent := range h.Telegram.SentCopy() {
+		if sent.Method == "sendMessage" && strings.Contains(sent.Text, "<b>From</b>") {
+			notification = sent.Text
+		}
+	}
+	if !strings.Contains(notification, "Go to the message") {
+		t.Errorf("mention notification without a link to the photo: %q", notification)
+	}
+}
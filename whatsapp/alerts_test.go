@@ -0,0 +1,42 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAlertPinned(t *testing.T) {
+	h := newTestHarness(t)
+	state.State.Config.WhatsApp.Alerts.Rules = []string{"(?i)urgent"}
+	if err := utils.AlertRulesReload(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		state.State.Config.WhatsApp.Alerts.Rules = nil
+		utils.AlertRulesReload()
+	}()
+
+	WhatsAppEventHandler(testMessage("ALERT", testGroup, testMember, &waProto.Message{
+		Conversation: proto.String("This is URGENT"),
+	}))
+
+	var alertId int64
+	for _, sent := range h.Telegram.Sent {
+		switch {
+		case sent.Method == "sendMessage" && strings.HasPrefix(sent.Text, "#alert"):
+			alertId = sent.SentId
+		case sent.Method == "pinChatMessage" && alertId != 0:
+			if sent.MessageId != alertId {
+				t.Errorf("pinned message %d, want the alert %d", sent.MessageId, alertId)
+			}
+			return
+		}
+	}
+	t.Errorf("alert not sent and pinned:\n%s", renderTelegramSent(h.Telegram.Sent))
+}
@@ -0,0 +1,160 @@
+package whatsapp
+
+import (
+	"fmt"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// AppStateEventHandler saves chats being archived, pinned or muted on the phone
+// and mirrors it to their topics as configured in mirror_app_state. Changes
+// other than the ones coming from the sync after pairing are also noted in the
+// topic, after it is reopened for unarchived chats.
+func AppStateEventHandler(evt interface{}) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	var (
+		chat         waTypes.JID
+		fromFullSync bool
+		notice       string
+		mirror       func(tgThreadId int64) error
+	)
+	switch v := evt.(type) {
+	case *events.Archive:
+		chat, fromFullSync = v.JID, v.FromFullSync
+		archived := v.Action.GetArchived()
+		appStateUpdate(chat, func(appState *database.ChatAppState) {
+			appState.Archived = archived
+		})
+		if !cfg.WhatsApp.MirrorAppState.Archive {
+			return
+		}
+
+		notice = "📦 The chat was unarchived on WhatsApp"
+		if archived {
+			notice = "📦 The chat was archived on WhatsApp"
+		}
+		mirror = func(tgThreadId int64) error {
+			var err error
+			if archived {
+				_, err = tgBot.CloseForumTopic(cfg.Telegram.TargetChatID, tgThreadId, nil)
+			} else {
+				_, err = tgBot.ReopenForumTopic(cfg.Telegram.TargetChatID, tgThreadId, nil)
+			}
+			return err
+		}
+
+	case *events.Pin:
+		chat, fromFullSync = v.JID, v.FromFullSync
+		pinned := v.Action.GetPinned()
+		appStateUpdate(chat, func(appState *database.ChatAppState) {
+			appState.Pinned = pinned
+		})
+		if !cfg.WhatsApp.MirrorAppState.Pin {
+			return
+		}
+
+		notice = "📌 The chat was unpinned on WhatsApp"
+		if pinned {
+			notice = "📌 The chat was pinned on WhatsApp"
+		}
+		mirror = func(tgThreadId int64) error {
+			waChatId := chat.ToNonAD().String()
+			_, err := tgBot.EditForumTopic(cfg.Telegram.TargetChatID, tgThreadId, &gotgbot.EditForumTopicOpts{
+				Name: utils.TgTopicNameWithPin(waChatId, utils.TgGetTopicNameForWa(waChatId)),
+			})
+			return err
+		}
+
+	case *events.Mute:
+		chat, fromFullSync = v.JID, v.FromFullSync
+		muted := v.Action.GetMuted()
+		var mutedUntil time.Time
+		if end := v.Action.GetMuteEndTimestamp(); muted && end > 0 {
+			mutedUntil = time.UnixMilli(end)
+		}
+		appStateUpdate(chat, func(appState *database.ChatAppState) {
+			appState.Muted, appState.MutedUntil = muted, mutedUntil
+		})
+		if !cfg.WhatsApp.MirrorAppState.Mute {
+			return
+		}
+
+		notice = "🔔 The chat was unmuted on WhatsApp"
+		if muted && mutedUntil.IsZero() {
+			notice = "🔕 The chat was muted on WhatsApp, its messages are bridged silently"
+		} else if muted {
+			notice = fmt.Sprintf("🔕 The chat was muted on WhatsApp until %s, its messages are bridged silently until then",
+				mutedUntil.In(state.State.LocalLocation).Format(cfg.TimeFormat))
+		}
+
+	default:
+		return
+	}
+
+	waChatId := chat.ToNonAD().String()
+	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound || tgThreadId == 0 {
+		return
+	}
+
+	if mirror != nil {
+		if err = mirror(tgThreadId); err != nil {
+			logger.Warn("failed to mirror app state change to topic",
+				zap.String("chat", waChatId),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if fromFullSync {
+		return
+	}
+	_, err = utils.TgSenderFor(waChatId).SendMessage(cfg.Telegram.TargetChatID, notice, &gotgbot.SendMessageOpts{
+		MessageThreadId:     tgThreadId,
+		DisableNotification: true,
+	})
+	if err != nil {
+		logger.Warn("failed to post app state change to topic",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+	}
+}
+
+func appStateUpdate(chat waTypes.JID, update func(*database.ChatAppState)) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	waChatId := chat.ToNonAD().String()
+	appState, _, err := database.ChatAppStateGet(waChatId)
+	if err != nil {
+		logger.Error("failed to get app state of chat",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	appState.ID = waChatId
+	update(&appState)
+	if err = database.ChatAppStateSave(appState); err != nil {
+		logger.Error("failed to save app state of chat",
+			zap.String("chat", waChatId),
+			zap.Error(err),
+		)
+	}
+}
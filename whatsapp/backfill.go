@@ -0,0 +1,117 @@
+package whatsapp
+
+import (
+	"sort"
+	"sync"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+var (
+	// IDs of the messages currently being bridged from the history
+	backfilledMessages sync.Map
+	// Chats whose oldest known message has already been recorded
+	historyAnchoredChats sync.Map
+)
+
+func BackfillIsMessage(msgId string) bool {
+	_, found := backfilledMessages.Load(msgId)
+	return found
+}
+
+// HistoryAnchorTrack records the first message seen in a chat, so that
+// history from before it can be requested later with /backfill
+func HistoryAnchorTrack(v *events.Message) {
+	waChatId := v.Info.Chat.ToNonAD().String()
+	if _, found := historyAnchoredChats.Load(waChatId); found {
+		return
+	}
+
+	if err := database.HistoryAnchorUpdate(waChatId, v.Info.ID, v.Info.IsFromMe, v.Info.Timestamp); err == nil {
+		historyAnchoredChats.Store(waChatId, true)
+	}
+}
+
+// BackfillHistorySync bridges the messages of a history sync to the topics of
+// their chats, oldest first
+func BackfillHistorySync(v *events.HistorySync) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		waClient = state.State.WhatsAppClient
+		limit    int
+	)
+	defer logger.Sync()
+
+	switch v.Data.GetSyncType() {
+	case waProto.HistorySync_ON_DEMAND:
+		limit = 0
+	case waProto.HistorySync_INITIAL_BOOTSTRAP, waProto.HistorySync_RECENT, waProto.HistorySync_FULL:
+		if !cfg.WhatsApp.HistoryBackfill.OnPairing || cfg.WhatsApp.HistoryBackfill.MessagesPerChat <= 0 {
+			return
+		}
+		limit = cfg.WhatsApp.HistoryBackfill.MessagesPerChat
+	default:
+		return
+	}
+
+	for _, conv := range v.Data.GetConversations() {
+		chatJID, err := waTypes.ParseJID(conv.GetId())
+		if err != nil {
+			continue
+		}
+
+		var msgs []*events.Message
+		for _, historyMsg := range conv.GetMessages() {
+			evt, err := waClient.ParseWebMessage(chatJID, historyMsg.GetMessage())
+			if err != nil || evt.Message == nil {
+				continue
+			}
+			msgs = append(msgs, evt)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		sort.Slice(msgs, func(i, j int) bool {
+			return msgs[i].Info.Timestamp.Before(msgs[j].Info.Timestamp)
+		})
+		database.HistoryAnchorUpdate(chatJID.ToNonAD().String(), msgs[0].Info.ID, msgs[0].Info.IsFromMe, msgs[0].Info.Timestamp)
+
+		if limit > 0 && len(msgs) > limit {
+			msgs = msgs[len(msgs)-limit:]
+		}
+
+		bridged := 0
+		for _, msg := range msgs {
+			if msg.Message.GetProtocolMessage() != nil || msg.Message.GetReactionMessage() != nil {
+				continue
+			}
+			if msg.Info.IsFromMe && !cfg.WhatsApp.SendMyMessagesFromOtherDevices {
+				continue
+			}
+
+			text := msg.Message.GetExtendedTextMessage().GetText()
+			if text == "" {
+				text = msg.Message.GetConversation()
+			}
+
+			backfilledMessages.Store(msg.Info.ID, true)
+			MessageFromOthersEventHandler(text, msg, false)
+			backfilledMessages.Delete(msg.Info.ID)
+			bridged += 1
+		}
+
+		logger.Info("backfilled messages from history sync",
+			zap.String("chat_jid", chatJID.String()),
+			zap.String("sync_type", v.Data.GetSyncType().String()),
+			zap.Int("count", bridged),
+		)
+	}
+}
@@ -0,0 +1,232 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/fakes"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// bridgeTestMessage bridges a message and returns what it was sent to
+// Telegram as
+func bridgeTestMessage(t *testing.T, h *fakes.Harness, id string, chat, sender types.JID, msg *waProto.Message) fakes.TelegramSent {
+	t.Helper()
+
+	before := len(h.Telegram.Sent)
+	WhatsAppEventHandler(testMessage(id, chat, sender, msg))
+	if len(h.Telegram.Sent) != before+1 {
+		t.Fatalf("%s was sent to Telegram as %d messages, want 1:\n%s",
+			id, len(h.Telegram.Sent)-before, renderTelegramSent(h.Telegram.Sent[before:]))
+	}
+	return h.Telegram.Sent[before]
+}
+
+func assertPair(t *testing.T, waMsgId string, chat types.JID, sent fakes.TelegramSent) {
+	t.Helper()
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, chat.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgChatId != sent.ChatId || tgThreadId != sent.ThreadId || tgMsgId != sent.SentId {
+		t.Errorf("%s is paired with %d/%d/%d, want %d/%d/%d", waMsgId,
+			tgChatId, tgThreadId, tgMsgId, sent.ChatId, sent.ThreadId, sent.SentId)
+	}
+
+	msgId, _, chatId, err := database.MsgIdGetWaFromTg(sent.ChatId, sent.SentId, sent.ThreadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgId != waMsgId || chatId != chat.String() {
+		t.Errorf("Telegram message %d is paired with %s in %s, want %s in %s",
+			sent.SentId, msgId, chatId, waMsgId, chat)
+	}
+}
+
+func TestBridgeText(t *testing.T) {
+	h := newTestHarness(t)
+
+	threads := make(map[int64]types.JID)
+	for _, chat := range []types.JID{testContact, testGroup} {
+		sent := bridgeTestMessage(t, h, "TEXT"+chat.User, chat, testContact, &waProto.Message{
+			Conversation: proto.String("Hello"),
+		})
+		if sent.Method != "sendMessage" || sent.ChatId != fakes.HarnessTargetChatID || sent.ThreadId == 0 {
+			t.Errorf("text from %s sent as %+v", chat, sent)
+		}
+		assertPair(t, "TEXT"+chat.User, chat, sent)
+
+		if other, found := threads[sent.ThreadId]; found {
+			t.Errorf("%s and %s were bridged to the same topic", other, chat)
+		}
+		threads[sent.ThreadId] = chat
+	}
+}
+
+func TestBridgeMedia(t *testing.T) {
+	h := newTestHarness(t)
+	for path, data := range goldenMedia {
+		h.WhatsApp.Media[path] = data
+	}
+
+	sent := bridgeTestMessage(t, h, "MEDIA", testGroup, testMember, &waProto.Message{
+		DocumentMessage: &waProto.DocumentMessage{
+			Url:        proto.String("https://mmg.whatsapp.net/golden/document"),
+			DirectPath: proto.String("/golden/document"),
+			Mimetype:   proto.String("application/pdf"),
+			FileName:   proto.String("report.pdf"),
+			FileLength: goldenMediaLength("/golden/document"),
+		},
+	})
+	if sent.Method != "sendDocument" || sent.File == nil {
+		t.Errorf("document sent as %+v", sent)
+	}
+	assertPair(t, "MEDIA", testGroup, sent)
+
+	// Media that can't be downloaded is still announced in the topic
+	WhatsAppEventHandler(testMessage("MISSING", testGroup, testMember, &waProto.Message{
+		ImageMessage: &waProto.ImageMessage{
+			Url:        proto.String("https://mmg.whatsapp.net/missing"),
+			DirectPath: proto.String("/missing"),
+			Mimetype:   proto.String("image/jpeg"),
+		},
+	}))
+	inTopic := h.TelegramSentTo(sent.ThreadId)
+	if last := inTopic[len(inTopic)-1]; !strings.Contains(last.Text, "Couldn't download the photo") {
+		t.Errorf("undownloadable image sent as %+v", last)
+	}
+}
+
+func TestBridgeLargeMedia(t *testing.T) {
+	h := newTestHarness(t)
+	largeMedia := &state.State.Config.Telegram.LargeMediaHandler
+	largeMedia.Type, largeMedia.PublicURL, largeMedia.Local.Directory = utils.LargeMediaLocal, "https://media.example", t.TempDir()
+	defer func() { largeMedia.Type, largeMedia.PublicURL = "", "" }()
+	h.WhatsApp.Media["/large/video"] = []byte("large video")
+
+	sent := bridgeTestMessage(t, h, "LARGE", testGroup, testMember, &waProto.Message{
+		VideoMessage: &waProto.VideoMessage{
+			Url:        proto.String("https://mmg.whatsapp.net/large/video"),
+			DirectPath: proto.String("/large/video"),
+			Mimetype:   proto.String("video/mp4"),
+			FileLength: proto.Uint64(utils.TgUploadSizeLimit() + 1),
+		},
+	})
+	if !strings.Contains(sent.Text, "uploading it to external storage") {
+		t.Errorf("large video announced as %+v", sent)
+	}
+
+	// The upload runs in the background and links the media when done
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, edit := range h.Telegram.SentCopy() {
+			if edit.Method == "editMessageText" && edit.MessageId == sent.SentId {
+				if !strings.Contains(edit.Text, `href="https://media.example/`) {
+					t.Errorf("large video linked as %q", edit.Text)
+				}
+				if skipped, _ := database.ActivityEventCountByChat(database.ActivityMediaSkipped, time.Time{}); len(skipped) != 0 {
+					t.Errorf("uploaded video counted as skipped: %v", skipped)
+				}
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("large video never linked:\n%s", renderTelegramSent(h.Telegram.SentCopy()))
+}
+
+func TestBridgeReply(t *testing.T) {
+	h := newTestHarness(t)
+
+	original := bridgeTestMessage(t, h, "ORIGINAL", testGroup, testContact, &waProto.Message{
+		Conversation: proto.String("Question?"),
+	})
+	reply := bridgeTestMessage(t, h, "REPLY", testGroup, testMember, &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: proto.String("Answer"),
+			ContextInfo: &waProto.ContextInfo{
+				StanzaId:      proto.String("ORIGINAL"),
+				Participant:   proto.String(testContact.String()),
+				QuotedMessage: &waProto.Message{Conversation: proto.String("Question?")},
+			},
+		},
+	})
+	if reply.ReplyTo != original.SentId || reply.ThreadId != original.ThreadId {
+		t.Errorf("reply sent as %+v, want a reply to %d", reply, original.SentId)
+	}
+
+	// Replies to messages which were never bridged go to the topic as is
+	orphan := bridgeTestMessage(t, h, "ORPHAN", testGroup, testMember, &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String("Answer"),
+			ContextInfo: &waProto.ContextInfo{StanzaId: proto.String("UNKNOWN")},
+		},
+	})
+	if orphan.ReplyTo != 0 {
+		t.Errorf("reply to an unknown message sent as a reply to %d", orphan.ReplyTo)
+	}
+}
+
+func TestBridgeEdit(t *testing.T) {
+	h := newTestHarness(t)
+
+	original := bridgeTestMessage(t, h, "EDITED", testContact, testContact, &waProto.Message{
+		Conversation: proto.String("Helo"),
+	})
+	edit := bridgeTestMessage(t, h, "EDIT", testContact, testContact, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			Key: &waProto.MessageKey{
+				RemoteJid: proto.String(testContact.String()),
+				Id:        proto.String("EDITED"),
+			},
+			EditedMessage: &waProto.Message{Conversation: proto.String("Hello")},
+		},
+	})
+	if edit.ReplyTo != original.SentId {
+		t.Errorf("edit sent as %+v, want a reply to %d", edit, original.SentId)
+	}
+	compareGolden(t, "edit", renderTelegramSent([]fakes.TelegramSent{edit}))
+}
+
+func TestBridgeRevoke(t *testing.T) {
+	h := newTestHarness(t)
+	state.State.Config.WhatsApp.SendRevokedMessageUpdates = true
+
+	original := bridgeTestMessage(t, h, "REVOKED", testGroup, testMember, &waProto.Message{
+		Conversation: proto.String("Oops"),
+	})
+	revoke := bridgeTestMessage(t, h, "REVOKE", testGroup, testMember, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key: &waProto.MessageKey{
+				RemoteJid: proto.String(testGroup.String()),
+				Id:        proto.String("REVOKED"),
+			},
+		},
+	})
+	if revoke.ReplyTo != original.SentId || revoke.ThreadId != original.ThreadId {
+		t.Errorf("revoke sent as %+v, want a reply to %d", revoke, original.SentId)
+	}
+	compareGolden(t, "revoke", renderTelegramSent([]fakes.TelegramSent{revoke}))
+
+	// Revokes of messages which were never bridged aren't announced
+	before := len(h.Telegram.Sent)
+	WhatsAppEventHandler(testMessage("REVOKE2", testGroup, testMember, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key:  &waProto.MessageKey{Id: proto.String("UNKNOWN")},
+		},
+	}))
+	if len(h.Telegram.Sent) != before {
+		t.Errorf("revoke of an unknown message sent as:\n%s", renderTelegramSent(h.Telegram.Sent[before:]))
+	}
+}
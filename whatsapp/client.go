@@ -1,11 +1,12 @@
 package whatsapp
 
 import (
-	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"watgbridge/state"
+	"watgbridge/utils"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	_ "github.com/jackc/pgx/v5"
@@ -67,6 +68,10 @@ func NewWhatsAppClient() error {
 		}
 	}
 	logger = logger.Named("WaTgBridge")
+	if cfg.LogObfuscation.Enabled {
+		logger = logger.WithOptions(zap.WrapCore(utils.LogObfuscateCore))
+	}
+	logger = logger.WithOptions(zap.WrapCore(utils.LogFileCore), zap.WrapCore(utils.LogLevelCore))
 	defer logger.Sync()
 
 	waDatabaseLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Database")}
@@ -98,29 +103,29 @@ func NewWhatsAppClient() error {
 
 	client := whatsmeow.NewClient(deviceStore, waClientLogger)
 	state.State.WhatsAppClient = client
+	state.State.WhatsAppSender = client
+
+	if state.State.Config.WhatsApp.ProcessOfflineMessages {
+		client.AddEventHandler(OfflineEventBuffer)
+	}
 
 	if client.Store.ID == nil {
-		qrChan, _ := client.GetQRChannel(context.Background())
-		err = client.Connect()
-		if err != nil {
-			return fmt.Errorf("could not connect to Whatsapp for login : %s", err)
-		}
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				if state.State.TelegramBot != nil {
-					state.State.TelegramBot.SendMessage(
-						state.State.Config.Telegram.OwnerID,
-						"Please check your terminal and scan the QR code to login to WhatsApp.",
-						&gotgbot.SendMessageOpts{},
-					)
-				}
-				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
-			} else {
-				logger.Info("received WhatsApp login event",
-					zap.Any("event", evt.Event),
-				)
+		notifyOwner := func(text string) {
+			if state.State.TelegramBot != nil {
+				state.State.TelegramBot.SendMessage(state.State.Config.Telegram.OwnerID, text, &gotgbot.SendMessageOpts{})
 			}
 		}
+		pairPhone := state.State.Config.WhatsApp.PairPhoneNumber
+		err = utils.WaLogin(client, pairPhone, func(code string) {
+			notifyOwner(utils.WaPairCodeText + "\n\n<code>" + code + "</code>")
+			fmt.Printf("%s %s\n", utils.WaPairCodeText, code)
+		}, func(code string, timeout time.Duration) {
+			notifyOwner("Please check your terminal and scan the QR code to login to WhatsApp.")
+			qrterminal.GenerateHalfBlock(code, qrterminal.L, os.Stdout)
+		})
+		if err != nil {
+			return fmt.Errorf("could not login to Whatsapp : %s", err)
+		}
 	} else {
 		err = client.Connect()
 		if err != nil {
@@ -0,0 +1,99 @@
+package whatsapp
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// DocumentSendInParts splits a document too large for Telegram into parts
+// which can be joined back together, posting an index message with how to do
+// it which the parts are sent as replies to. The parts are plain byte ranges
+// of the file rather than a split zip, so they are joined by concatenating.
+func DocumentSendInParts(v *events.Message, msgId string, documentMsg *waProto.DocumentMessage,
+	bridgedText string, threadId, replyToMsgId int64) {
+
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = utils.TgSenderFor(v.Info.Chat.String())
+	)
+	defer logger.Sync()
+
+	documentBytes, err := utils.WaDownloadMedia(v.Info.Chat, documentMsg)
+	if err != nil {
+		utils.TgReportError("Failed to download a document from WhatsApp", err)
+		bridgedText += "\nCouldn't download the document due to some errors"
+		sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+			bridgedText, documentMsg.GetJpegThumbnail())
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+	}
+
+	partSize := uint64(cfg.Telegram.DocumentParts.PartSizeMB) * 1024 * 1024
+	if partSize == 0 || partSize > utils.TgUploadSizeLimit() {
+		partSize = utils.TgUploadSizeLimit()
+	}
+	parts := utils.SplitBytes(documentBytes, int(partSize))
+
+	fileName := documentMsg.GetFileName()
+	if fileName == "" {
+		fileName = "document"
+	}
+
+	if caption := documentMsg.GetCaption(); caption != "" {
+		if len(caption) > 1020 {
+			bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "...\n"
+		} else {
+			bridgedText += html.EscapeString(caption) + "\n"
+		}
+	}
+	bridgedText += fmt.Sprintf("\n<b>%s</b> (%s) is too large for Telegram and is sent in %d parts.\n",
+		html.EscapeString(fileName), utils.HumanizeBytes(int64(len(documentBytes))), len(parts))
+	bridgedText += fmt.Sprintf("They are not a zip archive, join them with <code>cat %s.0* &gt; %s</code>, "+
+		"or <code>copy /b %s.001+%s.002 %s</code> listing all the parts on Windows\n",
+		html.EscapeString(fileName), html.EscapeString(fileName),
+		html.EscapeString(fileName), html.EscapeString(fileName), html.EscapeString(fileName))
+	bridgedText += fmt.Sprintf("<b>SHA-256</b>: <code>%x</code>", sha256.Sum256(documentBytes))
+
+	indexMsg, err := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+		bridgedText, documentMsg.GetJpegThumbnail())
+	if err != nil || indexMsg.MessageId == 0 {
+		utils.TgReportError("Failed to send the index of a document split in parts", err)
+		return
+	}
+	database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+		cfg.Telegram.TargetChatID, indexMsg.MessageId, indexMsg.MessageThreadId)
+
+	for idx, part := range parts {
+		_, err = tgBot.SendDocument(cfg.Telegram.TargetChatID,
+			utils.TgInputFile(part, fmt.Sprintf("%s.%03d", fileName, idx+1)),
+			&gotgbot.SendDocumentOpts{
+				Caption:          fmt.Sprintf("Part %d/%d", idx+1, len(parts)),
+				ReplyToMessageId: indexMsg.MessageId,
+				MessageThreadId:  threadId,
+			})
+		if err != nil {
+			logger.Error("failed to send part of a document",
+				zap.String("event_id", v.Info.ID),
+				zap.Int("part", idx+1),
+				zap.Error(err),
+			)
+			utils.TgReportError(fmt.Sprintf("Failed to send part %d/%d of <b>%s</b>", idx+1, len(parts),
+				html.EscapeString(fileName)), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"watgbridge/fakes"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+const testFanOutChatID int64 = -1002000000002
+
+func newFanOutTestHarness(t *testing.T) *fakes.Harness {
+	t.Helper()
+
+	h := newTestHarness(t)
+	state.State.Config.Telegram.FanOut = append(state.State.Config.Telegram.FanOut, struct {
+		ChatID       int64    `yaml:"chat_id"`
+		Chats        []string `yaml:"chats"`
+		SingleThread bool     `yaml:"single_thread"`
+	}{ChatID: testFanOutChatID, Chats: []string{testContact.User}})
+	return h
+}
+
+// fanOutSent returns what was sent to the fan_out destination
+func fanOutSent(h *fakes.Harness) []fakes.TelegramSent {
+	var sent []fakes.TelegramSent
+	for _, s := range h.Telegram.SentCopy() {
+		if s.ChatId == testFanOutChatID {
+			sent = append(sent, s)
+		}
+	}
+	return sent
+}
+
+func TestFanOutEditAndRevoke(t *testing.T) {
+	h := newFanOutTestHarness(t)
+	state.State.Config.WhatsApp.SendRevokedMessageUpdates = true
+
+	WhatsAppEventHandler(testMessage("ORIGINAL", testContact, testContact, &waProto.Message{
+		Conversation: proto.String("Helo"),
+	}))
+	WhatsAppEventHandler(testMessage("EDIT", testContact, testContact, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			Key: &waProto.MessageKey{
+				RemoteJid: proto.String(testContact.String()),
+				Id:        proto.String("ORIGINAL"),
+			},
+			EditedMessage: &waProto.Message{Conversation: proto.String("Hello")},
+		},
+	}))
+	WhatsAppEventHandler(testMessage("REVOKE", testContact, testContact, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key: &waProto.MessageKey{
+				RemoteJid: proto.String(testContact.String()),
+				Id:        proto.String("ORIGINAL"),
+			},
+		},
+	}))
+
+	// Every message is copied right after it is bridged, and replies in the
+	// target chat are replies to the copies of the same messages there
+	sent := h.Telegram.SentCopy()
+	if len(sent) != 6 {
+		t.Fatalf("sent %d messages, want 3 and their copies:\n%s", len(sent), renderTelegramSent(sent))
+	}
+	copyOf := make(map[int64]int64)
+	for idx := 0; idx < len(sent); idx += 2 {
+		bridged, copied := sent[idx], sent[idx+1]
+		if copied.Method != "copyMessage" || copied.ChatId != testFanOutChatID || copied.ThreadId == 0 {
+			t.Fatalf("%q not copied to the fan out destination:\n%s", bridged.Text, renderTelegramSent(sent))
+		}
+		copyOf[bridged.SentId] = copied.SentId
+		if copied.ReplyTo != copyOf[bridged.ReplyTo] {
+			t.Errorf("%q copied as a reply to %d, want %d", bridged.Text, copied.ReplyTo, copyOf[bridged.ReplyTo])
+		}
+	}
+	if sent[2].ReplyTo == 0 || sent[4].ReplyTo == 0 {
+		t.Errorf("edit or revoke not sent as a reply:\n%s", renderTelegramSent(sent))
+	}
+
+	// Bridged messages edited in place have their copies edited too
+	tgMsgId := sent[0].SentId
+	_, _, err := utils.TgSenderFor(testContact.String()).EditMessageText("Hello", &gotgbot.EditMessageTextOpts{
+		ChatId:    state.State.Config.Telegram.TargetChatID,
+		MessageId: tgMsgId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copies := fanOutSent(h); len(copies) != 4 || copies[3].Method != "editMessageText" ||
+		copies[3].MessageId != copyOf[tgMsgId] {
+		t.Errorf("copy not edited along with the message:\n%s", renderTelegramSent(copies))
+	}
+}
+
+func TestFanOutAlbum(t *testing.T) {
+	h := newFanOutTestHarness(t)
+	for path, data := range goldenMedia {
+		h.WhatsApp.Media[path] = data
+	}
+	state.State.Config.Telegram.AlbumWindowSeconds = 60
+
+	WhatsAppEventHandler(testMessage("PHOTO1", testContact, testContact, testAlbumPhoto("Photo 1")))
+	WhatsAppEventHandler(testMessage("PHOTO2", testContact, testContact, testAlbumPhoto("Photo 2")))
+	AlbumFlushAll()
+
+	copies := fanOutSent(h)
+	if len(copies) != 2 || copies[0].Method != "sendMediaGroup" || copies[1].Method != "sendMediaGroup" {
+		t.Fatalf("album not copied as an album:\n%s", renderTelegramSent(copies))
+	}
+	for idx, copied := range copies {
+		if fileId, _ := copied.File.(string); fileId == "" {
+			t.Errorf("photo %d of the album uploaded again instead of sent by its file ID", idx+1)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package whatsapp
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+var updateGolden = flag.Bool("update", false, "write the golden files from the current output")
+
+// The media of the golden messages, downloaded from the fake by direct path
+var goldenMedia = map[string][]byte{
+	"/golden/image":    []byte("\xff\xd8\xff\xe0 fake jpeg"),
+	"/golden/video":    []byte("\x00\x00\x00\x18ftypmp42 fake mp4"),
+	"/golden/audio":    []byte("ID3 fake mp3"),
+	"/golden/voice":    []byte("OggS fake opus"),
+	"/golden/document": []byte("%PDF-1.4 fake pdf"),
+	"/golden/sticker":  []byte("RIFF\x00\x00\x00\x00WEBPVP8 fake webp"),
+}
+
+func goldenMediaLength(path string) *uint64 {
+	return proto.Uint64(uint64(len(goldenMedia[path])))
+}
+
+func TestBridgeGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		chat types.JID
+		msg  *waProto.Message
+	}{
+		{"text", testContact, &waProto.Message{
+			Conversation: proto.String("Hello <b>there</b> & welcome"),
+		}},
+		{"extended_text", testContact, &waProto.Message{
+			ExtendedTextMessage: &waProto.ExtendedTextMessage{
+				Text:        proto.String("Look at https://example.com"),
+				MatchedText: proto.String("https://example.com"),
+			},
+		}},
+		{"group_text", testGroup, &waProto.Message{
+			Conversation: proto.String("Hello group"),
+		}},
+		{"forwarded_text", testContact, &waProto.Message{
+			ExtendedTextMessage: &waProto.ExtendedTextMessage{
+				Text:        proto.String("Passed along"),
+				ContextInfo: &waProto.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(2)},
+			},
+		}},
+		{"image", testContact, &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/image"),
+				DirectPath: proto.String("/golden/image"),
+				Mimetype:   proto.String("image/jpeg"),
+				Caption:    proto.String("A photo"),
+				FileLength: goldenMediaLength("/golden/image"),
+			},
+		}},
+		{"video", testContact, &waProto.Message{
+			VideoMessage: &waProto.VideoMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/video"),
+				DirectPath: proto.String("/golden/video"),
+				Mimetype:   proto.String("video/mp4"),
+				Caption:    proto.String("A video"),
+				FileLength: goldenMediaLength("/golden/video"),
+			},
+		}},
+		{"gif", testContact, &waProto.Message{
+			VideoMessage: &waProto.VideoMessage{
+				Url:         proto.String("https://mmg.whatsapp.net/golden/video"),
+				DirectPath:  proto.String("/golden/video"),
+				Mimetype:    proto.String("video/mp4"),
+				GifPlayback: proto.Bool(true),
+				FileLength:  goldenMediaLength("/golden/video"),
+			},
+		}},
+		{"video_note", testContact, &waProto.Message{
+			PtvMessage: &waProto.VideoMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/video"),
+				DirectPath: proto.String("/golden/video"),
+				Mimetype:   proto.String("video/mp4"),
+				FileLength: goldenMediaLength("/golden/video"),
+			},
+		}},
+		{"audio", testContact, &waProto.Message{
+			AudioMessage: &waProto.AudioMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/audio"),
+				DirectPath: proto.String("/golden/audio"),
+				Mimetype:   proto.String("audio/mpeg"),
+				Seconds:    proto.Uint32(12),
+				FileLength: goldenMediaLength("/golden/audio"),
+			},
+		}},
+		{"voice", testContact, &waProto.Message{
+			AudioMessage: &waProto.AudioMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/voice"),
+				DirectPath: proto.String("/golden/voice"),
+				Mimetype:   proto.String("audio/ogg; codecs=opus"),
+				Ptt:        proto.Bool(true),
+				Seconds:    proto.Uint32(3),
+				FileLength: goldenMediaLength("/golden/voice"),
+			},
+		}},
+		{"document", testContact, &waProto.Message{
+			DocumentMessage: &waProto.DocumentMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/document"),
+				DirectPath: proto.String("/golden/document"),
+				Mimetype:   proto.String("application/pdf"),
+				FileName:   proto.String("report.pdf"),
+				Caption:    proto.String("The report"),
+				FileLength: goldenMediaLength("/golden/document"),
+			},
+		}},
+		{"sticker", testContact, &waProto.Message{
+			StickerMessage: &waProto.StickerMessage{
+				Url:        proto.String("https://mmg.whatsapp.net/golden/sticker"),
+				DirectPath: proto.String("/golden/sticker"),
+				Mimetype:   proto.String("image/webp"),
+				FileLength: goldenMediaLength("/golden/sticker"),
+			},
+		}},
+		{"contact", testContact, &waProto.Message{
+			ContactMessage: &waProto.ContactMessage{
+				DisplayName: proto.String("Bob"),
+				Vcard:       proto.String("BEGIN:VCARD\nVERSION:3.0\nFN:Bob\nTEL;type=CELL;waid=10000000009:+1 000 000 0009\nEND:VCARD"),
+			},
+		}},
+		{"location", testContact, &waProto.Message{
+			LocationMessage: &waProto.LocationMessage{
+				DegreesLatitude:  proto.Float64(48.8584),
+				DegreesLongitude: proto.Float64(2.2945),
+				Name:             proto.String("Eiffel Tower"),
+			},
+		}},
+		{"live_location", testContact, &waProto.Message{
+			LiveLocationMessage: &waProto.LiveLocationMessage{
+				DegreesLatitude:  proto.Float64(51.5007),
+				DegreesLongitude: proto.Float64(-0.1246),
+				Caption:          proto.String("On my way"),
+			},
+		}},
+		{"poll", testGroup, &waProto.Message{
+			PollCreationMessage: &waProto.PollCreationMessage{
+				Name: proto.String("Lunch?"),
+				Options: []*waProto.PollCreationMessage_Option{
+					{OptionName: proto.String("Pizza")},
+					{OptionName: proto.String("Sushi")},
+				},
+				SelectableOptionsCount: proto.Uint32(1),
+			},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			for path, data := range goldenMedia {
+				h.WhatsApp.Media[path] = data
+			}
+
+			WhatsAppEventHandler(testMessage("GOLDEN"+tc.name, tc.chat, testContact, tc.msg))
+
+			got := renderTelegramSent(h.TelegramSentTo(1))
+			compareGolden(t, tc.name, got)
+		})
+	}
+}
+
+func compareGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s (run the tests with -update to write it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("bridged output differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"html"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"watgbridge/database"
 	"watgbridge/state"
@@ -26,12 +27,23 @@ import (
 func WhatsAppEventHandler(evt interface{}) {
 
 	cfg := state.State.Config
+	defer utils.LagTrackEvent()()
 
 	switch v := evt.(type) {
 
 	case *events.LoggedOut:
 		LogoutHandler(v)
 
+	case *events.Connected, *events.Disconnected, *events.StreamReplaced,
+		*events.TemporaryBan, *events.ConnectFailure, *events.ClientOutdated:
+		ConnectionEventHandler(v)
+
+	case *events.KeepAliveTimeout:
+		utils.HealthRecordKeepAlive(false)
+
+	case *events.KeepAliveRestored:
+		utils.HealthRecordKeepAlive(true)
+
 	case *events.Receipt:
 		ReceiptEventHandler(v)
 
@@ -41,6 +53,7 @@ func WhatsAppEventHandler(evt interface{}) {
 		}
 
 	case *events.GroupInfo:
+		GroupJoinRequestEventHandler(v)
 		if !cfg.WhatsApp.SkipGroupSettingsUpdates {
 			GroupInfoEventHandler(v)
 		}
@@ -51,8 +64,59 @@ func WhatsAppEventHandler(evt interface{}) {
 	case *events.CallOffer:
 		CallOfferEventHandler(v)
 
+	case *events.OfflineSyncPreview:
+		logger := state.State.Logger
+		logger.Info("receiving events missed while offline",
+			zap.Int("messages", v.Messages),
+			zap.Int("receipts", v.Receipts),
+			zap.Int("notifications", v.Notifications),
+		)
+		_ = logger.Sync()
+
+	case *events.OfflineSyncCompleted:
+		logger := state.State.Logger
+		logger.Info("received all events missed while offline",
+			zap.Int("count", v.Count),
+		)
+		_ = logger.Sync()
+
+	case *events.HistorySync:
+		HistorySyncEventHandler(v)
+
+	case *events.UndecryptableMessage:
+		utils.StatsRecordUndecryptable(v.Info.Chat.String(), v.IsUnavailable)
+
+	case *events.NewsletterJoin:
+		NewsletterJoinEventHandler(v)
+
+	case *events.NewsletterLeave:
+		NewsletterLeaveEventHandler(v)
+
+	case *events.Star:
+		utils.ArchiveMessageStarred(v.MessageID, v.ChatJID, v.Action.GetStarred())
+
+	case *events.Blocklist:
+		BlocklistEventHandler(v)
+
+	case *events.PrivacySettings:
+		PrivacySettingsEventHandler(v)
+
+	case *events.Archive, *events.Pin, *events.Mute:
+		AppStateEventHandler(v)
+
 	case *events.Message:
 
+		// Chats are looked up by their normalized JID, so that the same chat
+		// never gets a second topic. The device of the sender is kept.
+		v.Info.Chat = utils.WaNormalizeJID(v.Info.Chat)
+		v.Info.Sender = utils.WaResolveLID(v.Info.Sender)
+
+		utils.LagRecordDelivery(v.Info.Timestamp)
+		HistoryAnchorTrack(v)
+		if v.RetryCount > 0 {
+			utils.StatsRecordRetryRecovered(v.Info.Chat.String())
+		}
+
 		isEdited := false
 		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
 			protoMsg.GetType() == waProto.ProtocolMessage_MESSAGE_EDIT {
@@ -67,12 +131,29 @@ func WhatsAppEventHandler(evt interface{}) {
 
 		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
 			protoMsg.GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING {
-			if protoMsg.GetEphemeralExpiration() == 0 {
-				database.UpdateEphemeralSettings(v.Info.Chat.ToNonAD().String(), false, 0)
-			} else {
-				database.UpdateEphemeralSettings(v.Info.Chat.ToNonAD().String(), true, protoMsg.GetEphemeralExpiration())
-			}
+			EphemeralSettingEventHandler(v)
+			return
+		}
 
+		if v.Message.GetPinInChatMessage() != nil {
+			PinInChatEventHandler(v)
+			return
+		}
+
+		if v.Message.GetKeepInChatMessage() != nil {
+			KeepInChatEventHandler(v)
+			return
+		}
+
+		if v.Message.GetPollUpdateMessage() != nil {
+			PollUpdateEventHandler(v)
+			return
+		}
+
+		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
+			protoMsg.GetType() == waProto.ProtocolMessage_HISTORY_SYNC_NOTIFICATION {
+			// whatsmeow downloads the history itself and dispatches it as
+			// an events.HistorySync, nothing to bridge here
 			return
 		}
 
@@ -114,9 +195,9 @@ func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
 
 	// Get ID of the current chat
 	if text == ".id" {
-		waClient := state.State.WhatsAppClient
+		waSender := state.State.WhatsAppSender
 
-		_, err := waClient.SendMessage(context.Background(), v.Info.Chat, &waProto.Message{
+		_, err := waSender.SendMessage(context.Background(), v.Info.Chat, &waProto.Message{
 			ExtendedTextMessage: &waProto.ExtendedTextMessage{
 				Text: proto.String(fmt.Sprintf("The ID of the current chat is:\n```%s```", v.Info.Chat.String())),
 				ContextInfo: &waProto.ContextInfo{
@@ -151,10 +232,8 @@ func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
 
 func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool) {
 	var (
-		cfg      = state.State.Config
-		logger   = state.State.Logger
-		tgBot    = state.State.TelegramBot
-		waClient = state.State.WhatsAppClient
+		cfg    = state.State.Config
+		logger = state.State.Logger
 	)
 	defer logger.Sync()
 
@@ -165,6 +244,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		msgId = v.Info.ID
 	}
 
+	backfilled := !isEdited && BackfillIsMessage(msgId)
+
 	if !isEdited {
 		// Return if duplicate event is emitted
 		tgChatId, _, _, _ := database.MsgIdGetTgFromWa(msgId, v.Info.Chat.String())
@@ -177,6 +258,22 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
+	if !v.Info.IsFromMe {
+		if ignored, reason := utils.WaMessageIsIgnored(v, text); ignored {
+			logger.Debug("returning because message matched an ignore rule",
+				zap.String("event_id", v.Info.ID),
+				zap.String("chat_jid", v.Info.Chat.String()),
+				zap.String("reason", reason),
+			)
+			return
+		}
+	}
+
+	isAlert := false
+	if !isEdited && !backfilled && !v.Info.IsFromMe {
+		isAlert = AlertRulesCheck(v)
+	}
+
 	if !v.Info.IsFromMe {
 		// Return if status is from ignored chat
 		if v.Info.Chat.String() == "status@broadcast" &&
@@ -196,55 +293,138 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
-	replyMarkup := utils.TgBuildUrlButton(utils.WaGetContactName(v.Info.Sender), fmt.Sprintf("https://wa.me/%s", v.Info.MessageSource.Sender.ToNonAD().User))
-	if !isEdited {
-		if lowercaseText := strings.ToLower(text); !v.Info.IsFromMe && v.Info.IsGroup && slices.Contains(cfg.WhatsApp.TagAllAllowedGroups, v.Info.Chat.User) &&
-			(strings.Contains(lowercaseText, "@all") || strings.Contains(lowercaseText, "@everyone")) {
-			logger.Debug("usage of @all/@everyone command from your account",
-				zap.String("event_id", v.Info.ID),
-				zap.String("chat_jid", v.Info.Chat.String()),
-			)
-			utils.WaTagAll(v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), false)
-		}
+	if !utils.WaChatIsBridged(v.Info.Chat) {
+		logger.Debug("returning because chat is not in only_chats",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
 	}
 
-	var bridgedText string
-	if cfg.WhatsApp.SkipChatDetails {
-		logger.Debug("skipping to add chat details as configured",
+	if _, paused, _ := database.PausedChatGet(v.Info.Chat.String()); paused {
+		logger.Debug("returning because bridging of the chat is paused",
 			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
 		)
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "<b>#Broadcast</b>\n"
-		} else if v.Info.IsFromMe {
-			bridgedText += "<b>You</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
+		return
+	}
 
+	if !v.Info.IsFromMe && QuarantineIgnored(v.Info.Chat) {
+		logger.Debug("returning because the new chat was ignored",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
+	}
+
+	defer utils.LagTrackSend(v.Info.Chat.String())()
+	database.ActivityEventAdd(database.ActivityMessage, v.Info.Chat.String(), 0)
+	if isEdited {
+		utils.ArchiveMessageEdit(msgId, v.Info.Chat, text, v.Info.Timestamp)
 	} else {
+		utils.ArchiveMessage(msgId, v.Info.Chat, v.Info.MessageSource.Sender, v.Info.PushName, v.Info.IsFromMe,
+			v.Message, text, v.Info.Timestamp)
+		utils.ForwardableStore(msgId, v.Info.Chat, v.Message)
+	}
 
-		if v.Info.IsFromMe {
-			bridgedText += "<b>You</b>\n"
-		} else {
-			bridgedText += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "<b>#Broadcast</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
-		} else {
-			bridgedText += "<b>#Private</b>\n"
+	if !isEdited && !backfilled && !v.Info.IsFromMe && !v.Info.IsGroup && v.Info.Chat.Server == waTypes.DefaultUserServer {
+		AwayModeAutoReply(v)
+	}
+
+	if !isAlert && !backfilled && !v.Info.IsFromMe && QuietHoursQueueMessage(text, v, isEdited) {
+		return
+	}
+
+	messageSendToTelegram(text, v, isEdited, backfilled, msgId)
+}
+
+// messageSendToTelegram bridges a message that passed all the filters to
+// Telegram, msgId is the id of the edited message if isEdited is true
+func messageSendToTelegram(text string, v *events.Message, isEdited, backfilled bool, msgId string) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = utils.TgSenderFor(v.Info.Chat.String())
+		waClient = state.State.WhatsAppClient
+	)
+	defer logger.Sync()
+
+	// Photos of the sender still held back for an album are sent before their
+	// next message so that the order is kept
+	if v.Message.GetImageMessage() == nil {
+		AlbumFlushSender(v.Info.Chat.String(), v.Info.MessageSource.Sender.String())
+	}
+
+	// Sent once the message is bridged so it can link to it
+	var mentionNotify func()
+	defer func() {
+		if mentionNotify != nil {
+			mentionNotify()
 		}
+	}()
 
+	isNewsletter := v.Info.Chat.Server == waTypes.NewsletterServer
+	senderName := utils.WaGetContactName(v.Info.Sender)
+	if isNewsletter {
+		senderName = utils.WaGetNewsletterName(v.Info.Chat)
 	}
 
-	if isEdited {
-		bridgedText += "<b>Edited</b>\n"
+	replyMarkup := utils.TgBuildUrlButton(senderName, fmt.Sprintf("https://wa.me/%s", v.Info.MessageSource.Sender.ToNonAD().User))
+	if isNewsletter {
+		replyMarkup = utils.TgBuildUrlButton(senderName, utils.WaGetNewsletterLink(v.Info.Chat))
+	} else if v.Info.IsFromMe && v.Info.Chat.Server == waTypes.DefaultUserServer {
+		// Point to who the message was sent to rather than to yourself
+		replyMarkup = utils.TgBuildUrlButton(utils.WaGetContactName(v.Info.Chat), fmt.Sprintf("https://wa.me/%s", v.Info.Chat.User))
+	}
+	if !isEdited && !backfilled {
+		if lowercaseText := strings.ToLower(text); !v.Info.IsFromMe && v.Info.IsGroup && slices.Contains(cfg.WhatsApp.TagAllAllowedGroups, v.Info.Chat.User) &&
+			(strings.Contains(lowercaseText, "@all") || strings.Contains(lowercaseText, "@everyone")) {
+			logger.Debug("usage of @all/@everyone command from your account",
+				zap.String("event_id", v.Info.ID),
+				zap.String("chat_jid", v.Info.Chat.String()),
+			)
+			utils.WaTagAll(v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), false)
+		}
 	}
 
-	if time.Since(v.Info.Timestamp).Seconds() > 60 {
-		bridgedText += fmt.Sprintf("<b>%s</b>\n",
-			html.EscapeString(v.Info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+	header := utils.BridgeHeader{
+		Sender:          senderName,
+		SenderNumber:    v.Info.MessageSource.Sender.ToNonAD().User,
+		IsFromMe:        v.Info.IsFromMe,
+		IsGroup:         v.Info.IsGroup,
+		IsChannel:       isNewsletter,
+		IsBroadcast:     v.Info.IsIncomingBroadcast(),
+		IsEdited:        isEdited,
+		IsBackfilled:    backfilled,
+		SkipChatDetails: cfg.WhatsApp.SkipChatDetails && !cfg.Telegram.SingleStream,
+	}
+	header.SetTime(v.Info.Timestamp)
+	if v.Info.IsFromMe {
+		header.Sender = cfg.WhatsApp.MyMessagesLabel
+	}
+	if header.IsBroadcast {
+		header.Chat = "#Broadcast"
+	} else if v.Info.Chat == waTypes.StatusBroadcastJID {
+		header.Chat = "#Story"
+	} else if v.Info.IsGroup {
+		// The topic already has the name, it isn't fetched when it isn't shown
+		if !header.SkipChatDetails {
+			header.Chat = utils.WaGetGroupTopicName(v.Info.Chat)
+		}
+	} else if isNewsletter {
+		header.Chat = "#Channel"
+	} else if v.Info.IsFromMe && cfg.Telegram.SingleStream {
+		// Without a topic of the chat, this is the only way to tell who it was sent to
+		header.Chat = utils.WaGetContactName(v.Info.Chat)
+		header.IsPrivate = true
+	} else {
+		header.Chat = "#Private"
+		header.IsPrivate = true
+	}
+	if header.SkipChatDetails {
+		logger.Debug("skipping to add chat details as configured",
+			zap.String("event_id", v.Info.ID),
+		)
 	}
 
 	var (
@@ -255,6 +435,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 	if isEdited {
 
+		// The edited text may still be held back to be combined
+		TextBatchFlushChat(v.Info.Chat.String())
 		tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(
 			v.Message.GetProtocolMessage().GetKey().GetId(),
 			v.Info.Chat.String(),
@@ -270,75 +452,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		logger.Debug("trying to retrieve context info from Message",
 			zap.String("event_id", v.Info.ID),
 		)
-		var contextInfo *waProto.ContextInfo = nil
-
-		if v.Message.GetExtendedTextMessage().GetContextInfo() != nil {
-			logger.Debug("taking context info from ExtendedTextMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetExtendedTextMessage().GetContextInfo()
-		} else if v.Message.GetImageMessage() != nil {
-			logger.Debug("taking context info from ImageMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetImageMessage().GetContextInfo()
-		} else if v.Message.GetVideoMessage() != nil {
-			logger.Debug("taking context info from VideoMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetVideoMessage().GetContextInfo()
-		} else if v.Message.GetAudioMessage() != nil {
-			logger.Debug("taking context info from AudioMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetAudioMessage().GetContextInfo()
-		} else if v.Message.GetDocumentMessage() != nil {
-			logger.Debug("taking context info from DocumentMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetDocumentMessage().GetContextInfo()
-		} else if v.Message.GetStickerMessage() != nil {
-			logger.Debug("taking context info from StickerMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetStickerMessage().GetContextInfo()
-		} else if v.Message.GetContactMessage() != nil {
-			logger.Debug("taking context info from ContactMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetContactMessage().GetContextInfo()
-		} else if v.Message.GetContactsArrayMessage() != nil {
-			logger.Debug("taking context info from ContactsArrayMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetContactsArrayMessage().GetContextInfo()
-		} else if v.Message.GetLocationMessage() != nil {
-			logger.Debug("taking context info from LocationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetLocationMessage().GetContextInfo()
-		} else if v.Message.GetLiveLocationMessage() != nil {
-			logger.Debug("taking context info from LiveLocationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetLiveLocationMessage().GetContextInfo()
-		} else if v.Message.GetPollCreationMessage() != nil {
-			logger.Debug("taking context info from PollCreationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessage().GetContextInfo()
-		} else if v.Message.GetPollCreationMessageV2() != nil {
-			logger.Debug("taking context info from PollCreationMessageV2",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessageV2().GetContextInfo()
-		} else if v.Message.GetPollCreationMessageV3() != nil {
-			logger.Debug("taking context info from PollCreationMessageV3",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessageV3().GetContextInfo()
-		} else {
-			logger.Debug("no context info found in any kind of messages",
+		contextInfo := utils.WaGetContextInfo(v.Message)
+		if contextInfo == nil {
+			logger.Debug("no context info found in the message",
 				zap.String("event_id", v.Info.ID),
 			)
 		}
@@ -346,30 +462,19 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		if contextInfo != nil {
 
 			if contextInfo.GetIsForwarded() {
-				bridgedText += fmt.Sprintf("<b>Forwarded (%v)</b>\n", contextInfo.GetForwardingScore())
+				header.IsForwarded = true
+				header.ForwardingScore = contextInfo.GetForwardingScore()
 			}
 
 			logger.Debug("checking if your account is mentioned in the message",
 				zap.String("event_id", v.Info.ID),
 			)
-			if mentioned := contextInfo.GetMentionedJid(); v.Info.IsGroup && mentioned != nil {
+			if mentioned := contextInfo.GetMentionedJid(); v.Info.IsGroup && !v.Info.IsFromMe && mentioned != nil {
 				for _, jid := range mentioned {
 					parsedJid, _ := utils.WaParseJID(jid)
 					if parsedJid.User == waClient.Store.ID.User {
-
-						tagInfoText := fmt.Sprintf("<b>%s</b>",
-							html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
-
-						threadId, err := utils.TgGetOrMakeThreadFromWa("#Mentions", cfg.Telegram.TargetChatID, "#Mentions")
-						if err != nil {
-							utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/find thread id for 'mentions'", err)
-						} else {
-							tgBot.SendMessage(cfg.Telegram.TargetChatID, tagInfoText, &gotgbot.SendMessageOpts{
-								MessageThreadId: threadId,
-								ReplyMarkup:     replyMarkup,
-							})
-						}
-
+						mentionMarkup := replyMarkup
+						mentionNotify = func() { MentionNotify(v, msgId, mentionMarkup) }
 						break
 					}
 				}
@@ -379,6 +484,10 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				zap.String("event_id", v.Info.ID),
 			)
 			stanzaId := contextInfo.GetStanzaId()
+			if stanzaId != "" {
+				// The quoted text may still be held back to be combined
+				TextBatchFlushChat(v.Info.Chat.String())
+			}
 			tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(stanzaId, v.Info.Chat.String())
 			if err == nil && tgChatId == cfg.Telegram.TargetChatID {
 				replyToMsgId = tgMsgId
@@ -388,32 +497,46 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
-	if !strings.HasSuffix(bridgedText, "\n\n") {
-		bridgedText += "\n"
-	}
-
 	if !threadIdFound {
 		var err error
 		if v.Info.Chat.String() == "status@broadcast" {
-			threadId, err = utils.TgGetOrMakeThreadFromWa("status@broadcast", cfg.Telegram.TargetChatID,
-				"#Stories")
+			poster := v.Info.MessageSource.Sender.ToNonAD()
+			switch cfg.WhatsApp.StatusTopics {
+			case "contact":
+				threadId, err = utils.TgGetOrMakeThreadFromWa(poster.String(), cfg.Telegram.TargetChatID,
+					utils.WaGetContactName(poster))
+			case "per_contact":
+				threadId, err = utils.TgGetOrMakeThreadFromWa(utils.WaStoryTopicKey(poster), cfg.Telegram.TargetChatID,
+					utils.WaGetStoryTopicName(poster))
+			default:
+				threadId, err = utils.TgGetOrMakeThreadFromWa("status@broadcast", cfg.Telegram.TargetChatID,
+					"#Stories")
+			}
 			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/find thread id for 'status@broadcast'", err)
+				utils.TgReportError("Failed to create/find thread id for 'status@broadcast'", err)
 				return
 			}
 		} else if v.Info.IsIncomingBroadcast() {
 			threadId, err = utils.TgGetOrMakeThreadFromWa(v.Info.MessageSource.Sender.ToNonAD().String(), cfg.Telegram.TargetChatID,
 				utils.WaGetContactName(v.Info.MessageSource.Sender))
 			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
+				utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
 					v.Info.MessageSource.Sender.ToNonAD().String()), err)
 				return
 			}
+		} else if isNewsletter {
+			threadId, err = utils.TgGetOrMakeThreadFromWa(v.Info.Chat.String(), cfg.Telegram.TargetChatID,
+				senderName)
+			if err != nil {
+				utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
+					v.Info.Chat.String()), err)
+				return
+			}
 		} else if v.Info.IsGroup {
 			threadId, err = utils.TgGetOrMakeThreadFromWa(v.Info.Chat.String(), cfg.Telegram.TargetChatID,
-				utils.WaGetGroupName(v.Info.Chat))
+				utils.WaGetGroupTopicName(v.Info.Chat))
 			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
+				utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
 					v.Info.Chat.String()), err)
 				return
 			}
@@ -425,15 +548,37 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				target_chat_jid = v.Info.Chat
 			}
 
-			threadId, err = utils.TgGetOrMakeThreadFromWa(target_chat_jid.ToNonAD().String(), cfg.Telegram.TargetChatID, utils.WaGetContactName(target_chat_jid))
-			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
-					target_chat_jid.ToNonAD().String()), err)
-				return
+			if quarantineThreadId, quarantined := QuarantineThread(target_chat_jid, v.Info.IsFromMe, v.Message); quarantined {
+				threadId = quarantineThreadId
+			} else {
+				threadId, err = utils.TgGetOrMakeThreadFromWa(target_chat_jid.ToNonAD().String(), cfg.Telegram.TargetChatID, utils.WaGetContactName(target_chat_jid))
+				if err != nil {
+					utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
+						target_chat_jid.ToNonAD().String()), err)
+					return
+				}
 			}
 		}
 	}
 
+	if isEdited || backfilled {
+		utils.HeaderBreakContinuation(cfg.Telegram.TargetChatID, threadId)
+	} else if utils.HeaderIsContinuation(cfg.Telegram.TargetChatID, threadId, v.Info.Chat.String(),
+		v.Info.MessageSource.Sender.ToNonAD().String(), v.Info.Timestamp) {
+		// Replies are shown with the header, they don't continue what was said before
+		header.IsContinuation = replyToMsgId == 0
+	}
+
+	bridgedText := utils.RenderBridgeHeader(header)
+	if bridgedText != "" && !strings.HasSuffix(bridgedText, "\n\n") {
+		bridgedText += "\n"
+	}
+
+	if v.Message.GetConversation() == "" && v.Message.GetExtendedTextMessage() == nil {
+		// Texts held back to be combined go before anything else from the chat
+		TextBatchFlush(v.Info.Chat.String(), threadId)
+	}
+
 	if v.Message.GetImageMessage() != nil {
 
 		imageMsg := v.Message.GetImageMessage()
@@ -441,36 +586,34 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipImages {
-			bridgedText += "\nSkipping image because 'skip_images' set in config file"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeImages); skip {
+			bridgedText += fmt.Sprintf("\nSkipping image because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(imageMsg.GetFileLength()))
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, imageMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && imageMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the photo as it exceeds Telegram size restrictions."
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		} else if imageMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("photo")
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, imageMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, imageMsg, int64(imageMsg.GetFileLength()),
+				"photo", "", imageMsg.GetMimetype())
 			return
 		} else {
-			imageBytes, err := waClient.Download(imageMsg)
+			imageBytes, err := utils.WaDownloadMedia(v.Info.Chat, imageMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a photo from WhatsApp", err)
 				bridgedText += "\nCouldn't download the photo due to some errors"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyToMessageId: replyToMsgId,
-					MessageThreadId:  threadId,
-				})
+				sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+					bridgedText, imageMsg.GetJpegThumbnail())
 				if sentMsg.MessageId != 0 {
 					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
@@ -478,23 +621,149 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 
-			if caption := imageMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
+			captionText, captionOverflow := utils.TgCaptionText(imageMsg.GetCaption())
+			bridgedText += captionText
+
+			originalBytes := imageBytes
+			imageBytes = utils.ImageProcessForTelegram(imageBytes)
+			if !isEdited && replyToMsgId == 0 && captionOverflow == "" && AlbumQueuePhoto(threadId, albumPhoto{
+				msgId:    msgId,
+				sender:   v.Info.MessageSource.Sender.String(),
+				chat:     v.Info.Chat.String(),
+				photo:    imageBytes,
+				original: originalBytes,
+				mimetype: imageMsg.GetMimetype(),
+				caption:  bridgedText,
+				text:     captionText,
+				bridged:  mentionNotify,
+			}) {
+				// The album notifies about the mention once it is sent
+				mentionNotify = nil
+				return
 			}
 
-			sentMsg, _ := tgBot.SendPhoto(cfg.Telegram.TargetChatID, imageBytes, &gotgbot.SendPhotoOpts{
+			AlbumFlushSender(v.Info.Chat.String(), v.Info.MessageSource.Sender.String())
+			sentMsg, _ := utils.TgSendPhotoWithFallback(tgBot, cfg.Telegram.TargetChatID, imageBytes, originalBytes, &gotgbot.SendPhotoOpts{
 				Caption:          bridgedText,
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
-			})
+			}, imageMsg.GetMimetype())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			if err = utils.TgSendCaptionOverflow(tgBot, sentMsg, captionOverflow); err != nil {
+				logger.Warn("failed to send the rest of the caption",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
+	} else if v.Message.GetPtvMessage() != nil {
+
+		ptvMsg := v.Message.GetPtvMessage()
+		if ptvMsg.GetUrl() == "" {
+			return
+		}
+
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeVideos); skip {
+			bridgedText += fmt.Sprintf("\nSkipping video note because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(ptvMsg.GetFileLength()))
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, ptvMsg.GetJpegThumbnail())
+			if sentMsg.MessageId != 0 {
+				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+			}
+			return
+		} else if ptvMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("video note")
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, ptvMsg.GetJpegThumbnail())
+			if sentMsg.MessageId != 0 {
+				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, ptvMsg, int64(ptvMsg.GetFileLength()),
+				"video note", "", ptvMsg.GetMimetype())
+			return
+		} else {
+			ptvBytes, err := utils.WaDownloadMedia(v.Info.Chat, ptvMsg)
+			if err != nil {
+				utils.TgReportError("Failed to download a video note from WhatsApp", err)
+				bridgedText += "\nCouldn't download the video note due to some errors"
+				sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+					bridgedText, ptvMsg.GetJpegThumbnail())
+				if sentMsg.MessageId != 0 {
+					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+				}
+				return
+			}
+
+			// Telegram only plays square video notes, others are cropped and
+			// sent as a normal video if that isn't possible
+			var (
+				length    = int64(ptvMsg.GetWidth())
+				sentMsg   *gotgbot.Message
+				canBeNote = ptvMsg.GetWidth() == ptvMsg.GetHeight()
+			)
+			if !canBeNote {
+				if squareBytes, err := utils.VideoConvertToSquare(ptvBytes, 640, v.Info.ID); err == nil {
+					ptvBytes, canBeNote = squareBytes, true
+					length = int64(ptvMsg.GetWidth())
+					if ptvMsg.GetHeight() < ptvMsg.GetWidth() {
+						length = int64(ptvMsg.GetHeight())
+					}
+					if length > 640 {
+						length = 640
+					}
+				} else {
+					logger.Warn("failed to crop video note to a square",
+						zap.String("msg_id", v.Info.ID),
+						zap.Error(err),
+					)
+				}
+			}
+
+			if canBeNote {
+				// Video notes have no caption, the header goes before it
+				var headerSent bool
+				if replyToMsgId, headerSent = utils.TgSendHeader(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId, bridgedText); headerSent {
+					bridgedText = ""
+				}
+				sentMsg, err = tgBot.SendVideoNote(cfg.Telegram.TargetChatID, utils.TgInputFile(ptvBytes, "video_note.mp4"), &gotgbot.SendVideoNoteOpts{
+					ReplyToMessageId: replyToMsgId,
+					MessageThreadId:  threadId,
+					Duration:         int64(ptvMsg.GetSeconds()),
+					Length:           length,
+					Thumbnail:        utils.TgThumbnailFile(ptvMsg.GetJpegThumbnail()),
+				})
+				if err != nil {
+					sentMsg = nil
+					logger.Warn("failed to send video note, sending it as a video",
+						zap.String("msg_id", v.Info.ID),
+						zap.Error(err),
+					)
+				}
+			}
+
+			if sentMsg == nil {
+				sentMsg, _ = tgBot.SendVideo(cfg.Telegram.TargetChatID, utils.TgInputFile(ptvBytes, "video_note.mp4"), &gotgbot.SendVideoOpts{
+					Caption:           bridgedText,
+					ReplyToMessageId:  replyToMsgId,
+					MessageThreadId:   threadId,
+					Duration:          int64(ptvMsg.GetSeconds()),
+					Thumbnail:         utils.TgThumbnailFile(ptvMsg.GetJpegThumbnail()),
+					SupportsStreaming: true,
+				})
+			}
+			if sentMsg != nil && sentMsg.MessageId != 0 {
+				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+			}
 			return
 		}
 
@@ -505,36 +774,34 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipGIFs {
-			bridgedText += "\nSkipping GIF because 'skip_gifs' set in config file"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeGIFs); skip {
+			bridgedText += fmt.Sprintf("\nSkipping GIF because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(gifMsg.GetFileLength()))
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, gifMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && gifMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the GIF as it exceeds Telegram size restrictions."
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		} else if gifMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("GIF")
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, gifMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, gifMsg, int64(gifMsg.GetFileLength()),
+				"GIF", "", gifMsg.GetMimetype())
 			return
 		} else {
-			gifBytes, err := waClient.Download(gifMsg)
+			gifBytes, err := utils.WaDownloadMedia(v.Info.Chat, gifMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a GIF from WhatsApp", err)
 				bridgedText += "\nCouldn't download the GIF due to some errors"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyToMessageId: replyToMsgId,
-					MessageThreadId:  threadId,
-				})
+				sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+					bridgedText, gifMsg.GetJpegThumbnail())
 				if sentMsg.MessageId != 0 {
 					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
@@ -542,16 +809,17 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 
-			if caption := gifMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
+			captionText, captionOverflow := utils.TgCaptionText(gifMsg.GetCaption())
+			bridgedText += captionText
 
+			// WhatsApp GIFs are MP4 videos, Telegram only loops them when
+			// they are sent as one
+			fileName := "animation.mp4"
+			if gifMsg.GetMimetype() == "image/gif" {
+				fileName = "animation.gif"
+			}
 			fileToSend := gotgbot.NamedFile{
-				FileName: "animation.gif",
+				FileName: fileName,
 				File:     bytes.NewReader(gifBytes),
 			}
 
@@ -559,11 +827,21 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				Caption:          bridgedText,
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
+				Duration:         int64(gifMsg.GetSeconds()),
+				Width:            int64(gifMsg.GetWidth()),
+				Height:           int64(gifMsg.GetHeight()),
+				Thumbnail:        utils.TgThumbnailFile(gifMsg.GetJpegThumbnail()),
 			})
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			if err = utils.TgSendCaptionOverflow(tgBot, sentMsg, captionOverflow); err != nil {
+				logger.Warn("failed to send the rest of the caption",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			}
 			return
 		}
 
@@ -574,36 +852,34 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipVideos {
-			bridgedText += "\nSkipping video because 'skip_videos' set in config file"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeVideos); skip {
+			bridgedText += fmt.Sprintf("\nSkipping video because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(videoMsg.GetFileLength()))
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, videoMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && videoMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the video as it exceeds Telegram size restrictions."
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		} else if videoMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("video")
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, videoMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, videoMsg, int64(videoMsg.GetFileLength()),
+				"video", "", videoMsg.GetMimetype())
 			return
 		} else {
-			videoBytes, err := waClient.Download(videoMsg)
+			videoBytes, err := utils.WaDownloadMedia(v.Info.Chat, videoMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a video from WhatsApp", err)
 				bridgedText += "\nCouldn't download the video due to some errors"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyToMessageId: replyToMsgId,
-					MessageThreadId:  threadId,
-				})
+				sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+					bridgedText, videoMsg.GetJpegThumbnail())
 				if sentMsg.MessageId != 0 {
 					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
@@ -611,28 +887,31 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 
-			if caption := videoMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
+			captionText, captionOverflow := utils.TgCaptionText(videoMsg.GetCaption())
+			bridgedText += captionText
 
-			fileToSend := gotgbot.NamedFile{
-				FileName: "video." + strings.Split(videoMsg.GetMimetype(), "/")[1],
-				File:     bytes.NewReader(videoBytes),
-			}
+			fileToSend := utils.TgInputFile(videoBytes, "video."+strings.Split(videoMsg.GetMimetype(), "/")[1])
 
 			sentMsg, _ := tgBot.SendVideo(cfg.Telegram.TargetChatID, fileToSend, &gotgbot.SendVideoOpts{
-				Caption:          bridgedText,
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
+				Caption:           bridgedText,
+				ReplyToMessageId:  replyToMsgId,
+				MessageThreadId:   threadId,
+				Duration:          int64(videoMsg.GetSeconds()),
+				Width:             int64(videoMsg.GetWidth()),
+				Height:            int64(videoMsg.GetHeight()),
+				Thumbnail:         utils.TgThumbnailFile(videoMsg.GetJpegThumbnail()),
+				SupportsStreaming: true,
 			})
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			if err = utils.TgSendCaptionOverflow(tgBot, sentMsg, captionOverflow); err != nil {
+				logger.Warn("failed to send the rest of the caption",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			}
 			return
 		}
 
@@ -643,8 +922,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipVoiceNotes {
-			bridgedText += "\nSkipping voice note because 'skip_voice_notes' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeVoiceNotes); skip {
+			bridgedText += fmt.Sprintf("\nSkipping voice note because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(audioMsg.GetFileLength()))
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -654,8 +934,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the audio as it exceeds Telegram size restrictions."
+		} else if audioMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("audio")
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -664,10 +944,13 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, audioMsg, int64(audioMsg.GetFileLength()),
+				"audio", "", audioMsg.GetMimetype())
 			return
 		} else {
-			audioBytes, err := waClient.Download(audioMsg)
+			audioBytes, err := utils.WaDownloadMedia(v.Info.Chat, audioMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a audio from WhatsApp", err)
 				bridgedText += "\nCouldn't download the audio due to some errors"
 				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyToMessageId: replyToMsgId,
@@ -705,8 +988,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipAudios {
-			bridgedText += "\nSkipping audio because 'skip_audios' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeAudios); skip {
+			bridgedText += fmt.Sprintf("\nSkipping audio because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(audioMsg.GetFileLength()))
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -716,8 +1000,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the audio as it exceeds Telegram size restrictions."
+		} else if audioMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("audio")
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -726,10 +1010,13 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, audioMsg, int64(audioMsg.GetFileLength()),
+				"audio", "", audioMsg.GetMimetype())
 			return
 		} else {
-			audioBytes, err := waClient.Download(audioMsg)
+			audioBytes, err := utils.WaDownloadMedia(v.Info.Chat, audioMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a audio from WhatsApp", err)
 				bridgedText += "\nCouldn't download the audio due to some errors"
 				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyToMessageId: replyToMsgId,
@@ -742,10 +1029,7 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 
-			fileToSend := gotgbot.NamedFile{
-				FileName: "audio.m4a",
-				File:     bytes.NewReader(audioBytes),
-			}
+			fileToSend := utils.TgInputFile(audioBytes, "audio.m4a")
 
 			sentMsg, _ := tgBot.SendAudio(cfg.Telegram.TargetChatID, fileToSend, &gotgbot.SendAudioOpts{
 				Caption:          bridgedText,
@@ -767,36 +1051,37 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipDocuments {
-			bridgedText += "\nSkipping document because 'skip_documents' set in config file"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeDocuments); skip {
+			bridgedText += fmt.Sprintf("\nSkipping document because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(documentMsg.GetFileLength()))
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, documentMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && documentMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the document as it exceeds Telegram size restrictions."
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyToMessageId: replyToMsgId,
-				MessageThreadId:  threadId,
-			})
+		} else if cfg.Telegram.DocumentParts.Enabled && documentMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			DocumentSendInParts(v, msgId, documentMsg, bridgedText, threadId, replyToMsgId)
+			return
+		} else if documentMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("document")
+			sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+				bridgedText, documentMsg.GetJpegThumbnail())
 			if sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, documentMsg, int64(documentMsg.GetFileLength()),
+				"document", documentMsg.GetFileName(), documentMsg.GetMimetype())
 			return
 		} else {
-			documentBytes, err := waClient.Download(documentMsg)
+			documentBytes, err := utils.WaDownloadMedia(v.Info.Chat, documentMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a document from WhatsApp", err)
 				bridgedText += "\nCouldn't download the document due to some errors"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyToMessageId: replyToMsgId,
-					MessageThreadId:  threadId,
-				})
+				sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+					bridgedText, documentMsg.GetJpegThumbnail())
 				if sentMsg.MessageId != 0 {
 					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
@@ -804,18 +1089,10 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 
-			if caption := documentMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
+			captionText, captionOverflow := utils.TgCaptionText(documentMsg.GetCaption())
+			bridgedText += captionText
 
-			fileToSend := gotgbot.NamedFile{
-				FileName: documentMsg.GetFileName(),
-				File:     bytes.NewReader(documentBytes),
-			}
+			fileToSend := utils.TgInputFile(documentBytes, documentMsg.GetFileName())
 
 			sentMsg, _ := tgBot.SendDocument(cfg.Telegram.TargetChatID, fileToSend, &gotgbot.SendDocumentOpts{
 				Caption:          bridgedText,
@@ -826,6 +1103,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			if err = utils.TgSendCaptionOverflow(tgBot, sentMsg, captionOverflow); err != nil {
+				logger.Warn("failed to send the rest of the caption",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			}
 			return
 		}
 
@@ -836,8 +1119,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
-		if cfg.WhatsApp.SkipStickers {
-			bridgedText += "\nSkipping sticker because 'skip_stickers' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeStickers); skip {
+			bridgedText += fmt.Sprintf("\nSkipping sticker because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), int64(stickerMsg.GetFileLength()))
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -847,8 +1131,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
 			return
-		} else if !cfg.Telegram.SelfHostedAPI && stickerMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\nCouldn't send the sticker as it exceeds Telegram size restrictions."
+		} else if stickerMsg.GetFileLength() > utils.TgUploadSizeLimit() {
+			bridgedText += utils.LargeMediaBridgeText("sticker")
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -857,10 +1141,13 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
 					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
 			}
+			utils.LargeMediaUploadLater(tgBot, sentMsg, bridgedText, v.Info.Chat, stickerMsg, int64(stickerMsg.GetFileLength()),
+				"sticker", "", stickerMsg.GetMimetype())
 			return
 		} else {
-			stickerBytes, err := waClient.Download(stickerMsg)
+			stickerBytes, err := utils.WaDownloadMedia(v.Info.Chat, stickerMsg)
 			if err != nil {
+				utils.TgReportError("Failed to download a sticker from WhatsApp", err)
 				bridgedText += "\nCouldn't download the sticker due to some errors"
 				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyToMessageId: replyToMsgId,
@@ -911,8 +1198,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 	} else if v.Message.GetContactMessage() != nil {
 		contactMsg := v.Message.GetContactMessage()
 
-		if cfg.WhatsApp.SkipContacts {
-			bridgedText += "\nSkipping contact because 'skip_contacts' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeContacts); skip {
+			bridgedText += fmt.Sprintf("\nSkipping contact because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), 0)
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -956,8 +1244,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		contactsMsg := v.Message.GetContactsArrayMessage()
 
-		if cfg.WhatsApp.SkipContacts {
-			bridgedText += "\nSkipping contact array because 'skip_contacts' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeContacts); skip {
+			bridgedText += fmt.Sprintf("\nSkipping contact array because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), 0)
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -998,8 +1287,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		locationMsg := v.Message.GetLocationMessage()
 
-		if cfg.WhatsApp.SkipLocations {
-			bridgedText += "\nSkipping location because 'skip_locations' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeLocations); skip {
+			bridgedText += fmt.Sprintf("\nSkipping location because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), 0)
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -1027,8 +1317,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		bridgedText += "\nShared their live location with you"
 
-		if cfg.WhatsApp.SkipLocations {
-			bridgedText += "\nSkipping live location because 'skip_locations' set in config file"
+		if skip, reason := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeLocations); skip {
+			bridgedText += fmt.Sprintf("\nSkipping live location because of '%s'", reason)
+			database.ActivityEventAdd(database.ActivityMediaSkipped, v.Info.Chat.String(), 0)
 			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyToMessageId: replyToMsgId,
 				MessageThreadId:  threadId,
@@ -1061,14 +1352,64 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			pollMsg = i
 		}
 
-		bridgedText += fmt.Sprintf("%s(<b>%v</b>)\n",
-			html.EscapeString(pollMsg.GetName()), pollMsg.GetSelectableOptionsCount())
-		for optionNum, option := range pollMsg.GetOptions() {
-			if len(bridgedText) > 4000 {
-				bridgedText += "\n..."
-				break
+		sentMsg, err := utils.TgBridgePoll(tgBot, &v.Info, pollMsg, bridgedText, threadId, replyToMsgId)
+		if err != nil {
+			logger.Error("failed to bridge poll",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
+		}
+		if sentMsg != nil {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
+	} else if scheduledCallMsg := v.Message.GetScheduledCallCreationMessage(); scheduledCallMsg != nil {
+
+		callType := "voice"
+		if scheduledCallMsg.GetCallType() == waProto.ScheduledCallCreationMessage_VIDEO {
+			callType = "video"
+		}
+
+		bridgedText += fmt.Sprintf("Scheduled a %s call: <b>%s</b>\n", callType, html.EscapeString(scheduledCallMsg.GetTitle()))
+		if scheduledTime := scheduledCallMsg.GetScheduledTimestampMs(); scheduledTime != 0 {
+			bridgedText += fmt.Sprintf("🕛: <i>%s</i>\n",
+				html.EscapeString(time.UnixMilli(scheduledTime).In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+		}
+
+		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			ReplyToMessageId: replyToMsgId,
+			MessageThreadId:  threadId,
+		})
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
+	} else if eventMsg := v.Message.GetEventMessage(); eventMsg != nil {
+
+		bridgedText += utils.WaFormatEventMessage(eventMsg)
+
+		if cfg.Telegram.SendEventICS && eventMsg.GetStartTime() != 0 && utf8.RuneCountInString(bridgedText) <= 1024 {
+			sentMsg, err := tgBot.SendDocument(cfg.Telegram.TargetChatID, gotgbot.NamedFile{
+				FileName: "event.ics",
+				File:     bytes.NewReader(utils.WaBuildEventICS(eventMsg, msgId)),
+			}, &gotgbot.SendDocumentOpts{
+				Caption:          bridgedText,
+				ReplyToMessageId: replyToMsgId,
+				MessageThreadId:  threadId,
+			})
+			if err == nil {
+				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+				return
 			}
-			bridgedText += fmt.Sprintf("%v. %s\n", optionNum+1, html.EscapeString(option.GetOptionName()))
+			logger.Error("failed to send event with calendar file",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
 		}
 
 		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
@@ -1081,11 +1422,117 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 		return
 
+	} else if productMsg := v.Message.GetProductMessage(); productMsg != nil {
+
+		bridgedText += utils.WaFormatProductMessage(productMsg)
+
+		imageMsg := productMsg.GetProduct().GetProductImage()
+		if imageMsg.GetUrl() != "" && utf8.RuneCountInString(bridgedText) <= 1024 {
+			if skip, _ := utils.WaChatSkipsMedia(v.Info.Chat, utils.MediaTypeImages); !skip {
+				imageBytes, err := utils.WaDownloadMedia(v.Info.Chat, imageMsg)
+				if err != nil {
+					utils.TgReportError("Failed to download a product image from WhatsApp", err)
+				} else {
+					sentMsg, _ := utils.TgSendPhotoWithFallback(tgBot, cfg.Telegram.TargetChatID,
+						utils.ImageProcessForTelegram(imageBytes), imageBytes, &gotgbot.SendPhotoOpts{
+							Caption:          bridgedText,
+							ReplyToMessageId: replyToMsgId,
+							MessageThreadId:  threadId,
+						}, imageMsg.GetMimetype())
+					if sentMsg.MessageId != 0 {
+						database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+							cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					}
+					return
+				}
+			}
+		}
+
+		sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+			bridgedText, imageMsg.GetJpegThumbnail())
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
+	} else if interactiveText, kind, options, found := utils.WaParseInteractiveMessage(v.Message); found {
+
+		bridgedText += interactiveText
+
+		sendOpts := &gotgbot.SendMessageOpts{
+			ReplyToMessageId: replyToMsgId,
+			MessageThreadId:  threadId,
+		}
+		keyboard, err := utils.TgMakeInteractiveKeyboard(msgId, v.Info.Chat, v.Info.MessageSource.Sender, kind, options)
+		if err != nil {
+			logger.Error("failed to save options of interactive message",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
+		} else if keyboard != nil {
+			sendOpts.ReplyMarkup = keyboard
+		}
+
+		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, sendOpts)
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
+	} else if paymentText, thumbnail, found := utils.WaFormatPaymentMessage(v.Message); found {
+
+		bridgedText += paymentText
+
+		sentMsg, _ := utils.TgSendTextWithThumbnail(tgBot, cfg.Telegram.TargetChatID, threadId, replyToMsgId,
+			bridgedText, thumbnail)
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
+	} else if inviteMsg := v.Message.GetGroupInviteMessage(); inviteMsg != nil {
+
+		bridgedText += fmt.Sprintf("Invited you to join the group: <b>%s</b>\n", html.EscapeString(inviteMsg.GetGroupName()))
+		bridgedText += fmt.Sprintf("Invited by: %s\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
+		if expiration := inviteMsg.GetInviteExpiration(); expiration != 0 {
+			bridgedText += fmt.Sprintf("Expires: <i>%s</i>\n",
+				html.EscapeString(time.Unix(expiration, 0).In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+		}
+		if caption := inviteMsg.GetCaption(); caption != "" {
+			bridgedText += "\n" + html.EscapeString(caption) + "\n"
+		}
+
+		sendOpts := &gotgbot.SendMessageOpts{
+			ReplyToMessageId: replyToMsgId,
+			MessageThreadId:  threadId,
+		}
+		err := database.GroupInviteAdd(msgId, inviteMsg.GetGroupJid(), v.Info.MessageSource.Sender.ToNonAD().String(),
+			inviteMsg.GetInviteCode(), inviteMsg.GetInviteExpiration())
+		if err != nil {
+			logger.Error("failed to save group invite",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
+		} else {
+			sendOpts.ReplyMarkup = utils.TgBuildCallbackButton("Join", "joininvite_"+msgId)
+		}
+
+		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, sendOpts)
+		if sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return
+
 	} else {
 		if text == "" {
 			return
 		}
 
+		headerText := bridgedText
 		if len(text) > 4000 {
 			bridgedText += html.EscapeString(utils.SubString(text, 0, 4000)) + "..."
 		} else {
@@ -1106,10 +1553,75 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				)
 			}
 		}
-		sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+		if utils.LinkPreviewEnabledFor(v.Info.Chat) {
+			if url, found := utils.LinkPreviewFindURL(text); found {
+				var (
+					preview *utils.LinkPreview
+					err     error
+				)
+				if extendedMsg := v.Message.GetExtendedTextMessage(); extendedMsg.GetTitle() != "" {
+					// WhatsApp already sent a preview along, no need to fetch the page
+					preview = &utils.LinkPreview{
+						URL:         url,
+						Title:       extendedMsg.GetTitle(),
+						Description: extendedMsg.GetDescription(),
+					}
+				} else if preview, err = utils.LinkPreviewFetch(url); err != nil {
+					logger.Debug("failed to fetch link preview",
+						zap.String("event_id", v.Info.ID),
+						zap.String("url", url),
+						zap.Error(err),
+					)
+				}
+				if preview != nil {
+					bridgedText += utils.LinkPreviewRender(preview)
+				}
+			}
+		}
+		if !v.Info.IsFromMe && utils.TranslateEnabledFor(v.Info.Chat) {
+			translated, language, err := utils.Translate(text)
+			if err != nil {
+				logger.Warn("failed to translate message",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			} else if translated != "" {
+				// Keep the whole message within the limits of Telegram
+				room := 4000 - len([]rune(bridgedText))
+				if room < 0 {
+					room = 0
+				}
+				if len([]rune(translated)) > room {
+					translated = utils.SubString(translated, 0, room) + "..."
+				}
+				bridgedText += fmt.Sprintf("\n\n<i>Translated from %s</i>:\n%s",
+					html.EscapeString(language), html.EscapeString(translated))
+			}
+		}
+		sendOpts := &gotgbot.SendMessageOpts{
 			ReplyToMessageId: replyToMsgId,
 			MessageThreadId:  threadId,
-		})
+		}
+		if callLink, isVideo, found := utils.WaFindCallLink(text); found {
+			buttonText := "Join voice call"
+			if isVideo {
+				buttonText = "Join video call"
+			}
+			sendOpts.ReplyMarkup = utils.TgBuildUrlButton(buttonText, callLink)
+		}
+		if !isEdited && replyToMsgId == 0 && sendOpts.ReplyMarkup == nil &&
+			len([]rune(text)) <= cfg.Telegram.TextBatching.MaxLength && TextBatchQueue(threadId, batchedText{
+			msgId:  msgId,
+			sender: v.Info.MessageSource.Sender.String(),
+			chat:   v.Info.Chat.String(),
+			text:   bridgedText,
+			body:   strings.TrimPrefix(bridgedText, headerText),
+		}) {
+			return
+		}
+		TextBatchFlush(v.Info.Chat.String(), threadId)
+
+		sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, sendOpts)
 		if err != nil {
 			panic(fmt.Errorf("Failed to send telegram message: %s", err))
 		}
@@ -1121,18 +1633,144 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 	}
 }
 
+func AlertRulesCheck(v *events.Message) bool {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	text := utils.WaGetMessageText(v.Message)
+	if text == "" {
+		return false
+	}
+
+	pattern, matched := utils.AlertMatch(text)
+	if !matched {
+		return false
+	}
+
+	alertText := "#alert\n\n"
+	alertText += fmt.Sprintf("<b>Rule</b>: <code>%s</code>\n", html.EscapeString(pattern))
+	alertText += fmt.Sprintf("<b>From</b>: %s\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
+	if v.Info.IsGroup {
+		alertText += fmt.Sprintf("<b>Group</b>: %s\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
+	}
+	if utf8.RuneCountInString(text) > 3000 {
+		alertText += "\n" + html.EscapeString(utils.SubString(text, 0, 3000)) + "..."
+	} else {
+		alertText += "\n" + html.EscapeString(text)
+	}
+
+	if cfg.WhatsApp.Alerts.SendToOwner {
+		err := utils.TgSendTextById(tgBot, cfg.Telegram.OwnerID, 0, alertText)
+		if err != nil {
+			logger.Error("failed to send alert to owner", zap.Error(err))
+		}
+		return true
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa("#Alerts", cfg.Telegram.TargetChatID, "#Alerts")
+	if err != nil {
+		utils.TgReportError("Failed to create/find thread id for 'alerts'", err)
+		return true
+	}
+
+	sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, alertText, &gotgbot.SendMessageOpts{
+		MessageThreadId: threadId,
+	})
+	if err != nil {
+		logger.Error("failed to send alert", zap.Error(err))
+		return true
+	}
+
+	// Pinning notifies the members of the chat even if the topic is muted
+	_, err = tgBot.PinChatMessage(cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{})
+	if err != nil {
+		logger.Warn("failed to pin alert, the bot needs to be allowed to pin messages", zap.Error(err))
+	}
+	return true
+}
+
+func AwayModeAutoReply(v *events.Message) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		waChatId = v.Info.Chat.ToNonAD().String()
+	)
+	defer logger.Sync()
+
+	enabled, message, err := database.AwayModeGet()
+	if err != nil {
+		logger.Error("failed to get away mode settings", zap.Error(err))
+		return
+	} else if !enabled {
+		return
+	}
+
+	inWindow, err := utils.TimeIsInWindow(time.Now().In(state.State.LocalLocation),
+		cfg.WhatsApp.AwayMode.ScheduleStart, cfg.WhatsApp.AwayMode.ScheduleEnd)
+	if err != nil {
+		logger.Warn("failed to check away mode schedule", zap.Error(err))
+		return
+	} else if !inWindow {
+		return
+	}
+
+	lastReplied, found, err := database.AwayModeGetLastReply(waChatId)
+	if err != nil {
+		logger.Error("failed to get last away mode reply time",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+		return
+	}
+	replyInterval := time.Duration(cfg.WhatsApp.AwayMode.ReplyIntervalHours) * time.Hour
+	if found && time.Since(lastReplied) < replyInterval {
+		return
+	}
+
+	if message == "" {
+		message = cfg.WhatsApp.AwayMode.DefaultMessage
+	}
+	replyText, err := utils.WaRenderAwayMessage(message, v.Info.MessageSource.Sender.ToNonAD(), v.Info.PushName)
+	if err != nil {
+		logger.Error("failed to render away mode message", zap.Error(err))
+		return
+	}
+
+	_, err = utils.WaSendText(v.Info.Chat, replyText, "", "", nil, false)
+	if err != nil {
+		logger.Error("failed to send away mode reply",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	err = database.AwayModeUpdateLastReply(waChatId, time.Now())
+	if err != nil {
+		logger.Warn("failed to save away mode reply time",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+	}
+}
+
 func CallOfferEventHandler(v *events.CallOffer) {
 	var (
 		cfg   = state.State.Config
-		tgBot = state.State.TelegramBot
+		tgBot = state.State.TelegramSender
 	)
 
 	// TODO : Check and handle group calls
 	callerName := utils.WaGetContactName(v.CallCreator)
+	database.ActivityEventAdd(database.ActivityMissedCall, v.CallCreator.ToNonAD().String(), 0)
 
 	callThreadId, err := utils.TgGetOrMakeThreadFromWa("#Calls", cfg.Telegram.TargetChatID, "#Calls")
 	if err != nil {
-		utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/retreive corresponding thread id for calls", err)
+		utils.TgReportError("Failed to create/retreive corresponding thread id for calls", err)
 		return
 	}
 
@@ -1143,10 +1781,15 @@ func CallOfferEventHandler(v *events.CallOffer) {
 }
 
 func ReceiptEventHandler(v *events.Receipt) {
-	if v.Type == waTypes.ReceiptTypeReadSelf {
+	switch v.Type {
+	case waTypes.ReceiptTypeReadSelf:
 		for _, msgId := range v.MessageIDs {
 			database.MsgIdMarkRead(v.Chat.String(), msgId)
 		}
+	case waTypes.ReceiptTypeDelivered, waTypes.ReceiptTypeRead, waTypes.ReceiptTypePlayed:
+		if !v.IsFromMe {
+			utils.WaDeliveryReceived(v.MessageIDs, v.Timestamp)
+		}
 	}
 }
 
@@ -1161,17 +1804,43 @@ func PushNameEventHandler(v *events.PushName) {
 	)
 
 	database.ContactUpdatePushName(v.JID.User, v.NewPushName)
+
+	if cfg := state.State.Config; cfg.Telegram.SyncTopicNames {
+		// Saved names take precedence, the topic name only changes without one
+		_, fullName, _, businessName, err := database.ContactNameGet(v.JID.User)
+		if err != nil || fullName != "" || businessName != "" {
+			return
+		}
+
+		tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(v.JID.ToNonAD().String(), cfg.Telegram.TargetChatID)
+		if err != nil || !threadFound || tgThreadId == 0 {
+			return
+		}
+
+		_, err = state.State.TelegramSender.EditForumTopic(cfg.Telegram.TargetChatID, tgThreadId, &gotgbot.EditForumTopicOpts{
+			Name: utils.TgTopicNameWithPin(v.JID.ToNonAD().String(), utils.WaGetContactName(v.JID)),
+		})
+		if err != nil {
+			logger.Error("failed to change thread name",
+				zap.Error(err),
+				zap.String("chat", v.JID.String()),
+				zap.String("new_push_name", v.NewPushName),
+			)
+		}
+	}
 }
 
 func RevokedMessageEventHandler(v *events.Message) {
 	var (
 		cfg         = state.State.Config
-		tgBot       = state.State.TelegramBot
+		tgBot       = utils.TgSenderFor(v.Info.Chat.String())
 		protocolMsg = v.Message.GetProtocolMessage()
 		waMsgId     = protocolMsg.GetKey().GetId()
 		waChatId    = v.Info.Chat.String()
 	)
 
+	utils.ArchiveMessageRevoked(waMsgId, v.Info.Chat)
+
 	if !cfg.WhatsApp.SendRevokedMessageUpdates {
 		return
 	}
@@ -1185,8 +1854,10 @@ func RevokedMessageEventHandler(v *events.Message) {
 		deleterName = utils.WaGetContactName(deleter)
 	}
 
+	// The revoked text may still be held back to be combined
+	TextBatchFlushChat(waChatId)
 	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, waChatId)
-	if err != nil || tgChatId == 0 || tgThreadId == 0 || tgMsgId == 0 {
+	if err != nil || tgChatId == 0 || tgMsgId == 0 {
 		return
 	}
 
@@ -1199,12 +1870,205 @@ func RevokedMessageEventHandler(v *events.Message) {
 	})
 }
 
+func EphemeralSettingEventHandler(v *events.Message) {
+	var (
+		cfg       = state.State.Config
+		logger    = state.State.Logger
+		tgBot     = utils.TgSenderFor(v.Info.Chat.String())
+		waChatId  = v.Info.Chat.ToNonAD().String()
+		timer     = v.Message.GetProtocolMessage().GetEphemeralExpiration()
+		dbErr     error
+		updateMsg string
+	)
+	defer logger.Sync()
+
+	if timer == 0 {
+		dbErr = database.UpdateEphemeralSettings(waChatId, false, 0)
+	} else {
+		dbErr = database.UpdateEphemeralSettings(waChatId, true, timer)
+	}
+	if dbErr != nil {
+		logger.Error("failed to save ephemeral settings",
+			zap.String("chat_jid", waChatId),
+			zap.Error(dbErr),
+		)
+	}
+
+	// Groups already report timer changes through GroupInfo events
+	if v.Info.IsGroup || v.Info.Chat.Server != waTypes.DefaultUserServer ||
+		slices.Contains(cfg.WhatsApp.IgnoreChats, v.Info.Chat.User) || !utils.WaChatIsBridged(v.Info.Chat) {
+		return
+	}
+
+	var changedBy string
+	if v.Info.IsFromMe {
+		changedBy = "You"
+	} else {
+		changedBy = utils.WaGetContactName(v.Info.Sender)
+	}
+
+	if timer == 0 {
+		updateMsg = fmt.Sprintf("<b>%s</b> turned off disappearing messages", html.EscapeString(changedBy))
+	} else {
+		updateMsg = fmt.Sprintf("<b>%s</b> turned on disappearing messages\nTimer: %s",
+			html.EscapeString(changedBy), time.Second*time.Duration(timer))
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa(waChatId, cfg.Telegram.TargetChatID, utils.WaGetContactName(v.Info.Chat))
+	if err != nil {
+		utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>",
+			waChatId), err)
+		return
+	}
+
+	err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, threadId, updateMsg)
+	if err != nil {
+		logger.Error("failed to send message", zap.Error(err))
+	}
+}
+
+func PollUpdateEventHandler(v *events.Message) {
+	var (
+		logger   = state.State.Logger
+		waClient = state.State.WhatsAppClient
+		pollId   = v.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetId()
+	)
+	defer logger.Sync()
+
+	vote, err := waClient.DecryptPollVote(v)
+	if err != nil {
+		logger.Debug("failed to decrypt poll vote",
+			zap.String("event_id", v.Info.ID),
+			zap.String("poll_id", pollId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	err = utils.WaPollRecordVote(pollId, v.Info.Sender, vote.GetSelectedOptions(), v.Info.Timestamp)
+	if err != nil {
+		logger.Error("failed to save poll vote",
+			zap.String("event_id", v.Info.ID),
+			zap.String("poll_id", pollId),
+			zap.Error(err),
+		)
+	}
+}
+
+func PinInChatEventHandler(v *events.Message) {
+	var (
+		logger   = state.State.Logger
+		tgBot    = utils.TgSenderFor(v.Info.Chat.String())
+		pinMsg   = v.Message.GetPinInChatMessage()
+		waMsgId  = pinMsg.GetKey().GetId()
+		waChatId = v.Info.Chat.String()
+		isPinned = pinMsg.GetType() == waProto.PinInChatMessage_PIN_FOR_ALL
+	)
+	defer logger.Sync()
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, waChatId)
+	if err != nil || tgChatId == 0 || tgMsgId == 0 {
+		return
+	}
+
+	if isPinned {
+		_, err = tgBot.PinChatMessage(tgChatId, tgMsgId, &gotgbot.PinChatMessageOpts{
+			DisableNotification: true,
+		})
+	} else {
+		_, err = tgBot.UnpinChatMessage(tgChatId, &gotgbot.UnpinChatMessageOpts{
+			MessageId: &tgMsgId,
+		})
+	}
+	if err != nil {
+		logger.Error("failed to mirror pinned message",
+			zap.String("chat_jid", waChatId),
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+	}
+
+	var pinnerName string
+	if v.Info.IsFromMe {
+		pinnerName = "you"
+	} else {
+		pinnerName = utils.WaGetContactName(v.Info.MessageSource.Sender)
+	}
+
+	action := "Pinned"
+	if !isPinned {
+		action = "Unpinned"
+	}
+
+	tgBot.SendMessage(tgChatId, fmt.Sprintf(
+		"%s by <b>%s</b>",
+		action, html.EscapeString(pinnerName),
+	), &gotgbot.SendMessageOpts{
+		MessageThreadId:  tgThreadId,
+		ReplyToMessageId: tgMsgId,
+	})
+}
+
+// KeepInChatEventHandler notes on the bridged message when it is kept from
+// disappearing in a chat with disappearing messages, or no longer kept
+func KeepInChatEventHandler(v *events.Message) {
+	var (
+		tgBot    = utils.TgSenderFor(v.Info.Chat.String())
+		keepMsg  = v.Message.GetKeepInChatMessage()
+		waMsgId  = keepMsg.GetKey().GetId()
+		waChatId = v.Info.Chat.String()
+	)
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, waChatId)
+	if err != nil || tgChatId == 0 || tgMsgId == 0 {
+		return
+	}
+
+	var keeperName string
+	if v.Info.IsFromMe {
+		keeperName = "you"
+	} else {
+		keeperName = utils.WaGetContactName(v.Info.MessageSource.Sender)
+	}
+
+	action := "Kept in chat"
+	if keepMsg.GetKeepType() == waProto.KeepType_UNDO_KEEP_FOR_ALL {
+		action = "No longer kept in chat"
+	}
+
+	tgBot.SendMessage(tgChatId, fmt.Sprintf(
+		"🔖 %s by <b>%s</b>",
+		action, html.EscapeString(keeperName),
+	), &gotgbot.SendMessageOpts{
+		MessageThreadId:  tgThreadId,
+		ReplyToMessageId: tgMsgId,
+	})
+}
+
+func HistorySyncEventHandler(v *events.HistorySync) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	logger.Info("received history sync",
+		zap.String("sync_type", v.Data.GetSyncType().String()),
+		zap.Int("conversations", len(v.Data.GetConversations())),
+	)
+
+	for _, conv := range v.Data.GetConversations() {
+		if timer := conv.GetEphemeralExpiration(); timer > 0 {
+			database.UpdateEphemeralSettings(conv.GetId(), true, timer)
+		}
+	}
+
+	BackfillHistorySync(v)
+}
+
 func PictureEventHandler(v *events.Picture) {
 	var (
 		cfg      = state.State.Config
 		logger   = state.State.Logger
-		tgBot    = state.State.TelegramBot
-		waClient = state.State.WhatsAppClient
+		tgBot    = utils.TgSenderFor(v.JID.ToNonAD().String())
+		waSender = state.State.WhatsAppSender
 	)
 	defer logger.Sync()
 
@@ -1228,6 +2092,8 @@ func PictureEventHandler(v *events.Picture) {
 	if v.JID.Server == waTypes.GroupServer {
 		changer := utils.WaGetContactName(v.Author)
 		if v.Remove {
+			utils.AvatarArchive(v.JID, v.Author, nil, true, v.Timestamp)
+			utils.TgTopicAvatarUpdate(v.JID, tgThreadId, nil, true, "")
 			updateText := fmt.Sprintf("The profile picture was removed by %s", html.EscapeString(changer))
 			err = utils.TgSendTextById(
 				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
@@ -1238,7 +2104,7 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 		} else {
-			pictureInfo, err := waClient.GetProfilePictureInfo(
+			pictureInfo, err := waSender.GetProfilePictureInfo(
 				v.JID,
 				&whatsmeow.GetProfilePictureParams{
 					Preview: false,
@@ -1259,9 +2125,16 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 
+			utils.AvatarArchive(v.JID, v.Author, newPictureBytes, false, v.Timestamp)
+			updateText := fmt.Sprintf("The profile picture was updated by %s", html.EscapeString(changer))
+			// With topic avatars the new picture is pinned, which already posts it
+			if utils.TgTopicAvatarUpdate(v.JID, tgThreadId, newPictureBytes, false, updateText) {
+				return
+			}
+
 			_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, newPictureBytes, &gotgbot.SendPhotoOpts{
 				MessageThreadId: tgThreadId,
-				Caption:         fmt.Sprintf("The profile picture was updated by %s", html.EscapeString(changer)),
+				Caption:         updateText,
 			})
 			if err != nil {
 				logger.Error("failed to send message to the group", zap.Error(err))
@@ -1270,6 +2143,8 @@ func PictureEventHandler(v *events.Picture) {
 		}
 	} else if v.JID.Server == waTypes.DefaultUserServer {
 		if v.Remove {
+			utils.AvatarArchive(v.JID, waTypes.EmptyJID, nil, true, v.Timestamp)
+			utils.TgTopicAvatarUpdate(v.JID, tgThreadId, nil, true, "")
 			updateText := fmt.Sprintf("The profile picture was removed")
 			err = utils.TgSendTextById(
 				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
@@ -1280,7 +2155,7 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 		} else {
-			pictureInfo, err := waClient.GetProfilePictureInfo(
+			pictureInfo, err := waSender.GetProfilePictureInfo(
 				v.JID,
 				&whatsmeow.GetProfilePictureParams{
 					Preview: false,
@@ -1301,9 +2176,16 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 
+			utils.AvatarArchive(v.JID, waTypes.EmptyJID, newPictureBytes, false, v.Timestamp)
+			updateText := "The profile picture was updated"
+			// With topic avatars the new picture is pinned, which already posts it
+			if utils.TgTopicAvatarUpdate(v.JID, tgThreadId, newPictureBytes, false, updateText) {
+				return
+			}
+
 			_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, newPictureBytes, &gotgbot.SendPhotoOpts{
 				MessageThreadId: tgThreadId,
-				Caption:         "The profile picture was updated",
+				Caption:         updateText,
 			})
 			if err != nil {
 				logger.Error("failed to send message to the group", zap.Error(err))
@@ -1322,10 +2204,18 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	var (
 		cfg    = state.State.Config
 		logger = state.State.Logger
-		tgBot  = state.State.TelegramBot
+		tgBot  = utils.TgSenderFor(v.JID.String())
 	)
 	defer logger.Sync()
 
+	utils.WaGroupInfoForget(v.JID)
+	if v.Link != nil {
+		CommunityLinkEventHandler(v.JID, v.Link, true)
+	}
+	if v.Unlink != nil {
+		CommunityLinkEventHandler(v.JID, v.Unlink, false)
+	}
+
 	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(v.JID.ToNonAD().String(), cfg.Telegram.TargetChatID)
 	if err != nil {
 		logger.Warn(
@@ -1487,7 +2377,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		_, err = tgBot.EditForumTopic(
 			cfg.Telegram.TargetChatID, tgThreadId,
 			&gotgbot.EditForumTopicOpts{
-				Name: v.Name.Name,
+				Name: utils.TgTopicNameWithPin(v.JID.ToNonAD().String(), utils.WaGetGroupTopicName(v.JID)),
 			},
 		)
 		if err != nil {
@@ -1512,16 +2402,248 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	}
 }
 
+// ConnectionEventHandler posts changes of the WhatsApp connection to the
+// '#System' topic
+func ConnectionEventHandler(evt interface{}) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var notice string
+	switch v := evt.(type) {
+	case *events.Connected:
+		// Pings of the previous connection no longer matter
+		utils.HealthRecordKeepAlive(true)
+		notice = "Connected to WhatsApp"
+	case *events.Disconnected:
+		notice = "Disconnected from WhatsApp"
+	case *events.StreamReplaced:
+		notice = "WhatsApp connection was replaced by another client using the same session"
+	case *events.TemporaryBan:
+		notice = fmt.Sprintf("Temporarily banned from WhatsApp: %s", html.EscapeString(v.String()))
+	case *events.ConnectFailure:
+		notice = fmt.Sprintf("Failed to connect to WhatsApp: %s", html.EscapeString(v.Reason.String()))
+		if v.Message != "" {
+			notice += fmt.Sprintf(" (%s)", html.EscapeString(v.Message))
+		}
+	case *events.ClientOutdated:
+		notice = "WhatsApp rejected the connection as the client is outdated, update the bridge"
+	default:
+		return
+	}
+
+	if err := utils.TgSendSystemNotice(notice); err != nil {
+		logger.Error("failed to post connection notice",
+			zap.String("notice", notice),
+			zap.Error(err),
+		)
+	}
+}
+
+// BlocklistEventHandler posts the users blocked or unblocked on WhatsApp,
+// including from the phone, to the '#System' topic
+func BlocklistEventHandler(v *events.Blocklist) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	notice := "<b>Blocklist changed</b>\n"
+	if len(v.Changes) == 0 {
+		notice += "\nUse /wa_blocklist to view the current blocklist"
+	}
+	for _, change := range v.Changes {
+		action := "🚫 Blocked"
+		if change.Action == events.BlocklistChangeActionUnblock {
+			action = "✅ Unblocked"
+		}
+		notice += fmt.Sprintf("\n%s %s (<code>%s</code>)", action,
+			html.EscapeString(utils.WaGetContactName(change.JID)), html.EscapeString(change.JID.User))
+	}
+
+	if err := utils.TgSendSystemNotice(notice); err != nil {
+		logger.Error("failed to post blocklist change notice", zap.Error(err))
+	}
+}
+
+// PrivacySettingsEventHandler posts the privacy settings changed on WhatsApp
+// to the '#System' topic
+func PrivacySettingsEventHandler(v *events.PrivacySettings) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var (
+		settings = v.NewSettings
+		changed  []string
+	)
+	for _, setting := range []struct {
+		name    string
+		changed bool
+		value   waTypes.PrivacySetting
+	}{
+		{"Last seen", v.LastSeenChanged, settings.LastSeen},
+		{"Online", v.OnlineChanged, settings.Online},
+		{"Profile photo", v.ProfileChanged, settings.Profile},
+		{"About", v.StatusChanged, settings.Status},
+		{"Read receipts", v.ReadReceiptsChanged, settings.ReadReceipts},
+		{"Groups", v.GroupAddChanged, settings.GroupAdd},
+		{"Calls", v.CallAddChanged, settings.CallAdd},
+	} {
+		if setting.changed {
+			changed = append(changed, fmt.Sprintf("<b>%s</b>: %s", setting.name, privacySettingName(setting.value)))
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	notice := "<b>Privacy settings changed</b>\n\n" + strings.Join(changed, "\n")
+	if err := utils.TgSendSystemNotice(notice); err != nil {
+		logger.Error("failed to post privacy settings change notice", zap.Error(err))
+	}
+}
+
+func privacySettingName(setting waTypes.PrivacySetting) string {
+	switch setting {
+	case waTypes.PrivacySettingAll:
+		return "Everyone"
+	case waTypes.PrivacySettingContacts:
+		return "My contacts"
+	case waTypes.PrivacySettingContactBlacklist:
+		return "My contacts except..."
+	case waTypes.PrivacySettingMatchLastSeen:
+		return "Same as last seen"
+	case waTypes.PrivacySettingKnown:
+		return "Known numbers"
+	case waTypes.PrivacySettingNone:
+		return "Nobody"
+	}
+	return html.EscapeString(string(setting))
+}
+
 func LogoutHandler(v *events.LoggedOut) {
 	var (
 		cfg    = state.State.Config
 		logger = state.State.Logger
-		tgBot  = state.State.TelegramBot
+		tgBot  = state.State.TelegramSender
 	)
 	defer logger.Sync()
 
 	updateText := fmt.Sprintf("You have been logged out from WhatsApp:\n\n")
 	updateText += fmt.Sprintf("<b>Reason:</b> %s", html.EscapeString(v.Reason.String()))
 
+	if cfg.WhatsApp.ReloginViaTelegram {
+		updateText += "\n\nSending the codes to log back in here..."
+	} else {
+		updateText += "\n\nSend /relogin to log back in"
+	}
+
 	utils.TgSendTextById(tgBot, cfg.Telegram.OwnerID, 0, updateText)
+	utils.TgSendSystemNotice(fmt.Sprintf("Logged out from WhatsApp: %s", html.EscapeString(v.Reason.String())))
+
+	if cfg.WhatsApp.ReloginViaTelegram {
+		go func() {
+			if err := utils.WaLoginViaTelegram(""); err != nil {
+				utils.TgReportError("Failed to log back into WhatsApp", err)
+			}
+		}()
+	}
+}
+
+func NewsletterJoinEventHandler(v *events.NewsletterJoin) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	_, err := utils.TgGetOrMakeThreadFromWa(v.ID.String(), cfg.Telegram.TargetChatID, v.ThreadMeta.Name.Text)
+	if err != nil {
+		utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>", v.ID.String()), err)
+		return
+	}
+
+	logger.Info("followed a channel",
+		zap.String("jid", v.ID.String()),
+		zap.String("name", v.ThreadMeta.Name.Text),
+	)
+}
+
+func NewsletterLeaveEventHandler(v *events.NewsletterLeave) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = utils.TgSenderFor(v.ID.String())
+	)
+	defer logger.Sync()
+
+	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(v.ID.String(), cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound {
+		return
+	}
+
+	utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, "You are no longer following this channel")
+}
+
+// CommunityLinkEventHandler reports a group being added to or removed from a
+// community in the topic of the group, and renames the topic accordingly
+func CommunityLinkEventHandler(jid waTypes.JID, change *waTypes.GroupLinkChange, linked bool) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	var communityJid, groupJid waTypes.JID
+	switch change.Type {
+	case waTypes.GroupLinkChangeTypeSub:
+		communityJid, groupJid = jid, change.Group.JID
+	case waTypes.GroupLinkChangeTypeParent:
+		communityJid, groupJid = change.Group.JID, jid
+	default:
+		return
+	}
+	utils.WaGroupInfoForget(groupJid)
+	tgBot := utils.TgSenderFor(groupJid.String())
+
+	groupName := utils.WaGetGroupName(groupJid)
+	communityName := utils.WaGetGroupName(communityJid)
+	if change.Type == waTypes.GroupLinkChangeTypeSub && change.Group.Name != "" {
+		groupName = change.Group.Name
+	} else if change.Type == waTypes.GroupLinkChangeTypeParent && change.Group.Name != "" {
+		communityName = change.Group.Name
+	}
+
+	var updateText string
+	if linked {
+		updateText = fmt.Sprintf("The group <b>%s</b> was added to the community <b>%s</b>",
+			html.EscapeString(groupName), html.EscapeString(communityName))
+	} else {
+		updateText = fmt.Sprintf("The group <b>%s</b> was removed from the community <b>%s</b>",
+			html.EscapeString(groupName), html.EscapeString(communityName))
+		if change.UnlinkReason == waTypes.GroupUnlinkReasonDelete {
+			updateText += " as the community was deleted"
+		}
+	}
+
+	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(groupJid.ToNonAD().String(), cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound {
+		tgThreadId = 0
+	} else {
+		_, err = tgBot.EditForumTopic(
+			cfg.Telegram.TargetChatID, tgThreadId,
+			&gotgbot.EditForumTopicOpts{
+				Name: utils.TgTopicNameWithPin(groupJid.ToNonAD().String(), utils.WaGetGroupTopicName(groupJid)),
+			},
+		)
+		if err != nil {
+			logger.Error(
+				"failed to change thread name",
+				zap.Error(err),
+				zap.String("chat", groupJid.String()),
+			)
+		}
+	}
+
+	err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+	if err != nil {
+		logger.Error("failed to send message", zap.Error(err))
+	}
 }
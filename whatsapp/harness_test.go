@@ -0,0 +1,88 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"watgbridge/fakes"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var (
+	testContact = types.NewJID("10000000002", types.DefaultUserServer)
+	testMember  = types.NewJID("10000000003", types.DefaultUserServer)
+	testGroup   = types.NewJID("120363000000000001", types.GroupServer)
+)
+
+func newTestHarness(t *testing.T) *fakes.Harness {
+	t.Helper()
+
+	h, err := fakes.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.WhatsApp.Groups[testGroup] = &types.GroupInfo{
+		JID:       testGroup,
+		GroupName: types.GroupName{Name: "Test Group"},
+		Participants: []types.GroupParticipant{
+			{JID: fakes.HarnessOwnJID},
+			{JID: testContact},
+			{JID: testMember},
+		},
+	}
+	return h
+}
+
+// testMessage returns the event of a message received in the chat
+func testMessage(id string, chat, sender types.JID, msg *waProto.Message) *events.Message {
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:     chat,
+				Sender:   sender,
+				IsFromMe: sender.User == fakes.HarnessOwnJID.User,
+				IsGroup:  chat.Server == types.GroupServer,
+			},
+			ID:        id,
+			PushName:  "Push " + sender.User,
+			Timestamp: time.Now(),
+		},
+		Message: msg,
+	}
+}
+
+// renderTelegramSent writes what was sent to Telegram out the way the golden
+// files have it
+func renderTelegramSent(sent []fakes.TelegramSent) string {
+	var out strings.Builder
+	for i, msg := range sent {
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		fmt.Fprintf(&out, "%s thread=%d reply=%d", msg.Method, msg.ThreadId, msg.ReplyTo)
+		if msg.MessageId != 0 {
+			fmt.Fprintf(&out, " message=%d", msg.MessageId)
+		}
+		out.WriteString("\n")
+		switch file := msg.File.(type) {
+		case nil:
+		case gotgbot.NamedFile:
+			fmt.Fprintf(&out, "file: %s\n", file.FileName)
+		case []byte:
+			fmt.Fprintf(&out, "file: %d bytes\n", len(file))
+		case string:
+			fmt.Fprintf(&out, "file: %s\n", file)
+		default:
+			fmt.Fprintf(&out, "file: %T\n", file)
+		}
+		out.WriteString(msg.Text)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
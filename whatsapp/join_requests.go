@@ -0,0 +1,83 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// groupJoinRequests returns the users who asked or stopped asking to join the
+// group, whatsmeow doesn't parse these so they end up in the unknown changes
+func groupJoinRequests(v *events.GroupInfo) (created, revoked []waTypes.JID) {
+	for _, node := range v.UnknownChanges {
+		switch node.Tag {
+		case "membership_approval_request":
+			if jid, ok := node.AttrGetter().GetJID("jid", false); ok {
+				created = append(created, jid)
+			}
+		case "created_membership_requests", "revoked_membership_requests":
+			var users []waTypes.JID
+			for _, child := range node.GetChildrenByTag("requested_user") {
+				if jid, ok := child.AttrGetter().GetJID("jid", false); ok {
+					users = append(users, jid)
+				}
+			}
+			if node.Tag == "created_membership_requests" {
+				created = append(created, users...)
+			} else {
+				revoked = append(revoked, users...)
+			}
+		}
+	}
+	return created, revoked
+}
+
+// GroupJoinRequestEventHandler bridges requests to join a group to its topic
+// with buttons to approve or reject them. WhatsApp only sends these to the
+// admins of the group.
+func GroupJoinRequestEventHandler(v *events.GroupInfo) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = utils.TgSenderFor(v.JID.String())
+	)
+	defer logger.Sync()
+
+	created, revoked := groupJoinRequests(v)
+	if len(created) == 0 && len(revoked) == 0 {
+		return
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD().String(), cfg.Telegram.TargetChatID,
+		utils.WaGetGroupTopicName(v.JID))
+	if err != nil {
+		utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>", v.JID.String()), err)
+		return
+	}
+
+	for _, requester := range created {
+		requestText := fmt.Sprintf("<b>%s</b> asked to join the group", html.EscapeString(utils.WaGetContactName(requester)))
+		_, err = tgBot.SendMessage(cfg.Telegram.TargetChatID, requestText, &gotgbot.SendMessageOpts{
+			MessageThreadId: threadId,
+			ReplyMarkup:     utils.TgMakeJoinRequestKeyboard(v.JID, requester),
+		})
+		if err != nil {
+			logger.Error("failed to send message", zap.Error(err))
+		}
+	}
+
+	for _, requester := range revoked {
+		requestText := fmt.Sprintf("<b>%s</b> cancelled their request to join the group", html.EscapeString(utils.WaGetContactName(requester)))
+		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, threadId, requestText)
+		if err != nil {
+			logger.Error("failed to send message", zap.Error(err))
+		}
+	}
+}
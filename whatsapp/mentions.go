@@ -0,0 +1,92 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// mentionsTarget returns where the notification for a mention in the group
+// is sent, the chat configured for the group or for all mentions, otherwise
+// the #Mentions topic
+func mentionsTarget(v *events.Message) (int64, int64, error) {
+	cfg := state.State.Config
+
+	chatId := cfg.WhatsApp.Mentions.ChatID
+	if groupChatId, found := cfg.WhatsApp.Mentions.GroupChatIDs[v.Info.Chat.User]; found {
+		chatId = groupChatId
+	}
+	if chatId != 0 && chatId != cfg.Telegram.TargetChatID {
+		return chatId, 0, nil
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa("#Mentions", cfg.Telegram.TargetChatID, "#Mentions")
+	return cfg.Telegram.TargetChatID, threadId, err
+}
+
+// MentionNotify posts a notification about a group message mentioning you with
+// its sender, an excerpt and a link to where it was bridged. It is called once
+// the message is bridged so the link can be included. Replies to it are sent
+// to the group as replies to the message.
+func MentionNotify(v *events.Message, msgId string, replyMarkup gotgbot.InlineKeyboardMarkup) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	notifyText := fmt.Sprintf("<b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
+	notifyText += fmt.Sprintf("<b>From</b>: %s\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
+
+	if excerptLength := cfg.WhatsApp.Mentions.ExcerptLength; excerptLength > 0 {
+		if text := utils.WaGetMessageText(v.Message); text != "" {
+			if len([]rune(text)) > excerptLength {
+				notifyText += "\n" + html.EscapeString(utils.SubString(text, 0, excerptLength)) + "...\n"
+			} else {
+				notifyText += "\n" + html.EscapeString(text) + "\n"
+			}
+		}
+	}
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(msgId, v.Info.Chat.String())
+	if err == nil && tgChatId != 0 && tgMsgId != 0 {
+		notifyText += fmt.Sprintf("\n<a href=\"%s\">Go to the message</a>", utils.TgBuildMessageLink(tgChatId, tgThreadId, tgMsgId))
+	}
+
+	chatId, threadId, err := mentionsTarget(v)
+	if err != nil {
+		utils.TgReportError("Failed to create/find thread id for 'mentions'", err)
+		return
+	}
+
+	sentMsg, err := tgBot.SendMessage(chatId, notifyText, &gotgbot.SendMessageOpts{
+		MessageThreadId: threadId,
+		ReplyMarkup:     replyMarkup,
+	})
+	if err != nil {
+		logger.Error("failed to send mention notification",
+			zap.String("event_id", v.Info.ID),
+			zap.Int64("chat_id", chatId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// Replies to the notification are sent to the group as replies to the message
+	err = database.MentionNotificationAdd(chatId, sentMsg.MessageId, msgId, v.Info.Chat.String(),
+		v.Info.MessageSource.Sender.ToNonAD().String())
+	if err != nil {
+		logger.Error("failed to save mention notification",
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,53 @@
+package whatsapp
+
+import (
+	"sync"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+// WhatsApp delivers the events missed while the bridge was down right after
+// connecting, before the handlers are set up. They are buffered here and
+// processed once the bridge is ready, duplicates are dropped by the handlers
+// using the message ID pairs.
+var offlineEvents struct {
+	lock   sync.Mutex
+	ready  bool
+	events []interface{}
+}
+
+// OfflineEventBuffer is registered before connecting when offline messages
+// should be processed, it holds the events until OfflineEventsRelease
+func OfflineEventBuffer(evt interface{}) {
+	offlineEvents.lock.Lock()
+	if !offlineEvents.ready {
+		offlineEvents.events = append(offlineEvents.events, evt)
+		offlineEvents.lock.Unlock()
+		return
+	}
+	offlineEvents.lock.Unlock()
+
+	WhatsAppEventHandler(evt)
+}
+
+// OfflineEventsRelease processes the buffered events in the order they were
+// received and passes all further events straight to WhatsAppEventHandler
+func OfflineEventsRelease() {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	offlineEvents.lock.Lock()
+	defer offlineEvents.lock.Unlock()
+
+	logger.Info("processing events received while starting up",
+		zap.Int("count", len(offlineEvents.events)),
+	)
+
+	for _, evt := range offlineEvents.events {
+		WhatsAppEventHandler(evt)
+	}
+	offlineEvents.events = nil
+	offlineEvents.ready = true
+}
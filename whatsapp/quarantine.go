@@ -0,0 +1,154 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+const quarantineTopic = "#NewChats"
+
+var quarantineSpamLinkRegex = regexp.MustCompile(`(?i)\b(?:https?://|www\.|wa\.me/|t\.me/)\S+`)
+
+func quarantineEnabled() bool {
+	cfg := state.State.Config
+	return cfg.WhatsApp.QuarantineNewChats || cfg.WhatsApp.AntiSpam.Enabled
+}
+
+// quarantineIsSpam applies the anti-spam heuristics to the first message of a
+// new chat, which are kept simple on purpose: strangers opening with a link
+func quarantineIsSpam(msg *waProto.Message) bool {
+	if !state.State.Config.WhatsApp.AntiSpam.Enabled || msg == nil {
+		return false
+	}
+	msg = utils.WaUnwrapMessage(msg)
+	return quarantineSpamLinkRegex.MatchString(utils.WaGetMessageText(msg)) ||
+		msg.GetExtendedTextMessage().GetMatchedText() != ""
+}
+
+// QuarantineIgnored reports whether the chat was ignored from the #NewChats
+// topic, its messages are dropped
+func QuarantineIgnored(chat waTypes.JID) bool {
+	if !quarantineEnabled() {
+		return false
+	}
+
+	quarantined, found, err := database.QuarantinedChatGet(chat.ToNonAD().String())
+	return err == nil && found && quarantined.Status == database.QuarantineIgnored
+}
+
+// QuarantineThread returns the #NewChats topic if the messages of the chat are
+// to be held there until it is accepted. That is the case for people who never
+// messaged before and are not in your contacts, a notice with the buttons to
+// accept or ignore them is posted for their first message. Sending a message
+// to them yourself accepts them. With anti_spam only their first messages
+// which look like spam are held, and the senders are blocked if configured.
+func QuarantineThread(chat waTypes.JID, isFromMe bool, msg *waProto.Message) (int64, bool) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramSender
+		waChatId = chat.ToNonAD().String()
+	)
+	defer logger.Sync()
+
+	if !quarantineEnabled() || chat.Server != waTypes.DefaultUserServer {
+		return 0, false
+	}
+
+	quarantined, found, err := database.QuarantinedChatGet(waChatId)
+	if err != nil {
+		logger.Warn("failed to check if chat is quarantined",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+	if found {
+		if isFromMe {
+			database.QuarantinedChatDelete(waChatId)
+			return 0, false
+		} else if quarantined.Status != database.QuarantinePending {
+			return 0, false
+		}
+		threadId, err := utils.TgGetOrMakeThreadFromWa(quarantineTopic, cfg.Telegram.TargetChatID, quarantineTopic)
+		return threadId, err == nil
+	}
+	if isFromMe {
+		return 0, false
+	}
+
+	if _, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID); err != nil || threadFound {
+		return 0, false
+	}
+	if hasPairs, err := database.MsgIdChatHasPairs(waChatId); err != nil || hasPairs {
+		return 0, false
+	}
+	if firstName, fullName, _, _, err := database.ContactNameGet(chat.User); err != nil ||
+		firstName != "" || fullName != "" {
+		return 0, false
+	}
+
+	isSpam := quarantineIsSpam(msg)
+	if !cfg.WhatsApp.QuarantineNewChats && !isSpam {
+		return 0, false
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa(quarantineTopic, cfg.Telegram.TargetChatID, quarantineTopic)
+	if err != nil {
+		utils.TgReportError(fmt.Sprintf("Failed to create/find thread id for <b>%s</b>", quarantineTopic), err)
+		return 0, false
+	}
+	if err = database.QuarantinedChatSet(waChatId, database.QuarantinePending); err != nil {
+		logger.Error("failed to quarantine new chat",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	noticeText := fmt.Sprintf("🆕 New chat from <b>%s</b> (+%s), accept it to give it a topic of its own",
+		html.EscapeString(utils.WaGetContactName(chat)), chat.User)
+	if isSpam {
+		database.ActivityEventAdd(database.ActivitySpamHeld, waChatId, 0)
+		noticeText = fmt.Sprintf("🚫 Possible spam from <b>%s</b> (+%s), accept it to give it a topic of its own",
+			html.EscapeString(utils.WaGetContactName(chat)), chat.User)
+
+		if cfg.WhatsApp.AntiSpam.BlockSenders {
+			if _, err := state.State.WhatsAppClient.UpdateBlocklist(chat.ToNonAD(), events.BlocklistChangeActionBlock); err != nil {
+				logger.Error("failed to block suspected spammer",
+					zap.String("chat_jid", waChatId),
+					zap.Error(err),
+				)
+			} else {
+				database.ActivityEventAdd(database.ActivitySpamBlocked, waChatId, 0)
+				noticeText += "\n\nThe sender was blocked, use /unblock to undo it"
+			}
+		}
+	}
+
+	keyboard := utils.TgMakeNewChatKeyboard(chat, false)
+	_, err = tgBot.SendMessage(cfg.Telegram.TargetChatID, noticeText,
+		&gotgbot.SendMessageOpts{
+			MessageThreadId: threadId,
+			ReplyMarkup:     keyboard,
+		})
+	if err != nil {
+		logger.Error("failed to post new chat notice",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+	}
+
+	return threadId, true
+}
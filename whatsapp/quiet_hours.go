@@ -0,0 +1,274 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const quietHoursDigestLines = 5
+
+func QuietHoursActive() bool {
+	cfg := state.State.Config
+
+	if cfg.WhatsApp.QuietHours.Start == "" || cfg.WhatsApp.QuietHours.End == "" {
+		return false
+	}
+
+	active, err := utils.TimeIsInWindow(time.Now().In(state.State.LocalLocation),
+		cfg.WhatsApp.QuietHours.Start, cfg.WhatsApp.QuietHours.End)
+	return err == nil && active
+}
+
+// QuietHoursQueueMessage stores the message in the database if quiet hours are
+// active, returns true if the message was queued and should not be bridged now
+func QuietHoursQueueMessage(text string, v *events.Message, isEdited bool) bool {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	if !QuietHoursActive() {
+		return false
+	}
+
+	serialized, err := proto.Marshal(v.Message)
+	if err != nil {
+		logger.Error("failed to serialize message for quiet hours queue",
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	info, err := json.Marshal(v.Info)
+	if err != nil {
+		logger.Error("failed to serialize message info for quiet hours queue",
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	queued := &database.QueuedMessage{
+		WaChatId:  v.Info.Chat.String(),
+		SenderId:  v.Info.MessageSource.Sender.String(),
+		MsgId:     v.Info.ID,
+		PushName:  v.Info.PushName,
+		IsGroup:   v.Info.IsGroup,
+		IsEdited:  isEdited,
+		Text:      text,
+		Message:   serialized,
+		Timestamp: v.Info.Timestamp,
+		Info:      info,
+	}
+	if err = utils.ArchiveEncryptQueued(queued); err != nil {
+		logger.Error("failed to encrypt message for quiet hours queue",
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	err = database.QueuedMessageAdd(queued)
+	if err != nil {
+		logger.Error("failed to queue message during quiet hours",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	logger.Debug("queued message during quiet hours",
+		zap.String("event_id", v.Info.ID),
+		zap.String("chat_jid", v.Info.Chat.String()),
+	)
+	return true
+}
+
+func quietHoursBuildDigest(chatId string, msgs []database.QueuedMessage) string {
+	chat, _ := waTypes.ParseJID(chatId)
+
+	var (
+		senders      []string
+		senderCounts = make(map[string]int)
+	)
+	for _, msg := range msgs {
+		if senderCounts[msg.SenderId] == 0 {
+			senders = append(senders, msg.SenderId)
+		}
+		senderCounts[msg.SenderId] += 1
+	}
+
+	digestText := "#digest\n\n"
+	digestText += fmt.Sprintf("<b>%s</b> (%d messages)\n", html.EscapeString(utils.WaGetChatName(chat)), len(msgs))
+
+	var senderSummary []string
+	for _, senderId := range senders {
+		sender, _ := waTypes.ParseJID(senderId)
+		senderSummary = append(senderSummary, fmt.Sprintf("%s (%d)",
+			html.EscapeString(utils.WaGetContactName(sender)), senderCounts[senderId]))
+	}
+	digestText += "<b>From</b>: " + strings.Join(senderSummary, ", ") + "\n\n"
+
+	for idx, msg := range msgs {
+		if idx == quietHoursDigestLines {
+			digestText += fmt.Sprintf("<i>...and %d more</i>\n", len(msgs)-quietHoursDigestLines)
+			break
+		}
+
+		sender, _ := waTypes.ParseJID(msg.SenderId)
+		firstLine, _, _ := strings.Cut(msg.Text, "\n")
+		if firstLine == "" {
+			firstLine = "<i>media/other message</i>"
+		} else {
+			firstLine = html.EscapeString(utils.SubString(firstLine, 0, 100))
+		}
+		digestText += fmt.Sprintf("%s <b>%s</b>: %s\n",
+			msg.Timestamp.In(state.State.LocalLocation).Format("15:04"),
+			html.EscapeString(utils.WaGetContactName(sender)), firstLine)
+	}
+
+	return digestText
+}
+
+// quietHoursReplay sends a queued message to Telegram, skipping the filters
+// and the side effects that already ran when it was received
+func quietHoursReplay(msg database.QueuedMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var waMsg waProto.Message
+	if err = proto.Unmarshal(msg.Message, &waMsg); err != nil {
+		return fmt.Errorf("failed to deserialize queued message: %s", err)
+	}
+
+	var info waTypes.MessageInfo
+	if len(msg.Info) > 0 {
+		if err = json.Unmarshal(msg.Info, &info); err != nil {
+			return fmt.Errorf("failed to deserialize queued message info: %s", err)
+		}
+	} else {
+		// Queued before the message info was stored
+		chat, _ := waTypes.ParseJID(msg.WaChatId)
+		sender, _ := waTypes.ParseJID(msg.SenderId)
+		info = waTypes.MessageInfo{
+			MessageSource: waTypes.MessageSource{
+				Chat:    chat,
+				Sender:  sender,
+				IsGroup: msg.IsGroup,
+			},
+			ID:        msg.MsgId,
+			PushName:  msg.PushName,
+			Timestamp: msg.Timestamp,
+		}
+	}
+
+	msgId := msg.MsgId
+	if msg.IsEdited {
+		msgId = waMsg.GetProtocolMessage().GetKey().GetId()
+	}
+
+	messageSendToTelegram(msg.Text, &events.Message{
+		Info:    info,
+		Message: &waMsg,
+	}, msg.IsEdited, false, msgId)
+	return nil
+}
+
+// QuietHoursFlush posts the digest of the queued messages once quiet hours
+// are over, and forwards the full backlog if configured
+func QuietHoursFlush() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	if QuietHoursActive() {
+		return
+	}
+
+	queued, err := database.QueuedMessageGetAll()
+	if err != nil {
+		logger.Error("failed to get messages queued during quiet hours", zap.Error(err))
+		return
+	} else if len(queued) == 0 {
+		return
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa("#Digest", cfg.Telegram.TargetChatID, "#Digest")
+	if err != nil {
+		utils.TgReportError("Failed to create/find thread id for 'digest'", err)
+		return
+	}
+
+	var (
+		chats     []string
+		byChat    = make(map[string][]database.QueuedMessage)
+		decrypted []database.QueuedMessage
+	)
+	for _, msg := range queued {
+		msg, err := utils.ArchiveDecryptQueued(msg)
+		if err != nil {
+			logger.Error("failed to decrypt message queued during quiet hours",
+				zap.String("msg_id", msg.MsgId),
+				zap.Error(err),
+			)
+			continue
+		}
+		decrypted = append(decrypted, msg)
+
+		if _, found := byChat[msg.WaChatId]; !found {
+			chats = append(chats, msg.WaChatId)
+		}
+		byChat[msg.WaChatId] = append(byChat[msg.WaChatId], msg)
+	}
+
+	for _, chatId := range chats {
+		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, threadId, quietHoursBuildDigest(chatId, byChat[chatId]))
+		if err != nil {
+			logger.Error("failed to send quiet hours digest",
+				zap.String("chat_jid", chatId),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if cfg.WhatsApp.QuietHours.SendFullBacklog {
+		for _, msg := range decrypted {
+			if err = quietHoursReplay(msg); err != nil {
+				logger.Error("failed to send message queued during quiet hours",
+					zap.String("msg_id", msg.MsgId),
+					zap.Error(err),
+				)
+			}
+			// Deleted right away so that an interrupted flush does not send it again
+			if err = database.QueuedMessageDelete(msg.ID); err != nil {
+				logger.Error("failed to clear message queued during quiet hours",
+					zap.String("msg_id", msg.MsgId),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+
+	if err = database.QueuedMessageDeleteUpTo(queued[len(queued)-1].ID); err != nil {
+		logger.Error("failed to clear messages queued during quiet hours", zap.Error(err))
+	}
+}
@@ -0,0 +1,64 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestQuietHoursReplay(t *testing.T) {
+	h := newTestHarness(t)
+	quietHours := &state.State.Config.WhatsApp.QuietHours
+	now := time.Now().In(state.State.LocalLocation)
+	quietHours.Start = now.Add(-time.Hour).Format("15:04")
+	quietHours.End = now.Add(time.Hour).Format("15:04")
+	quietHours.SendFullBacklog = true
+	defer func() {
+		quietHours.Start, quietHours.End, quietHours.SendFullBacklog = "", "", false
+	}()
+
+	msg := testMessage("QUIET", testGroup, testMember, &waProto.Message{
+		Conversation: proto.String("Sent at night"),
+	})
+	msg.Info.Type = "text"
+	WhatsAppEventHandler(msg)
+
+	if len(h.Telegram.Sent) != 0 {
+		t.Fatalf("message bridged during quiet hours:\n%s", renderTelegramSent(h.Telegram.Sent))
+	}
+
+	queued, err := database.QueuedMessageGetAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(queued) != 1 {
+		t.Fatalf("queued %d messages, want 1", len(queued))
+	}
+
+	quietHours.Start, quietHours.End = "", ""
+	QuietHoursFlush()
+
+	var bridged int
+	for _, sent := range h.Telegram.Sent {
+		if sent.Method == "sendMessage" && strings.Contains(sent.Text, "Sent at night") &&
+			!strings.HasPrefix(sent.Text, "#digest") {
+			bridged++
+		}
+	}
+	if bridged != 1 {
+		t.Errorf("bridged the queued message %d times, want 1:\n%s", bridged, renderTelegramSent(h.Telegram.Sent))
+	}
+
+	if _, _, tgMsgId, err := database.MsgIdGetTgFromWa("QUIET", testGroup.String()); err != nil || tgMsgId == 0 {
+		t.Errorf("queued message not paired after the replay: %v", err)
+	}
+
+	if queued, _ = database.QueuedMessageGetAll(); len(queued) != 0 {
+		t.Errorf("%d messages left in the queue after the flush", len(queued))
+	}
+}
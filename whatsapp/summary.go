@@ -0,0 +1,144 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+const (
+	dailySummaryTopChats  = 10
+	dailySummaryRetention = 7 * 24 * time.Hour
+)
+
+func countTotal(counts map[string]int64) int64 {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+func buildDailySummary(since time.Time) (string, error) {
+	cfg := state.State.Config
+
+	messages, err := database.ActivityEventCountByChat(database.ActivityMessage, since)
+	if err != nil {
+		return "", err
+	}
+	newChats, err := database.ActivityEventCountByChat(database.ActivityNewChat, since)
+	if err != nil {
+		return "", err
+	}
+	missedCalls, err := database.ActivityEventCountByChat(database.ActivityMissedCall, since)
+	if err != nil {
+		return "", err
+	}
+	failures, err := database.ActivityEventCountByChat(database.ActivityFailure, since)
+	if err != nil {
+		return "", err
+	}
+	skippedMedia, err := database.ActivityEventCountByChat(database.ActivityMediaSkipped, since)
+	if err != nil {
+		return "", err
+	}
+	largestSkipped, largestFound, err := database.ActivityEventLargest(database.ActivityMediaSkipped, since)
+	if err != nil {
+		return "", err
+	}
+	spamHeld, err := database.ActivityEventCountByChat(database.ActivitySpamHeld, since)
+	if err != nil {
+		return "", err
+	}
+	spamBlocked, err := database.ActivityEventCountByChat(database.ActivitySpamBlocked, since)
+	if err != nil {
+		return "", err
+	}
+
+	summaryText := "#summary\n\n"
+	summaryText += fmt.Sprintf("<b>Activity since %s</b>\n\n",
+		html.EscapeString(since.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+
+	summaryText += fmt.Sprintf("<b>Messages</b>: %d\n", countTotal(messages))
+	chats := make([]string, 0, len(messages))
+	for chat := range messages {
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return messages[chats[i]] > messages[chats[j]]
+	})
+	for idx, chat := range chats {
+		if idx == dailySummaryTopChats {
+			summaryText += fmt.Sprintf("<i>...and %d more chats</i>\n", len(chats)-dailySummaryTopChats)
+			break
+		}
+		chatJid, _ := waTypes.ParseJID(chat)
+		summaryText += fmt.Sprintf("- %s: %d\n", html.EscapeString(utils.WaGetChatName(chatJid)), messages[chat])
+	}
+
+	var newContacts, newGroups int64
+	for chat := range newChats {
+		if chatJid, _ := waTypes.ParseJID(chat); chatJid.Server == waTypes.GroupServer {
+			newGroups += 1
+		} else {
+			newContacts += 1
+		}
+	}
+	summaryText += fmt.Sprintf("\n<b>New contacts</b>: %d\n", newContacts)
+	summaryText += fmt.Sprintf("<b>New groups</b>: %d\n", newGroups)
+	summaryText += fmt.Sprintf("<b>Missed calls</b>: %d\n", countTotal(missedCalls))
+	summaryText += fmt.Sprintf("<b>Failures</b>: %d\n", countTotal(failures))
+	summaryText += fmt.Sprintf("<b>Media skipped</b>: %d\n", countTotal(skippedMedia))
+	if largestFound && largestSkipped.Size > 0 {
+		chatJid, _ := waTypes.ParseJID(largestSkipped.WaChatId)
+		summaryText += fmt.Sprintf("<b>Largest media skipped</b>: %s in %s\n",
+			utils.HumanizeBytes(largestSkipped.Size), html.EscapeString(utils.WaGetChatName(chatJid)))
+	}
+	if cfg.WhatsApp.AntiSpam.Enabled {
+		summaryText += fmt.Sprintf("<b>Possible spam held</b>: %d\n", countTotal(spamHeld))
+		summaryText += fmt.Sprintf("<b>Spammers blocked</b>: %d\n", countTotal(spamBlocked))
+	}
+
+	return summaryText, nil
+}
+
+// DailySummaryPost posts the summary of the activity of the last day to the
+// '#Summary' topic and prunes old activity events
+func DailySummaryPost() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramSender
+	)
+	defer logger.Sync()
+
+	now := time.Now().UTC()
+	summaryText, err := buildDailySummary(now.Add(-24 * time.Hour))
+	if err != nil {
+		logger.Error("failed to build daily summary", zap.Error(err))
+		return
+	}
+
+	threadId, err := utils.TgGetOrMakeThreadFromWa("#Summary", cfg.Telegram.TargetChatID, "#Summary")
+	if err != nil {
+		utils.TgReportError("Failed to create/find thread id for 'summary'", err)
+		return
+	}
+
+	err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, threadId, summaryText)
+	if err != nil {
+		logger.Error("failed to send daily summary", zap.Error(err))
+	}
+
+	if err = database.ActivityEventDeleteBefore(now.Add(-dailySummaryRetention)); err != nil {
+		logger.Error("failed to prune old activity events", zap.Error(err))
+	}
+}
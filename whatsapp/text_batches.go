@@ -0,0 +1,196 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+// Telegram doesn't allow longer messages than this
+const textBatchMaxLength = 4096
+
+type batchedText struct {
+	msgId  string
+	sender string
+	chat   string
+	text   string // Text with the header, used for the first message
+	body   string // Text of the message itself, used for the rest
+	done   func() // Marks the text as no longer in flight
+}
+
+type textBatch struct {
+	threadId int64
+	texts    []batchedText
+	length   int
+	timer    *time.Timer
+}
+
+var (
+	textBatchesLock sync.Mutex
+	textBatches     = make(map[string]*textBatch)
+)
+
+func textBatchKey(chat string, threadId int64) string {
+	return fmt.Sprintf("%s|%d", chat, threadId)
+}
+
+// TextBatchQueue holds a short text back for a moment in case the same sender
+// sends more right after it, so that they are bridged together as one message
+// instead of flooding the topic. A batch from someone else in the chat is sent
+// first. Returns false if batching is disabled and the text should be sent
+// right away.
+func TextBatchQueue(threadId int64, text batchedText) bool {
+	window := state.State.Config.Telegram.TextBatching.WindowSeconds
+	if window <= 0 {
+		return false
+	}
+
+	var (
+		key    = textBatchKey(text.chat, threadId)
+		length = len([]rune(text.body)) + 1
+	)
+
+	textBatchesLock.Lock()
+	current, found := textBatches[key]
+	if found && (current.texts[0].sender != text.sender || current.length+length > textBatchMaxLength) {
+		current.timer.Stop()
+		delete(textBatches, key)
+		textBatchesLock.Unlock()
+		textBatchSend(current)
+		textBatchesLock.Lock()
+		current, found = textBatches[key]
+	}
+	defer textBatchesLock.Unlock()
+
+	if !found {
+		current = &textBatch{threadId: threadId}
+		textBatches[key] = current
+		length = len([]rune(text.text))
+		current.timer = time.AfterFunc(time.Duration(window)*time.Second, func() {
+			textBatchFlush(key, current)
+		})
+	} else {
+		current.timer.Reset(time.Duration(window) * time.Second)
+	}
+
+	// Held back texts are still being bridged, shutdown waits for them
+	text.done = utils.LagTrackSend(text.chat)
+	current.texts = append(current.texts, text)
+	current.length += length
+	return true
+}
+
+// TextBatchFlush sends the texts held back from the chat right away, so that
+// they don't end up after a message which was sent later
+func TextBatchFlush(chat string, threadId int64) {
+	key := textBatchKey(chat, threadId)
+
+	textBatchesLock.Lock()
+	current, found := textBatches[key]
+	if !found {
+		textBatchesLock.Unlock()
+		return
+	}
+	current.timer.Stop()
+	textBatchesLock.Unlock()
+
+	textBatchFlush(key, current)
+}
+
+// TextBatchFlushChat sends the texts held back from the chat in any topic
+// right away, so that edits and revokes of them find where they were sent
+func TextBatchFlushChat(chat string) {
+	var threadIds []int64
+
+	textBatchesLock.Lock()
+	for _, batch := range textBatches {
+		if batch.texts[0].chat == chat {
+			threadIds = append(threadIds, batch.threadId)
+		}
+	}
+	textBatchesLock.Unlock()
+
+	for _, threadId := range threadIds {
+		TextBatchFlush(chat, threadId)
+	}
+}
+
+// TextBatchFlushAll sends the texts held back from every chat right away and
+// waits for them to be sent
+func TextBatchFlushAll() {
+	textBatchesLock.Lock()
+	flushed := make([]*textBatch, 0, len(textBatches))
+	for key, batch := range textBatches {
+		batch.timer.Stop()
+		delete(textBatches, key)
+		flushed = append(flushed, batch)
+	}
+	textBatchesLock.Unlock()
+
+	for _, batch := range flushed {
+		textBatchSend(batch)
+	}
+}
+
+func textBatchFlush(key string, flushed *textBatch) {
+	textBatchesLock.Lock()
+	if textBatches[key] != flushed {
+		// Already flushed by a message sent after it
+		textBatchesLock.Unlock()
+		return
+	}
+	delete(textBatches, key)
+	textBatchesLock.Unlock()
+
+	textBatchSend(flushed)
+}
+
+func textBatchSend(batch *textBatch) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = utils.TgSenderFor(batch.texts[0].chat)
+	)
+	defer logger.Sync()
+	defer func() {
+		for _, text := range batch.texts {
+			text.done()
+		}
+	}()
+
+	combined := []string{batch.texts[0].text}
+	for _, text := range batch.texts[1:] {
+		combined = append(combined, text.body)
+	}
+
+	sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, strings.Join(combined, "\n"), &gotgbot.SendMessageOpts{
+		MessageThreadId: batch.threadId,
+	})
+	if err != nil {
+		logger.Error("failed to send batched texts",
+			zap.String("chat_jid", batch.texts[0].chat),
+			zap.Int("texts", len(batch.texts)),
+			zap.Error(err),
+		)
+		utils.TgReportError(fmt.Sprintf("Failed to send %d texts from <b>%s</b>", len(batch.texts),
+			batch.texts[0].chat), err)
+		return
+	}
+
+	// Replies and reactions to the combined message go to the last text
+	last := len(batch.texts) - 1
+	for _, text := range batch.texts[:last] {
+		database.MsgIdAddCombinedPair(text.msgId, text.sender, text.chat,
+			cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+	}
+	database.MsgIdAddNewPair(batch.texts[last].msgId, batch.texts[last].sender, batch.texts[last].chat,
+		cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+}
@@ -0,0 +1,76 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTextBatchFlushAll(t *testing.T) {
+	h := newTestHarness(t)
+	state.State.Config.Telegram.TextBatching.WindowSeconds = 60
+
+	for _, id := range []string{"BATCH1", "BATCH2"} {
+		WhatsAppEventHandler(testMessage(id, testContact, testContact, &waProto.Message{
+			Conversation: proto.String("Hello " + id),
+		}))
+	}
+	if len(h.Telegram.Sent) != 0 {
+		t.Fatalf("sent before the window ended:\n%s", renderTelegramSent(h.Telegram.Sent))
+	}
+	if inFlight := utils.LagInFlight(); inFlight != 2 {
+		t.Errorf("%d messages in flight, want the 2 held back", inFlight)
+	}
+
+	TextBatchFlushAll()
+	if len(h.Telegram.Sent) != 1 {
+		t.Fatalf("flushed as %d messages, want 1:\n%s", len(h.Telegram.Sent), renderTelegramSent(h.Telegram.Sent))
+	}
+	if inFlight := utils.LagInFlight(); inFlight != 0 {
+		t.Errorf("%d messages in flight after the flush, want 0", inFlight)
+	}
+	for _, id := range []string{"BATCH1", "BATCH2"} {
+		_, _, tgMsgId, err := database.MsgIdGetTgFromWa(id, testContact.String())
+		if err != nil || tgMsgId != h.Telegram.Sent[0].SentId {
+			t.Errorf("%s is paired with %d (%v), want %d", id, tgMsgId, err, h.Telegram.Sent[0].SentId)
+		}
+	}
+}
+
+func TestTextBatchEditAndReply(t *testing.T) {
+	h := newTestHarness(t)
+	state.State.Config.Telegram.TextBatching.WindowSeconds = 60
+	defer TextBatchFlushAll()
+
+	for _, id := range []string{"BATCH1", "BATCH2"} {
+		WhatsAppEventHandler(testMessage(id, testContact, testContact, &waProto.Message{
+			Conversation: proto.String("Helo " + id),
+		}))
+	}
+	WhatsAppEventHandler(testMessage("EDIT", testContact, testContact, &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			Key: &waProto.MessageKey{
+				RemoteJid: proto.String(testContact.String()),
+				Id:        proto.String("BATCH1"),
+			},
+			EditedMessage: &waProto.Message{Conversation: proto.String("Hello BATCH1")},
+		},
+	}))
+
+	sent := h.Telegram.SentCopy()
+	if len(sent) != 2 || sent[1].ReplyTo != sent[0].SentId {
+		t.Fatalf("edit of a held back text not sent as a reply to the batch:\n%s", renderTelegramSent(sent))
+	}
+
+	// Replies and reactions from Telegram go to the last text of the batch
+	waMsgId, _, _, err := database.MsgIdGetWaFromTg(sent[0].ChatId, sent[0].SentId, sent[0].ThreadId)
+	if err != nil || waMsgId != "BATCH2" {
+		t.Errorf("combined message maps to %q (%v), want BATCH2", waMsgId, err)
+	}
+}